@@ -0,0 +1,313 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// swagger:route GET /alerting/rules alerting getAlertRules
+//
+// Get all alert rules, optionally only the rules of a dashboard with
+// dashboardUid and of one of its panels with panelId. Rules with a query to
+// a datasource the user may not query are left out.
+//
+// Responses:
+// 200: getAlertRulesResponse
+// 400: badRequestError
+// 500: internalServerError
+func (hs *HTTPServer) GetAlertRules(c *contextmodel.ReqContext) response.Response {
+	query := alerting.GetRulesQuery{DashboardUID: c.Req.URL.Query().Get("dashboardUid"), Identity: c.Identity}
+	if panelID := c.Req.URL.Query().Get("panelId"); panelID != "" {
+		var err error
+		if query.PanelID, err = strconv.ParseInt(panelID, 10, 64); err != nil {
+			return response.Error(http.StatusBadRequest, "panelId is invalid", err)
+		}
+	}
+
+	rules, err := hs.AlertingService.GetRules(c.Req.Context(), &query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query alert rules", err)
+	}
+	return response.JSON(http.StatusOK, rules)
+}
+
+// swagger:route GET /alerting/rules/uid/{uid} alerting getAlertRuleByUID
+//
+// Get an alert rule by UID.
+//
+// Responses:
+// 200: alertRuleResponse
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetAlertRuleByUID(c *contextmodel.ReqContext) response.Response {
+	rule, err := hs.AlertingService.GetRule(c.Req.Context(), &alerting.GetRuleQuery{UID: web.Params(c.Req)[":uid"], Identity: c.Identity})
+	if err != nil {
+		return alertRuleErrorResponse(err, "Failed to query alert rule")
+	}
+	return response.JSON(http.StatusOK, rule)
+}
+
+// swagger:route POST /alerting/rules alerting addAlertRule
+//
+// Create an alert rule. The user must have the query permission on the
+// datasources of its queries and of the queries of its panel.
+//
+// Responses:
+// 200: saveAlertRuleResponse
+// 400: badRequestError
+// 403: forbiddenError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) AddAlertRule(c *contextmodel.ReqContext) response.Response {
+	cmd := alerting.AddRuleCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	cmd.Identity = c.Identity
+
+	rule, err := hs.AlertingService.AddRule(c.Req.Context(), &cmd)
+	if err != nil {
+		return alertRuleErrorResponse(err, "Failed to add alert rule")
+	}
+	return saveAlertRuleResponse("Alert rule added", rule)
+}
+
+// swagger:route PUT /alerting/rules/uid/{uid} alerting updateAlertRuleByUID
+//
+// Update an alert rule, the state of its alerts is kept.
+//
+// Set version to the version the update is based on to fail with 409
+// when the rule was changed in the meantime.
+//
+// Responses:
+// 200: saveAlertRuleResponse
+// 400: badRequestError
+// 403: forbiddenError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateAlertRuleByUID(c *contextmodel.ReqContext) response.Response {
+	cmd := alerting.UpdateRuleCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.UID = web.Params(c.Req)[":uid"]
+	cmd.Identity = c.Identity
+
+	rule, err := hs.AlertingService.UpdateRule(c.Req.Context(), &cmd)
+	if err != nil {
+		return alertRuleErrorResponse(err, "Failed to update alert rule")
+	}
+	return saveAlertRuleResponse("Alert rule updated", rule)
+}
+
+// swagger:route DELETE /alerting/rules/uid/{uid} alerting deleteAlertRuleByUID
+//
+// Delete an alert rule and the state of its alerts.
+//
+// Responses:
+// 200: okResponse
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteAlertRuleByUID(c *contextmodel.ReqContext) response.Response {
+	err := hs.AlertingService.DeleteRule(c.Req.Context(), &alerting.DeleteRuleCommand{UID: web.Params(c.Req)[":uid"], Identity: c.Identity})
+	if err != nil {
+		return alertRuleErrorResponse(err, "Failed to delete alert rule")
+	}
+	return response.Success("Alert rule deleted")
+}
+
+// swagger:route GET /alerting/state alerting getAlertState
+//
+// Get the last evaluation of the alert rules with the state of their alerts.
+// ruleUid and dashboardUid limit the rules, state limits the alerts, e.g. to
+// Firing. Rules with a query to a datasource the user may not query are
+// left out.
+//
+// Responses:
+// 200: getAlertStateResponse
+// 400: badRequestError
+// 500: internalServerError
+func (hs *HTTPServer) GetAlertState(c *contextmodel.ReqContext) response.Response {
+	query := alerting.GetStatesQuery{
+		RuleUID:      c.Req.URL.Query().Get("ruleUid"),
+		DashboardUID: c.Req.URL.Query().Get("dashboardUid"),
+		State:        alerting.State(c.Req.URL.Query().Get("state")),
+		Identity:     c.Identity,
+	}
+	switch query.State {
+	case "", alerting.StateNormal, alerting.StatePending, alerting.StateFiring, alerting.StateNoData, alerting.StateError:
+	default:
+		return response.Error(http.StatusBadRequest, "state is invalid", nil)
+	}
+
+	states, err := hs.AlertingService.GetStates(c.Req.Context(), &query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query alert state", err)
+	}
+	return response.JSON(http.StatusOK, states)
+}
+
+// swagger:route GET /alerting/notifications alerting getNotificationConfig
+//
+// Get the notification config: the Alertmanagers alerts are posted to, and
+// the route and receivers of the built-in dispatcher.
+//
+// Responses:
+// 200: notificationConfigResponse
+// 500: internalServerError
+func (hs *HTTPServer) GetNotificationConfig(c *contextmodel.ReqContext) response.Response {
+	config, err := hs.NotificationService.GetNotificationConfig(c.Req.Context())
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query notification config", err)
+	}
+	return response.JSON(http.StatusOK, config)
+}
+
+// swagger:route PUT /alerting/notifications alerting saveNotificationConfig
+//
+// Replace the notification config. The pending notifications of the
+// previous config are dropped.
+//
+// Set version to the version the update is based on to fail with 409
+// when the config was changed in the meantime.
+//
+// Responses:
+// 200: notificationConfigResponse
+// 400: badRequestError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) SaveNotificationConfig(c *contextmodel.ReqContext) response.Response {
+	cmd := alerting.SaveNotificationConfigCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	config, err := hs.NotificationService.SaveNotificationConfig(c.Req.Context(), &cmd)
+	if err != nil {
+		return notificationErrorResponse(err, "Failed to save notification config")
+	}
+	return response.JSON(http.StatusOK, config)
+}
+
+// swagger:route GET /alerting/silences alerting getSilences
+//
+// Get the silences, active ones first, optionally only the ones in state:
+// pending, active or expired.
+//
+// Responses:
+// 200: getSilencesResponse
+// 400: badRequestError
+// 500: internalServerError
+func (hs *HTTPServer) GetSilences(c *contextmodel.ReqContext) response.Response {
+	query := alerting.GetSilencesQuery{State: alerting.SilenceState(c.Req.URL.Query().Get("state"))}
+	switch query.State {
+	case "", alerting.SilenceStatePending, alerting.SilenceStateActive, alerting.SilenceStateExpired:
+	default:
+		return response.Error(http.StatusBadRequest, "state is invalid", nil)
+	}
+
+	silences, err := hs.NotificationService.GetSilences(c.Req.Context(), &query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query silences", err)
+	}
+	return response.JSON(http.StatusOK, silences)
+}
+
+// swagger:route GET /alerting/silences/id/{id} alerting getSilenceByID
+//
+// Get a silence by ID.
+//
+// Responses:
+// 200: silenceResponse
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetSilenceByID(c *contextmodel.ReqContext) response.Response {
+	silence, err := hs.NotificationService.GetSilence(c.Req.Context(), &alerting.GetSilenceQuery{ID: web.Params(c.Req)[":id"]})
+	if err != nil {
+		return notificationErrorResponse(err, "Failed to query silence")
+	}
+	return response.JSON(http.StatusOK, silence)
+}
+
+// swagger:route POST /alerting/silences alerting addSilence
+//
+// Create a silence. It mutes the notifications of the alerts matching all
+// its matchers until endsAt.
+//
+// Responses:
+// 200: addSilenceResponse
+// 400: badRequestError
+// 500: internalServerError
+func (hs *HTTPServer) AddSilence(c *contextmodel.ReqContext) response.Response {
+	cmd := alerting.AddSilenceCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	silence, err := hs.NotificationService.AddSilence(c.Req.Context(), &cmd)
+	if err != nil {
+		return notificationErrorResponse(err, "Failed to add silence")
+	}
+	return response.JSON(http.StatusOK, map[string]any{
+		"message": "Silence added",
+		"id":      silence.ID,
+		"silence": silence,
+	})
+}
+
+// swagger:route DELETE /alerting/silences/id/{id} alerting expireSilenceByID
+//
+// Expire a silence. Expired silences are kept for 5 days.
+//
+// Responses:
+// 200: okResponse
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) ExpireSilenceByID(c *contextmodel.ReqContext) response.Response {
+	err := hs.NotificationService.ExpireSilence(c.Req.Context(), &alerting.ExpireSilenceCommand{ID: web.Params(c.Req)[":id"]})
+	if err != nil {
+		return notificationErrorResponse(err, "Failed to expire silence")
+	}
+	return response.Success("Silence expired")
+}
+
+func saveAlertRuleResponse(message string, rule *alerting.Rule) response.Response {
+	return response.JSON(http.StatusOK, map[string]any{
+		"message": message,
+		"uid":     rule.UID,
+		"version": rule.Version,
+		"rule":    rule,
+	})
+}
+
+func alertRuleErrorResponse(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, alerting.ErrRuleNotFound):
+		return response.Error(http.StatusNotFound, "Alert rule not found", nil)
+	case errors.Is(err, alerting.ErrRuleUidExists):
+		return response.Error(http.StatusConflict, err.Error(), err)
+	case errors.Is(err, alerting.ErrRuleVersionMismatch):
+		return response.Error(http.StatusConflict, "Alert rule has already been updated by someone else. Please reload and try again", err)
+	}
+	return response.ErrOrFallback(http.StatusInternalServerError, message, err)
+}
+
+func notificationErrorResponse(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, alerting.ErrSilenceNotFound):
+		return response.Error(http.StatusNotFound, "Silence not found", nil)
+	case errors.Is(err, alerting.ErrNotificationConfigVersionMismatch):
+		return response.Error(http.StatusConflict, "Notification config has already been updated by someone else. Please reload and try again", err)
+	}
+	return response.ErrOrFallback(http.StatusInternalServerError, message, err)
+}
@@ -0,0 +1,177 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/services/annotations"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// swagger:route GET /annotations annotations getAnnotations
+//
+// Get annotations, latest first. from and to (epoch milliseconds) limit the
+// result to the annotations overlapping the range, dashboardUid and panelId
+// to a dashboard or a panel, tags to the annotations with all the tags, or
+// any of them with matchAny=true. limit defaults to 100.
+//
+// Responses:
+// 200: getAnnotationsResponse
+// 400: badRequestError
+// 500: internalServerError
+func (hs *HTTPServer) GetAnnotations(c *contextmodel.ReqContext) response.Response {
+	params := c.Req.URL.Query()
+	query := annotations.GetAnnotationsQuery{
+		DashboardUID: params.Get("dashboardUid"),
+		Tags:         params["tags"],
+		MatchAny:     params.Get("matchAny") == "true",
+	}
+	ints := []struct {
+		name  string
+		value *int64
+	}{{"from", &query.From}, {"to", &query.To}, {"panelId", &query.PanelID}}
+	for _, p := range ints {
+		if v := params.Get(p.name); v != "" {
+			var err error
+			if *p.value, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return response.Error(http.StatusBadRequest, p.name+" is invalid", err)
+			}
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		var err error
+		if query.Limit, err = strconv.Atoi(v); err != nil {
+			return response.Error(http.StatusBadRequest, "limit is invalid", err)
+		}
+	}
+
+	items, err := hs.AnnotationsService.GetAnnotations(c.Req.Context(), &query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query annotations", err)
+	}
+	return response.JSON(http.StatusOK, items)
+}
+
+// swagger:route GET /annotations/{annotationId} annotations getAnnotationByID
+//
+// Get an annotation by ID.
+//
+// Responses:
+// 200: annotationResponse
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetAnnotationByID(c *contextmodel.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":annotationId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "annotationId is invalid", err)
+	}
+
+	a, err := hs.AnnotationsService.GetAnnotation(c.Req.Context(), &annotations.GetAnnotationQuery{ID: id})
+	if err != nil {
+		return annotationErrorResponse(err, "Failed to query annotation")
+	}
+	return response.JSON(http.StatusOK, a)
+}
+
+// swagger:route POST /annotations annotations addAnnotation
+//
+// Create an annotation, at now unless time is set.
+//
+// Responses:
+// 200: addAnnotationResponse
+// 400: badRequestError
+// 500: internalServerError
+func (hs *HTTPServer) AddAnnotation(c *contextmodel.ReqContext) response.Response {
+	cmd := annotations.AddAnnotationCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	a, err := hs.AnnotationsService.AddAnnotation(c.Req.Context(), &cmd)
+	if err != nil {
+		return annotationErrorResponse(err, "Failed to add annotation")
+	}
+	return response.JSON(http.StatusOK, map[string]any{
+		"message":    "Annotation added",
+		"id":         a.ID,
+		"annotation": a,
+	})
+}
+
+// swagger:route PUT /annotations/{annotationId} annotations updateAnnotation
+//
+// Update an annotation.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateAnnotation(c *contextmodel.ReqContext) response.Response {
+	cmd := annotations.UpdateAnnotationCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	var err error
+	if cmd.ID, err = strconv.ParseInt(web.Params(c.Req)[":annotationId"], 10, 64); err != nil {
+		return response.Error(http.StatusBadRequest, "annotationId is invalid", err)
+	}
+
+	if _, err := hs.AnnotationsService.UpdateAnnotation(c.Req.Context(), &cmd); err != nil {
+		return annotationErrorResponse(err, "Failed to update annotation")
+	}
+	return response.Success("Annotation updated")
+}
+
+// swagger:route DELETE /annotations/{annotationId} annotations deleteAnnotationByID
+//
+// Delete an annotation.
+//
+// Responses:
+// 200: okResponse
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteAnnotationByID(c *contextmodel.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":annotationId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "annotationId is invalid", err)
+	}
+
+	if err := hs.AnnotationsService.DeleteAnnotation(c.Req.Context(), &annotations.DeleteAnnotationCommand{ID: id}); err != nil {
+		return annotationErrorResponse(err, "Failed to delete annotation")
+	}
+	return response.Success("Annotation deleted")
+}
+
+// swagger:route POST /annotations/query annotations queryAnnotations
+//
+// Run Loki log queries over a time range and get an annotation for every
+// matching line, latest first. The title, text and tags are taken from the
+// labels of the lines.
+//
+// Responses:
+// 200: getAnnotationsResponse
+// 400: badRequestError
+// 500: internalServerError
+func (hs *HTTPServer) QueryAnnotations(c *contextmodel.ReqContext) response.Response {
+	cmd := annotations.QueryAnnotationsCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	items, err := hs.AnnotationsService.QueryAnnotations(c.Req.Context(), &cmd)
+	if err != nil {
+		return hs.handleQueryMetricsError(err)
+	}
+	return response.JSON(http.StatusOK, items)
+}
+
+func annotationErrorResponse(err error, message string) response.Response {
+	if errors.Is(err, annotations.ErrAnnotationNotFound) {
+		return response.Error(http.StatusNotFound, "Annotation not found", nil)
+	}
+	return response.ErrOrFallback(http.StatusInternalServerError, message, err)
+}
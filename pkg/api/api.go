@@ -31,18 +31,100 @@ package api
 
 import (
 	"github.com/xquare-dashboard/pkg/api/routing"
+	"github.com/xquare-dashboard/pkg/middleware"
 	"github.com/xquare-dashboard/pkg/middleware/requestmeta"
 )
 
-// registerRoutes registers all API HTTP routes.
+// registerRoutes registers all API HTTP routes. Every route but the login
+// ones requires an identity, viewers can query and read, changes require the
+// editor role and the management of notifications and service accounts the
+// admin role. Datasources are added by admins, their permissions decide who
+// queries, explores and manages them.
 func (hs *HTTPServer) registerRoutes() {
+	reqSignedIn := middleware.ReqSignedIn
+	reqEditorRole := middleware.ReqEditorRole
+	reqAdmin := middleware.ReqAdmin
+
 	r := hs.RouteRegister
-	r.Post("/api/test", requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), routing.Wrap(hs.QueryMetrics))
+	r.Get("/login/oauth", routing.Wrap(hs.OAuthLogin))
+	r.Get("/login/oauth/callback", routing.Wrap(hs.OAuthLoginCallback))
+	r.Get("/logout", routing.Wrap(hs.Logout))
+	r.Post("/api/test", reqSignedIn, requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), routing.Wrap(hs.QueryMetrics))
 	r.Group("/api", func(apiRoute routing.RouteRegister) {
+		apiRoute.Get("/user", routing.Wrap(hs.GetSignedInUser))
 		// metrics
 		// DataSource w/ expressions
 		apiRoute.Post("/ds/query", requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), routing.Wrap(hs.QueryMetrics))
+		apiRoute.Post("/ds/query/cancel", routing.Wrap(hs.CancelQueryMetrics))
+		// datasources
+		apiRoute.Group("/datasources", func(datasourceRoute routing.RouteRegister) {
+			datasourceRoute.Get("/", routing.Wrap(hs.GetDataSources))
+			datasourceRoute.Post("/", reqAdmin, routing.Wrap(hs.AddDataSource))
+			datasourceRoute.Get("/uid/:uid", routing.Wrap(hs.GetDataSourceByUID))
+			datasourceRoute.Put("/uid/:uid", routing.Wrap(hs.UpdateDataSourceByUID))
+			datasourceRoute.Delete("/uid/:uid", routing.Wrap(hs.DeleteDataSourceByUID))
+			datasourceRoute.Get("/uid/:uid/health", requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), routing.Wrap(hs.CheckDatasourceHealthWithUID))
+		})
+		apiRoute.Get("/health/datasources", routing.Wrap(hs.GetDatasourcesHealth))
+		// dashboards
+		apiRoute.Group("/dashboards", func(dashboardRoute routing.RouteRegister) {
+			dashboardRoute.Get("/", routing.Wrap(hs.GetDashboards))
+			dashboardRoute.Post("/", reqEditorRole, routing.Wrap(hs.AddDashboard))
+			dashboardRoute.Get("/uid/:uid", routing.Wrap(hs.GetDashboardByUID))
+			dashboardRoute.Put("/uid/:uid", reqEditorRole, routing.Wrap(hs.UpdateDashboardByUID))
+			dashboardRoute.Delete("/uid/:uid", reqEditorRole, routing.Wrap(hs.DeleteDashboardByUID))
+			dashboardRoute.Get("/uid/:uid/versions", routing.Wrap(hs.GetDashboardVersions))
+			dashboardRoute.Get("/uid/:uid/versions/:version", routing.Wrap(hs.GetDashboardVersion))
+			dashboardRoute.Post("/uid/:uid/restore", reqEditorRole, routing.Wrap(hs.RestoreDashboardVersion))
+			dashboardRoute.Post("/uid/:uid/panels/:id/query", requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), routing.Wrap(hs.QueryDashboardPanel))
+		})
+		// alerting
+		apiRoute.Group("/alerting", func(alertingRoute routing.RouteRegister) {
+			alertingRoute.Get("/rules", routing.Wrap(hs.GetAlertRules))
+			alertingRoute.Post("/rules", reqEditorRole, routing.Wrap(hs.AddAlertRule))
+			alertingRoute.Get("/rules/uid/:uid", routing.Wrap(hs.GetAlertRuleByUID))
+			alertingRoute.Put("/rules/uid/:uid", reqEditorRole, routing.Wrap(hs.UpdateAlertRuleByUID))
+			alertingRoute.Delete("/rules/uid/:uid", reqEditorRole, routing.Wrap(hs.DeleteAlertRuleByUID))
+			alertingRoute.Get("/state", routing.Wrap(hs.GetAlertState))
+			alertingRoute.Get("/notifications", reqAdmin, routing.Wrap(hs.GetNotificationConfig))
+			alertingRoute.Put("/notifications", reqAdmin, routing.Wrap(hs.SaveNotificationConfig))
+			alertingRoute.Get("/silences", routing.Wrap(hs.GetSilences))
+			alertingRoute.Post("/silences", reqEditorRole, routing.Wrap(hs.AddSilence))
+			alertingRoute.Get("/silences/id/:id", routing.Wrap(hs.GetSilenceByID))
+			alertingRoute.Delete("/silences/id/:id", reqEditorRole, routing.Wrap(hs.ExpireSilenceByID))
+		})
+		// annotations
+		apiRoute.Group("/annotations", func(annotationsRoute routing.RouteRegister) {
+			annotationsRoute.Get("/", routing.Wrap(hs.GetAnnotations))
+			annotationsRoute.Post("/", reqEditorRole, routing.Wrap(hs.AddAnnotation))
+			annotationsRoute.Post("/query", requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), routing.Wrap(hs.QueryAnnotations))
+			annotationsRoute.Get("/:annotationId", routing.Wrap(hs.GetAnnotationByID))
+			annotationsRoute.Put("/:annotationId", reqEditorRole, routing.Wrap(hs.UpdateAnnotation))
+			annotationsRoute.Delete("/:annotationId", reqEditorRole, routing.Wrap(hs.DeleteAnnotationByID))
+		})
+		// recording rules
+		apiRoute.Group("/recording", func(recordingRoute routing.RouteRegister) {
+			recordingRoute.Get("/rules", routing.Wrap(hs.GetRecordingRules))
+			recordingRoute.Post("/rules", reqEditorRole, routing.Wrap(hs.AddRecordingRule))
+			recordingRoute.Get("/rules/uid/:uid", routing.Wrap(hs.GetRecordingRuleByUID))
+			recordingRoute.Put("/rules/uid/:uid", reqEditorRole, routing.Wrap(hs.UpdateRecordingRuleByUID))
+			recordingRoute.Delete("/rules/uid/:uid", reqEditorRole, routing.Wrap(hs.DeleteRecordingRuleByUID))
+			recordingRoute.Get("/status", routing.Wrap(hs.GetRecordingStatus))
+		})
+		// service accounts
+		apiRoute.Group("/serviceaccounts", func(serviceAccountsRoute routing.RouteRegister) {
+			serviceAccountsRoute.Get("/", routing.Wrap(hs.GetServiceAccounts))
+			serviceAccountsRoute.Post("/", routing.Wrap(hs.AddServiceAccount))
+			serviceAccountsRoute.Get("/uid/:uid", routing.Wrap(hs.GetServiceAccountByUID))
+			serviceAccountsRoute.Put("/uid/:uid", routing.Wrap(hs.UpdateServiceAccountByUID))
+			serviceAccountsRoute.Delete("/uid/:uid", routing.Wrap(hs.DeleteServiceAccountByUID))
+			serviceAccountsRoute.Get("/uid/:uid/tokens", routing.Wrap(hs.GetServiceAccountTokens))
+			serviceAccountsRoute.Post("/uid/:uid/tokens", routing.Wrap(hs.AddServiceAccountToken))
+			serviceAccountsRoute.Delete("/uid/:uid/tokens/:tokenId", routing.Wrap(hs.DeleteServiceAccountToken))
+		}, reqAdmin)
+		apiRoute.Post("/variables/query", requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), routing.Wrap(hs.QueryVariable))
+		apiRoute.Get("/live/ds/:uid/tail", hs.TailDatasource)
 		apiRoute.Any("/datasources/uid/:uid/resources/*", requestmeta.SetSLOGroup(requestmeta.SLOGroupHighSlow), hs.CallDatasourceResourceWithUID)
-	})
+	}, reqSignedIn)
 
 }
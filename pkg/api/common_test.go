@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/api/routing"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/plugins"
+	"github.com/xquare-dashboard/pkg/services/admission"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/caching"
+	"github.com/xquare-dashboard/pkg/services/contexthandler"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	datasourcesservice "github.com/xquare-dashboard/pkg/services/datasources/service"
+	"github.com/xquare-dashboard/pkg/services/live"
+	"github.com/xquare-dashboard/pkg/services/pluginsintegration/plugincontext"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/variables"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// testIdentityHeader names the identity of testIdentities a test request is
+// made by.
+const testIdentityHeader = "X-Test-Identity"
+
+var testIdentities = map[string]*authn.Identity{
+	"viewer": {Kind: authn.KindUser, ID: "viewer", Login: "viewer", Role: authn.RoleViewer},
+	"editor": {Kind: authn.KindUser, ID: "editor", Login: "editor", Role: authn.RoleEditor},
+	"sre":    {Kind: authn.KindUser, ID: "sre", Login: "sre", Role: authn.RoleViewer, Groups: []string{"sre"}},
+	"admin":  {Kind: authn.KindUser, ID: "admin", Login: "admin", Role: authn.RoleAdmin},
+}
+
+// fakeAuthn authenticates requests as the identity of their testIdentityHeader.
+type fakeAuthn struct{}
+
+func (fakeAuthn) Authenticate(_ context.Context, r *http.Request) (*authn.Identity, error) {
+	return testIdentities[r.Header.Get(testIdentityHeader)], nil
+}
+
+func (fakeAuthn) OAuthLoginURL(string, string) (string, error) {
+	return "", authn.ErrOAuthDisabled
+}
+
+func (fakeAuthn) OAuthLogin(context.Context, string, string) (*authn.Session, error) {
+	return nil, authn.ErrOAuthDisabled
+}
+
+func (fakeAuthn) Logout(context.Context, string) error {
+	return nil
+}
+
+// fakePluginStore has a plugin for every ID.
+type fakePluginStore struct{}
+
+func (fakePluginStore) Plugin(_ context.Context, id string) (*plugins.Plugin, bool) {
+	return &plugins.Plugin{ID: id}, true
+}
+
+// fakePluginClient answers with the functions that are set and fails
+// otherwise.
+type fakePluginClient struct {
+	queryData    func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error)
+	checkHealth  func(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error)
+	callResource func(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error
+}
+
+var _ plugins.Client = (*fakePluginClient)(nil)
+
+func (c *fakePluginClient) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	if c.queryData == nil {
+		return nil, plugins.ErrMethodNotImplemented
+	}
+	return c.queryData(ctx, req)
+}
+
+func (c *fakePluginClient) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	if c.checkHealth == nil {
+		return nil, plugins.ErrMethodNotImplemented
+	}
+	return c.checkHealth(ctx, req)
+}
+
+func (c *fakePluginClient) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if c.callResource == nil {
+		return plugins.ErrMethodNotImplemented
+	}
+	return c.callResource(ctx, req, sender)
+}
+
+func (c *fakePluginClient) CollectMetrics(context.Context, *backend.CollectMetricsRequest) (*backend.CollectMetricsResult, error) {
+	return nil, plugins.ErrMethodNotImplemented
+}
+
+func (c *fakePluginClient) SubscribeStream(context.Context, *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	return nil, plugins.ErrMethodNotImplemented
+}
+
+func (c *fakePluginClient) PublishStream(context.Context, *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return nil, plugins.ErrMethodNotImplemented
+}
+
+func (c *fakePluginClient) RunStream(context.Context, *backend.RunStreamRequest, *backend.StreamSender) error {
+	return plugins.ErrMethodNotImplemented
+}
+
+type testServer struct {
+	hs          *HTTPServer
+	client      *fakePluginClient
+	dataSources *datasourcesservice.Service
+}
+
+// setupTestServer returns an HTTPServer with the datasource, query, variables
+// and live services, whose plugins are answered by the returned client. The
+// options change the default configuration.
+func setupTestServer(t *testing.T, opts ...func(cfg *setting.Cfg)) *testServer {
+	t.Helper()
+	cfg := &setting.Cfg{DataPath: t.TempDir(), QueryMaxConcurrent: 10, QueryMaxQueue: 10, QueryMaxQueuePerTenant: 10}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+	cachingService, err := caching.ProvideService(cfg, prometheus.NewRegistry())
+	require.NoError(t, err)
+	dataSources, err := datasourcesservice.ProvideService(cfg)
+	require.NoError(t, err)
+	client := &fakePluginClient{}
+	pCtxProvider := plugincontext.ProvideService(fakePluginStore{}, dataSources)
+
+	hs := &HTTPServer{
+		log:                log.New("http.server"),
+		web:                web.New(),
+		RouteRegister:      routing.NewRouteRegister(),
+		pluginStore:        fakePluginStore{},
+		pluginClient:       client,
+		ContextHandler:     contexthandler.ProvideService(fakeAuthn{}),
+		pCtxProvider:       pCtxProvider,
+		queryDataService:   query.ProvideService(cfg, pCtxProvider, client, dataSources, cachingService, admission.NewService(cfg)),
+		DataSourcesService: dataSources,
+		liveService:        live.ProvideService(client),
+		variablesService:   variables.ProvideService(pCtxProvider, client),
+	}
+	hs.registerRoutes()
+	hs.applyRoutes()
+	return &testServer{hs: hs, client: client, dataSources: dataSources}
+}
+
+// addDataSource adds a Loki datasource with the given uid and permissions.
+func (s *testServer) addDataSource(t *testing.T, uid string, permissions ...*datasources.DataSourcePermission) {
+	t.Helper()
+	_, err := s.dataSources.AddDataSource(context.Background(), &datasources.AddDataSourceCommand{
+		UID: uid, Name: uid, Type: datasources.LokiType, URL: "http://" + uid + ":3100", Permissions: permissions,
+	})
+	require.NoError(t, err)
+}
+
+// request serves a request of the identity with the given name, none when
+// empty.
+func (s *testServer) request(t *testing.T, method, path, identity, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if identity != "" {
+		r.Header.Set(testIdentityHeader, identity)
+	}
+	rec := httptest.NewRecorder()
+	s.hs.web.ServeHTTP(rec, r)
+	return rec
+}
+
+// decode decodes the JSON body of rec.
+func decode(t *testing.T, rec *httptest.ResponseRecorder) map[string]any {
+	t.Helper()
+	b, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	result := map[string]any{}
+	require.NoError(t, json.Unmarshal(b, &result), string(b))
+	return result
+}
+
+// requireAccessDenied asserts that rec is the response to a request without
+// permission on the datasource with the given uid.
+func requireAccessDenied(t *testing.T, rec *httptest.ResponseRecorder, permission datasources.Permission, uid string) {
+	t.Helper()
+	require.Equal(t, http.StatusForbidden, rec.Code, rec.Body.String())
+	body := decode(t, rec)
+	require.Equal(t, "datasource.accessDenied", body["messageId"])
+	require.Equal(t, "The "+string(permission)+" permission on datasource "+uid+" is required.", body["message"])
+}
@@ -0,0 +1,239 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// swagger:route GET /dashboards dashboards getDashboards
+//
+// Get all dashboards, optionally filtered by title with query and by tag.
+//
+// Responses:
+// 200: getDashboardsResponse
+// 500: internalServerError
+func (hs *HTTPServer) GetDashboards(c *contextmodel.ReqContext) response.Response {
+	dashs, err := hs.DashboardService.GetDashboards(c.Req.Context(), &dashboards.GetDashboardsQuery{
+		Query: c.Req.URL.Query().Get("query"),
+		Tag:   c.Req.URL.Query().Get("tag"),
+	})
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query dashboards", err)
+	}
+	return response.JSON(http.StatusOK, dashs)
+}
+
+// swagger:route GET /dashboards/uid/{uid} dashboards getDashboardByUID
+//
+// Get a dashboard by UID.
+//
+// Responses:
+// 200: dashboardResponse
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetDashboardByUID(c *contextmodel.ReqContext) response.Response {
+	dash, err := hs.DashboardService.GetDashboard(c.Req.Context(), &dashboards.GetDashboardQuery{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to query dashboard")
+	}
+	return response.JSON(http.StatusOK, dash)
+}
+
+// swagger:route POST /dashboards dashboards addDashboard
+//
+// Create a dashboard.
+//
+// Responses:
+// 200: saveDashboardResponse
+// 400: badRequestError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) AddDashboard(c *contextmodel.ReqContext) response.Response {
+	cmd := dashboards.AddDashboardCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	dash, err := hs.DashboardService.AddDashboard(c.Req.Context(), &cmd)
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to add dashboard")
+	}
+	return saveDashboardResponse("Dashboard added", dash)
+}
+
+// swagger:route PUT /dashboards/uid/{uid} dashboards updateDashboardByUID
+//
+// Update a dashboard, the previous definition is kept as a version.
+//
+// Set version to the version the update is based on to fail with 409
+// when the dashboard was changed in the meantime.
+//
+// Responses:
+// 200: saveDashboardResponse
+// 400: badRequestError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateDashboardByUID(c *contextmodel.ReqContext) response.Response {
+	cmd := dashboards.UpdateDashboardCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.UID = web.Params(c.Req)[":uid"]
+
+	dash, err := hs.DashboardService.UpdateDashboard(c.Req.Context(), &cmd)
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to update dashboard")
+	}
+	return saveDashboardResponse("Dashboard updated", dash)
+}
+
+// swagger:route DELETE /dashboards/uid/{uid} dashboards deleteDashboardByUID
+//
+// Delete a dashboard and its versions.
+//
+// Responses:
+// 200: okResponse
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteDashboardByUID(c *contextmodel.ReqContext) response.Response {
+	err := hs.DashboardService.DeleteDashboard(c.Req.Context(), &dashboards.DeleteDashboardCommand{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to delete dashboard")
+	}
+	return response.Success("Dashboard deleted")
+}
+
+// swagger:route GET /dashboards/uid/{uid}/versions dashboards getDashboardVersionsByUID
+//
+// Get the saved versions of a dashboard, newest first.
+//
+// Responses:
+// 200: dashboardVersionsResponse
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetDashboardVersions(c *contextmodel.ReqContext) response.Response {
+	versions, err := hs.DashboardService.GetDashboardVersions(c.Req.Context(), &dashboards.GetDashboardVersionsQuery{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to query dashboard versions")
+	}
+	return response.JSON(http.StatusOK, versions)
+}
+
+// swagger:route GET /dashboards/uid/{uid}/versions/{version} dashboards getDashboardVersionByUID
+//
+// Get a saved version of a dashboard.
+//
+// Responses:
+// 200: dashboardResponse
+// 400: badRequestError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetDashboardVersion(c *contextmodel.ReqContext) response.Response {
+	version, err := strconv.Atoi(web.Params(c.Req)[":version"])
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "version is invalid", err)
+	}
+
+	dash, err := hs.DashboardService.GetDashboardVersion(c.Req.Context(), &dashboards.GetDashboardVersionQuery{
+		UID:     web.Params(c.Req)[":uid"],
+		Version: version,
+	})
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to query dashboard version")
+	}
+	return response.JSON(http.StatusOK, dash)
+}
+
+// swagger:route POST /dashboards/uid/{uid}/restore dashboards restoreDashboardVersionByUID
+//
+// Restore a saved version of a dashboard as its latest version.
+//
+// Responses:
+// 200: saveDashboardResponse
+// 400: badRequestError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) RestoreDashboardVersion(c *contextmodel.ReqContext) response.Response {
+	cmd := dashboards.RestoreDashboardVersionCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.UID = web.Params(c.Req)[":uid"]
+
+	dash, err := hs.DashboardService.RestoreDashboardVersion(c.Req.Context(), &cmd)
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to restore dashboard version")
+	}
+	return saveDashboardResponse("Dashboard restored", dash)
+}
+
+// swagger:route POST /dashboards/uid/{uid}/panels/{id}/query dashboards queryDashboardPanel
+//
+// Run the queries of a stored panel.
+//
+// The body is optional, from, to, maxDataPoints and intervalMs default to the
+// time range of the dashboard and the settings of the panel.
+//
+// Responses:
+// 200: queryMetricsWithExpressionsRespons
+// 400: badRequestError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) QueryDashboardPanel(c *contextmodel.ReqContext) response.Response {
+	cmd := dashboards.PanelQueryCommand{}
+	if c.Req.ContentLength != 0 {
+		if err := web.Bind(c.Req, &cmd); err != nil {
+			return response.Error(http.StatusBadRequest, "bad request data", err)
+		}
+	}
+	panelID, err := strconv.ParseInt(web.Params(c.Req)[":id"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "panel id is invalid", err)
+	}
+	cmd.UID = web.Params(c.Req)[":uid"]
+	cmd.PanelID = panelID
+
+	reqDTO, err := hs.DashboardService.GetPanelQuery(c.Req.Context(), &cmd)
+	if err != nil {
+		return dashboardErrorResponse(err, "Failed to build panel query")
+	}
+
+	resp, err := hs.queryDataService.QueryData(c.Req.Context(), reqDTO)
+	if err != nil {
+		return hs.handleQueryMetricsError(err)
+	}
+	return hs.toStreamingResponse(c.Req.Context(), response.NegotiateFrameFormat(c.Req.Header.Get("Accept")), resp)
+}
+
+func saveDashboardResponse(message string, dash *dashboards.Dashboard) response.Response {
+	return response.JSON(http.StatusOK, map[string]any{
+		"message":   message,
+		"uid":       dash.UID,
+		"version":   dash.Version,
+		"dashboard": dash,
+	})
+}
+
+func dashboardErrorResponse(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, dashboards.ErrDashboardNotFound):
+		return response.Error(http.StatusNotFound, "Dashboard not found", nil)
+	case errors.Is(err, dashboards.ErrDashboardVersionNotFound):
+		return response.Error(http.StatusNotFound, "Dashboard version not found", nil)
+	case errors.Is(err, dashboards.ErrDashboardPanelNotFound):
+		return response.Error(http.StatusNotFound, "Panel not found", nil)
+	case errors.Is(err, dashboards.ErrDashboardUidExists), errors.Is(err, dashboards.ErrDashboardWithSameNameExists):
+		return response.Error(http.StatusConflict, err.Error(), err)
+	case errors.Is(err, dashboards.ErrDashboardVersionMismatch):
+		return response.Error(http.StatusConflict, "Dashboard has already been updated by someone else. Please reload and try again", err)
+	}
+	return response.ErrOrFallback(http.StatusInternalServerError, message, err)
+}
@@ -0,0 +1,166 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/api/response"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// swagger:route GET /datasources datasources getDataSources
+//
+// Get the data sources the signed in identity may query.
+//
+// Responses:
+// 200: getDataSourcesResponse
+// 500: internalServerError
+func (hs *HTTPServer) GetDataSources(c *contextmodel.ReqContext) response.Response {
+	dss, err := hs.DataSourcesService.GetDataSources(c.Req.Context(), &datasources.GetDataSourcesQuery{})
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query datasources", err)
+	}
+
+	result := make(dtos.DataSourceList, 0, len(dss))
+	for _, ds := range dss {
+		if ds.PermissionOf(c.Identity).Includes(datasources.PermissionQuery) {
+			result = append(result, dtos.NewDataSource(ds))
+		}
+	}
+	return response.JSON(http.StatusOK, &result)
+}
+
+// swagger:route GET /datasources/uid/{uid} datasources getDataSourceByUID
+//
+// Get a single data source by UID.
+//
+// Responses:
+// 200: getDataSourceResponse
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetDataSourceByUID(c *contextmodel.ReqContext) response.Response {
+	ds, err := hs.getDataSourceWithPermission(c, datasources.PermissionQuery)
+	if err != nil {
+		return dataSourceErrorResponse(err, "Failed to query datasource")
+	}
+	return response.JSON(http.StatusOK, dtos.NewDataSource(ds))
+}
+
+// swagger:route POST /datasources datasources addDataSource
+//
+// Create a data source.
+//
+// Responses:
+// 200: createOrUpdateDatasourceResponse
+// 400: badRequestError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) AddDataSource(c *contextmodel.ReqContext) response.Response {
+	cmd := datasources.AddDataSourceCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	ds, err := hs.DataSourcesService.AddDataSource(c.Req.Context(), &cmd)
+	if err != nil {
+		return dataSourceErrorResponse(err, "Failed to add datasource")
+	}
+
+	return response.JSON(http.StatusOK, map[string]any{
+		"message":    "Datasource added",
+		"id":         ds.ID,
+		"uid":        ds.UID,
+		"name":       ds.Name,
+		"datasource": dtos.NewDataSource(ds),
+	})
+}
+
+// swagger:route PUT /datasources/uid/{uid} datasources updateDataSourceByUID
+//
+// Update an existing data source.
+//
+// Set version to the version the update is based on to fail with 409
+// when the data source was changed in the meantime.
+//
+// Responses:
+// 200: createOrUpdateDatasourceResponse
+// 400: badRequestError
+// 403: forbiddenError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateDataSourceByUID(c *contextmodel.ReqContext) response.Response {
+	cmd := datasources.UpdateDataSourceCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.UID = web.Params(c.Req)[":uid"]
+	if _, err := hs.getDataSourceWithPermission(c, datasources.PermissionAdmin); err != nil {
+		return dataSourceErrorResponse(err, "Failed to update datasource")
+	}
+
+	ds, err := hs.DataSourcesService.UpdateDataSource(c.Req.Context(), &cmd)
+	if err != nil {
+		return dataSourceErrorResponse(err, "Failed to update datasource")
+	}
+
+	return response.JSON(http.StatusOK, map[string]any{
+		"message":    "Datasource updated",
+		"id":         ds.ID,
+		"uid":        ds.UID,
+		"name":       ds.Name,
+		"datasource": dtos.NewDataSource(ds),
+	})
+}
+
+// swagger:route DELETE /datasources/uid/{uid} datasources deleteDataSourceByUID
+//
+// Delete an existing data source by UID.
+//
+// Responses:
+// 200: okResponse
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteDataSourceByUID(c *contextmodel.ReqContext) response.Response {
+	if _, err := hs.getDataSourceWithPermission(c, datasources.PermissionAdmin); err != nil {
+		return dataSourceErrorResponse(err, "Failed to delete datasource")
+	}
+	err := hs.DataSourcesService.DeleteDataSource(c.Req.Context(), &datasources.DeleteDataSourceCommand{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return dataSourceErrorResponse(err, "Failed to delete datasource")
+	}
+	return response.Success("Data source deleted")
+}
+
+// getDataSourceWithPermission returns the datasource of the :uid parameter,
+// or datasources.ErrDataSourceAccessDenied when the identity of c does not
+// have permission on it.
+func (hs *HTTPServer) getDataSourceWithPermission(c *contextmodel.ReqContext, permission datasources.Permission) (*datasources.DataSource, error) {
+	ds, err := hs.DataSourcesService.GetDataSource(c.Req.Context(), &datasources.GetDataSourceQuery{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return nil, err
+	}
+	if err := datasources.CheckPermission(c.Identity, ds, permission); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+func dataSourceErrorResponse(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, datasources.ErrDataSourceNotFound):
+		return response.Error(http.StatusNotFound, "Data source not found", nil)
+	case errors.Is(err, datasources.ErrDataSourceNameExists), errors.Is(err, datasources.ErrDataSourceUidExists):
+		return response.Error(http.StatusConflict, err.Error(), err)
+	case errors.Is(err, datasources.ErrDataSourceUpdatingOldVersion):
+		return response.Error(http.StatusConflict, "Datasource has already been updated by someone else. Please reload and try again", err)
+	case errors.Is(err, datasources.ErrDatasourceIsReadOnly):
+		return response.Error(http.StatusForbidden, "Cannot modify a provisioned datasource", err)
+	}
+	return response.ErrOrFallback(http.StatusInternalServerError, message, err)
+}
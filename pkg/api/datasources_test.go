@@ -0,0 +1,36 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+func TestUpdateDataSourceByUID_Permissions(t *testing.T) {
+	s := setupTestServer(t)
+	s.addDataSource(t, "restricted", restrictedPermissions...)
+	body := `{"name":"restricted","type":"loki","url":"http://loki:3100"}`
+
+	requireAccessDenied(t, s.request(t, http.MethodPut, "/api/datasources/uid/restricted", "editor", body), datasources.PermissionAdmin, "restricted")
+	requireAccessDenied(t, s.request(t, http.MethodPut, "/api/datasources/uid/restricted", "sre", body), datasources.PermissionAdmin, "restricted")
+
+	rec := s.request(t, http.MethodPut, "/api/datasources/uid/restricted", "admin", body)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, "Datasource updated", decode(t, rec)["message"])
+}
+
+func TestDeleteDataSourceByUID_Permissions(t *testing.T) {
+	s := setupTestServer(t)
+	s.addDataSource(t, "restricted", restrictedPermissions...)
+
+	requireAccessDenied(t, s.request(t, http.MethodDelete, "/api/datasources/uid/restricted", "editor", ""), datasources.PermissionAdmin, "restricted")
+	requireAccessDenied(t, s.request(t, http.MethodDelete, "/api/datasources/uid/restricted", "sre", ""), datasources.PermissionAdmin, "restricted")
+
+	rec := s.request(t, http.MethodDelete, "/api/datasources/uid/restricted", "admin", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	rec = s.request(t, http.MethodGet, "/api/datasources/uid/restricted", "admin", "")
+	require.Equal(t, http.StatusNotFound, rec.Code, rec.Body.String())
+}
@@ -0,0 +1,52 @@
+package dtos
+
+import (
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+// DataSource is the API representation of a datasource. Secure settings are
+// never returned, SecureJsonFields only tells which of them are set.
+type DataSource struct {
+	Id               int64                      `json:"id"`
+	UID              string                     `json:"uid"`
+	Name             string                     `json:"name"`
+	Type             datasources.DataSourceType `json:"type"`
+	Url              string                     `json:"url"`
+	BasicAuth        bool                       `json:"basicAuth"`
+	BasicAuthUser    string                     `json:"basicAuthUser"`
+	IsDefault        bool                       `json:"isDefault"`
+	JsonData         *simplejson.Json           `json:"jsonData,omitempty"`
+	SecureJsonFields map[string]bool            `json:"secureJsonFields"`
+	Version          int                        `json:"version"`
+	ReadOnly         bool                       `json:"readOnly"`
+
+	Permissions []*datasources.DataSourcePermission `json:"permissions,omitempty"`
+}
+
+func NewDataSource(ds *datasources.DataSource) *DataSource {
+	secureFields := make(map[string]bool, len(ds.SecureJsonData))
+	for k, v := range ds.SecureJsonData {
+		if v != "" {
+			secureFields[k] = true
+		}
+	}
+
+	return &DataSource{
+		Id:               ds.ID,
+		UID:              ds.UID,
+		Name:             ds.Name,
+		Type:             ds.Type,
+		Url:              ds.URL,
+		BasicAuth:        ds.BasicAuth,
+		BasicAuthUser:    ds.BasicAuthUser,
+		IsDefault:        ds.IsDefault,
+		JsonData:         ds.JsonData,
+		SecureJsonFields: secureFields,
+		Version:          ds.Version,
+		ReadOnly:         ds.ReadOnly,
+		Permissions:      ds.Permissions,
+	}
+}
+
+type DataSourceList []*DataSource
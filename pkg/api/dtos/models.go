@@ -3,6 +3,7 @@ package dtos
 import (
 	"github.com/xquare-dashboard/pkg/components/simplejson"
 	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/templating"
 	"regexp"
 )
 
@@ -30,6 +31,19 @@ type MetricRequest struct {
 	// required: true
 	// example: now
 	To string `json:"to"`
+	// Timezone is the IANA timezone in which relative times are rounded, now/d is the start of the day in it.
+	// Defaults to UTC.
+	// required: false
+	// example: Asia/Seoul
+	Timezone string `json:"timezone,omitempty"`
+	// WeekStart is the first day of the week for now/w, e.g. sunday. Defaults to monday.
+	// required: false
+	// example: sunday
+	WeekStart string `json:"weekStart,omitempty"`
+	// FiscalYearStartMonth is the first month of the fiscal year for now/fy and now/fQ, 0 is January.
+	// required: false
+	// example: 3
+	FiscalYearStartMonth int `json:"fiscalYearStartMonth,omitempty"`
 	// queries.refId – Specifies an identifier of the query. Is optional and default to “A”.
 	// queries.datasourceId – Specifies the data source to be queried. Each query in the request must have an unique datasourceId.
 	// queries.maxDataPoints - Species maximum amount of data points that dashboard panel can render. Is optional and default to 100.
@@ -37,8 +51,21 @@ type MetricRequest struct {
 	// required: true
 	// example: [ { "refId": "A", "intervalMs": 86400000, "maxDataPoints": 1092, "datasource":{ "uid":"PD8C576611E62080A" }, "rawSql": "SELECT 1 as valueOne, 2 as valueTwo", "format": "table" } ]
 	Queries []*simplejson.Json `json:"queries"`
+	// Debug adds the upstream requests, the interpolated expression, the step and
+	// the datasource statistics of every query to the custom meta of its first frame.
 	// required: false
 	Debug bool `json:"debug"`
+	// Variables are the values of the dashboard template variables, they are interpolated in the queries.
+	// A value is a string, a list of strings or an object with value, allValue and options.
+	// required: false
+	// example: { "namespace": "prod", "pod": ["api-0", "api-1"], "job": { "value": "$__all", "allValue": ".*" } }
+	Variables templating.Variables `json:"variables,omitempty"`
+	// Timeout is the maximum duration of the queries, e.g. 30s. It can only
+	// shorten the timeout of the datasources. A query can set its own timeout
+	// too, the queries of a datasource run with the shortest one.
+	// required: false
+	// example: 30s
+	Timeout string `json:"timeout,omitempty"`
 }
 
 func (mr *MetricRequest) GetUniqueDatasourceTypes() []string {
@@ -62,9 +89,14 @@ func (mr *MetricRequest) GetUniqueDatasourceTypes() []string {
 
 func (mr *MetricRequest) CloneWithQueries(queries []*simplejson.Json) MetricRequest {
 	return MetricRequest{
-		From:    mr.From,
-		To:      mr.To,
-		Queries: queries,
-		Debug:   mr.Debug,
+		From:                 mr.From,
+		To:                   mr.To,
+		Timezone:             mr.Timezone,
+		WeekStart:            mr.WeekStart,
+		FiscalYearStartMonth: mr.FiscalYearStartMonth,
+		Queries:              queries,
+		Debug:                mr.Debug,
+		Variables:            mr.Variables,
+		Timeout:              mr.Timeout,
 	}
 }
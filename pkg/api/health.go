@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/plugins"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+const (
+	// healthCheckTimeout bounds a single datasource health check.
+	healthCheckTimeout = 5 * time.Second
+	// healthCacheTTL is how long /api/health reuses the datasource health checks,
+	// so frequent load balancer probes do not turn into queries against every datasource.
+	healthCacheTTL = 10 * time.Second
+)
+
+// swagger:route GET /datasources/uid/{uid}/health datasources checkDatasourceHealthWithUID
+//
+// Sends a health check request to the plugin datasource identified by the UID.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) CheckDatasourceHealthWithUID(c *contextmodel.ReqContext) response.Response {
+	ds, err := hs.DataSourcesService.GetDataSource(c.Req.Context(), &datasources.GetDataSourceQuery{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return dataSourceErrorResponse(err, "Failed to query datasource")
+	}
+	if err := datasources.CheckPermission(c.Identity, ds, datasources.PermissionQuery); err != nil {
+		return response.Err(err)
+	}
+
+	resp, err := hs.checkDatasourceHealth(c.Req.Context(), ds)
+	if err != nil {
+		if errors.Is(err, plugins.ErrPluginNotRegistered) {
+			return response.Error(http.StatusNotFound, "Plugin not found", err)
+		}
+		return response.ErrOrFallback(http.StatusInternalServerError, "Plugin health check failed", err)
+	}
+
+	payload := map[string]any{
+		"status":  resp.Status.String(),
+		"message": resp.Message,
+	}
+
+	// Unmarshal JSONDetails if it's not empty.
+	if len(resp.JSONDetails) > 0 {
+		var jsonDetails map[string]any
+		if err := json.Unmarshal(resp.JSONDetails, &jsonDetails); err != nil {
+			return response.Error(http.StatusInternalServerError, "Failed to unmarshal detailed response from backend plugin", err)
+		}
+		payload["details"] = jsonDetails
+	}
+
+	if resp.Status != backend.HealthStatusOk {
+		return response.JSON(http.StatusBadRequest, payload)
+	}
+	return response.JSON(http.StatusOK, payload)
+}
+
+// swagger:route GET /health/datasources datasources getDatasourcesHealth
+//
+// Returns the aggregated health of the server and the cached health of every
+// datasource the identity may query.
+//
+// Responses:
+// 200: okResponse
+// 401: unauthorisedError
+// 500: internalServerError
+// 503: serviceUnavailableError
+func (hs *HTTPServer) GetDatasourcesHealth(c *contextmodel.ReqContext) response.Response {
+	report := hs.datasourcesHealth(c.Req.Context())
+	byUID := make(map[string]*datasources.DataSource, len(report.Datasources))
+	if len(report.Datasources) > 0 {
+		dss, err := hs.DataSourcesService.GetDataSources(c.Req.Context(), &datasources.GetDataSourcesQuery{})
+		if err != nil {
+			return response.Error(http.StatusInternalServerError, "Failed to query datasources", err)
+		}
+		for _, ds := range dss {
+			byUID[ds.UID] = ds
+		}
+	}
+
+	visible := &healthReport{Status: report.Status, Datasources: []datasourceHealth{}}
+	for _, health := range report.Datasources {
+		if ds, ok := byUID[health.UID]; ok && datasources.CheckPermission(c.Identity, ds, datasources.PermissionQuery) == nil {
+			visible.Datasources = append(visible.Datasources, health)
+		}
+	}
+	if report.Status == healthStatusFailing {
+		return response.JSON(http.StatusServiceUnavailable, visible)
+	}
+	return response.JSON(http.StatusOK, visible)
+}
+
+func (hs *HTTPServer) checkDatasourceHealth(ctx context.Context, ds *datasources.DataSource) (*backend.CheckHealthResult, error) {
+	pCtx, err := hs.pCtxProvider.GetWithDataSource(ctx, string(ds.Type), ds)
+	if err != nil {
+		return nil, err
+	}
+	return hs.pluginClient.CheckHealth(ctx, &backend.CheckHealthRequest{PluginContext: pCtx})
+}
+
+type datasourceHealth struct {
+	UID     string `json:"uid"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+type healthReport struct {
+	Status      string             `json:"status"`
+	Datasources []datasourceHealth `json:"datasources"`
+}
+
+// Statuses of the health report.
+const (
+	// healthStatusOK means the server and all datasources are healthy.
+	healthStatusOK = "ok"
+	// healthStatusDegraded means the server is healthy but some datasources
+	// are not.
+	healthStatusDegraded = "degraded"
+	// healthStatusFailing means the server can not serve requests.
+	healthStatusFailing = "failing"
+)
+
+// healthCache holds the last aggregated health report.
+type healthCache struct {
+	group   singleflight.Group
+	mu      sync.Mutex
+	checked time.Time
+	report  *healthReport
+}
+
+// datasourcesHealth returns the health report of all datasources. The report
+// is cached for healthCacheTTL, concurrent callers share a single round of
+// checks.
+func (hs *HTTPServer) datasourcesHealth(ctx context.Context) *healthReport {
+	hs.healthCache.mu.Lock()
+	report, checked := hs.healthCache.report, hs.healthCache.checked
+	hs.healthCache.mu.Unlock()
+	if report != nil && time.Since(checked) < healthCacheTTL {
+		return report
+	}
+
+	// The report is shared with other callers, so a caller going away must not
+	// fail the checks.
+	ctx = context.WithoutCancel(ctx)
+	v, _, _ := hs.healthCache.group.Do("health", func() (any, error) {
+		report, err := hs.checkDatasourcesHealth(ctx)
+		if err != nil {
+			hs.log.Error("Failed to list datasources for health check", "error", err)
+			return &healthReport{Status: healthStatusFailing, Datasources: []datasourceHealth{}}, nil
+		}
+
+		hs.healthCache.mu.Lock()
+		hs.healthCache.report = report
+		hs.healthCache.checked = time.Now()
+		hs.healthCache.mu.Unlock()
+		return report, nil
+	})
+	return v.(*healthReport)
+}
+
+// checkDatasourcesHealth checks all datasources concurrently.
+func (hs *HTTPServer) checkDatasourcesHealth(ctx context.Context) (*healthReport, error) {
+	dss, err := hs.DataSourcesService.GetDataSources(ctx, &datasources.GetDataSourcesQuery{})
+	if err != nil {
+		return nil, err
+	}
+
+	report := &healthReport{Status: healthStatusOK, Datasources: make([]datasourceHealth, len(dss))}
+	var wg sync.WaitGroup
+	for i, ds := range dss {
+		wg.Add(1)
+		go func(i int, ds *datasources.DataSource) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			health := datasourceHealth{UID: ds.UID, Name: ds.Name, Type: string(ds.Type)}
+			if resp, err := hs.checkDatasourceHealth(ctx, ds); err != nil {
+				health.Status = backend.HealthStatusError.String()
+				health.Message = err.Error()
+			} else {
+				health.Status = resp.Status.String()
+				health.Message = resp.Message
+			}
+			report.Datasources[i] = health
+		}(i, ds)
+	}
+	wg.Wait()
+
+	for _, health := range report.Datasources {
+		if health.Status != backend.HealthStatusOk.String() {
+			report.Status = healthStatusDegraded
+		}
+	}
+	return report, nil
+}
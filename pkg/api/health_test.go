@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+// failingHealth fails the health checks of the datasource with the uid "down".
+func failingHealth(calls *atomic.Int32) func(context.Context, *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+	return func(_ context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+		calls.Add(1)
+		if req.PluginContext.DataSourceInstanceSettings.UID == "down" {
+			return &backend.CheckHealthResult{Status: backend.HealthStatusError, Message: "connection refused"}, nil
+		}
+		return &backend.CheckHealthResult{Status: backend.HealthStatusOk, Message: "Data source is working"}, nil
+	}
+}
+
+func TestCheckDatasourceHealthWithUID(t *testing.T) {
+	s := setupTestServer(t)
+	var calls atomic.Int32
+	s.client.checkHealth = failingHealth(&calls)
+	s.addDataSource(t, "up")
+	s.addDataSource(t, "down")
+
+	t.Run("healthy datasource", func(t *testing.T) {
+		rec := s.request(t, http.MethodGet, "/api/datasources/uid/up/health", "viewer", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, map[string]any{"status": "OK", "message": "Data source is working"}, decode(t, rec))
+	})
+
+	t.Run("unhealthy datasource", func(t *testing.T) {
+		rec := s.request(t, http.MethodGet, "/api/datasources/uid/down/health", "viewer", "")
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Equal(t, map[string]any{"status": "ERROR", "message": "connection refused"}, decode(t, rec))
+	})
+
+	t.Run("unknown datasource", func(t *testing.T) {
+		rec := s.request(t, http.MethodGet, "/api/datasources/uid/missing/health", "viewer", "")
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("failed health check", func(t *testing.T) {
+		s.client.checkHealth = func(context.Context, *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
+			return nil, errors.New("plugin crashed")
+		}
+		t.Cleanup(func() { s.client.checkHealth = failingHealth(&calls) })
+
+		rec := s.request(t, http.MethodGet, "/api/datasources/uid/up/health", "viewer", "")
+		require.Equal(t, http.StatusInternalServerError, rec.Code)
+	})
+}
+
+func TestAPIHealth(t *testing.T) {
+	t.Run("all datasources healthy", func(t *testing.T) {
+		s := setupTestServer(t)
+		var calls atomic.Int32
+		s.client.checkHealth = failingHealth(&calls)
+		s.addDataSource(t, "up")
+
+		rec := s.request(t, http.MethodGet, "/api/health", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, map[string]any{"status": healthStatusOK}, decode(t, rec))
+	})
+
+	t.Run("failing datasource degrades the report", func(t *testing.T) {
+		s := setupTestServer(t)
+		var calls atomic.Int32
+		s.client.checkHealth = failingHealth(&calls)
+		s.addDataSource(t, "up")
+		s.addDataSource(t, "down")
+
+		rec := s.request(t, http.MethodGet, "/api/health", "", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, map[string]any{"status": healthStatusDegraded}, decode(t, rec))
+
+		rec = s.request(t, http.MethodGet, "/api/health/datasources", "viewer", "")
+		require.Equal(t, http.StatusOK, rec.Code)
+		report := decode(t, rec)
+		require.Equal(t, healthStatusDegraded, report["status"])
+		require.ElementsMatch(t, []any{
+			map[string]any{"uid": "up", "name": "up", "type": "loki", "status": "OK", "message": "Data source is working"},
+			map[string]any{"uid": "down", "name": "down", "type": "loki", "status": "ERROR", "message": "connection refused"},
+		}, report["datasources"])
+	})
+
+	t.Run("report is cached", func(t *testing.T) {
+		s := setupTestServer(t)
+		var calls atomic.Int32
+		s.client.checkHealth = failingHealth(&calls)
+		s.addDataSource(t, "up")
+		s.addDataSource(t, "down")
+
+		for i := 0; i < 3; i++ {
+			rec := s.request(t, http.MethodGet, "/api/health", "", "")
+			require.Equal(t, http.StatusOK, rec.Code)
+		}
+		require.EqualValues(t, 2, calls.Load())
+	})
+}
+
+func TestGetDatasourcesHealth(t *testing.T) {
+	s := setupTestServer(t)
+	var calls atomic.Int32
+	s.client.checkHealth = failingHealth(&calls)
+	s.addDataSource(t, "up")
+	s.addDataSource(t, "down", &datasources.DataSourcePermission{Team: "sre", Permission: datasources.PermissionQuery})
+
+	t.Run("requires authentication", func(t *testing.T) {
+		rec := s.request(t, http.MethodGet, "/api/health/datasources", "", "")
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("lists the datasources the identity may query", func(t *testing.T) {
+		uids := func(identity string) []any {
+			rec := s.request(t, http.MethodGet, "/api/health/datasources", identity, "")
+			require.Equal(t, http.StatusOK, rec.Code)
+			report := decode(t, rec)
+			require.Equal(t, healthStatusDegraded, report["status"])
+			var uids []any
+			for _, ds := range report["datasources"].([]any) {
+				uids = append(uids, ds.(map[string]any)["uid"])
+			}
+			return uids
+		}
+		require.ElementsMatch(t, []any{"up"}, uids("viewer"))
+		require.ElementsMatch(t, []any{"up", "down"}, uids("sre"))
+		require.ElementsMatch(t, []any{"up", "down"}, uids("admin"))
+	})
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/prometheus/client_golang/prometheus"
@@ -10,17 +11,25 @@ import (
 	"github.com/xquare-dashboard/pkg/middleware"
 	"github.com/xquare-dashboard/pkg/plugins"
 	"github.com/xquare-dashboard/pkg/plugins/manager/store"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/services/annotations"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/live"
 	"github.com/xquare-dashboard/pkg/services/pluginsintegration/plugincontext"
+	"github.com/xquare-dashboard/pkg/services/recording"
+	"github.com/xquare-dashboard/pkg/services/variables"
 	"net"
 	"net/http"
 	"path"
 	"sync"
 
 	httpstatic "github.com/xquare-dashboard/pkg/api/static"
-	"github.com/xquare-dashboard/pkg/components/simplejson"
 	"github.com/xquare-dashboard/pkg/infra/log"
 	"github.com/xquare-dashboard/pkg/services/contexthandler"
 	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
 	"github.com/xquare-dashboard/pkg/web"
 )
 
@@ -35,30 +44,57 @@ type HTTPServer struct {
 	pluginStore      store.Service
 	pluginClient     plugins.Client
 
-	ContextHandler   *contexthandler.ContextHandler
-	pCtxProvider     *plugincontext.Provider
-	queryDataService query.Service
-	promRegister     prometheus.Registerer
-	promGatherer     prometheus.Gatherer
+	ContextHandler      *contexthandler.ContextHandler
+	pCtxProvider        *plugincontext.Provider
+	queryDataService    query.Service
+	DataSourcesService  datasources.DataSourceService
+	DashboardService    dashboards.DashboardService
+	AlertingService     alerting.AlertingService
+	NotificationService alerting.NotificationService
+	RecordingService    recording.RecordingService
+	AnnotationsService  annotations.AnnotationsService
+	ServiceAccounts     serviceaccounts.ServiceAccountService
+	authnService        authn.Service
+	liveService         *live.Service
+	variablesService    *variables.Service
+	promRegister        prometheus.Registerer
+	promGatherer        prometheus.Gatherer
+
+	healthCache healthCache
 }
 
 func ProvideHTTPServer(
 	contextHandler *contexthandler.ContextHandler, queryDataService query.Service,
 	promGatherer prometheus.Gatherer, promRegister prometheus.Registerer, pluginClient plugins.Client,
 	routeRegister routing.RouteRegister, pluginStore store.Service, pCtxProvider *plugincontext.Provider,
+	dataSourcesService datasources.DataSourceService, liveService *live.Service,
+	dashboardService dashboards.DashboardService, variablesService *variables.Service,
+	alertingService alerting.AlertingService, notificationService alerting.NotificationService,
+	recordingService recording.RecordingService, annotationsService annotations.AnnotationsService,
+	serviceAccounts serviceaccounts.ServiceAccountService, authnService authn.Service,
 ) (*HTTPServer, error) {
 	m := web.New()
 	hs := &HTTPServer{
-		ContextHandler:   contextHandler,
-		log:              log.New("http.server"),
-		web:              m,
-		queryDataService: queryDataService,
-		pluginClient:     pluginClient,
-		promRegister:     promRegister,
-		promGatherer:     promGatherer,
-		RouteRegister:    routeRegister,
-		pluginStore:      pluginStore,
-		pCtxProvider:     pCtxProvider,
+		ContextHandler:      contextHandler,
+		log:                 log.New("http.server"),
+		web:                 m,
+		queryDataService:    queryDataService,
+		DataSourcesService:  dataSourcesService,
+		DashboardService:    dashboardService,
+		AlertingService:     alertingService,
+		NotificationService: notificationService,
+		RecordingService:    recordingService,
+		AnnotationsService:  annotationsService,
+		ServiceAccounts:     serviceAccounts,
+		authnService:        authnService,
+		liveService:         liveService,
+		variablesService:    variablesService,
+		pluginClient:        pluginClient,
+		promRegister:        promRegister,
+		promGatherer:        promGatherer,
+		RouteRegister:       routeRegister,
+		pluginStore:         pluginStore,
+		pCtxProvider:        pCtxProvider,
 	}
 	hs.registerRoutes()
 	return hs, nil
@@ -161,21 +197,28 @@ func (hs *HTTPServer) metricsEndpoint(ctx *web.Context) {
 		ServeHTTP(ctx.Resp, ctx.Req)
 }
 
-// apiHealthHandler will return ok if web server is running.
+// apiHealthHandler reports the aggregated health of the server and its
+// datasources. It runs before authentication, so the details of the
+// datasources are left to /api/health/datasources. It responds with 503 only
+// when the server itself can not serve requests, so it can be used as
+// readiness probe; unhealthy datasources make the status "degraded".
 func (hs *HTTPServer) apiHealthHandler(ctx *web.Context) {
 	notHeadOrGet := ctx.Req.Method != http.MethodGet && ctx.Req.Method != http.MethodHead
 	if notHeadOrGet || ctx.Req.URL.Path != "/api/health" {
 		return
 	}
 
-	data := simplejson.New()
-	data.Set("database", "ok")
-	dataBytes, err := data.EncodePretty()
+	report := hs.datasourcesHealth(ctx.Req.Context())
+	dataBytes, err := json.MarshalIndent(map[string]string{"status": report.Status}, "", "  ")
 	if err != nil {
 		hs.log.Error("Failed to encode data", "err", err)
 		return
 	}
 
+	ctx.Resp.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if report.Status == healthStatusFailing {
+		ctx.Resp.WriteHeader(http.StatusServiceUnavailable)
+	}
 	if _, err := ctx.Resp.Write(dataBytes); err != nil {
 		hs.log.Error("Failed to write to response", "err", err)
 	}
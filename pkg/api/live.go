@@ -0,0 +1,217 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/plugins"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/live"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+const (
+	liveWriteTimeout = 10 * time.Second
+	livePingInterval = 30 * time.Second
+)
+
+var liveUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// TailDatasource streams the log lines matching the expr query parameter.
+// Clients requesting a WebSocket upgrade receive one frame per message, all other
+// clients receive server-sent events. The first message is the data cached for the
+// channel, if any.
+//
+// All subscribers of the same query share a single upstream tail.
+func (hs *HTTPServer) TailDatasource(c *contextmodel.ReqContext) {
+	expr := c.Req.URL.Query().Get("expr")
+	if expr == "" {
+		c.JsonApiErr(http.StatusBadRequest, "Missing expr query parameter", nil)
+		return
+	}
+
+	ds, err := hs.DataSourcesService.GetDataSource(c.Req.Context(), &datasources.GetDataSourceQuery{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		if errors.Is(err, datasources.ErrDataSourceNotFound) {
+			c.JsonApiErr(http.StatusNotFound, "Data source not found", nil)
+			return
+		}
+		c.JsonApiErr(http.StatusInternalServerError, "Failed to load data source", err)
+		return
+	}
+	if err := datasources.CheckPermission(c.Identity, ds, datasources.PermissionExplore); err != nil {
+		response.Err(err).WriteTo(c)
+		return
+	}
+
+	pCtx, err := hs.pCtxProvider.GetWithDataSource(c.Req.Context(), string(ds.Type), ds)
+	if err != nil {
+		c.JsonApiErr(http.StatusInternalServerError, "Failed to get plugin settings", err)
+		return
+	}
+
+	data, err := json.Marshal(map[string]string{"expr": expr})
+	if err != nil {
+		c.JsonApiErr(http.StatusInternalServerError, "Failed to encode query", err)
+		return
+	}
+	sum := sha256.Sum256([]byte(expr))
+	path := "tail/" + hex.EncodeToString(sum[:8])
+
+	subResp, err := hs.pluginClient.SubscribeStream(c.Req.Context(), &backend.SubscribeStreamRequest{
+		PluginContext: pCtx,
+		Path:          path,
+		Data:          data,
+	})
+	if err != nil {
+		if errors.Is(err, plugins.ErrMethodNotImplemented) {
+			c.JsonApiErr(http.StatusBadRequest, fmt.Sprintf("Data source type %s does not support streaming", ds.Type), nil)
+			return
+		}
+		c.JsonApiErr(http.StatusInternalServerError, "Failed to subscribe to stream", err)
+		return
+	}
+	switch subResp.Status {
+	case backend.SubscribeStreamStatusOK:
+	case backend.SubscribeStreamStatusPermissionDenied:
+		c.JsonApiErr(http.StatusForbidden, "Permission denied", nil)
+		return
+	default:
+		c.JsonApiErr(http.StatusNotFound, "Stream not found", nil)
+		return
+	}
+
+	sub := hs.liveService.Subscribe(ds.UID+"/"+path, &backend.RunStreamRequest{
+		PluginContext: pCtx,
+		Path:          path,
+		Data:          data,
+	})
+	defer sub.Unsubscribe()
+
+	var initial []byte
+	if subResp.InitialData != nil {
+		initial = subResp.InitialData.Data()
+	}
+
+	if websocket.IsWebSocketUpgrade(c.Req) {
+		hs.serveLiveWebSocket(c, sub, initial)
+		return
+	}
+	hs.serveLiveSSE(c, sub, initial)
+}
+
+func (hs *HTTPServer) serveLiveWebSocket(c *contextmodel.ReqContext, sub *live.Subscription, initial []byte) {
+	conn, err := liveUpgrader.Upgrade(c.Resp, c.Req, nil)
+	if err != nil {
+		// Upgrade already replied with an error.
+		hs.log.Debug("Failed to upgrade to websocket", "error", err)
+		return
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// Nothing is expected from the client, but reading is needed to notice it went away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	write := func(messageType int, msg []byte) error {
+		if err := conn.SetWriteDeadline(time.Now().Add(liveWriteTimeout)); err != nil {
+			return err
+		}
+		return conn.WriteMessage(messageType, msg)
+	}
+
+	if initial != nil {
+		if err := write(websocket.TextMessage, initial); err != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-closed:
+			return
+		case <-ping.C:
+			if err := write(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case msg, ok := <-sub.C:
+			if !ok {
+				_ = write(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "stream ended"))
+				return
+			}
+			if err := write(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (hs *HTTPServer) serveLiveSSE(c *contextmodel.ReqContext, sub *live.Subscription, initial []byte) {
+	flusher, ok := c.Resp.(http.Flusher)
+	if !ok {
+		c.JsonApiErr(http.StatusInternalServerError, "Streaming not supported", nil)
+		return
+	}
+
+	h := c.Resp.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// Disable response buffering in nginx.
+	h.Set("X-Accel-Buffering", "no")
+	c.Resp.WriteHeader(http.StatusOK)
+
+	if initial != nil {
+		if _, err := fmt.Fprintf(c.Resp, "data: %s\n\n", initial); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ping := time.NewTicker(livePingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-c.Req.Context().Done():
+			return
+		case <-ping.C:
+			if _, err := fmt.Fprint(c.Resp, ": ping\n\n"); err != nil {
+				return
+			}
+		case msg, ok := <-sub.C:
+			if !ok {
+				_, _ = fmt.Fprint(c.Resp, "event: close\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			if _, err := fmt.Fprintf(c.Resp, "data: %s\n\n", msg); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,25 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+func TestTailDatasource_Permissions(t *testing.T) {
+	s := setupTestServer(t)
+	s.addDataSource(t, "restricted", restrictedPermissions...)
+	path := "/api/live/ds/restricted/tail?expr=%7Bapp%3D%22api%22%7D"
+
+	requireAccessDenied(t, s.request(t, http.MethodGet, path, "viewer", ""), datasources.PermissionExplore, "restricted")
+	requireAccessDenied(t, s.request(t, http.MethodGet, path, "editor", ""), datasources.PermissionExplore, "restricted")
+
+	// The test client doesn't stream, the request gets past the permission
+	// check.
+	rec := s.request(t, http.MethodGet, path, "sre", "")
+	require.Equal(t, http.StatusBadRequest, rec.Code, rec.Body.String())
+	require.Equal(t, "Data source type loki does not support streaming", decode(t, rec)["message"])
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+)
+
+const (
+	// oauthStateCookieName and oauthVerifierCookieName keep the state and
+	// the PKCE code verifier of an OAuth login until its callback.
+	oauthStateCookieName    = "xquare_oauth_state"
+	oauthVerifierCookieName = "xquare_oauth_verifier"
+	oauthCookiePath         = "/login/oauth"
+	oauthCookieMaxAge       = 10 * time.Minute
+)
+
+// swagger:route GET /login/oauth login oauthLogin
+//
+// Redirect to the identity provider to log in.
+//
+// Responses:
+// 302: redirectResponse
+// 404: notFoundError
+func (hs *HTTPServer) OAuthLogin(c *contextmodel.ReqContext) response.Response {
+	state, verifier := oauth2.GenerateVerifier(), oauth2.GenerateVerifier()
+	url, err := hs.authnService.OAuthLoginURL(state, verifier)
+	if err != nil {
+		return response.ErrOrFallback(http.StatusInternalServerError, "Failed to start OAuth login", err)
+	}
+	setCookie(c, oauthStateCookieName, state, oauthCookiePath, time.Now().Add(oauthCookieMaxAge))
+	setCookie(c, oauthVerifierCookieName, verifier, oauthCookiePath, time.Now().Add(oauthCookieMaxAge))
+	return response.Redirect(url)
+}
+
+// swagger:route GET /login/oauth/callback login oauthLoginCallback
+//
+// Log in with the code of the identity provider and start a session.
+//
+// Responses:
+// 302: redirectResponse
+// 401: unauthorisedError
+// 404: notFoundError
+func (hs *HTTPServer) OAuthLoginCallback(c *contextmodel.ReqContext) response.Response {
+	query := c.Req.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		return response.Err(authn.ErrOAuthLoginFailed.Errorf("the identity provider returned %s: %s", errCode, query.Get("error_description")))
+	}
+	state, err := c.Req.Cookie(oauthStateCookieName)
+	if err != nil || subtle.ConstantTimeCompare([]byte(state.Value), []byte(query.Get("state"))) != 1 {
+		return response.Err(authn.ErrOAuthLoginFailed.Errorf("the state does not match"))
+	}
+	verifier, err := c.Req.Cookie(oauthVerifierCookieName)
+	if err != nil {
+		return response.Err(authn.ErrOAuthLoginFailed.Errorf("no code verifier"))
+	}
+	deleteCookie(c, oauthStateCookieName, oauthCookiePath)
+	deleteCookie(c, oauthVerifierCookieName, oauthCookiePath)
+
+	session, err := hs.authnService.OAuthLogin(c.Req.Context(), query.Get("code"), verifier.Value)
+	if err != nil {
+		return response.ErrOrFallback(http.StatusInternalServerError, "Failed to log in", err)
+	}
+	setCookie(c, authn.SessionCookieName, session.Token, "/", session.Expires)
+	return response.Redirect("/")
+}
+
+// swagger:route GET /logout login logout
+//
+// End the session of the OAuth login.
+//
+// Responses:
+// 302: redirectResponse
+func (hs *HTTPServer) Logout(c *contextmodel.ReqContext) response.Response {
+	if cookie, err := c.Req.Cookie(authn.SessionCookieName); err == nil {
+		if err := hs.authnService.Logout(c.Req.Context(), cookie.Value); err != nil {
+			return response.Error(http.StatusInternalServerError, "Failed to log out", err)
+		}
+	}
+	deleteCookie(c, authn.SessionCookieName, "/")
+	return response.Redirect("/")
+}
+
+func setCookie(c *contextmodel.ReqContext, name, value, path string, expires time.Time) {
+	http.SetCookie(c.Resp, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     path,
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isHTTPS(c.Req),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func deleteCookie(c *contextmodel.ReqContext, name, path string) {
+	http.SetCookie(c.Resp, &http.Cookie{
+		Name:     name,
+		Path:     path,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   isHTTPS(c.Req),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// isHTTPS returns whether r was made over HTTPS, directly or through a proxy.
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
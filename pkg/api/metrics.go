@@ -10,12 +10,16 @@ import (
 	"github.com/xquare-dashboard/pkg/middleware/requestmeta"
 	"github.com/xquare-dashboard/pkg/plugins"
 	"github.com/xquare-dashboard/pkg/plugins/httpresponsesender"
+	"github.com/xquare-dashboard/pkg/services/admission"
 	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
 	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/query"
 	"github.com/xquare-dashboard/pkg/web"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 )
 
 // QueryMetrics returns query metrics.
@@ -43,21 +47,62 @@ func (hs *HTTPServer) QueryMetrics(c *contextmodel.ReqContext) response.Response
 	if err != nil {
 		return hs.handleQueryMetricsError(err)
 	}
-	return hs.toJsonStreamingResponse(c.Req.Context(), resp)
+	return hs.toStreamingResponse(c.Req.Context(), response.NegotiateFrameFormat(c.Req.Header.Get("Accept")), resp)
+}
+
+// CancelQueryMetrics aborts the running queries of a query group.
+// swagger:route POST /ds/query/cancel ds cancelQueryMetrics
+//
+// Cancel the queries of the requests with the same X-Query-Group-Id header
+// made by the same identity. The cancelled requests return the results of the
+// queries that completed and a cancellation error for the others.
+//
+// Responses:
+// 200: okResponse
+// 400: badRequestError
+// 404: notFoundError
+func (hs *HTTPServer) CancelQueryMetrics(c *contextmodel.ReqContext) response.Response {
+	groupID := c.Req.Header.Get(query.HeaderQueryGroupID)
+	if groupID == "" {
+		return response.Error(http.StatusBadRequest, "Missing "+query.HeaderQueryGroupID+" header", nil)
+	}
+	if err := hs.queryDataService.CancelQueryGroup(c.Req.Context(), groupID); err != nil {
+		return response.Err(err)
+	}
+	return response.Success("Queries cancelled")
 }
 
 func (hs *HTTPServer) handleQueryMetricsError(err error) *response.NormalResponse {
-	return response.ErrOrFallback(http.StatusInternalServerError, "Query data error", err)
+	resp := response.ErrOrFallback(http.StatusInternalServerError, "Query data error", err)
+	if retryAfter, ok := admission.RetryAfter(err); ok {
+		resp.SetHeader("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	}
+	return resp
 }
 
-func (hs *HTTPServer) toJsonStreamingResponse(ctx context.Context, qdr *backend.QueryDataResponse) response.Response {
+// toStreamingResponse streams qdr encoded as format. Responses with errors are
+// always JSON, the other formats have no place for them.
+func (hs *HTTPServer) toStreamingResponse(ctx context.Context, format response.FrameFormat, qdr *backend.QueryDataResponse) response.Response {
 	statusWhenError := http.StatusBadRequest
 
 	statusCode := http.StatusOK
+	var retryAfter time.Duration
+	rejected := false
 	for _, res := range qdr.Responses {
 		if res.Error != nil {
 			statusCode = statusWhenError
 		}
+		// Queries rejected by the admission control of their datasource make
+		// the whole request retryable.
+		if d, ok := admission.RetryAfter(res.Error); ok {
+			rejected = true
+			retryAfter = max(retryAfter, d)
+		}
+	}
+
+	if rejected {
+		return response.JSONStreaming(http.StatusTooManyRequests, qdr).
+			SetHeader("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
 	}
 
 	if statusCode == statusWhenError {
@@ -65,6 +110,9 @@ func (hs *HTTPServer) toJsonStreamingResponse(ctx context.Context, qdr *backend.
 		requestmeta.WithDownstreamStatusSource(ctx)
 	}
 
+	if statusCode == http.StatusOK && format != response.FrameFormatJSON {
+		return response.Frames(statusCode, format, qdr)
+	}
 	return response.JSONStreaming(statusCode, qdr)
 }
 
@@ -79,7 +127,19 @@ func (hs *HTTPServer) toJsonStreamingResponse(ctx context.Context, qdr *backend.
 // 500: internalServerError
 func (hs *HTTPServer) CallDatasourceResourceWithUID(c *contextmodel.ReqContext) {
 	dsUID := web.Params(c.Req)[":uid"]
-	ds, _ := datasources.GetDataSource(datasources.DataSourceType(dsUID))
+	ds, err := hs.DataSourcesService.GetDataSource(c.Req.Context(), &datasources.GetDataSourceQuery{UID: dsUID})
+	if err != nil {
+		if errors.Is(err, datasources.ErrDataSourceNotFound) {
+			c.JsonApiErr(http.StatusNotFound, "Data source not found", nil)
+			return
+		}
+		c.JsonApiErr(http.StatusInternalServerError, "Failed to load data source", err)
+		return
+	}
+	if err := datasources.CheckPermission(c.Identity, ds, datasources.PermissionExplore); err != nil {
+		response.Err(err).WriteTo(c)
+		return
+	}
 
 	plugin, exists := hs.pluginStore.Plugin(c.Req.Context(), string(ds.Type))
 	if !exists {
@@ -91,7 +151,7 @@ func (hs *HTTPServer) CallDatasourceResourceWithUID(c *contextmodel.ReqContext)
 }
 
 func (hs *HTTPServer) callPluginResourceWithDataSource(c *contextmodel.ReqContext, pluginID string, ds *datasources.DataSource) {
-	pCtx, err := hs.pCtxProvider.GetWithDataSource(c.Req.Context(), datasources.DataSourceType(pluginID), ds)
+	pCtx, err := hs.pCtxProvider.GetWithDataSource(c.Req.Context(), pluginID, ds)
 	if err != nil {
 		if errors.Is(err, plugins.ErrPluginNotRegistered) {
 			c.JsonApiErr(404, "Plugin not found", nil)
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/caching"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+// answerQueries answers every query with a frame.
+func answerQueries(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	resp := backend.NewQueryDataResponse()
+	for _, q := range req.Queries {
+		resp.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{
+			data.NewFrame(q.RefID, data.NewField("value", nil, []float64{1})),
+		}}
+	}
+	return resp, nil
+}
+
+func TestQueryMetrics_CacheHeader(t *testing.T) {
+	s := setupTestServer(t, func(cfg *setting.Cfg) {
+		cfg.QueryCacheEnabled = true
+		cfg.QueryCacheTTL = time.Minute
+		cfg.QueryCacheMaxSize = 1 << 20
+	})
+	s.client.queryData = answerQueries
+	s.addDataSource(t, "a")
+	s.addDataSource(t, "b")
+
+	query := func(uids ...string) *http.Response {
+		body := `{"from":"1700000000000","to":"1700003600000","queries":[`
+		for i, uid := range uids {
+			if i > 0 {
+				body += ","
+			}
+			body += `{"refId":"` + uid + `","datasource":{"uid":"` + uid + `"},"expr":"up"}`
+		}
+		rec := s.request(t, http.MethodPost, "/api/ds/query", "viewer", body+"]}")
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		return rec.Result()
+	}
+
+	require.Equal(t, []string{string(caching.StatusMiss)}, query("a").Header.Values(caching.HeaderCache))
+	require.Equal(t, []string{string(caching.StatusHit)}, query("a").Header.Values(caching.HeaderCache))
+	require.Equal(t, []string{string(caching.StatusMiss)}, query("a", "b").Header.Values(caching.HeaderCache))
+	require.Equal(t, []string{string(caching.StatusHit)}, query("a", "b").Header.Values(caching.HeaderCache))
+}
+
+// restrictedPermissions let the sre team explore a datasource and editors
+// query it.
+var restrictedPermissions = []*datasources.DataSourcePermission{
+	{Team: "sre", Permission: datasources.PermissionExplore},
+	{Role: authn.RoleEditor, Permission: datasources.PermissionQuery},
+}
+
+func TestQueryMetrics_Permissions(t *testing.T) {
+	s := setupTestServer(t)
+	var queried int
+	s.client.queryData = func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		queried++
+		return answerQueries(ctx, req)
+	}
+	s.addDataSource(t, "open")
+	s.addDataSource(t, "restricted", restrictedPermissions...)
+	body := `{"from":"now-1h","to":"now","queries":[` +
+		`{"refId":"A","datasource":{"uid":"open"},"expr":"up"},` +
+		`{"refId":"B","datasource":{"uid":"restricted"},"expr":"up"}]}`
+
+	requireAccessDenied(t, s.request(t, http.MethodPost, "/api/ds/query", "viewer", body), datasources.PermissionQuery, "restricted")
+	require.Zero(t, queried)
+
+	// Datasources referenced by name are checked too.
+	byName := `{"from":"now-1h","to":"now","queries":[{"refId":"A","datasource":"restricted","expr":"up"}]}`
+	requireAccessDenied(t, s.request(t, http.MethodPost, "/api/ds/query", "viewer", byName), datasources.PermissionQuery, "restricted")
+
+	rec := s.request(t, http.MethodPost, "/api/ds/query", "editor", body)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, 2, queried)
+}
+
+func TestCallDatasourceResourceWithUID_Permissions(t *testing.T) {
+	s := setupTestServer(t)
+	s.client.callResource = func(_ context.Context, _ *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusOK, Body: []byte(`{"status":"success","data":["job"]}`)})
+	}
+	s.addDataSource(t, "restricted", restrictedPermissions...)
+	path := "/api/datasources/uid/restricted/resources/labels"
+
+	requireAccessDenied(t, s.request(t, http.MethodGet, path, "viewer", ""), datasources.PermissionExplore, "restricted")
+	requireAccessDenied(t, s.request(t, http.MethodGet, path, "editor", ""), datasources.PermissionExplore, "restricted")
+
+	rec := s.request(t, http.MethodGet, path, "sre", "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	require.Equal(t, []any{"job"}, decode(t, rec)["data"])
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/recording"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// swagger:route GET /recording/rules recording getRecordingRules
+//
+// Get all recording rules, optionally only the rules of a datasource with
+// datasourceUid. Rules of a datasource the user may not query are left out.
+//
+// Responses:
+// 200: getRecordingRulesResponse
+// 500: internalServerError
+func (hs *HTTPServer) GetRecordingRules(c *contextmodel.ReqContext) response.Response {
+	query := recording.GetRulesQuery{DatasourceUID: c.Req.URL.Query().Get("datasourceUid"), Identity: c.Identity}
+	rules, err := hs.RecordingService.GetRules(c.Req.Context(), &query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query recording rules", err)
+	}
+	return response.JSON(http.StatusOK, rules)
+}
+
+// swagger:route GET /recording/rules/uid/{uid} recording getRecordingRuleByUID
+//
+// Get a recording rule by UID.
+//
+// Responses:
+// 200: recordingRuleResponse
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetRecordingRuleByUID(c *contextmodel.ReqContext) response.Response {
+	rule, err := hs.RecordingService.GetRule(c.Req.Context(), &recording.GetRuleQuery{UID: web.Params(c.Req)[":uid"], Identity: c.Identity})
+	if err != nil {
+		return recordingRuleErrorResponse(err, "Failed to query recording rule")
+	}
+	return response.JSON(http.StatusOK, rule)
+}
+
+// swagger:route POST /recording/rules recording addRecordingRule
+//
+// Create a recording rule. The user must have the query permission on its
+// datasource.
+//
+// Responses:
+// 200: saveRecordingRuleResponse
+// 400: badRequestError
+// 403: forbiddenError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) AddRecordingRule(c *contextmodel.ReqContext) response.Response {
+	cmd := recording.AddRuleCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	cmd.Identity = c.Identity
+
+	rule, err := hs.RecordingService.AddRule(c.Req.Context(), &cmd)
+	if err != nil {
+		return recordingRuleErrorResponse(err, "Failed to add recording rule")
+	}
+	return saveRecordingRuleResponse("Recording rule added", rule)
+}
+
+// swagger:route PUT /recording/rules/uid/{uid} recording updateRecordingRuleByUID
+//
+// Update a recording rule.
+//
+// Set version to the version the update is based on to fail with 409
+// when the rule was changed in the meantime.
+//
+// Responses:
+// 200: saveRecordingRuleResponse
+// 400: badRequestError
+// 403: forbiddenError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateRecordingRuleByUID(c *contextmodel.ReqContext) response.Response {
+	cmd := recording.UpdateRuleCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.UID = web.Params(c.Req)[":uid"]
+	cmd.Identity = c.Identity
+
+	rule, err := hs.RecordingService.UpdateRule(c.Req.Context(), &cmd)
+	if err != nil {
+		return recordingRuleErrorResponse(err, "Failed to update recording rule")
+	}
+	return saveRecordingRuleResponse("Recording rule updated", rule)
+}
+
+// swagger:route DELETE /recording/rules/uid/{uid} recording deleteRecordingRuleByUID
+//
+// Delete a recording rule. The series it wrote are left as they are.
+//
+// Responses:
+// 200: okResponse
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteRecordingRuleByUID(c *contextmodel.ReqContext) response.Response {
+	err := hs.RecordingService.DeleteRule(c.Req.Context(), &recording.DeleteRuleCommand{UID: web.Params(c.Req)[":uid"], Identity: c.Identity})
+	if err != nil {
+		return recordingRuleErrorResponse(err, "Failed to delete recording rule")
+	}
+	return response.Success("Recording rule deleted")
+}
+
+// swagger:route GET /recording/status recording getRecordingStatus
+//
+// Get the last evaluation of the recording rules, optionally only of the
+// rule with ruleUid. Rules of a datasource the user may not query are left
+// out.
+//
+// Responses:
+// 200: getRecordingStatusResponse
+// 500: internalServerError
+func (hs *HTTPServer) GetRecordingStatus(c *contextmodel.ReqContext) response.Response {
+	query := recording.GetStatusesQuery{RuleUID: c.Req.URL.Query().Get("ruleUid"), Identity: c.Identity}
+	statuses, err := hs.RecordingService.GetStatuses(c.Req.Context(), &query)
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query recording rule status", err)
+	}
+	return response.JSON(http.StatusOK, statuses)
+}
+
+func saveRecordingRuleResponse(message string, rule *recording.Rule) response.Response {
+	return response.JSON(http.StatusOK, map[string]any{
+		"message": message,
+		"uid":     rule.UID,
+		"version": rule.Version,
+		"rule":    rule,
+	})
+}
+
+func recordingRuleErrorResponse(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, recording.ErrRuleNotFound):
+		return response.Error(http.StatusNotFound, "Recording rule not found", nil)
+	case errors.Is(err, recording.ErrRuleUidExists):
+		return response.Error(http.StatusConflict, err.Error(), err)
+	case errors.Is(err, recording.ErrRuleVersionMismatch):
+		return response.Error(http.StatusConflict, "Recording rule has already been updated by someone else. Please reload and try again", err)
+	}
+	return response.ErrOrFallback(http.StatusInternalServerError, message, err)
+}
@@ -0,0 +1,446 @@
+package response
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+)
+
+// FrameFormat is an encoding of the frames of a query data response.
+type FrameFormat string
+
+const (
+	FrameFormatJSON   FrameFormat = "application/json"
+	FrameFormatArrow  FrameFormat = "application/vnd.apache.arrow.stream"
+	FrameFormatCSV    FrameFormat = "text/csv"
+	FrameFormatNDJSON FrameFormat = "application/x-ndjson"
+)
+
+var frameFormats = []FrameFormat{FrameFormatJSON, FrameFormatArrow, FrameFormatCSV, FrameFormatNDJSON}
+
+// NegotiateFrameFormat returns the frame format preferred by the Accept
+// header, JSON when it accepts none of the others.
+func NegotiateFrameFormat(accept string) FrameFormat {
+	format, best := FrameFormatJSON, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		for _, f := range frameFormats {
+			if mediaType == string(f) && q > best {
+				format, best = f, q
+			}
+		}
+	}
+	return format
+}
+
+// FramesResponse is a response that streams the frames of a query data
+// response in a format other than JSON.
+type FramesResponse struct {
+	qdr    *backend.QueryDataResponse
+	format FrameFormat
+	status int
+	header http.Header
+}
+
+// Frames creates a streaming response of the frames of qdr encoded as format.
+// Arrow responses with several frames are zipped, one file per frame, CSV
+// responses with several queries are zipped, one file per query. Responses
+// without frames are empty.
+func Frames(status int, format FrameFormat, qdr *backend.QueryDataResponse) FramesResponse {
+	header := make(http.Header)
+	header.Set("Content-Type", string(format))
+	frames := responseFrames(qdr)
+	if format == FrameFormatArrow && len(frames) > 1 || format == FrameFormatCSV && countQueries(frames) > 1 {
+		header.Set("Content-Type", "application/zip")
+		header.Set("Content-Disposition", `attachment;filename="query.zip"`)
+	}
+	return FramesResponse{
+		qdr:    qdr,
+		format: format,
+		status: status,
+		header: header,
+	}
+}
+
+// Status gets the response's status.
+// Required to implement api.Response.
+func (r FramesResponse) Status() int {
+	return r.status
+}
+
+// Body gets the response's body.
+// Required to implement api.Response.
+func (r FramesResponse) Body() []byte {
+	return nil
+}
+
+// WriteTo writes the response to the provided context.
+// Required to implement api.Response.
+func (r FramesResponse) WriteTo(ctx *contextmodel.ReqContext) {
+	header := ctx.Resp.Header()
+	for k, v := range r.header {
+		header[k] = v
+	}
+	ctx.Resp.WriteHeader(r.status)
+
+	if err := WriteFrames(ctx.Resp, r.format, r.qdr); err != nil {
+		ctx.Logger.Error("Error writing to response", "err", err)
+	}
+}
+
+// WriteFrames writes the frames of qdr to w encoded as format.
+func WriteFrames(w io.Writer, format FrameFormat, qdr *backend.QueryDataResponse) error {
+	frames := responseFrames(qdr)
+	switch format {
+	case FrameFormatArrow:
+		return writeArrow(w, frames)
+	case FrameFormatCSV:
+		queries := joinQueryFrames(frames)
+		switch len(queries) {
+		case 0:
+			// An empty CSV, the headers of a zip are only set for several queries.
+			return nil
+		case 1:
+			return writeCSV(w, queries[0].frame)
+		default:
+			return writeZip(w, queries, ".csv", writeCSV)
+		}
+	case FrameFormatNDJSON:
+		return writeNDJSON(w, frames)
+	default:
+		return fmt.Errorf("unsupported frame format %q", format)
+	}
+}
+
+type refFrame struct {
+	refID string
+	frame *data.Frame
+}
+
+// responseFrames returns the frames of qdr ordered by refId.
+func responseFrames(qdr *backend.QueryDataResponse) []refFrame {
+	refIDs := make([]string, 0, len(qdr.Responses))
+	for refID := range qdr.Responses {
+		refIDs = append(refIDs, refID)
+	}
+	sort.Strings(refIDs)
+
+	var frames []refFrame
+	for _, refID := range refIDs {
+		for _, frame := range qdr.Responses[refID].Frames {
+			frames = append(frames, refFrame{refID: refID, frame: frame})
+		}
+	}
+	return frames
+}
+
+// writeArrow writes a single frame as an Arrow IPC stream. Several frames
+// are zipped, one stream per frame, since stream readers stop at the end of
+// the first stream and the schemas of the frames differ.
+func writeArrow(w io.Writer, frames []refFrame) error {
+	switch len(frames) {
+	case 0:
+		return nil
+	case 1:
+		return writeArrowStream(w, frames[0].frame)
+	default:
+		return writeZip(w, frames, ".arrow", writeArrowStream)
+	}
+}
+
+func writeArrowStream(w io.Writer, frame *data.Frame) error {
+	b, err := frame.MarshalArrow()
+	if err != nil {
+		return err
+	}
+	fr, err := ipc.NewFileReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	sw := ipc.NewWriter(w, ipc.WithSchema(fr.Schema()))
+	for i := 0; i < fr.NumRecords(); i++ {
+		rec, err := fr.Record(i)
+		if err != nil {
+			return err
+		}
+		if err := sw.Write(rec); err != nil {
+			return err
+		}
+	}
+	return sw.Close()
+}
+
+// writeZip writes every frame as a file of a zip, named after its refId, or
+// like A-1 and A-2 when a query returns several frames.
+func writeZip(w io.Writer, frames []refFrame, ext string, write func(io.Writer, *data.Frame) error) error {
+	counts := map[string]int{}
+	for _, f := range frames {
+		counts[f.refID]++
+	}
+
+	zw := zip.NewWriter(w)
+	seen := map[string]int{}
+	for _, f := range frames {
+		name := f.refID + ext
+		if counts[f.refID] > 1 {
+			seen[f.refID]++
+			name = fmt.Sprintf("%s-%d%s", f.refID, seen[f.refID], ext)
+		}
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := write(fw, f.frame); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// countQueries returns the number of refIds of frames, which are ordered by
+// refId.
+func countQueries(frames []refFrame) int {
+	count := 0
+	for i, f := range frames {
+		if i == 0 || f.refID != frames[i-1].refID {
+			count++
+		}
+	}
+	return count
+}
+
+// joinQueryFrames joins the frames of every query into one, frames are
+// ordered by refId.
+func joinQueryFrames(frames []refFrame) []refFrame {
+	var queries []refFrame
+	for start := 0; start < len(frames); {
+		end := start + 1
+		for end < len(frames) && frames[end].refID == frames[start].refID {
+			end++
+		}
+		query := make([]*data.Frame, 0, end-start)
+		for _, f := range frames[start:end] {
+			query = append(query, f.frame)
+		}
+		queries = append(queries, refFrame{refID: frames[start].refID, frame: joinFrames(query)})
+		start = end
+	}
+	return queries
+}
+
+// joinFrames joins frames into one. Frames are joined on their first time
+// field, like the series of a Prometheus query, and the values missing at a
+// time are null. Rows of a frame at the same time are kept, the n-th ones of
+// every frame are joined. Frames are put side by side when one of them has
+// no time field.
+func joinFrames(frames []*data.Frame) *data.Frame {
+	if len(frames) == 1 {
+		return frames[0]
+	}
+
+	type rowKey struct {
+		time int64
+		n    int
+	}
+	timeFields := make([]int, len(frames))
+	frameKeys := make([][]*rowKey, len(frames))
+	rows := map[rowKey]int{}
+	for i, frame := range frames {
+		indices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+		if len(indices) == 0 {
+			return joinFramesByRow(frames)
+		}
+		timeFields[i] = indices[0]
+
+		field := frame.Fields[indices[0]]
+		seen := map[int64]int{}
+		frameKeys[i] = make([]*rowKey, field.Len())
+		for j := range frameKeys[i] {
+			v, ok := field.ConcreteAt(j)
+			if !ok {
+				continue
+			}
+			t := v.(time.Time).UnixNano()
+			key := rowKey{time: t, n: seen[t]}
+			seen[t]++
+			frameKeys[i][j] = &key
+			rows[key] = 0
+		}
+	}
+
+	keys := make([]rowKey, 0, len(rows))
+	for key := range rows {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].time != keys[j].time {
+			return keys[i].time < keys[j].time
+		}
+		return keys[i].n < keys[j].n
+	})
+
+	timeField := data.NewFieldFromFieldType(data.FieldTypeTime, len(keys))
+	timeField.Name = frames[0].Fields[timeFields[0]].Name
+	for i, key := range keys {
+		rows[key] = i
+		timeField.Set(i, time.Unix(0, key.time).UTC())
+	}
+
+	joined := data.NewFrame(frames[0].Name, timeField)
+	for i, frame := range frames {
+		for j, field := range frame.Fields {
+			if j == timeFields[i] {
+				continue
+			}
+			column := nullableCopy(field, len(keys))
+			for k, key := range frameKeys[i] {
+				if key == nil {
+					continue
+				}
+				if v, ok := field.ConcreteAt(k); ok {
+					column.SetConcrete(rows[*key], v)
+				}
+			}
+			joined.Fields = append(joined.Fields, column)
+		}
+	}
+	return joined
+}
+
+// joinFramesByRow puts frames side by side, the shorter ones are padded with
+// nulls.
+func joinFramesByRow(frames []*data.Frame) *data.Frame {
+	rows := 0
+	for _, frame := range frames {
+		if n, err := frame.RowLen(); err == nil && n > rows {
+			rows = n
+		}
+	}
+
+	joined := data.NewFrame(frames[0].Name)
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			column := nullableCopy(field, rows)
+			for k := 0; k < field.Len(); k++ {
+				if v, ok := field.ConcreteAt(k); ok {
+					column.SetConcrete(k, v)
+				}
+			}
+			joined.Fields = append(joined.Fields, column)
+		}
+	}
+	return joined
+}
+
+// nullableCopy returns an empty nullable field of length n with the name and
+// labels of field.
+func nullableCopy(field *data.Field, n int) *data.Field {
+	column := data.NewFieldFromFieldType(field.Type().NullableType(), n)
+	column.Name = field.Name
+	column.Labels = field.Labels
+	return column
+}
+
+func writeCSV(w io.Writer, frame *data.Frame) error {
+	cw := csv.NewWriter(w)
+
+	row := make([]string, len(frame.Fields))
+	for i, field := range frame.Fields {
+		row[i] = csvHeader(field)
+	}
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+
+	rows, err := frame.RowLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < rows; i++ {
+		for j, field := range frame.Fields {
+			row[j] = csvValue(field, i)
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvHeader returns the name of field followed by its labels, like a
+// Prometheus series.
+func csvHeader(field *data.Field) string {
+	if len(field.Labels) == 0 {
+		return field.Name
+	}
+	keys := make([]string, 0, len(field.Labels))
+	for k := range field.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + strconv.Quote(field.Labels[k])
+	}
+	return field.Name + "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func csvValue(field *data.Field, idx int) string {
+	v, ok := field.ConcreteAt(idx)
+	if !ok {
+		return ""
+	}
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32)
+	case string:
+		return t
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+// writeNDJSON writes every frame as a line of frame JSON.
+func writeNDJSON(w io.Writer, frames []refFrame) error {
+	for _, f := range frames {
+		b, err := f.frame.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package response
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v13/arrow/ipc"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateFrameFormat(t *testing.T) {
+	cases := map[string]FrameFormat{
+		"":                                       FrameFormatJSON,
+		"*/*":                                    FrameFormatJSON,
+		"text/csv":                               FrameFormatCSV,
+		"application/x-ndjson":                   FrameFormatNDJSON,
+		"application/vnd.apache.arrow.stream":    FrameFormatArrow,
+		"text/html, text/csv;q=0.9":              FrameFormatCSV,
+		"text/csv;q=0.5, application/x-ndjson":   FrameFormatNDJSON,
+		"application/json;q=0.9, text/csv;q=0.1": FrameFormatJSON,
+		"text/csv;q=0":                           FrameFormatJSON,
+		"application/vnd.apache.arrow.stream;q=x": FrameFormatJSON,
+	}
+	for accept, want := range cases {
+		assert.Equal(t, want, NegotiateFrameFormat(accept), accept)
+	}
+}
+
+func testQueryDataResponse() *backend.QueryDataResponse {
+	ts := []time.Time{time.Unix(0, 0), time.Unix(60, 0)}
+	a := data.NewFrame("",
+		data.NewField("Time", nil, ts),
+		data.NewField("Value", data.Labels{"job": "api", "code": "500"}, []*float64{ptr(1.5), nil}),
+	)
+	a.RefID = "A"
+	b := data.NewFrame("",
+		data.NewField("Time", nil, ts),
+		data.NewField("Value", nil, []float64{2, 3}),
+	)
+	b.RefID = "B"
+
+	resp := backend.NewQueryDataResponse()
+	resp.Responses["B"] = backend.DataResponse{Frames: data.Frames{b}}
+	resp.Responses["A"] = backend.DataResponse{Frames: data.Frames{a}}
+	return resp
+}
+
+func ptr(f float64) *float64 {
+	return &f
+}
+
+func TestWriteFramesCSV(t *testing.T) {
+	t.Run("single frame", func(t *testing.T) {
+		qdr := testQueryDataResponse()
+		delete(qdr.Responses, "B")
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrames(&buf, FrameFormatCSV, qdr))
+		assert.Equal(t, "Time,\"Value{code=\"\"500\"\", job=\"\"api\"\"}\"\n"+
+			"1970-01-01T00:00:00Z,1.5\n"+
+			"1970-01-01T00:01:00Z,\n", buf.String())
+	})
+
+	t.Run("series of a query are joined on time", func(t *testing.T) {
+		qdr := testQueryDataResponse()
+		delete(qdr.Responses, "B")
+		c := data.NewFrame("",
+			data.NewField("Time", nil, []time.Time{time.Unix(60, 0), time.Unix(120, 0)}),
+			data.NewField("Value", data.Labels{"job": "api", "code": "200"}, []float64{7, 8}),
+		)
+		qdr.Responses["A"] = backend.DataResponse{Frames: append(qdr.Responses["A"].Frames, c)}
+		assert.Equal(t, string(FrameFormatCSV), Frames(200, FrameFormatCSV, qdr).header.Get("Content-Type"))
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrames(&buf, FrameFormatCSV, qdr))
+		assert.Equal(t, "Time,\"Value{code=\"\"500\"\", job=\"\"api\"\"}\",\"Value{code=\"\"200\"\", job=\"\"api\"\"}\"\n"+
+			"1970-01-01T00:00:00Z,1.5,\n"+
+			"1970-01-01T00:01:00Z,,7\n"+
+			"1970-01-01T00:02:00Z,,8\n", buf.String())
+	})
+
+	t.Run("frames without time are put side by side", func(t *testing.T) {
+		qdr := &backend.QueryDataResponse{Responses: backend.Responses{"A": {Frames: data.Frames{
+			data.NewFrame("", data.NewField("Value", data.Labels{"job": "api"}, []float64{1})),
+			data.NewFrame("", data.NewField("Value", data.Labels{"job": "db"}, []float64{2, 3})),
+		}}}}
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrames(&buf, FrameFormatCSV, qdr))
+		assert.Equal(t, "\"Value{job=\"\"api\"\"}\",\"Value{job=\"\"db\"\"}\"\n1,2\n,3\n", buf.String())
+	})
+
+	t.Run("several queries are zipped", func(t *testing.T) {
+		qdr := testQueryDataResponse()
+		second := data.NewFrame("",
+			data.NewField("Time", nil, []time.Time{time.Unix(0, 0)}),
+			data.NewField("Value", data.Labels{"job": "db"}, []float64{4}),
+		)
+		qdr.Responses["B"] = backend.DataResponse{Frames: append(qdr.Responses["B"].Frames, second)}
+		assert.Equal(t, "application/zip", Frames(200, FrameFormatCSV, qdr).header.Get("Content-Type"))
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrames(&buf, FrameFormatCSV, qdr))
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		require.Len(t, zr.File, 2)
+		assert.Equal(t, "A.csv", zr.File[0].Name)
+		assert.Equal(t, "B.csv", zr.File[1].Name)
+
+		f, err := zr.File[1].Open()
+		require.NoError(t, err)
+		b, err := io.ReadAll(f)
+		require.NoError(t, err)
+		assert.Equal(t, "Time,Value,\"Value{job=\"\"db\"\"}\"\n1970-01-01T00:00:00Z,2,4\n1970-01-01T00:01:00Z,3,\n", string(b))
+	})
+	t.Run("no frames", func(t *testing.T) {
+		qdr := &backend.QueryDataResponse{Responses: backend.Responses{"A": {}}}
+		assert.Equal(t, string(FrameFormatCSV), Frames(200, FrameFormatCSV, qdr).header.Get("Content-Type"))
+		assert.Empty(t, Frames(200, FrameFormatCSV, qdr).header.Get("Content-Disposition"))
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrames(&buf, FrameFormatCSV, qdr))
+		assert.Empty(t, buf.String())
+	})
+}
+
+func TestWriteFramesNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrames(&buf, FrameFormatNDJSON, testQueryDataResponse()))
+
+	var refIDs []string
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		frame := &data.Frame{}
+		require.NoError(t, frame.UnmarshalJSON(sc.Bytes()))
+		refIDs = append(refIDs, frame.RefID)
+	}
+	assert.Equal(t, []string{"A", "B"}, refIDs)
+}
+
+func TestWriteFramesArrow(t *testing.T) {
+	// readStream reads a single Arrow IPC stream, like a standard stream
+	// reader does, and returns the refId and the rows of its frame.
+	readStream := func(t *testing.T, r io.Reader) (string, int64) {
+		t.Helper()
+		sr, err := ipc.NewReader(r)
+		require.NoError(t, err)
+		defer sr.Release()
+		refID, _ := sr.Schema().Metadata().GetValue("refId")
+		rows := int64(0)
+		for sr.Next() {
+			rows += sr.Record().NumRows()
+		}
+		require.NoError(t, sr.Err())
+		return refID, rows
+	}
+
+	t.Run("single frame", func(t *testing.T) {
+		qdr := testQueryDataResponse()
+		delete(qdr.Responses, "B")
+		assert.Equal(t, string(FrameFormatArrow), Frames(200, FrameFormatArrow, qdr).header.Get("Content-Type"))
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrames(&buf, FrameFormatArrow, qdr))
+		assert.False(t, strings.Contains(buf.String(), "ARROW1"), "expected the stream format, not the file format")
+		r := bytes.NewReader(buf.Bytes())
+		refID, rows := readStream(t, r)
+		assert.Equal(t, "A", refID)
+		assert.Equal(t, int64(2), rows)
+		assert.Zero(t, r.Len())
+	})
+
+	t.Run("several frames are zipped", func(t *testing.T) {
+		qdr := testQueryDataResponse()
+		assert.Equal(t, "application/zip", Frames(200, FrameFormatArrow, qdr).header.Get("Content-Type"))
+
+		var buf bytes.Buffer
+		require.NoError(t, WriteFrames(&buf, FrameFormatArrow, qdr))
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		require.NoError(t, err)
+		require.Len(t, zr.File, 2)
+
+		var refIDs []string
+		for i, name := range []string{"A.arrow", "B.arrow"} {
+			assert.Equal(t, name, zr.File[i].Name)
+			f, err := zr.File[i].Open()
+			require.NoError(t, err)
+			refID, rows := readStream(t, f)
+			require.NoError(t, f.Close())
+			assert.Equal(t, int64(2), rows)
+			refIDs = append(refIDs, refID)
+		}
+		assert.Equal(t, []string{"A", "B"}, refIDs)
+	})
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// swagger:route GET /serviceaccounts serviceaccounts getServiceAccounts
+//
+// Get all service accounts.
+//
+// Responses:
+// 200: getServiceAccountsResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 500: internalServerError
+func (hs *HTTPServer) GetServiceAccounts(c *contextmodel.ReqContext) response.Response {
+	items, err := hs.ServiceAccounts.GetServiceAccounts(c.Req.Context(), &serviceaccounts.GetServiceAccountsQuery{})
+	if err != nil {
+		return response.Error(http.StatusInternalServerError, "Failed to query service accounts", err)
+	}
+	return response.JSON(http.StatusOK, items)
+}
+
+// swagger:route GET /serviceaccounts/uid/{uid} serviceaccounts getServiceAccountByUID
+//
+// Get a service account by UID.
+//
+// Responses:
+// 200: serviceAccountResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetServiceAccountByUID(c *contextmodel.ReqContext) response.Response {
+	sa, err := hs.ServiceAccounts.GetServiceAccount(c.Req.Context(), &serviceaccounts.GetServiceAccountQuery{UID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return serviceAccountErrorResponse(err, "Failed to query service account")
+	}
+	return response.JSON(http.StatusOK, sa)
+}
+
+// swagger:route POST /serviceaccounts serviceaccounts addServiceAccount
+//
+// Create a service account. The role defaults to Viewer.
+//
+// Responses:
+// 200: serviceAccountResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) AddServiceAccount(c *contextmodel.ReqContext) response.Response {
+	cmd := serviceaccounts.AddServiceAccountCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	sa, err := hs.ServiceAccounts.AddServiceAccount(c.Req.Context(), &cmd)
+	if err != nil {
+		return serviceAccountErrorResponse(err, "Failed to add service account")
+	}
+	return response.JSON(http.StatusOK, sa)
+}
+
+// swagger:route PUT /serviceaccounts/uid/{uid} serviceaccounts updateServiceAccountByUID
+//
+// Update a service account. Disabling it rejects its tokens.
+//
+// Responses:
+// 200: serviceAccountResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) UpdateServiceAccountByUID(c *contextmodel.ReqContext) response.Response {
+	cmd := serviceaccounts.UpdateServiceAccountCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.UID = web.Params(c.Req)[":uid"]
+
+	sa, err := hs.ServiceAccounts.UpdateServiceAccount(c.Req.Context(), &cmd)
+	if err != nil {
+		return serviceAccountErrorResponse(err, "Failed to update service account")
+	}
+	return response.JSON(http.StatusOK, sa)
+}
+
+// swagger:route DELETE /serviceaccounts/uid/{uid} serviceaccounts deleteServiceAccountByUID
+//
+// Delete a service account and its tokens.
+//
+// Responses:
+// 200: okResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteServiceAccountByUID(c *contextmodel.ReqContext) response.Response {
+	cmd := serviceaccounts.DeleteServiceAccountCommand{UID: web.Params(c.Req)[":uid"]}
+	if err := hs.ServiceAccounts.DeleteServiceAccount(c.Req.Context(), &cmd); err != nil {
+		return serviceAccountErrorResponse(err, "Failed to delete service account")
+	}
+	return response.Success("Service account deleted")
+}
+
+// swagger:route GET /serviceaccounts/uid/{uid}/tokens serviceaccounts getServiceAccountTokens
+//
+// Get the tokens of a service account, without their keys.
+//
+// Responses:
+// 200: getServiceAccountTokensResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) GetServiceAccountTokens(c *contextmodel.ReqContext) response.Response {
+	tokens, err := hs.ServiceAccounts.GetTokens(c.Req.Context(), &serviceaccounts.GetTokensQuery{ServiceAccountUID: web.Params(c.Req)[":uid"]})
+	if err != nil {
+		return serviceAccountErrorResponse(err, "Failed to query tokens")
+	}
+	return response.JSON(http.StatusOK, tokens)
+}
+
+// swagger:route POST /serviceaccounts/uid/{uid}/tokens serviceaccounts addServiceAccountToken
+//
+// Create a token of a service account. The key of the token is only
+// returned in this response.
+//
+// Responses:
+// 200: addServiceAccountTokenResponse
+// 400: badRequestError
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 409: conflictError
+// 500: internalServerError
+func (hs *HTTPServer) AddServiceAccountToken(c *contextmodel.ReqContext) response.Response {
+	cmd := serviceaccounts.AddTokenCommand{}
+	if err := web.Bind(c.Req, &cmd); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+	cmd.ServiceAccountUID = web.Params(c.Req)[":uid"]
+
+	token, err := hs.ServiceAccounts.AddToken(c.Req.Context(), &cmd)
+	if err != nil {
+		return serviceAccountErrorResponse(err, "Failed to add token")
+	}
+	return response.JSON(http.StatusOK, token)
+}
+
+// swagger:route DELETE /serviceaccounts/uid/{uid}/tokens/{tokenId} serviceaccounts deleteServiceAccountToken
+//
+// Revoke a token of a service account.
+//
+// Responses:
+// 200: okResponse
+// 401: unauthorisedError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+func (hs *HTTPServer) DeleteServiceAccountToken(c *contextmodel.ReqContext) response.Response {
+	id, err := strconv.ParseInt(web.Params(c.Req)[":tokenId"], 10, 64)
+	if err != nil {
+		return response.Error(http.StatusBadRequest, "tokenId is invalid", err)
+	}
+
+	cmd := serviceaccounts.DeleteTokenCommand{ServiceAccountUID: web.Params(c.Req)[":uid"], ID: id}
+	if err := hs.ServiceAccounts.DeleteToken(c.Req.Context(), &cmd); err != nil {
+		return serviceAccountErrorResponse(err, "Failed to revoke token")
+	}
+	return response.Success("Token revoked")
+}
+
+func serviceAccountErrorResponse(err error, message string) response.Response {
+	switch {
+	case errors.Is(err, serviceaccounts.ErrServiceAccountNotFound):
+		return response.Error(http.StatusNotFound, "Service account not found", nil)
+	case errors.Is(err, serviceaccounts.ErrTokenNotFound):
+		return response.Error(http.StatusNotFound, "Token not found", nil)
+	case errors.Is(err, serviceaccounts.ErrServiceAccountUidExists),
+		errors.Is(err, serviceaccounts.ErrServiceAccountNameExists),
+		errors.Is(err, serviceaccounts.ErrTokenNameExists):
+		return response.Error(http.StatusConflict, err.Error(), nil)
+	}
+	return response.ErrOrFallback(http.StatusInternalServerError, message, err)
+}
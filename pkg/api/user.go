@@ -0,0 +1,19 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+)
+
+// swagger:route GET /user signed_in_user getSignedInUser
+//
+// Get the identity the request was authenticated as.
+//
+// Responses:
+// 200: userResponse
+// 401: unauthorisedError
+func (hs *HTTPServer) GetSignedInUser(c *contextmodel.ReqContext) response.Response {
+	return response.JSON(http.StatusOK, c.Identity)
+}
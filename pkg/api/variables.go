@@ -0,0 +1,49 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/plugins"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/variables"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// swagger:route POST /variables/query variables queryVariable
+//
+// Resolve the values of a template variable query.
+//
+// The query is label_names(), label_values(label), label_values(selector, label),
+// metrics(regex) or query_result(expr) for Prometheus, and label_names() or
+// label_values() for Loki. Values are sorted, distinct and filtered by regex.
+// query_result needs the query permission on the datasource, the other
+// functions the explore permission.
+//
+// Responses:
+// 200: queryVariableResponse
+// 400: badRequestError
+// 403: forbiddenError
+// 404: notFoundError
+// 500: internalServerError
+// 502: badGatewayError
+func (hs *HTTPServer) QueryVariable(c *contextmodel.ReqContext) response.Response {
+	q := variables.Query{}
+	if err := web.Bind(c.Req, &q); err != nil {
+		return response.Error(http.StatusBadRequest, "bad request data", err)
+	}
+
+	values, err := hs.variablesService.Query(c.Req.Context(), c.Identity, &q)
+	if err != nil {
+		switch {
+		case errors.Is(err, datasources.ErrInvalidDatasourceID):
+			return response.Error(http.StatusNotFound, "Data source not found", err)
+		case errors.Is(err, plugins.ErrPluginNotRegistered):
+			return response.Error(http.StatusNotFound, "Plugin not found", err)
+		}
+		return response.ErrOrFallback(http.StatusInternalServerError, "Failed to query variable", err)
+	}
+	return response.JSON(http.StatusOK, map[string]any{"values": values})
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+func TestQueryVariable(t *testing.T) {
+	s := setupTestServer(t)
+	s.client.callResource = func(_ context.Context, _ *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+		return sender.Send(&backend.CallResourceResponse{Status: http.StatusOK, Body: []byte(`{"status":"success","data":["api"]}`)})
+	}
+	s.addDataSource(t, "loki",
+		&datasources.DataSourcePermission{Role: "Viewer", Permission: datasources.PermissionQuery},
+		&datasources.DataSourcePermission{Team: "sre", Permission: datasources.PermissionExplore})
+
+	t.Run("allowed", func(t *testing.T) {
+		rec := s.request(t, http.MethodPost, "/api/variables/query", "sre", `{"datasource":"loki","query":"label_values(app)"}`)
+		require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		require.Equal(t, map[string]any{"values": []any{"api"}}, decode(t, rec))
+	})
+
+	t.Run("without explore permission", func(t *testing.T) {
+		rec := s.request(t, http.MethodPost, "/api/variables/query", "viewer", `{"datasource":"loki","query":"label_values(app)"}`)
+		requireAccessDenied(t, rec, datasources.PermissionExplore, "loki")
+	})
+}
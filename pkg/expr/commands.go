@@ -0,0 +1,371 @@
+package expr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/tsdb/intervalv2"
+)
+
+// Command is an expression, it computes its results from the results of the
+// queries and expressions it needs.
+type Command interface {
+	NeedsVars() []string
+	Execute(tr backend.TimeRange, vars map[string]Results) (Results, error)
+}
+
+// commandModel is the JSON of an expression query.
+type commandModel struct {
+	Type       string `json:"type"`
+	Expression string `json:"expression"`
+
+	// reduce
+	Reducer  string         `json:"reducer"`
+	Settings reduceSettings `json:"settings"`
+
+	// resample
+	Window      string `json:"window"`
+	Downsampler string `json:"downsampler"`
+	Upsampler   string `json:"upsampler"`
+
+	// threshold
+	Conditions []struct {
+		Evaluator struct {
+			Type   string    `json:"type"`
+			Params []float64 `json:"params"`
+		} `json:"evaluator"`
+	} `json:"conditions"`
+}
+
+// UnmarshalCommand parses the JSON of an expression query.
+func UnmarshalCommand(refID string, b []byte) (Command, error) {
+	var m commandModel
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, ErrInvalidExpression.Errorf("%s: %w", refID, err)
+	}
+
+	var cmd Command
+	var err error
+	switch m.Type {
+	case "math":
+		cmd, err = newMathCommand(m.Expression)
+	case "reduce":
+		cmd, err = newReduceCommand(m)
+	case "resample":
+		cmd, err = newResampleCommand(m)
+	case "threshold":
+		cmd, err = newThresholdCommand(m)
+	default:
+		err = fmt.Errorf("unknown expression type %q", m.Type)
+	}
+	if err != nil {
+		return nil, ErrInvalidExpression.Errorf("%s: %w", refID, err)
+	}
+	return cmd, nil
+}
+
+type reduceSettings struct {
+	Mode             string  `json:"mode"`
+	ReplaceWithValue float64 `json:"replaceWithValue"`
+}
+
+// inputRefID returns the refId referenced by the expression of reduce,
+// resample and threshold, written as A, $A or ${A}.
+func inputRefID(expression string) (string, error) {
+	refID := strings.TrimSpace(expression)
+	refID = strings.TrimPrefix(refID, "$")
+	refID = strings.TrimSuffix(strings.TrimPrefix(refID, "{"), "}")
+	if refID == "" {
+		return "", fmt.Errorf("missing input")
+	}
+	return refID, nil
+}
+
+// MathCommand computes a math expression across refIds, e.g. $A * 100 / $B.
+type MathCommand struct {
+	node node
+	vars []string
+}
+
+func newMathCommand(expression string) (*MathCommand, error) {
+	n, vars, err := parseMath(expression)
+	if err != nil {
+		return nil, fmt.Errorf("invalid math expression %q: %w", expression, err)
+	}
+	return &MathCommand{node: n, vars: vars}, nil
+}
+
+func (c *MathCommand) NeedsVars() []string { return c.vars }
+
+func (c *MathCommand) Execute(_ backend.TimeRange, vars map[string]Results) (Results, error) {
+	o, err := c.node.eval(vars)
+	if err != nil {
+		return Results{}, err
+	}
+	if o.isScalar {
+		return Results{Values: []Value{&Number{Value: o.scalar}}}, nil
+	}
+	return o.results, nil
+}
+
+var reducers = map[string]func(values []float64) float64{
+	"last": func(values []float64) float64 {
+		if len(values) == 0 {
+			return math.NaN()
+		}
+		return values[len(values)-1]
+	},
+	"mean": func(values []float64) float64 {
+		if len(values) == 0 {
+			return math.NaN()
+		}
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"sum": func(values []float64) float64 {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"max": func(values []float64) float64 {
+		if len(values) == 0 {
+			return math.NaN()
+		}
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Max(m, v)
+		}
+		return m
+	},
+	"min": func(values []float64) float64 {
+		if len(values) == 0 {
+			return math.NaN()
+		}
+		m := values[0]
+		for _, v := range values[1:] {
+			m = math.Min(m, v)
+		}
+		return m
+	},
+	"count": func(values []float64) float64 {
+		return float64(len(values))
+	},
+}
+
+// ReduceCommand reduces every series to a number.
+type ReduceCommand struct {
+	input   string
+	reducer func([]float64) float64
+	// mode is how NaN values are treated: "" keeps them, dropNN drops them and
+	// replaceNN replaces them with replaceWith.
+	mode        string
+	replaceWith float64
+}
+
+func newReduceCommand(m commandModel) (*ReduceCommand, error) {
+	input, err := inputRefID(m.Expression)
+	if err != nil {
+		return nil, err
+	}
+	reducer, ok := reducers[m.Reducer]
+	if !ok {
+		return nil, fmt.Errorf("unknown reducer %q", m.Reducer)
+	}
+	switch m.Settings.Mode {
+	case "", "strict", "dropNN", "replaceNN":
+	default:
+		return nil, fmt.Errorf("unknown reduce mode %q", m.Settings.Mode)
+	}
+	return &ReduceCommand{input: input, reducer: reducer, mode: m.Settings.Mode, replaceWith: m.Settings.ReplaceWithValue}, nil
+}
+
+func (c *ReduceCommand) NeedsVars() []string { return []string{c.input} }
+
+func (c *ReduceCommand) Execute(_ backend.TimeRange, vars map[string]Results) (Results, error) {
+	res := Results{}
+	for _, v := range vars[c.input].Values {
+		switch v := v.(type) {
+		case *Number:
+			res.Values = append(res.Values, &Number{Labels: v.Labels, Value: v.Value})
+		case *Series:
+			values := make([]float64, 0, len(v.Points))
+			for _, p := range v.Points {
+				switch {
+				case !math.IsNaN(p.Value):
+					values = append(values, p.Value)
+				case c.mode == "replaceNN":
+					values = append(values, c.replaceWith)
+				case c.mode != "dropNN":
+					values = append(values, p.Value)
+				}
+			}
+			res.Values = append(res.Values, &Number{Labels: v.Labels, Value: c.reducer(values)})
+		}
+	}
+	return res, nil
+}
+
+// maxResamplePoints is the maximum number of points of a resampled series,
+// the same as the maximum resolution of a Prometheus range query.
+const maxResamplePoints = 11000
+
+// ResampleCommand aligns series to a common interval, so that series of
+// different datasources can be combined.
+type ResampleCommand struct {
+	input       string
+	window      time.Duration
+	downsampler func([]float64) float64
+	upsampler   string
+}
+
+func newResampleCommand(m commandModel) (*ResampleCommand, error) {
+	input, err := inputRefID(m.Expression)
+	if err != nil {
+		return nil, err
+	}
+	window, err := intervalv2.ParseIntervalStringToTimeDuration(m.Window)
+	if err != nil || window <= 0 {
+		return nil, fmt.Errorf("invalid window %q", m.Window)
+	}
+	downsampler, ok := reducers[m.Downsampler]
+	if !ok {
+		return nil, fmt.Errorf("unknown downsampler %q", m.Downsampler)
+	}
+	switch m.Upsampler {
+	case "pad", "backfilling", "fillna":
+	case "":
+		m.Upsampler = "fillna"
+	default:
+		return nil, fmt.Errorf("unknown upsampler %q", m.Upsampler)
+	}
+	return &ResampleCommand{input: input, window: window, downsampler: downsampler, upsampler: m.Upsampler}, nil
+}
+
+func (c *ResampleCommand) NeedsVars() []string { return []string{c.input} }
+
+// Execute resamples every series to points at multiples of the window within
+// the time range. A point is the downsampled value of the points in the window
+// ending at it, windows without points are upsampled: pad takes the previous
+// value, backfilling the next one and fillna leaves them null. Windows that
+// would make a series of more than maxResamplePoints points are rejected.
+func (c *ResampleCommand) Execute(tr backend.TimeRange, vars map[string]Results) (Results, error) {
+	if points := tr.To.Sub(tr.From) / c.window; points >= maxResamplePoints {
+		return Results{}, ErrInvalidExpression.Errorf("window %s resamples the time range to more than %d points, use a larger window", c.window, maxResamplePoints)
+	}
+	res := Results{}
+	for _, v := range vars[c.input].Values {
+		s, ok := v.(*Series)
+		if !ok {
+			return Results{}, fmt.Errorf("can only resample series")
+		}
+		res.Values = append(res.Values, c.resample(s, tr))
+	}
+	return res, nil
+}
+
+func (c *ResampleCommand) resample(s *Series, tr backend.TimeRange) *Series {
+	out := &Series{Labels: s.Labels}
+	start := tr.From.Truncate(c.window)
+	if start.Before(tr.From) {
+		start = start.Add(c.window)
+	}
+
+	i := 0
+	last := math.NaN()
+	var pending []int
+	for t := start; !t.After(tr.To); t = t.Add(c.window) {
+		var window []float64
+		for ; i < len(s.Points) && !s.Points[i].Time.After(t); i++ {
+			if s.Points[i].Time.After(t.Add(-c.window)) {
+				window = append(window, s.Points[i].Value)
+			}
+		}
+
+		value := math.NaN()
+		switch {
+		case len(window) > 0:
+			value = c.downsampler(window)
+			last = value
+		case c.upsampler == "pad":
+			value = last
+		}
+		out.Points = append(out.Points, Point{Time: t, Value: value})
+
+		if c.upsampler == "backfilling" {
+			if len(window) == 0 {
+				pending = append(pending, len(out.Points)-1)
+			} else {
+				for _, p := range pending {
+					out.Points[p].Value = value
+				}
+				pending = pending[:0]
+			}
+		}
+	}
+	return out
+}
+
+var thresholdEvaluators = map[string]int{
+	"gt":            1,
+	"lt":            1,
+	"within_range":  2,
+	"outside_range": 2,
+}
+
+// ThresholdCommand evaluates a condition on every value, the result is 1
+// where it is met and 0 where it isn't.
+type ThresholdCommand struct {
+	input     string
+	evaluator string
+	params    []float64
+}
+
+func newThresholdCommand(m commandModel) (*ThresholdCommand, error) {
+	input, err := inputRefID(m.Expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Conditions) != 1 {
+		return nil, fmt.Errorf("threshold needs exactly one condition")
+	}
+	evaluator := m.Conditions[0].Evaluator
+	params, ok := thresholdEvaluators[evaluator.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown threshold type %q", evaluator.Type)
+	}
+	if len(evaluator.Params) < params {
+		return nil, fmt.Errorf("threshold %s needs %d parameters", evaluator.Type, params)
+	}
+	return &ThresholdCommand{input: input, evaluator: evaluator.Type, params: evaluator.Params}, nil
+}
+
+func (c *ThresholdCommand) NeedsVars() []string { return []string{c.input} }
+
+func (c *ThresholdCommand) Execute(_ backend.TimeRange, vars map[string]Results) (Results, error) {
+	o := mapOperand(operand{results: vars[c.input]}, func(v float64) float64 {
+		if math.IsNaN(v) {
+			return v
+		}
+		switch c.evaluator {
+		case "gt":
+			return boolFloat(v > c.params[0])
+		case "lt":
+			return boolFloat(v < c.params[0])
+		case "within_range":
+			return boolFloat(v > c.params[0] && v < c.params[1])
+		default:
+			return boolFloat(v < c.params[0] || v > c.params[1])
+		}
+	})
+	return o.results, nil
+}
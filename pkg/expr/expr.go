@@ -0,0 +1,155 @@
+// Package expr implements server side expressions.
+//
+// Expressions are queries of the __expr__ pseudo-datasource. They run after
+// the datasource queries of the request and compute new results from them,
+// e.g. the ratio of a Loki metric query and a Prometheus query.
+package expr
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+const (
+	// DatasourceType is the type of the expression pseudo-datasource.
+	DatasourceType = "__expr__"
+	// DatasourceUID is the uid of the expression pseudo-datasource.
+	DatasourceUID = DatasourceType
+	// OldDatasourceUID is the uid older clients use for expressions.
+	OldDatasourceUID = "-100"
+)
+
+var (
+	ErrInvalidExpression = errutil.BadRequest("expr.invalidExpression", errutil.WithPublicMessage("Invalid expression."))
+	ErrDependencyCycle   = errutil.BadRequest("expr.dependencyCycle", errutil.WithPublicMessage("Expressions have a circular dependency."))
+	ErrUnknownRefID      = errutil.BadRequest("expr.unknownRefId", errutil.WithPublicMessage("An expression references an unknown query."))
+)
+
+// IsDataSource reports whether uid refers to the expression pseudo-datasource.
+func IsDataSource(uid string) bool {
+	return uid == DatasourceUID || uid == OldDatasourceUID
+}
+
+// DataSourceModel returns the expression pseudo-datasource.
+func DataSourceModel() *datasources.DataSource {
+	return &datasources.DataSource{
+		UID:  DatasourceUID,
+		Name: "Expression",
+		Type: DatasourceType,
+	}
+}
+
+// Query is an expression query of a request.
+type Query struct {
+	RefID     string
+	JSON      []byte
+	TimeRange backend.TimeRange
+}
+
+type pipelineNode struct {
+	refID     string
+	cmd       Command
+	timeRange backend.TimeRange
+}
+
+// Pipeline is a set of expressions sorted so that every expression comes after
+// the expressions it needs.
+type Pipeline []pipelineNode
+
+// BuildPipeline parses the expressions and orders them by their dependencies.
+// Expressions can reference each other and the refIds of datasource queries.
+func BuildPipeline(queries []Query, dsRefIDs []string) (Pipeline, error) {
+	known := make(map[string]bool, len(queries)+len(dsRefIDs))
+	for _, refID := range dsRefIDs {
+		known[refID] = true
+	}
+	nodes := make(map[string]pipelineNode, len(queries))
+	for _, q := range queries {
+		cmd, err := UnmarshalCommand(q.RefID, q.JSON)
+		if err != nil {
+			return nil, err
+		}
+		nodes[q.RefID] = pipelineNode{refID: q.RefID, cmd: cmd, timeRange: q.TimeRange}
+		known[q.RefID] = true
+	}
+
+	// Sort by refId first so that the order is stable.
+	refIDs := make([]string, 0, len(nodes))
+	for refID := range nodes {
+		refIDs = append(refIDs, refID)
+	}
+	sort.Strings(refIDs)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(nodes))
+	pipeline := make(Pipeline, 0, len(nodes))
+	var visit func(refID string) error
+	visit = func(refID string) error {
+		switch state[refID] {
+		case visiting:
+			return ErrDependencyCycle.Errorf("expression %s depends on itself", refID)
+		case visited:
+			return nil
+		}
+		state[refID] = visiting
+		node := nodes[refID]
+		for _, need := range node.cmd.NeedsVars() {
+			if !known[need] {
+				return ErrUnknownRefID.Errorf("expression %s references unknown refId %s", refID, need)
+			}
+			if _, ok := nodes[need]; ok {
+				if err := visit(need); err != nil {
+					return err
+				}
+			}
+		}
+		state[refID] = visited
+		pipeline = append(pipeline, node)
+		return nil
+	}
+	for _, refID := range refIDs {
+		if err := visit(refID); err != nil {
+			return nil, err
+		}
+	}
+	return pipeline, nil
+}
+
+// Execute runs the expressions on the datasource responses and adds their
+// responses. An expression fails when any response it needs failed.
+func (p Pipeline) Execute(responses backend.Responses) {
+	vars := make(map[string]Results, len(responses)+len(p))
+	for refID, resp := range responses {
+		if resp.Error == nil {
+			vars[refID] = FromFrames(resp.Frames)
+		}
+	}
+
+	for _, node := range p {
+		var err error
+		for _, need := range node.cmd.NeedsVars() {
+			if resp := responses[need]; resp.Error != nil {
+				err = fmt.Errorf("%s depends on %s which failed: %w", node.refID, need, resp.Error)
+				break
+			}
+		}
+		var res Results
+		if err == nil {
+			res, err = node.cmd.Execute(node.timeRange, vars)
+		}
+		if err != nil {
+			responses[node.refID] = backend.DataResponse{Error: err}
+			continue
+		}
+		vars[node.refID] = res
+		responses[node.refID] = backend.DataResponse{Frames: res.Frames(node.refID)}
+	}
+}
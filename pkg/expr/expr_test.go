@@ -0,0 +1,218 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+var t0 = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func seriesFrame(name string, labels data.Labels, values ...float64) *data.Frame {
+	times := make([]time.Time, len(values))
+	for i := range values {
+		times[i] = t0.Add(time.Duration(i) * time.Minute)
+	}
+	return data.NewFrame(name,
+		data.NewField("time", nil, times),
+		data.NewField("value", labels, values),
+	)
+}
+
+func seriesValues(t *testing.T, v Value) []float64 {
+	t.Helper()
+	s, ok := v.(*Series)
+	require.True(t, ok, "expected series, got %T", v)
+	values := make([]float64, len(s.Points))
+	for i, p := range s.Points {
+		values[i] = p.Value
+	}
+	return values
+}
+
+func TestPipeline(t *testing.T) {
+	responses := backend.Responses{
+		// Loki metric query, e.g. count_over_time of error lines.
+		"A": {Frames: data.Frames{seriesFrame("errors", data.Labels{"app": "api"}, 1, 2, 3)}},
+		// Prometheus query, e.g. requests.
+		"B": {Frames: data.Frames{seriesFrame("requests", data.Labels{"app": "api", "job": "api"}, 10, 10, 20)}},
+	}
+	pipeline, err := BuildPipeline([]Query{
+		{RefID: "D", JSON: []byte(`{"type": "reduce", "expression": "$C", "reducer": "max"}`)},
+		{RefID: "C", JSON: []byte(`{"type": "math", "expression": "$A * 100 / ${B}"}`)},
+		{RefID: "E", JSON: []byte(`{"type": "threshold", "expression": "D", "conditions": [{"evaluator": {"type": "gt", "params": [15]}}]}`)},
+	}, []string{"A", "B"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"C", "D", "E"}, []string{pipeline[0].refID, pipeline[1].refID, pipeline[2].refID})
+
+	pipeline.Execute(responses)
+	require.NoError(t, responses["C"].Error)
+	c := FromFrames(responses["C"].Frames)
+	require.Len(t, c.Values, 1)
+	require.Equal(t, []float64{10, 20, 15}, seriesValues(t, c.Values[0]))
+	require.Equal(t, data.Labels{"app": "api", "job": "api"}, c.Values[0].GetLabels())
+
+	d := FromFrames(responses["D"].Frames)
+	require.Equal(t, 20.0, d.Values[0].(*Number).Value)
+	e := FromFrames(responses["E"].Frames)
+	require.Equal(t, 1.0, e.Values[0].(*Number).Value)
+
+	t.Run("failed inputs fail the expression", func(t *testing.T) {
+		responses := backend.Responses{"A": {Error: errTest}}
+		pipeline, err := BuildPipeline([]Query{{RefID: "B", JSON: []byte(`{"type": "math", "expression": "$A + 1"}`)}}, []string{"A"})
+		require.NoError(t, err)
+		pipeline.Execute(responses)
+		require.ErrorIs(t, responses["B"].Error, errTest)
+	})
+}
+
+var errTest = ErrInvalidExpression.Errorf("test")
+
+func TestBuildPipeline_Errors(t *testing.T) {
+	_, err := BuildPipeline([]Query{{RefID: "B", JSON: []byte(`{"type": "math", "expression": "$X + 1"}`)}}, []string{"A"})
+	require.ErrorIs(t, err, ErrUnknownRefID)
+
+	_, err = BuildPipeline([]Query{
+		{RefID: "B", JSON: []byte(`{"type": "math", "expression": "$C + 1"}`)},
+		{RefID: "C", JSON: []byte(`{"type": "reduce", "expression": "B", "reducer": "last"}`)},
+	}, nil)
+	require.ErrorIs(t, err, ErrDependencyCycle)
+
+	for _, q := range []string{
+		`{"type": "nope"}`,
+		`{"type": "math", "expression": "$A +"}`,
+		`{"type": "reduce", "expression": "A", "reducer": "median"}`,
+		`{"type": "resample", "expression": "A", "window": "x", "downsampler": "mean"}`,
+		`{"type": "threshold", "expression": "A", "conditions": [{"evaluator": {"type": "within_range", "params": [1]}}]}`,
+	} {
+		_, err := BuildPipeline([]Query{{RefID: "B", JSON: []byte(q)}}, []string{"A"})
+		require.ErrorIs(t, err, ErrInvalidExpression, q)
+	}
+}
+
+func TestMath(t *testing.T) {
+	vars := map[string]Results{
+		"A": FromFrames(data.Frames{
+			seriesFrame("", data.Labels{"host": "a"}, 1, 2),
+			seriesFrame("", data.Labels{"host": "b"}, 3, 4),
+		}),
+		"B": {Values: []Value{
+			&Number{Labels: data.Labels{"host": "a"}, Value: 10},
+			&Number{Labels: data.Labels{"host": "b"}, Value: 20},
+		}},
+		"N": {Values: []Value{&Number{Value: 4}}},
+	}
+
+	tests := []struct {
+		expression string
+		expected   [][]float64
+	}{
+		{expression: "$A * $B", expected: [][]float64{{10, 20}, {60, 80}}},
+		{expression: "-$A + 2 ^ 3 ^ 0", expected: [][]float64{{1, 0}, {-1, -2}}},
+		{expression: "($A + 1) * 2", expected: [][]float64{{4, 6}, {8, 10}}},
+		{expression: "$A > 1 && $A < 4", expected: [][]float64{{0, 1}, {1, 0}}},
+		{expression: "abs(-$A) % 2", expected: [][]float64{{1, 0}, {1, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			cmd, err := newMathCommand(tt.expression)
+			require.NoError(t, err)
+			res, err := cmd.Execute(backend.TimeRange{}, vars)
+			require.NoError(t, err)
+			require.Len(t, res.Values, len(tt.expected))
+			for i, expected := range tt.expected {
+				require.Equal(t, expected, seriesValues(t, res.Values[i]))
+			}
+		})
+	}
+
+	t.Run("numbers and scalars", func(t *testing.T) {
+		cmd, err := newMathCommand("sqrt($N) + 1.5e1")
+		require.NoError(t, err)
+		res, err := cmd.Execute(backend.TimeRange{}, vars)
+		require.NoError(t, err)
+		require.Equal(t, 17.0, res.Values[0].(*Number).Value)
+	})
+
+	t.Run("series are joined on time", func(t *testing.T) {
+		vars := map[string]Results{
+			"A": FromFrames(data.Frames{seriesFrame("", nil, 1, 2, 3)}),
+			"B": {Values: []Value{&Series{Points: []Point{{Time: t0.Add(time.Minute), Value: 2}}}}},
+		}
+		cmd, err := newMathCommand("$A / $B")
+		require.NoError(t, err)
+		res, err := cmd.Execute(backend.TimeRange{}, vars)
+		require.NoError(t, err)
+		require.Equal(t, []float64{1}, seriesValues(t, res.Values[0]))
+	})
+}
+
+func TestReduceAndResample(t *testing.T) {
+	vars := map[string]Results{
+		"A": {Values: []Value{&Series{Points: []Point{
+			{Time: t0.Add(10 * time.Second), Value: 1},
+			{Time: t0.Add(50 * time.Second), Value: 3},
+			{Time: t0.Add(150 * time.Second), Value: math.NaN()},
+			{Time: t0.Add(230 * time.Second), Value: 5},
+		}}}},
+	}
+	tr := backend.TimeRange{From: t0.Add(-30 * time.Second), To: t0.Add(4 * time.Minute)}
+
+	reduce := func(reducer, mode string) float64 {
+		cmd, err := newReduceCommand(commandModel{Expression: "A", Reducer: reducer, Settings: reduceSettings{Mode: mode, ReplaceWithValue: 7}})
+		require.NoError(t, err)
+		res, err := cmd.Execute(tr, vars)
+		require.NoError(t, err)
+		return res.Values[0].(*Number).Value
+	}
+	require.True(t, math.IsNaN(reduce("mean", "")))
+	require.Equal(t, 3.0, reduce("mean", "dropNN"))
+	require.Equal(t, 7.0, reduce("max", "replaceNN"))
+	require.Equal(t, 3.0, reduce("count", "dropNN"))
+	require.Equal(t, 5.0, reduce("last", ""))
+
+	resample := func(upsampler string) []float64 {
+		cmd, err := newResampleCommand(commandModel{Expression: "$A", Window: "1m", Downsampler: "mean", Upsampler: upsampler})
+		require.NoError(t, err)
+		res, err := cmd.Execute(tr, vars)
+		require.NoError(t, err)
+		s := res.Values[0].(*Series)
+		require.Equal(t, t0, s.Points[0].Time)
+		return seriesValues(t, res.Values[0])
+	}
+	// Points at 0s, 60s, 120s, 180s and 240s, the window ending at 180s only
+	// has a null value.
+	require.Equal(t, "[NaN 2 NaN NaN 5]", fmt.Sprint(resample("fillna")))
+	require.Equal(t, "[NaN 2 2 NaN 5]", fmt.Sprint(resample("pad")))
+	require.Equal(t, "[2 2 NaN NaN 5]", fmt.Sprint(resample("backfilling")))
+
+	t.Run("rejects windows with too many points", func(t *testing.T) {
+		cmd, err := newResampleCommand(commandModel{Expression: "A", Window: "1s", Downsampler: "mean"})
+		require.NoError(t, err)
+		_, err = cmd.Execute(backend.TimeRange{From: t0, To: t0.Add(24 * time.Hour)}, vars)
+		require.ErrorIs(t, err, ErrInvalidExpression)
+
+		_, err = cmd.Execute(backend.TimeRange{From: t0, To: t0.Add(time.Hour)}, vars)
+		require.NoError(t, err)
+	})
+}
+
+func TestLastValue(t *testing.T) {
+	series := &Series{Points: []Point{{Time: t0, Value: 1}, {Time: t0.Add(time.Minute), Value: math.NaN()}}}
+	v, ok := LastValue(series)
+	require.True(t, ok)
+	require.Equal(t, 1.0, v)
+
+	_, ok = LastValue(&Series{Points: []Point{{Time: t0, Value: math.NaN()}}})
+	require.False(t, ok)
+	_, ok = LastValue(&Number{Value: math.NaN()})
+	require.False(t, ok)
+	v, ok = LastValue(&Number{Value: 2})
+	require.True(t, ok)
+	require.Equal(t, 2.0, v)
+}
@@ -0,0 +1,440 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// mathFuncs are the functions math expressions can call.
+var mathFuncs = map[string]func(float64) float64{
+	"abs":   math.Abs,
+	"ceil":  math.Ceil,
+	"floor": math.Floor,
+	"log":   math.Log,
+	"round": math.Round,
+	"sqrt":  math.Sqrt,
+}
+
+// node is a node of a parsed math expression.
+type node interface {
+	eval(vars map[string]Results) (operand, error)
+}
+
+// operand is either a scalar or the results of a query or an expression.
+type operand struct {
+	isScalar bool
+	scalar   float64
+	results  Results
+}
+
+type numberNode float64
+
+type varNode string
+
+type unaryNode struct {
+	op  string
+	arg node
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+type funcNode struct {
+	name string
+	arg  node
+}
+
+// parseMath parses a math expression like $A * 100 / ${B}.
+func parseMath(s string) (node, []string, error) {
+	p := &mathParser{input: s}
+	if err := p.tokenize(); err != nil {
+		return nil, nil, err
+	}
+	n, err := p.parseBinary(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, nil, fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	return n, p.vars, nil
+}
+
+type tokenKind int
+
+const (
+	tokenNumber tokenKind = iota
+	tokenVar
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+type mathParser struct {
+	input  string
+	tokens []token
+	pos    int
+	vars   []string
+}
+
+var twoCharOps = []string{"==", "!=", ">=", "<=", "&&", "||", "**"}
+
+func (p *mathParser) tokenize() error {
+	s := p.input
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '$':
+			j := i + 1
+			braced := j < len(s) && s[j] == '{'
+			if braced {
+				j++
+			}
+			start := j
+			for j < len(s) && isIdentChar(rune(s[j])) {
+				j++
+			}
+			if j == start {
+				return fmt.Errorf("missing variable name at %d", i)
+			}
+			name := s[start:j]
+			if braced {
+				if j >= len(s) || s[j] != '}' {
+					return fmt.Errorf("missing } after ${%s", name)
+				}
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokenVar, text: name})
+			i = j
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsDigit(rune(s[j])) || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				((s[j] == '+' || s[j] == '-') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokenNumber, text: s[i:j]})
+			i = j
+		case isIdentChar(c):
+			j := i
+			for j < len(s) && isIdentChar(rune(s[j])) {
+				j++
+			}
+			p.tokens = append(p.tokens, token{kind: tokenIdent, text: s[i:j]})
+			i = j
+		default:
+			op := string(c)
+			for _, two := range twoCharOps {
+				if strings.HasPrefix(s[i:], two) {
+					op = two
+				}
+			}
+			if !strings.Contains("+-*/%^()<>!", op) && len(op) == 1 {
+				return fmt.Errorf("unexpected character %q at %d", c, i)
+			}
+			p.tokens = append(p.tokens, token{kind: tokenOp, text: op})
+			i += len(op)
+		}
+	}
+	return nil
+}
+
+func isIdentChar(c rune) bool {
+	return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+// precedence of the binary operators, higher binds tighter.
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3, ">": 3, "<": 3, ">=": 3, "<=": 3,
+	"+": 4, "-": 4,
+	"*": 5, "/": 5, "%": 5,
+	"^": 6, "**": 6,
+}
+
+func (p *mathParser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *mathParser) parseBinary(minPrec int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t, ok := p.peek()
+		if !ok || t.kind != tokenOp {
+			return left, nil
+		}
+		prec, isBinary := precedence[t.text]
+		if !isBinary || prec <= minPrec {
+			return left, nil
+		}
+		p.pos++
+		// power is right associative, everything else is left associative.
+		next := prec
+		if t.text == "^" || t.text == "**" {
+			next = prec - 1
+		}
+		right, err := p.parseBinary(next)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *mathParser) parseUnary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	if t.kind == tokenOp && (t.text == "-" || t.text == "!") {
+		p.pos++
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.text, arg: arg}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *mathParser) parsePrimary() (node, error) {
+	t, _ := p.peek()
+	p.pos++
+	switch t.kind {
+	case tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", t.text)
+		}
+		return numberNode(f), nil
+	case tokenVar:
+		p.vars = append(p.vars, t.text)
+		return varNode(t.text), nil
+	case tokenIdent:
+		if _, ok := mathFuncs[t.text]; !ok {
+			return nil, fmt.Errorf("unknown function %q", t.text)
+		}
+		if next, ok := p.peek(); !ok || next.text != "(" {
+			return nil, fmt.Errorf("expected ( after %s", t.text)
+		}
+		p.pos++
+		arg, err := p.parseParenthesized()
+		if err != nil {
+			return nil, err
+		}
+		return &funcNode{name: t.text, arg: arg}, nil
+	case tokenOp:
+		if t.text == "(" {
+			return p.parseParenthesized()
+		}
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+// parseParenthesized parses the rest of a parenthesized expression, after the (.
+func (p *mathParser) parseParenthesized() (node, error) {
+	n, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); !ok || t.text != ")" {
+		return nil, fmt.Errorf("missing )")
+	}
+	p.pos++
+	return n, nil
+}
+
+func (n numberNode) eval(map[string]Results) (operand, error) {
+	return operand{isScalar: true, scalar: float64(n)}, nil
+}
+
+func (n varNode) eval(vars map[string]Results) (operand, error) {
+	res, ok := vars[string(n)]
+	if !ok {
+		return operand{}, fmt.Errorf("unknown variable $%s", string(n))
+	}
+	return operand{results: res}, nil
+}
+
+func (n *unaryNode) eval(vars map[string]Results) (operand, error) {
+	arg, err := n.arg.eval(vars)
+	if err != nil {
+		return operand{}, err
+	}
+	f := func(v float64) float64 { return -v }
+	if n.op == "!" {
+		f = func(v float64) float64 { return boolFloat(v == 0) }
+	}
+	return mapOperand(arg, f), nil
+}
+
+func (n *funcNode) eval(vars map[string]Results) (operand, error) {
+	arg, err := n.arg.eval(vars)
+	if err != nil {
+		return operand{}, err
+	}
+	return mapOperand(arg, mathFuncs[n.name]), nil
+}
+
+func (n *binaryNode) eval(vars map[string]Results) (operand, error) {
+	left, err := n.left.eval(vars)
+	if err != nil {
+		return operand{}, err
+	}
+	right, err := n.right.eval(vars)
+	if err != nil {
+		return operand{}, err
+	}
+	op := binaryOps[n.op]
+
+	switch {
+	case left.isScalar && right.isScalar:
+		return operand{isScalar: true, scalar: op(left.scalar, right.scalar)}, nil
+	case left.isScalar:
+		return mapOperand(right, func(v float64) float64 { return op(left.scalar, v) }), nil
+	case right.isScalar:
+		return mapOperand(left, func(v float64) float64 { return op(v, right.scalar) }), nil
+	}
+
+	res := Results{}
+	for _, pair := range union(left.results, right.results) {
+		if v := combine(pair.left, pair.right, pair.labels, op); v != nil {
+			res.Values = append(res.Values, v)
+		}
+	}
+	return operand{results: res}, nil
+}
+
+var binaryOps = map[string]func(a, b float64) float64{
+	"+":  func(a, b float64) float64 { return a + b },
+	"-":  func(a, b float64) float64 { return a - b },
+	"*":  func(a, b float64) float64 { return a * b },
+	"/":  func(a, b float64) float64 { return a / b },
+	"%":  math.Mod,
+	"^":  math.Pow,
+	"**": math.Pow,
+	"==": func(a, b float64) float64 { return boolFloat(a == b) },
+	"!=": func(a, b float64) float64 { return boolFloat(a != b) },
+	">":  func(a, b float64) float64 { return boolFloat(a > b) },
+	"<":  func(a, b float64) float64 { return boolFloat(a < b) },
+	">=": func(a, b float64) float64 { return boolFloat(a >= b) },
+	"<=": func(a, b float64) float64 { return boolFloat(a <= b) },
+	"&&": func(a, b float64) float64 { return boolFloat(a != 0 && b != 0) },
+	"||": func(a, b float64) float64 { return boolFloat(a != 0 || b != 0) },
+}
+
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func mapOperand(o operand, f func(float64) float64) operand {
+	if o.isScalar {
+		return operand{isScalar: true, scalar: f(o.scalar)}
+	}
+	res := Results{Values: make([]Value, 0, len(o.results.Values))}
+	for _, v := range o.results.Values {
+		switch v := v.(type) {
+		case *Series:
+			s := &Series{Labels: v.Labels, Points: make([]Point, len(v.Points))}
+			for i, p := range v.Points {
+				s.Points[i] = Point{Time: p.Time, Value: f(p.Value)}
+			}
+			res.Values = append(res.Values, s)
+		case *Number:
+			res.Values = append(res.Values, &Number{Labels: v.Labels, Value: f(v.Value)})
+		}
+	}
+	return operand{results: res}
+}
+
+type valuePair struct {
+	left, right Value
+	labels      data.Labels
+}
+
+// union pairs the values of both sides with the same labels, or where the
+// labels of one side are a subset of the other. The pair gets the larger set
+// of labels. Two single values are always paired, so that the results of
+// different datasources can be combined.
+func union(left, right Results) []valuePair {
+	if len(left.Values) == 1 && len(right.Values) == 1 {
+		l, r := left.Values[0], right.Values[0]
+		labels := l.GetLabels()
+		if len(r.GetLabels()) > len(labels) {
+			labels = r.GetLabels()
+		}
+		return []valuePair{{left: l, right: r, labels: labels}}
+	}
+
+	var pairs []valuePair
+	for _, l := range left.Values {
+		for _, r := range right.Values {
+			ll, rl := l.GetLabels(), r.GetLabels()
+			switch {
+			case ll.Equals(rl), ll.Contains(rl):
+				pairs = append(pairs, valuePair{left: l, right: r, labels: ll})
+			case rl.Contains(ll):
+				pairs = append(pairs, valuePair{left: l, right: r, labels: rl})
+			}
+		}
+	}
+	return pairs
+}
+
+// combine applies op to a pair of values. Series are joined on time, points
+// without a point at the same time on the other side are dropped.
+func combine(left, right Value, labels data.Labels, op func(a, b float64) float64) Value {
+	switch l := left.(type) {
+	case *Number:
+		switch r := right.(type) {
+		case *Number:
+			return &Number{Labels: labels, Value: op(l.Value, r.Value)}
+		case *Series:
+			s := mapOperand(operand{results: Results{Values: []Value{r}}}, func(v float64) float64 { return op(l.Value, v) })
+			s.results.Values[0].SetLabels(labels)
+			return s.results.Values[0]
+		}
+	case *Series:
+		switch r := right.(type) {
+		case *Number:
+			s := mapOperand(operand{results: Results{Values: []Value{l}}}, func(v float64) float64 { return op(v, r.Value) })
+			s.results.Values[0].SetLabels(labels)
+			return s.results.Values[0]
+		case *Series:
+			rightPoints := make(map[int64]float64, len(r.Points))
+			for _, p := range r.Points {
+				rightPoints[p.Time.UnixNano()] = p.Value
+			}
+			s := &Series{Labels: labels}
+			for _, p := range l.Points {
+				if rv, ok := rightPoints[p.Time.UnixNano()]; ok {
+					s.Points = append(s.Points, Point{Time: p.Time, Value: op(p.Value, rv)})
+				}
+			}
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package expr
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Value is a single labeled result of a query or an expression, either a
+// Series or a Number.
+type Value interface {
+	GetLabels() data.Labels
+	SetLabels(data.Labels)
+}
+
+// Series is a time series. Null values are NaN.
+type Series struct {
+	Labels data.Labels
+	Points []Point
+}
+
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+func (s *Series) GetLabels() data.Labels  { return s.Labels }
+func (s *Series) SetLabels(l data.Labels) { s.Labels = l }
+
+// Number is a single value, the result of reducing a series.
+type Number struct {
+	Labels data.Labels
+	Value  float64
+}
+
+func (n *Number) GetLabels() data.Labels  { return n.Labels }
+func (n *Number) SetLabels(l data.Labels) { n.Labels = l }
+
+// LastValue returns the value of a number or the last value of a series that
+// isn't null.
+func LastValue(v Value) (float64, bool) {
+	switch v := v.(type) {
+	case *Number:
+		return v.Value, !math.IsNaN(v.Value)
+	case *Series:
+		for i := len(v.Points) - 1; i >= 0; i-- {
+			if !math.IsNaN(v.Points[i].Value) {
+				return v.Points[i].Value, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// Results are the values of a query or an expression.
+type Results struct {
+	Values []Value
+}
+
+// FromFrames converts datasource frames into results. Every numeric field of a
+// frame with a time field is a series, numeric fields of frames without time
+// field are numbers, one per row.
+func FromFrames(frames data.Frames) Results {
+	res := Results{}
+	for _, frame := range frames {
+		timeIndices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+		for _, field := range frame.Fields {
+			if !field.Type().Numeric() {
+				continue
+			}
+			labels := field.Labels.Copy()
+			if labels == nil {
+				labels = data.Labels{}
+			}
+
+			if len(timeIndices) == 0 {
+				for i := 0; i < field.Len(); i++ {
+					res.Values = append(res.Values, &Number{Labels: labels, Value: floatAt(field, i)})
+				}
+				continue
+			}
+
+			timeField := frame.Fields[timeIndices[0]]
+			s := &Series{Labels: labels, Points: make([]Point, 0, field.Len())}
+			for i := 0; i < field.Len(); i++ {
+				t, ok := timeField.ConcreteAt(i)
+				if !ok {
+					continue
+				}
+				s.Points = append(s.Points, Point{Time: t.(time.Time), Value: floatAt(field, i)})
+			}
+			sort.Slice(s.Points, func(i, j int) bool { return s.Points[i].Time.Before(s.Points[j].Time) })
+			res.Values = append(res.Values, s)
+		}
+	}
+	return res
+}
+
+func floatAt(field *data.Field, i int) float64 {
+	f, err := field.NullableFloatAt(i)
+	if err != nil || f == nil {
+		return math.NaN()
+	}
+	return *f
+}
+
+// Frames converts results into frames, one per value. NaN values are null.
+func (r Results) Frames(refID string) data.Frames {
+	frames := make(data.Frames, 0, len(r.Values))
+	for _, v := range r.Values {
+		switch v := v.(type) {
+		case *Series:
+			times := make([]time.Time, len(v.Points))
+			values := make([]*float64, len(v.Points))
+			for i, p := range v.Points {
+				times[i] = p.Time
+				values[i] = nullable(p.Value)
+			}
+			frame := data.NewFrame(refID,
+				data.NewField("Time", nil, times),
+				data.NewField(refID, v.Labels, values),
+			)
+			frame.SetMeta(&data.FrameMeta{Type: data.FrameTypeTimeSeriesMulti, TypeVersion: data.FrameTypeVersion{0, 1}})
+			frames = append(frames, frame)
+		case *Number:
+			frame := data.NewFrame(refID, data.NewField(refID, v.Labels, []*float64{nullable(v.Value)}))
+			frame.SetMeta(&data.FrameMeta{Type: data.FrameTypeNumericMulti, TypeVersion: data.FrameTypeVersion{0, 1}})
+			frames = append(frames, frame)
+		}
+	}
+	return frames
+}
+
+func nullable(f float64) *float64 {
+	if math.IsNaN(f) {
+		return nil
+	}
+	return &f
+}
@@ -0,0 +1,28 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dir", "items.json")
+
+	items := []string{"kept"}
+	require.NoError(t, ReadJSON(path, &items))
+	require.Equal(t, []string{"kept"}, items)
+
+	require.NoError(t, WriteJSONAtomic(path, []string{"a", "b"}, 0o600))
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	require.NoError(t, ReadJSON(path, &items))
+	require.Equal(t, []string{"a", "b"}, items)
+
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	require.Error(t, ReadJSON(path, &items))
+}
@@ -17,6 +17,7 @@ func New() *sdkhttpclient.Provider {
 
 	middlewares := []sdkhttpclient.Middleware{
 		DataSourceMetricsMiddleware(),
+		RequestRecorderMiddleware(),
 		sdkhttpclient.ContextualMiddleware(),
 		sdkhttpclient.BasicAuthenticationMiddleware(),
 		sdkhttpclient.CustomHeadersMiddleware(),
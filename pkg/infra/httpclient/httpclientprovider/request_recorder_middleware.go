@@ -0,0 +1,46 @@
+package httpclientprovider
+
+import (
+	"net/http"
+	"time"
+
+	sdkhttpclient "github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+)
+
+const RequestRecorderMiddlewareName = "request-recorder"
+
+// RequestRecorderMiddleware records the requests sent with a context returned
+// by httpclient.WithRequestRecorder, with their latency and response size.
+func RequestRecorderMiddleware() sdkhttpclient.Middleware {
+	return sdkhttpclient.NamedMiddlewareFunc(RequestRecorderMiddlewareName, func(opts sdkhttpclient.Options, next http.RoundTripper) http.RoundTripper {
+		return sdkhttpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			recorder := httpclient.RequestRecorderFromContext(req.Context())
+			if recorder == nil {
+				return next.RoundTrip(req)
+			}
+
+			start := time.Now()
+			recorded := httpclient.RecordedRequest{Method: req.Method, URL: req.URL.Redacted()}
+			res, err := next.RoundTrip(req)
+			recorded.LatencyMs = time.Since(start).Milliseconds()
+			if err != nil {
+				recorded.Error = err.Error()
+				recorder.Record(recorded)
+				return nil, err
+			}
+
+			recorded.StatusCode = res.StatusCode
+			if res.StatusCode == http.StatusSwitchingProtocols {
+				recorder.Record(recorded)
+				return res, nil
+			}
+			res.Body = httpclient.CountBytesReader(res.Body, func(bytesRead int64) {
+				recorded.ResponseBytes = bytesRead
+				recorder.Record(recorded)
+			})
+			return res, nil
+		})
+	})
+}
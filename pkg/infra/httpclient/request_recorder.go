@@ -0,0 +1,52 @@
+package httpclient
+
+import (
+	"context"
+	"sync"
+)
+
+// RecordedRequest describes an outgoing request and its response.
+type RecordedRequest struct {
+	Method        string `json:"method"`
+	URL           string `json:"url"`
+	StatusCode    int    `json:"statusCode,omitempty"`
+	Error         string `json:"error,omitempty"`
+	LatencyMs     int64  `json:"latencyMs"`
+	ResponseBytes int64  `json:"responseBytes"`
+}
+
+// RequestRecorder collects the requests sent with a context returned by
+// WithRequestRecorder. Requests are recorded once their response body is
+// closed.
+type RequestRecorder struct {
+	mu       sync.Mutex
+	requests []RecordedRequest
+}
+
+type requestRecorderKey struct{}
+
+// WithRequestRecorder returns a context whose outgoing requests are recorded.
+func WithRequestRecorder(ctx context.Context) (context.Context, *RequestRecorder) {
+	r := &RequestRecorder{}
+	return context.WithValue(ctx, requestRecorderKey{}, r), r
+}
+
+// RequestRecorderFromContext returns the recorder of ctx, nil if its requests
+// are not recorded.
+func RequestRecorderFromContext(ctx context.Context) *RequestRecorder {
+	r, _ := ctx.Value(requestRecorderKey{}).(*RequestRecorder)
+	return r
+}
+
+func (r *RequestRecorder) Record(req RecordedRequest) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+}
+
+// Requests returns the recorded requests in the order they completed.
+func (r *RequestRecorder) Requests() []RecordedRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedRequest(nil), r.requests...)
+}
@@ -4,6 +4,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// Namespace prefixes the names of the metrics of the server.
+const Namespace = "xquare_dashboard"
+
 func ProvideRegisterer() prometheus.Registerer {
 	return prometheus.DefaultRegisterer
 }
@@ -0,0 +1,34 @@
+package middleware
+
+import (
+	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+var (
+	// ReqSignedIn rejects requests without identity with 401.
+	ReqSignedIn = RoleAuth(authn.RoleViewer)
+	// ReqEditorRole rejects requests of viewers with 403.
+	ReqEditorRole = RoleAuth(authn.RoleEditor)
+	// ReqAdmin rejects requests of viewers and editors with 403.
+	ReqAdmin = RoleAuth(authn.RoleAdmin)
+)
+
+// RoleAuth returns a handler that rejects requests without identity with 401
+// and requests of identities without the permissions of role with 403.
+func RoleAuth(role authn.Role) web.Handler {
+	return func(c *contextmodel.ReqContext) {
+		if c.Identity == nil {
+			response.Err(authn.ErrUnauthenticated.Errorf("%s %s requires authentication", c.Req.Method, c.Req.URL.Path)).WriteTo(c)
+			return
+		}
+		if !c.Identity.HasRole(role) {
+			response.Err(authn.ErrRoleRequired.Build(errutil.TemplateData{
+				Public: map[string]any{"Role": role},
+			})).WriteTo(c)
+		}
+	}
+}
@@ -3,13 +3,22 @@ package backgroundsvcs
 import (
 	"github.com/xquare-dashboard/pkg/api"
 	"github.com/xquare-dashboard/pkg/registry"
+	"github.com/xquare-dashboard/pkg/services/alerting/notifier"
+	alertingservice "github.com/xquare-dashboard/pkg/services/alerting/service"
+	"github.com/xquare-dashboard/pkg/services/provisioning"
+	recordingservice "github.com/xquare-dashboard/pkg/services/recording/service"
 )
 
 func ProvideBackgroundServiceRegistry(
-	httpServer *api.HTTPServer,
+	httpServer *api.HTTPServer, provisioning *provisioning.ProvisioningServiceImpl,
+	alerting *alertingservice.Service, notifier *notifier.Service, recording *recordingservice.Service,
 ) *BackgroundServiceRegistry {
 	return NewBackgroundServiceRegistry(
 		httpServer,
+		provisioning,
+		alerting,
+		notifier,
+		recording,
 	)
 }
 
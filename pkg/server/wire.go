@@ -19,7 +19,28 @@ import (
 	"github.com/xquare-dashboard/pkg/plugins/manager/store"
 	"github.com/xquare-dashboard/pkg/registry"
 	"github.com/xquare-dashboard/pkg/registry/backgroundsvcs"
+	"github.com/xquare-dashboard/pkg/services/admission"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/services/alerting/notifier"
+	alertingservice "github.com/xquare-dashboard/pkg/services/alerting/service"
+	"github.com/xquare-dashboard/pkg/services/annotations"
+	annotationsservice "github.com/xquare-dashboard/pkg/services/annotations/service"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/authn/authnimpl"
+	"github.com/xquare-dashboard/pkg/services/caching"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	dashboardservice "github.com/xquare-dashboard/pkg/services/dashboards/service"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	datasourceservice "github.com/xquare-dashboard/pkg/services/datasources/service"
+	"github.com/xquare-dashboard/pkg/services/live"
 	"github.com/xquare-dashboard/pkg/services/pluginsintegration/plugincontext"
+	"github.com/xquare-dashboard/pkg/services/provisioning"
+	"github.com/xquare-dashboard/pkg/services/recording"
+	recordingservice "github.com/xquare-dashboard/pkg/services/recording/service"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+	serviceaccountsservice "github.com/xquare-dashboard/pkg/services/serviceaccounts/service"
+	"github.com/xquare-dashboard/pkg/services/variables"
+	"github.com/xquare-dashboard/pkg/setting"
 
 	"github.com/xquare-dashboard/pkg/services/contexthandler"
 	"github.com/xquare-dashboard/pkg/services/query"
@@ -48,6 +69,30 @@ var wireSet = wire.NewSet(
 	wire.Bind(new(plugins.Client), new(*client.Service)),
 	metrics.ProvideRegisterer,
 	metrics.ProvideGatherer,
+	setting.ProvideCfg,
+	datasourceservice.ProvideService,
+	wire.Bind(new(datasources.DataSourceService), new(*datasourceservice.Service)),
+	provisioning.ProvideService,
+	wire.Bind(new(provisioning.ProvisioningService), new(*provisioning.ProvisioningServiceImpl)),
+	live.ProvideService,
+	caching.ProvideService,
+	admission.ProvideService,
+	dashboardservice.ProvideService,
+	wire.Bind(new(dashboards.DashboardService), new(*dashboardservice.Service)),
+	variables.ProvideService,
+	alertingservice.ProvideService,
+	wire.Bind(new(alerting.AlertingService), new(*alertingservice.Service)),
+	notifier.ProvideService,
+	wire.Bind(new(alerting.Notifier), new(*notifier.Service)),
+	wire.Bind(new(alerting.NotificationService), new(*notifier.Service)),
+	recordingservice.ProvideService,
+	wire.Bind(new(recording.RecordingService), new(*recordingservice.Service)),
+	annotationsservice.ProvideService,
+	wire.Bind(new(annotations.AnnotationsService), new(*annotationsservice.Service)),
+	serviceaccountsservice.ProvideService,
+	wire.Bind(new(serviceaccounts.ServiceAccountService), new(*serviceaccountsservice.Service)),
+	authnimpl.ProvideService,
+	wire.Bind(new(authn.Service), new(*authnimpl.Service)),
 )
 
 func Initialize() (*Server, error) {
@@ -0,0 +1,308 @@
+// Package admission limits how many queries run at once against a datasource.
+package admission
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/infra/metrics"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+// maxConcurrentQueriesKey is the jsonData key of the per datasource limit. A
+// limit of 0 disables admission control for the datasource.
+const maxConcurrentQueriesKey = "maxConcurrentQueries"
+
+const (
+	minRetryAfter = time.Second
+	maxRetryAfter = time.Minute
+)
+
+var ErrQueueFull = errutil.TooManyRequests("query.queueFull", errutil.WithPublicMessage("Too many queries are waiting for this datasource, retry later."))
+
+// RetryAfter returns how long a client should wait before retrying a request
+// rejected with ErrQueueFull.
+func RetryAfter(err error) (time.Duration, bool) {
+	var e errutil.Error
+	if !errors.Is(err, ErrQueueFull) || !errors.As(err, &e) {
+		return 0, false
+	}
+	seconds, _ := e.PublicPayload["retryAfter"].(int)
+	return time.Duration(seconds) * time.Second, true
+}
+
+type Service struct {
+	log               log.Logger
+	maxConcurrent     int
+	maxQueue          int
+	maxQueuePerTenant int
+	mu                sync.Mutex
+	limiters          map[string]*limiter
+	queueDepth        *prometheus.GaugeVec
+	running           *prometheus.GaugeVec
+	waitDuration      *prometheus.HistogramVec
+	rejected          *prometheus.CounterVec
+}
+
+func ProvideService(cfg *setting.Cfg, registerer prometheus.Registerer) (*Service, error) {
+	s := NewService(cfg)
+	for _, c := range []prometheus.Collector{s.queueDepth, s.running, s.waitDuration, s.rejected} {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewService returns an admission controller whose metrics are not registered.
+func NewService(cfg *setting.Cfg) *Service {
+	return &Service{
+		log:               log.New("query_admission"),
+		maxConcurrent:     cfg.QueryMaxConcurrent,
+		maxQueue:          cfg.QueryMaxQueue,
+		maxQueuePerTenant: cfg.QueryMaxQueuePerTenant,
+		limiters:          map[string]*limiter{},
+		queueDepth: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "query_admission",
+			Name:      "queue_depth",
+			Help:      "Number of queries waiting for a datasource.",
+		}, []string{"datasource"}),
+		running: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "query_admission",
+			Name:      "running",
+			Help:      "Number of queries running against a datasource.",
+		}, []string{"datasource"}),
+		waitDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "query_admission",
+			Name:      "wait_duration_seconds",
+			Help:      "Time queries waited before running against a datasource.",
+			Buckets:   []float64{.001, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, []string{"datasource"}),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "query_admission",
+			Name:      "rejected_total",
+			Help:      "Number of queries rejected because the queue of a datasource was full.",
+		}, []string{"datasource"}),
+	}
+}
+
+// Acquire waits until a query of tenant may run against ds and returns the
+// func releasing its slot. Waiting queries are admitted in turn per tenant, so
+// that a tenant with many queries doesn't delay the others. Acquire fails with
+// ErrQueueFull when the queue of the datasource or of the tenant is full, and
+// with the cause of ctx when it is done before the query is admitted.
+func (s *Service) Acquire(ctx context.Context, ds *datasources.DataSource, tenant string) (func(), error) {
+	l := s.limiterFor(ds)
+	if l == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	w, err := l.enqueue(tenant, s.maxQueue, s.maxQueuePerTenant)
+	if err != nil {
+		s.rejected.WithLabelValues(ds.UID).Inc()
+		s.log.Warn("Query queue is full", "uid", ds.UID, "tenant", tenant)
+		return nil, err
+	}
+	s.queueDepth.WithLabelValues(ds.UID).Set(float64(l.depth()))
+
+	if w != nil {
+		select {
+		case <-w.ready:
+		case <-ctx.Done():
+			if l.dequeue(w) {
+				s.queueDepth.WithLabelValues(ds.UID).Set(float64(l.depth()))
+				return nil, context.Cause(ctx)
+			}
+			// The query was admitted while ctx was done.
+			l.release(time.Now())
+			s.queueDepth.WithLabelValues(ds.UID).Set(float64(l.depth()))
+			return nil, context.Cause(ctx)
+		}
+	}
+
+	s.waitDuration.WithLabelValues(ds.UID).Observe(time.Since(start).Seconds())
+	s.queueDepth.WithLabelValues(ds.UID).Set(float64(l.depth()))
+	s.running.WithLabelValues(ds.UID).Inc()
+	admitted := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.running.WithLabelValues(ds.UID).Dec()
+			l.release(admitted)
+			s.queueDepth.WithLabelValues(ds.UID).Set(float64(l.depth()))
+		})
+	}, nil
+}
+
+// limiterFor returns the limiter of ds, nil when its queries are not limited.
+func (s *Service) limiterFor(ds *datasources.DataSource) *limiter {
+	limit := s.maxConcurrent
+	if ds.JsonData != nil {
+		if raw, ok := ds.JsonData.CheckGet(maxConcurrentQueriesKey); ok {
+			if v, err := raw.Int(); err == nil && v >= 0 {
+				limit = v
+			} else {
+				s.log.Warn("Invalid datasource concurrent query limit, using the default", "uid", ds.UID, "maxConcurrentQueries", raw.Interface())
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.limiters[ds.UID]
+	if limit <= 0 {
+		if ok {
+			// Let the queued queries of a datasource whose limit was removed run.
+			l.setLimit(math.MaxInt)
+			delete(s.limiters, ds.UID)
+		}
+		return nil
+	}
+	if !ok {
+		l = &limiter{queues: map[string][]*waiter{}}
+		s.limiters[ds.UID] = l
+	}
+	l.setLimit(limit)
+	return l
+}
+
+type waiter struct {
+	tenant string
+	ready  chan struct{}
+}
+
+// limiter admits up to limit queries at once, the others wait in a queue per
+// tenant. The queues are served round-robin.
+type limiter struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	queued  int
+	queues  map[string][]*waiter
+	// tenants are the tenants with waiting queries, in the order they are served.
+	tenants []string
+	// avgHold is a moving average of how long queries hold a slot, it is used
+	// to tell rejected clients when to retry.
+	avgHold time.Duration
+}
+
+// enqueue admits a query of tenant right away and returns a nil waiter, or
+// queues it and returns the waiter that is ready once it is admitted.
+func (l *limiter) enqueue(tenant string, maxQueue, maxQueuePerTenant int) (*waiter, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.running < l.limit && l.queued == 0 {
+		l.running++
+		return nil, nil
+	}
+	if l.queued >= maxQueue || len(l.queues[tenant]) >= maxQueuePerTenant {
+		err := ErrQueueFull.Errorf("%d queries are waiting, %d of tenant %q", l.queued, len(l.queues[tenant]), tenant)
+		err.PublicPayload = map[string]any{"retryAfter": int(l.retryAfter() / time.Second)}
+		return nil, err
+	}
+
+	w := &waiter{tenant: tenant, ready: make(chan struct{})}
+	if len(l.queues[tenant]) == 0 {
+		l.tenants = append(l.tenants, tenant)
+	}
+	l.queues[tenant] = append(l.queues[tenant], w)
+	l.queued++
+	return w, nil
+}
+
+// dequeue removes a waiter that gave up, it returns false when the waiter was
+// already admitted.
+func (l *limiter) dequeue(w *waiter) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	queue := l.queues[w.tenant]
+	for i, qw := range queue {
+		if qw == w {
+			l.queues[w.tenant] = append(queue[:i:i], queue[i+1:]...)
+			l.queued--
+			if len(l.queues[w.tenant]) == 0 {
+				l.removeTenant(w.tenant)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// release frees the slot of a query admitted at admitted.
+func (l *limiter) release(admitted time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	hold := time.Since(admitted)
+	if l.avgHold == 0 {
+		l.avgHold = hold
+	} else {
+		l.avgHold = (4*l.avgHold + hold) / 5
+	}
+	l.running--
+	l.admit()
+}
+
+func (l *limiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit = limit
+	l.admit()
+}
+
+// admit admits waiting queries while there are free slots, taking the next
+// query of each tenant in turn.
+func (l *limiter) admit() {
+	for l.running < l.limit && len(l.tenants) > 0 {
+		tenant := l.tenants[0]
+		l.tenants = l.tenants[1:]
+		queue := l.queues[tenant]
+		w := queue[0]
+		if len(queue) > 1 {
+			l.queues[tenant] = queue[1:]
+			l.tenants = append(l.tenants, tenant)
+		} else {
+			delete(l.queues, tenant)
+		}
+		l.queued--
+		l.running++
+		close(w.ready)
+	}
+}
+
+func (l *limiter) removeTenant(tenant string) {
+	delete(l.queues, tenant)
+	for i, t := range l.tenants {
+		if t == tenant {
+			l.tenants = append(l.tenants[:i:i], l.tenants[i+1:]...)
+			return
+		}
+	}
+}
+
+func (l *limiter) depth() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.queued
+}
+
+// retryAfter estimates when the queue will have room again: once the queries
+// ahead have run, rounded up to a second.
+func (l *limiter) retryAfter() time.Duration {
+	d := l.avgHold * time.Duration(l.queued/max(l.limit, 1)+1)
+	d = (d + time.Second - 1).Truncate(time.Second)
+	return min(max(d, minRetryAfter), maxRetryAfter)
+}
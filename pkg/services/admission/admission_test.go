@@ -0,0 +1,109 @@
+package admission
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func TestService_Acquire(t *testing.T) {
+	cfg := &setting.Cfg{QueryMaxConcurrent: 1, QueryMaxQueue: 4, QueryMaxQueuePerTenant: 3}
+	ds := &datasources.DataSource{UID: "loki"}
+
+	depth := func(s *Service) int {
+		return s.limiterFor(ds).depth()
+	}
+	// queue acquires a slot in the background and reports the tenant once it
+	// is admitted. It returns once the query is queued.
+	queue := func(t *testing.T, s *Service, tenant string, admitted chan<- string) {
+		t.Helper()
+		queued := depth(s)
+		go func() {
+			release, err := s.Acquire(context.Background(), ds, tenant)
+			if err != nil {
+				admitted <- err.Error()
+				return
+			}
+			admitted <- tenant
+			release()
+		}()
+		require.Eventually(t, func() bool { return depth(s) == queued+1 }, time.Second, time.Millisecond)
+	}
+
+	t.Run("serves tenants in turn", func(t *testing.T) {
+		s := NewService(cfg)
+		release, err := s.Acquire(context.Background(), ds, "a")
+		require.NoError(t, err)
+
+		admitted := make(chan string, 4)
+		for _, tenant := range []string{"a", "a", "a", "b"} {
+			queue(t, s, tenant, admitted)
+		}
+		require.Equal(t, 4, depth(s))
+
+		release()
+		var order []string
+		for i := 0; i < 4; i++ {
+			order = append(order, <-admitted)
+		}
+		require.Equal(t, []string{"a", "b", "a", "a"}, order)
+	})
+
+	t.Run("rejects queries when the queue is full", func(t *testing.T) {
+		s := NewService(cfg)
+		release, err := s.Acquire(context.Background(), ds, "a")
+		require.NoError(t, err)
+		defer release()
+
+		admitted := make(chan string, 4)
+		for i := 0; i < 3; i++ {
+			queue(t, s, "a", admitted)
+		}
+		_, err = s.Acquire(context.Background(), ds, "a")
+		require.ErrorIs(t, err, ErrQueueFull)
+		retryAfter, ok := RetryAfter(err)
+		require.True(t, ok)
+		require.GreaterOrEqual(t, retryAfter, time.Second)
+
+		// Another tenant still has room.
+		queue(t, s, "b", admitted)
+		_, err = s.Acquire(context.Background(), ds, "c")
+		require.ErrorIs(t, err, ErrQueueFull)
+	})
+
+	t.Run("gives up when the context is done", func(t *testing.T) {
+		s := NewService(cfg)
+		release, err := s.Acquire(context.Background(), ds, "a")
+		require.NoError(t, err)
+		defer release()
+
+		cause := errors.New("cancelled")
+		ctx, cancel := context.WithCancelCause(context.Background())
+		go func() {
+			for depth(s) != 1 {
+				time.Sleep(time.Millisecond)
+			}
+			cancel(cause)
+		}()
+		_, err = s.Acquire(ctx, ds, "b")
+		require.ErrorIs(t, err, cause)
+		require.Equal(t, 0, depth(s))
+	})
+
+	t.Run("datasources can disable the limit", func(t *testing.T) {
+		s := NewService(cfg)
+		ds := &datasources.DataSource{UID: "prom", JsonData: simplejson.NewFromAny(map[string]any{"maxConcurrentQueries": 0})}
+		for i := 0; i < 10; i++ {
+			_, err := s.Acquire(context.Background(), ds, "a")
+			require.NoError(t, err)
+		}
+		require.NotContains(t, s.limiters, "prom")
+	})
+}
@@ -0,0 +1,26 @@
+package alerting
+
+import (
+	"context"
+)
+
+// AlertingService interface for interacting with alert rules and their state.
+type AlertingService interface {
+	// GetRule gets an alert rule.
+	GetRule(ctx context.Context, query *GetRuleQuery) (*Rule, error)
+
+	// GetRules gets alert rules sorted by title.
+	GetRules(ctx context.Context, query *GetRulesQuery) ([]*Rule, error)
+
+	// AddRule adds a new alert rule.
+	AddRule(ctx context.Context, cmd *AddRuleCommand) (*Rule, error)
+
+	// UpdateRule updates an existing alert rule.
+	UpdateRule(ctx context.Context, cmd *UpdateRuleCommand) (*Rule, error)
+
+	// DeleteRule deletes an alert rule and its state.
+	DeleteRule(ctx context.Context, cmd *DeleteRuleCommand) error
+
+	// GetStates gets the state of the alert rules sorted by title.
+	GetStates(ctx context.Context, query *GetStatesQuery) ([]*RuleState, error)
+}
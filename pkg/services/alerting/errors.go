@@ -0,0 +1,21 @@
+package alerting
+
+import (
+	"errors"
+
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+var (
+	ErrRuleNotFound                = errors.New("alert rule not found")
+	ErrRuleUidExists               = errors.New("alert rule with the same uid already exists")
+	ErrRuleVersionMismatch         = errors.New("the alert rule has been changed by someone else")
+	ErrRuleFailedGenerateUniqueUid = errors.New("failed to generate unique alert rule ID")
+	ErrRuleUIDInvalid              = errutil.ValidationFailed("alerting.ruleUidInvalid", errutil.WithPublicMessage("Invalid alert rule uid."))
+	ErrRuleInvalid                 = errutil.ValidationFailed("alerting.ruleInvalid").MustTemplate("invalid alert rule: {{ .Public.Reason }}", errutil.WithPublic("Invalid alert rule: {{ .Public.Reason }}"))
+
+	ErrNotificationConfigVersionMismatch = errors.New("the notification config has been changed by someone else")
+	ErrNotificationConfigInvalid         = errutil.ValidationFailed("alerting.notificationConfigInvalid").MustTemplate("invalid notification config: {{ .Public.Reason }}", errutil.WithPublic("Invalid notification config: {{ .Public.Reason }}"))
+	ErrSilenceNotFound                   = errors.New("silence not found")
+	ErrSilenceInvalid                    = errutil.ValidationFailed("alerting.silenceInvalid").MustTemplate("invalid silence: {{ .Public.Reason }}", errutil.WithPublic("Invalid silence: {{ .Public.Reason }}"))
+)
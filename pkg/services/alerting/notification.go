@@ -0,0 +1,265 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier receives the alerts of the rule evaluations.
+type Notifier interface {
+	// Notify is called after every evaluation of rule with its alerts.
+	Notify(ctx context.Context, rule *Rule, alerts []*Alert)
+}
+
+// NotificationService interface for the notification config and silences.
+type NotificationService interface {
+	Notifier
+
+	// GetNotificationConfig gets the notification config.
+	GetNotificationConfig(ctx context.Context) (*NotificationConfig, error)
+
+	// SaveNotificationConfig replaces the notification config.
+	SaveNotificationConfig(ctx context.Context, cmd *SaveNotificationConfigCommand) (*NotificationConfig, error)
+
+	// GetSilences gets the silences, active ones first.
+	GetSilences(ctx context.Context, query *GetSilencesQuery) ([]*Silence, error)
+
+	// GetSilence gets a silence.
+	GetSilence(ctx context.Context, query *GetSilenceQuery) (*Silence, error)
+
+	// AddSilence adds a new silence.
+	AddSilence(ctx context.Context, cmd *AddSilenceCommand) (*Silence, error)
+
+	// ExpireSilence ends a silence now.
+	ExpireSilence(ctx context.Context, cmd *ExpireSilenceCommand) error
+}
+
+// NotificationConfig defines where alerts are sent. Alerts are posted to the
+// Alertmanagers as they are evaluated, and delivered to the receivers of
+// Route by the built-in dispatcher. Either may be left out.
+type NotificationConfig struct {
+	Alertmanagers []*AlertmanagerConfig `json:"alertmanagers"`
+	Route         *Route                `json:"route,omitempty"`
+	Receivers     []*Receiver           `json:"receivers"`
+	Version       int                   `json:"version"`
+
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// Receiver returns the receiver with the given name.
+func (c *NotificationConfig) Receiver(name string) (*Receiver, bool) {
+	for _, r := range c.Receivers {
+		if r.Name == name {
+			return r, true
+		}
+	}
+	return nil, false
+}
+
+// AlertmanagerConfig is an external Alertmanager, alerts are posted to its
+// /api/v2/alerts. It groups, silences and routes them itself.
+type AlertmanagerConfig struct {
+	// URL is the base URL, e.g. http://alertmanager:9093.
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Route matches alerts to a receiver. Alerts go down the first child route
+// they match, and stay on the route when they match none. Empty fields are
+// inherited from the parent route.
+type Route struct {
+	Receiver string `json:"receiver,omitempty"`
+	// Matchers the labels of the alerts must match, e.g. severity="page".
+	Matchers []string `json:"matchers,omitempty"`
+	// GroupBy are the labels alerts are grouped by into one notification,
+	// "..." groups by all labels.
+	GroupBy []string `json:"groupBy,omitempty"`
+	// GroupWait is how long a new group waits for more alerts before it is
+	// sent, GroupInterval how long it waits before it is sent again with new
+	// alerts, and RepeatInterval before an unchanged group is sent again.
+	GroupWait      string `json:"groupWait,omitempty"`
+	GroupInterval  string `json:"groupInterval,omitempty"`
+	RepeatInterval string `json:"repeatInterval,omitempty"`
+	// Continue makes alerts that match the route try the next routes too.
+	Continue bool     `json:"continue,omitempty"`
+	Routes   []*Route `json:"routes,omitempty"`
+}
+
+// Receiver is a named set of webhooks notified together.
+type Receiver struct {
+	Name     string           `json:"name"`
+	Webhooks []*WebhookConfig `json:"webhooks"`
+}
+
+// WebhookConfig is a webhook a notification is sent to.
+type WebhookConfig struct {
+	URL string `json:"url"`
+	// HTTPMethod is POST by default.
+	HTTPMethod string            `json:"httpMethod,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	// Body is a Go template of the request body, executed with the message.
+	// The body is the message as JSON, like Alertmanager's webhook, when empty.
+	Body string `json:"body,omitempty"`
+	// DisableResolveMessage skips the notifications with resolved alerts only.
+	DisableResolveMessage bool `json:"disableResolveMessage,omitempty"`
+}
+
+// SaveNotificationConfigCommand replaces the notification config.
+type SaveNotificationConfigCommand struct {
+	Alertmanagers []*AlertmanagerConfig `json:"alertmanagers"`
+	Route         *Route                `json:"route"`
+	Receivers     []*Receiver           `json:"receivers"`
+
+	// Version is the version the update is based on. When set, the update is
+	// rejected with ErrNotificationConfigVersionMismatch if the config
+	// changed since.
+	Version int `json:"version"`
+}
+
+// Matcher matches the value of a label, a missing label has the value "".
+// It has the JSON format of the matchers of Alertmanager's silences.
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+
+	re *regexp.Regexp
+}
+
+// matcherRegexp matches the matchers of a route: name, operator and the
+// value, optionally quoted.
+var matcherRegexp = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+// ParseMatcher parses a matcher like Alertmanager: job="api", env!="dev",
+// team=~"api|web" or code!~"2..".
+func ParseMatcher(s string) (*Matcher, error) {
+	m := matcherRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid matcher %q", s)
+	}
+	value := m[3]
+	if strings.HasPrefix(value, `"`) {
+		var err error
+		if value, err = strconv.Unquote(value); err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %w", s, err)
+		}
+	}
+	matcher := &Matcher{
+		Name:    m[1],
+		Value:   value,
+		IsRegex: strings.HasSuffix(m[2], "~"),
+		IsEqual: !strings.HasPrefix(m[2], "!"),
+	}
+	if err := matcher.Compile(); err != nil {
+		return nil, err
+	}
+	return matcher, nil
+}
+
+// Compile validates the matcher and compiles its regular expression, it
+// must be called before Matches.
+func (m *Matcher) Compile() error {
+	if m.Name == "" {
+		return fmt.Errorf("matcher without label name")
+	}
+	if !m.IsRegex {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid regular expression of matcher %s: %w", m.Name, err)
+	}
+	m.re = re
+	return nil
+}
+
+// Matches reports whether the labels match.
+func (m *Matcher) Matches(labels map[string]string) bool {
+	value := labels[m.Name]
+	var matches bool
+	if m.IsRegex {
+		matches = m.re != nil && m.re.MatchString(value)
+	} else {
+		matches = value == m.Value
+	}
+	return matches == m.IsEqual
+}
+
+// SilenceState is the state of a silence at a time.
+type SilenceState string
+
+const (
+	SilenceStatePending SilenceState = "pending"
+	SilenceStateActive  SilenceState = "active"
+	SilenceStateExpired SilenceState = "expired"
+)
+
+// Silence mutes the notifications of the alerts matching all its matchers
+// between StartsAt and EndsAt.
+type Silence struct {
+	ID        string     `json:"id"`
+	Matchers  []*Matcher `json:"matchers"`
+	StartsAt  time.Time  `json:"startsAt"`
+	EndsAt    time.Time  `json:"endsAt"`
+	CreatedBy string     `json:"createdBy"`
+	Comment   string     `json:"comment"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	// Status is set on the silences returned by the NotificationService.
+	Status *SilenceStatus `json:"status,omitempty"`
+}
+
+// SilenceStatus is the state of a silence when it was read.
+type SilenceStatus struct {
+	State SilenceState `json:"state"`
+}
+
+// State returns the state of the silence at now.
+func (s *Silence) State(now time.Time) SilenceState {
+	switch {
+	case !s.EndsAt.After(now):
+		return SilenceStateExpired
+	case s.StartsAt.After(now):
+		return SilenceStatePending
+	}
+	return SilenceStateActive
+}
+
+// Mutes reports whether the silence mutes the alert with the labels at now.
+func (s *Silence) Mutes(labels map[string]string, now time.Time) bool {
+	if s.State(now) != SilenceStateActive {
+		return false
+	}
+	for _, m := range s.Matchers {
+		if !m.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+type GetSilencesQuery struct {
+	// State limits the result to the silences in the state, when set.
+	State SilenceState
+}
+
+type GetSilenceQuery struct {
+	ID string
+}
+
+// AddSilenceCommand creates a silence. It starts now when StartsAt is empty.
+type AddSilenceCommand struct {
+	Matchers  []*Matcher `json:"matchers"`
+	StartsAt  time.Time  `json:"startsAt"`
+	EndsAt    time.Time  `json:"endsAt"`
+	CreatedBy string     `json:"createdBy"`
+	Comment   string     `json:"comment"`
+}
+
+type ExpireSilenceCommand struct {
+	ID string
+}
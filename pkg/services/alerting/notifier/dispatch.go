@@ -0,0 +1,210 @@
+package notifier
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/xquare-dashboard/pkg/services/alerting"
+)
+
+const (
+	// ruleUIDLabel identifies the rule of an alert, like Grafana does.
+	ruleUIDLabel = "__alert_rule_uid__"
+	// resendFactor times the interval of its rule is how long a firing alert
+	// lasts without being evaluated again, e.g. when its rule was deleted.
+	resendFactor = 4
+)
+
+// notificationAlert is an alert as sent to Alertmanager. It is resolved once
+// EndsAt passed, firing alerts are given an EndsAt past the next evaluations.
+type notificationAlert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+func (a *notificationAlert) resolved(now time.Time) bool {
+	return !a.EndsAt.After(now)
+}
+
+func (a *notificationAlert) fingerprint() string {
+	return data.Labels(a.Labels).String()
+}
+
+// newNotificationAlert returns the notification of an alert: firing alerts,
+// NoData and Error included, and alerts resolved by this evaluation. Other
+// alerts have nothing to notify.
+func newNotificationAlert(rule *alerting.Rule, a *alerting.Alert, now time.Time) *notificationAlert {
+	var endsAt time.Time
+	switch a.State {
+	case alerting.StateFiring, alerting.StateNoData, alerting.StateError:
+		interval, err := gtime.ParseDuration(rule.Interval)
+		if err != nil {
+			interval = time.Minute
+		}
+		endsAt = now.Add(resendFactor * interval)
+	case alerting.StateNormal:
+		if a.ResolvedAt.IsZero() || !a.ResolvedAt.Equal(a.LastEvaluation) {
+			return nil
+		}
+		endsAt = a.ResolvedAt
+	default:
+		return nil
+	}
+
+	labels := make(map[string]string, len(a.Labels)+1)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels[ruleUIDLabel] = rule.UID
+	annotations := make(map[string]string, len(a.Annotations)+3)
+	for k, v := range a.Annotations {
+		annotations[k] = v
+	}
+	if rule.DashboardUID != "" {
+		annotations["__dashboardUid__"] = rule.DashboardUID
+	}
+	if rule.PanelID != 0 {
+		annotations["__panelId__"] = strconv.FormatInt(rule.PanelID, 10)
+	}
+	if a.Error != "" {
+		annotations["error"] = a.Error
+	}
+	return &notificationAlert{Labels: labels, Annotations: annotations, StartsAt: a.FiredAt, EndsAt: endsAt}
+}
+
+// aggrGroup is the group of alerts with the same labels on a route, they are
+// notified together.
+type aggrGroup struct {
+	key    string
+	route  *route
+	labels map[string]string
+	alerts map[string]*notificationAlert
+	// notified are the firing alerts of the last notification.
+	notified   map[string]bool
+	lastNotify time.Time
+	next       time.Time
+	flushing   bool
+}
+
+// dispatch adds alerts to the groups of their routes. New groups are sent
+// after the group wait of their route.
+func (s *Service) dispatch(root *route, alerts []*notificationAlert, now time.Time) {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	for _, a := range alerts {
+		for _, r := range root.match(a.Labels) {
+			labels := r.groupLabels(a.Labels)
+			key := r.id + ":" + data.Labels(labels).String()
+			g, ok := s.groups[key]
+			if !ok {
+				if a.resolved(now) {
+					continue
+				}
+				g = &aggrGroup{
+					key:      key,
+					route:    r,
+					labels:   labels,
+					alerts:   map[string]*notificationAlert{},
+					notified: map[string]bool{},
+					next:     now.Add(r.groupWait),
+				}
+				s.groups[key] = g
+			}
+			g.alerts[a.fingerprint()] = a
+		}
+	}
+}
+
+// dueGroups returns the groups to flush at now and marks them as flushing.
+func (s *Service) dueGroups(now time.Time) []*aggrGroup {
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+
+	var due []*aggrGroup
+	for _, g := range s.groups {
+		if g.flushing || now.Before(g.next) {
+			continue
+		}
+		g.flushing = true
+		due = append(due, g)
+	}
+	return due
+}
+
+// flush notifies the receiver of a group when its alerts changed since the
+// last notification, or when the repeat interval passed. Silenced alerts are
+// left out. Resolved alerts are dropped once notified.
+func (s *Service) flush(ctx context.Context, g *aggrGroup, now time.Time) {
+	silences := s.activeSilences(now)
+	s.mu.RLock()
+	receiver, _ := s.config.Receiver(g.route.receiver)
+	s.mu.RUnlock()
+
+	s.groupsMu.Lock()
+	var firing, resolved []*notificationAlert
+	firingFPs := map[string]bool{}
+	seen := make(map[string]*notificationAlert, len(g.alerts))
+	for fp, a := range g.alerts {
+		seen[fp] = a
+		if muted(silences, a.Labels, now) {
+			continue
+		}
+		if a.resolved(now) {
+			if g.notified[fp] {
+				resolved = append(resolved, a)
+			}
+			continue
+		}
+		firing = append(firing, a)
+		firingFPs[fp] = true
+	}
+	changed := len(resolved) > 0 || len(firingFPs) != len(g.notified)
+	for fp := range firingFPs {
+		changed = changed || !g.notified[fp]
+	}
+	repeat := len(firing) > 0 && !now.Before(g.lastNotify.Add(g.route.repeatInterval))
+	send := (changed || repeat) && len(firing)+len(resolved) > 0
+	groupLabels := g.labels
+	s.groupsMu.Unlock()
+
+	var err error
+	if send && receiver != nil {
+		msg := newMessage(g.key, receiver.Name, groupLabels, firing, resolved)
+		err = s.send(ctx, receiver, msg)
+	}
+
+	s.groupsMu.Lock()
+	defer s.groupsMu.Unlock()
+	g.flushing = false
+	g.next = now.Add(g.route.groupInterval)
+	if err != nil {
+		s.log.Warn("Failed to send notification", "receiver", g.route.receiver, "group", g.key, "error", err)
+		return
+	}
+	if send {
+		g.lastNotify = now
+	}
+	g.notified = firingFPs
+	for fp, a := range seen {
+		if a.resolved(now) && g.alerts[fp] == a {
+			delete(g.alerts, fp)
+		}
+	}
+	if len(g.alerts) == 0 && s.groups[g.key] == g {
+		delete(s.groups, g.key)
+	}
+}
+
+func sortAlerts(alerts []*notificationAlert) {
+	sort.Slice(alerts, func(i, j int) bool {
+		return alerts[i].fingerprint() < alerts[j].fingerprint()
+	})
+}
@@ -0,0 +1,163 @@
+package notifier
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+
+	"github.com/xquare-dashboard/pkg/services/alerting"
+)
+
+const (
+	defaultGroupWait      = 30 * time.Second
+	defaultGroupInterval  = 5 * time.Minute
+	defaultRepeatInterval = 4 * time.Hour
+)
+
+// defaultGroupBy groups the alerts of a rule into one notification.
+var defaultGroupBy = []string{"alertname"}
+
+// route is a Route with the settings of its parents applied.
+type route struct {
+	// id is the position of the route in the tree, it keeps the groups of
+	// routes with the same labels apart.
+	id             string
+	receiver       string
+	matchers       []*alerting.Matcher
+	groupBy        []string
+	groupByAll     bool
+	groupWait      time.Duration
+	groupInterval  time.Duration
+	repeatInterval time.Duration
+	continueAfter  bool
+	routes         []*route
+}
+
+// compileRoute validates the route tree of config. The root route matches
+// every alert and needs a receiver.
+func compileRoute(config *alerting.NotificationConfig) (*route, error) {
+	if config.Route == nil {
+		return nil, nil
+	}
+	if config.Route.Receiver == "" {
+		return nil, fmt.Errorf("the root route needs a receiver")
+	}
+	if len(config.Route.Matchers) > 0 {
+		return nil, fmt.Errorf("the root route matches all alerts, it can't have matchers")
+	}
+	root := &route{
+		id:             "0",
+		groupBy:        defaultGroupBy,
+		groupWait:      defaultGroupWait,
+		groupInterval:  defaultGroupInterval,
+		repeatInterval: defaultRepeatInterval,
+	}
+	return root, root.apply(config, config.Route)
+}
+
+// apply sets the fields of r from src and compiles its child routes.
+func (r *route) apply(config *alerting.NotificationConfig, src *alerting.Route) error {
+	if src.Receiver != "" {
+		if _, ok := config.Receiver(src.Receiver); !ok {
+			return fmt.Errorf("route %s: unknown receiver %q", r.id, src.Receiver)
+		}
+		r.receiver = src.Receiver
+	}
+	r.matchers = nil
+	for _, s := range src.Matchers {
+		m, err := alerting.ParseMatcher(s)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", r.id, err)
+		}
+		r.matchers = append(r.matchers, m)
+	}
+	if len(src.GroupBy) > 0 {
+		r.groupBy, r.groupByAll = src.GroupBy, false
+		for _, label := range src.GroupBy {
+			if label == "..." {
+				r.groupByAll = true
+			}
+		}
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"groupWait", src.GroupWait, &r.groupWait},
+		{"groupInterval", src.GroupInterval, &r.groupInterval},
+		{"repeatInterval", src.RepeatInterval, &r.repeatInterval},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := gtime.ParseDuration(d.value)
+		if err != nil || v < 0 || (v == 0 && d.name != "groupWait") {
+			return fmt.Errorf("route %s: invalid %s %q", r.id, d.name, d.value)
+		}
+		*d.dst = v
+	}
+	r.continueAfter = src.Continue
+
+	r.routes = nil
+	for i, child := range src.Routes {
+		if child == nil {
+			continue
+		}
+		c := &route{
+			id:             r.id + "." + strconv.Itoa(i),
+			receiver:       r.receiver,
+			groupBy:        r.groupBy,
+			groupByAll:     r.groupByAll,
+			groupWait:      r.groupWait,
+			groupInterval:  r.groupInterval,
+			repeatInterval: r.repeatInterval,
+		}
+		if err := c.apply(config, child); err != nil {
+			return err
+		}
+		r.routes = append(r.routes, c)
+	}
+	return nil
+}
+
+// match returns the routes an alert with the labels goes to: the first child
+// route it matches, the following ones as long as they continue, or r itself.
+func (r *route) match(labels map[string]string) []*route {
+	for _, m := range r.matchers {
+		if !m.Matches(labels) {
+			return nil
+		}
+	}
+	var matches []*route
+	for _, child := range r.routes {
+		childMatches := child.match(labels)
+		matches = append(matches, childMatches...)
+		if len(childMatches) > 0 && !child.continueAfter {
+			break
+		}
+	}
+	if len(matches) == 0 {
+		return []*route{r}
+	}
+	return matches
+}
+
+// groupLabels returns the labels an alert is grouped by on the route.
+func (r *route) groupLabels(labels map[string]string) map[string]string {
+	result := map[string]string{}
+	if r.groupByAll {
+		for k, v := range labels {
+			result[k] = v
+		}
+		return result
+	}
+	for _, k := range r.groupBy {
+		if v, ok := labels[k]; ok {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,248 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+const (
+	// dispatchInterval is how often the groups are checked for notifications
+	// that are due.
+	dispatchInterval = time.Second
+	// silenceRetention is how long expired silences are kept.
+	silenceRetention = 5 * 24 * time.Hour
+)
+
+// Service sends the alerts of the rule evaluations to external Alertmanagers
+// and, grouped and routed, to webhooks. The config and the silences are
+// stored in the data directory, the notification groups in memory.
+type Service struct {
+	log           log.Logger
+	client        *http.Client
+	configStore   *fileStore
+	silencesStore *fileStore
+	now           func() time.Time
+
+	mu           sync.RWMutex
+	config       *alerting.NotificationConfig
+	route        *route
+	silencesByID map[string]*alerting.Silence
+
+	groupsMu sync.Mutex
+	groups   map[string]*aggrGroup
+
+	// lastSilencesGC is only used by Run.
+	lastSilencesGC time.Time
+}
+
+var _ alerting.NotificationService = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg, httpClientProvider httpclient.Provider) (*Service, error) {
+	client, err := httpClientProvider.New()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(cfg.DataPath, "alerting")
+	s := &Service{
+		log:           log.New("alerting.notifier"),
+		client:        client,
+		configStore:   &fileStore{path: filepath.Join(dir, "notifications.json")},
+		silencesStore: &fileStore{path: filepath.Join(dir, "silences.json")},
+		now:           time.Now,
+		silencesByID:  map[string]*alerting.Silence{},
+		config:        &alerting.NotificationConfig{},
+		groups:        map[string]*aggrGroup{},
+	}
+
+	if err := s.configStore.load(s.config); err != nil {
+		return nil, err
+	}
+	if s.route, err = compileRoute(s.config); err != nil {
+		return nil, fmt.Errorf("invalid notification config %q: %w", s.configStore.path, err)
+	}
+	var silences []*alerting.Silence
+	if err := s.silencesStore.load(&silences); err != nil {
+		return nil, err
+	}
+	for _, silence := range silences {
+		for _, m := range silence.Matchers {
+			if err := m.Compile(); err != nil {
+				return nil, fmt.Errorf("invalid silence %s: %w", silence.ID, err)
+			}
+		}
+		s.silencesByID[silence.ID] = silence
+	}
+	return s, nil
+}
+
+// Run sends the notifications of the groups as they are due until ctx is
+// done, and drops the silences that expired a while ago.
+func (s *Service) Run(ctx context.Context) error {
+	ticker := time.NewTicker(dispatchInterval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			now := s.now()
+			for _, g := range s.dueGroups(now) {
+				wg.Add(1)
+				go func(g *aggrGroup) {
+					defer wg.Done()
+					s.flush(ctx, g, now)
+				}(g)
+			}
+			if now.Sub(s.lastSilencesGC) > time.Hour {
+				s.lastSilencesGC = now
+				if err := s.gcSilences(now); err != nil {
+					s.log.Warn("Failed to drop expired silences", "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (s *Service) GetNotificationConfig(_ context.Context) (*alerting.NotificationConfig, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.config, nil
+}
+
+func (s *Service) SaveNotificationConfig(_ context.Context, cmd *alerting.SaveNotificationConfigCommand) (*alerting.NotificationConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cmd.Version != 0 && cmd.Version != s.config.Version {
+		return nil, alerting.ErrNotificationConfigVersionMismatch
+	}
+	config := &alerting.NotificationConfig{
+		Alertmanagers: cmd.Alertmanagers,
+		Route:         cmd.Route,
+		Receivers:     cmd.Receivers,
+		Version:       s.config.Version + 1,
+		Updated:       s.now(),
+	}
+	root, err := prepareConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.configStore.save(config); err != nil {
+		return nil, err
+	}
+	s.config, s.route = config, root
+
+	// The groups belong to the routes of the previous config.
+	s.groupsMu.Lock()
+	s.groups = map[string]*aggrGroup{}
+	s.groupsMu.Unlock()
+
+	s.log.Info("Saved notification config", "version", config.Version)
+	return config, nil
+}
+
+// prepareConfig validates config, fills in defaults and returns its routes.
+func prepareConfig(config *alerting.NotificationConfig) (*route, error) {
+	if config.Alertmanagers == nil {
+		config.Alertmanagers = []*alerting.AlertmanagerConfig{}
+	}
+	if config.Receivers == nil {
+		config.Receivers = []*alerting.Receiver{}
+	}
+	for _, am := range config.Alertmanagers {
+		if err := validateURL(am.URL); err != nil {
+			return nil, invalidConfig(err, "invalid alertmanager url %q", am.URL)
+		}
+	}
+
+	names := map[string]bool{}
+	for _, r := range config.Receivers {
+		if r.Name == "" {
+			return nil, invalidConfig(nil, "every receiver needs a name")
+		}
+		if names[r.Name] {
+			return nil, invalidConfig(nil, "duplicate receiver %q", r.Name)
+		}
+		names[r.Name] = true
+		for _, wh := range r.Webhooks {
+			if err := validateURL(wh.URL); err != nil {
+				return nil, invalidConfig(err, "receiver %s: invalid webhook url %q", r.Name, wh.URL)
+			}
+			if wh.HTTPMethod == "" {
+				wh.HTTPMethod = http.MethodPost
+			}
+			if wh.HTTPMethod != http.MethodPost && wh.HTTPMethod != http.MethodPut {
+				return nil, invalidConfig(nil, "receiver %s: invalid webhook method %q", r.Name, wh.HTTPMethod)
+			}
+			if _, err := parseBodyTemplate(wh.Body); err != nil {
+				return nil, invalidConfig(err, "receiver %s: invalid webhook body: %s", r.Name, err)
+			}
+		}
+	}
+
+	root, err := compileRoute(config)
+	if err != nil {
+		return nil, invalidConfig(err, "%s", err)
+	}
+	return root, nil
+}
+
+func validateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("expected an http or https url")
+	}
+	return nil
+}
+
+func invalidConfig(err error, format string, args ...any) error {
+	return alerting.ErrNotificationConfigInvalid.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+		Error:  err,
+	})
+}
+
+// Notify posts the firing and just resolved alerts of rule to the
+// Alertmanagers and adds them to the notification groups of their routes.
+func (s *Service) Notify(ctx context.Context, rule *alerting.Rule, alerts []*alerting.Alert) {
+	now := s.now()
+	var notify []*notificationAlert
+	for _, a := range alerts {
+		if na := newNotificationAlert(rule, a, now); na != nil {
+			notify = append(notify, na)
+		}
+	}
+	if len(notify) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	alertmanagers, root := s.config.Alertmanagers, s.route
+	s.mu.RUnlock()
+
+	if root != nil {
+		s.dispatch(root, notify, now)
+	}
+	for _, am := range alertmanagers {
+		if err := s.postAlerts(ctx, am, notify); err != nil {
+			s.log.Warn("Failed to send alerts to Alertmanager", "url", am.URL, "rule", rule.UID, "error", err)
+		}
+	}
+}
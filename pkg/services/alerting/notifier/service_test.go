@@ -0,0 +1,337 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+type request struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+}
+
+type recorder struct {
+	mu   sync.Mutex
+	reqs []request
+}
+
+func (r *recorder) take() []request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reqs := r.reqs
+	r.reqs = nil
+	return reqs
+}
+
+func setupService(t *testing.T) (*Service, *recorder, string, *time.Time) {
+	t.Helper()
+	rec := &recorder{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		rec.mu.Lock()
+		rec.reqs = append(rec.reqs, request{method: r.Method, path: r.URL.Path, header: r.Header, body: string(b)})
+		rec.mu.Unlock()
+	}))
+	t.Cleanup(srv.Close)
+
+	s, err := ProvideService(&setting.Cfg{DataPath: t.TempDir()}, httpclient.NewProvider())
+	require.NoError(t, err)
+	now := time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return now }
+	return s, rec, srv.URL, &now
+}
+
+// flush sends the notifications that are due.
+func flush(s *Service) {
+	now := s.now()
+	for _, g := range s.dueGroups(now) {
+		s.flush(context.Background(), g, now)
+	}
+}
+
+func firing(job string, firedAt time.Time) *alerting.Alert {
+	return &alerting.Alert{
+		Labels:         map[string]string{"alertname": "Errors", "job": job},
+		Annotations:    map[string]string{"summary": job + " errors"},
+		State:          alerting.StateFiring,
+		FiredAt:        firedAt,
+		LastEvaluation: firedAt,
+	}
+}
+
+func resolved(job string, firedAt, now time.Time) *alerting.Alert {
+	a := firing(job, firedAt)
+	a.State, a.ResolvedAt, a.LastEvaluation = alerting.StateNormal, now, now
+	return a
+}
+
+func webhookMessages(t *testing.T, reqs []request) []*message {
+	t.Helper()
+	msgs := make([]*message, 0, len(reqs))
+	for _, r := range reqs {
+		msg := &message{}
+		require.NoError(t, json.Unmarshal([]byte(r.body), msg))
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestService_Config(t *testing.T) {
+	s, _, url, _ := setupService(t)
+	ctx := context.Background()
+
+	invalid := []*alerting.SaveNotificationConfigCommand{
+		{Alertmanagers: []*alerting.AlertmanagerConfig{{URL: "alertmanager:9093"}}},
+		{Receivers: []*alerting.Receiver{{Name: "a"}, {Name: "a"}}},
+		{Receivers: []*alerting.Receiver{{Name: "a", Webhooks: []*alerting.WebhookConfig{{URL: url, HTTPMethod: "GET"}}}}},
+		{Receivers: []*alerting.Receiver{{Name: "a", Webhooks: []*alerting.WebhookConfig{{URL: url, Body: "{{ .Alerts"}}}}},
+		{Route: &alerting.Route{}},
+		{Route: &alerting.Route{Receiver: "missing"}},
+		{Receivers: []*alerting.Receiver{{Name: "a"}}, Route: &alerting.Route{Receiver: "a", Routes: []*alerting.Route{{Matchers: []string{"job=~\"(\""}}}}},
+		{Receivers: []*alerting.Receiver{{Name: "a"}}, Route: &alerting.Route{Receiver: "a", GroupWait: "soon"}},
+	}
+	for _, cmd := range invalid {
+		_, err := s.SaveNotificationConfig(ctx, cmd)
+		require.ErrorIs(t, err, alerting.ErrNotificationConfigInvalid)
+	}
+
+	config, err := s.SaveNotificationConfig(ctx, &alerting.SaveNotificationConfigCommand{
+		Receivers: []*alerting.Receiver{{Name: "team", Webhooks: []*alerting.WebhookConfig{{URL: url}}}},
+		Route:     &alerting.Route{Receiver: "team"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, config.Version)
+	require.Equal(t, http.MethodPost, config.Receivers[0].Webhooks[0].HTTPMethod)
+
+	_, err = s.SaveNotificationConfig(ctx, &alerting.SaveNotificationConfigCommand{Version: 5})
+	require.ErrorIs(t, err, alerting.ErrNotificationConfigVersionMismatch)
+
+	reloaded, err := ProvideService(&setting.Cfg{DataPath: filepath.Dir(filepath.Dir(s.configStore.path))}, httpclient.NewProvider())
+	require.NoError(t, err)
+	got, err := reloaded.GetNotificationConfig(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, got.Version)
+	require.NotNil(t, reloaded.route)
+}
+
+func TestService_Dispatch(t *testing.T) {
+	s, rec, url, now := setupService(t)
+	ctx := context.Background()
+	_, err := s.SaveNotificationConfig(ctx, &alerting.SaveNotificationConfigCommand{
+		Receivers: []*alerting.Receiver{
+			{Name: "default", Webhooks: []*alerting.WebhookConfig{{URL: url + "/default"}}},
+			{Name: "api", Webhooks: []*alerting.WebhookConfig{{URL: url + "/api", Headers: map[string]string{"Authorization": "Bearer token"}}}},
+		},
+		Route: &alerting.Route{
+			Receiver:       "default",
+			GroupBy:        []string{"alertname"},
+			GroupWait:      "30s",
+			GroupInterval:  "1m",
+			RepeatInterval: "1h",
+			Routes: []*alerting.Route{
+				{Receiver: "api", Matchers: []string{`job="api"`}, Continue: true},
+				{Matchers: []string{`alertname=~"Err.*"`}},
+			},
+		},
+	})
+	require.NoError(t, err)
+	rule := &alerting.Rule{UID: "errors", Title: "Errors", Interval: "1m"}
+	start := *now
+
+	s.Notify(ctx, rule, []*alerting.Alert{firing("api", start), firing("web", start)})
+	flush(s)
+	require.Empty(t, rec.take(), "new groups wait for more alerts")
+
+	*now = start.Add(30 * time.Second)
+	flush(s)
+	reqs := rec.take()
+	require.Len(t, reqs, 2)
+	byPath := map[string]*message{}
+	for i, msg := range webhookMessages(t, reqs) {
+		byPath[reqs[i].path] = msg
+	}
+	require.Len(t, byPath["/default"].Alerts, 2, "api continues to the default route")
+	require.Equal(t, "firing", byPath["/default"].Status)
+	require.Equal(t, "4", byPath["/default"].Version)
+	require.Equal(t, map[string]string{"alertname": "Errors"}, byPath["/default"].GroupLabels)
+	require.Equal(t, map[string]string{"alertname": "Errors", ruleUIDLabel: "errors"}, byPath["/default"].CommonLabels)
+	require.Len(t, byPath["/api"].Alerts, 1)
+	require.Equal(t, "api", byPath["/api"].Alerts[0].Labels["job"])
+	for _, r := range reqs {
+		if r.path == "/api" {
+			require.Equal(t, "Bearer token", r.header.Get("Authorization"))
+		}
+	}
+
+	// Unchanged groups wait for the repeat interval.
+	*now = start.Add(2 * time.Minute)
+	s.Notify(ctx, rule, []*alerting.Alert{firing("api", start), firing("web", start)})
+	flush(s)
+	require.Empty(t, rec.take())
+	*now = start.Add(90 * time.Minute)
+	s.Notify(ctx, rule, []*alerting.Alert{firing("api", start), firing("web", start)})
+	flush(s)
+	require.Len(t, rec.take(), 2)
+
+	// Resolved alerts are sent once with the next group interval.
+	*now = start.Add(90*time.Minute + 30*time.Second)
+	s.Notify(ctx, rule, []*alerting.Alert{resolved("api", start, *now), firing("web", start)})
+	flush(s)
+	require.Empty(t, rec.take())
+	*now = start.Add(91 * time.Minute)
+	flush(s)
+	msgs := webhookMessages(t, rec.take())
+	require.Len(t, msgs, 2)
+	for _, msg := range msgs {
+		require.NotEmpty(t, msg.Alerts.Resolved())
+	}
+	*now = start.Add(93 * time.Minute)
+	flush(s)
+	require.Empty(t, rec.take())
+	require.Len(t, s.groups, 1, "the group of the api route is empty")
+}
+
+func TestService_Silences(t *testing.T) {
+	s, rec, url, now := setupService(t)
+	ctx := context.Background()
+	_, err := s.SaveNotificationConfig(ctx, &alerting.SaveNotificationConfigCommand{
+		Receivers: []*alerting.Receiver{{Name: "default", Webhooks: []*alerting.WebhookConfig{{URL: url}}}},
+		Route:     &alerting.Route{Receiver: "default", GroupBy: []string{"..."}, GroupWait: "0s", GroupInterval: "1m"},
+	})
+	require.NoError(t, err)
+	start := *now
+
+	_, err = s.AddSilence(ctx, &alerting.AddSilenceCommand{EndsAt: start.Add(time.Hour)})
+	require.ErrorIs(t, err, alerting.ErrSilenceInvalid)
+	_, err = s.AddSilence(ctx, &alerting.AddSilenceCommand{Matchers: []*alerting.Matcher{{Name: "job", Value: "(", IsEqual: true}}, EndsAt: start.Add(time.Hour)})
+	require.NoError(t, err, "plain matchers aren't regular expressions")
+	_, err = s.AddSilence(ctx, &alerting.AddSilenceCommand{Matchers: []*alerting.Matcher{{Name: "job", Value: "(", IsRegex: true, IsEqual: true}}, EndsAt: start.Add(time.Hour)})
+	var e errutil.Error
+	require.True(t, errors.As(err, &e))
+	require.Equal(t, "alerting.silenceInvalid", e.MessageID)
+
+	silence, err := s.AddSilence(ctx, &alerting.AddSilenceCommand{
+		Matchers: []*alerting.Matcher{{Name: "job", Value: "a.*", IsRegex: true, IsEqual: true}},
+		EndsAt:   start.Add(time.Hour),
+		Comment:  "maintenance",
+	})
+	require.NoError(t, err)
+	require.Equal(t, alerting.SilenceStateActive, silence.Status.State)
+
+	rule := &alerting.Rule{UID: "errors", Title: "Errors", Interval: "1m"}
+	s.Notify(ctx, rule, []*alerting.Alert{firing("api", start), firing("web", start)})
+	flush(s)
+	msgs := webhookMessages(t, rec.take())
+	require.Len(t, msgs, 1, "the api alert is silenced")
+	require.Equal(t, "web", msgs[0].Alerts[0].Labels["job"])
+
+	require.NoError(t, s.ExpireSilence(ctx, &alerting.ExpireSilenceCommand{ID: silence.ID}))
+	got, err := s.GetSilence(ctx, &alerting.GetSilenceQuery{ID: silence.ID})
+	require.NoError(t, err)
+	require.Equal(t, alerting.SilenceStateExpired, got.Status.State)
+	active, err := s.GetSilences(ctx, &alerting.GetSilencesQuery{State: alerting.SilenceStateActive})
+	require.NoError(t, err)
+	require.Len(t, active, 1)
+
+	*now = start.Add(time.Minute)
+	s.Notify(ctx, rule, []*alerting.Alert{firing("api", start), firing("web", start)})
+	flush(s)
+	msgs = webhookMessages(t, rec.take())
+	require.Len(t, msgs, 1)
+	require.Equal(t, "api", msgs[0].Alerts[0].Labels["job"])
+
+	require.NoError(t, s.gcSilences(start.Add(6*24*time.Hour)))
+	_, err = s.GetSilence(ctx, &alerting.GetSilenceQuery{ID: silence.ID})
+	require.ErrorIs(t, err, alerting.ErrSilenceNotFound)
+	require.ErrorIs(t, s.ExpireSilence(ctx, &alerting.ExpireSilenceCommand{ID: "missing"}), alerting.ErrSilenceNotFound)
+}
+
+func TestService_WebhookBody(t *testing.T) {
+	s, rec, url, now := setupService(t)
+	ctx := context.Background()
+	_, err := s.SaveNotificationConfig(ctx, &alerting.SaveNotificationConfigCommand{
+		Receivers: []*alerting.Receiver{{Name: "chat", Webhooks: []*alerting.WebhookConfig{{
+			URL:                   url,
+			HTTPMethod:            http.MethodPut,
+			Headers:               map[string]string{"Content-Type": "text/plain"},
+			Body:                  `[{{ .Status | toUpper }}] {{ range .Alerts.Firing }}{{ .Labels.job }}: {{ .Annotations.summary }} {{ end }}{{ json .GroupLabels }}`,
+			DisableResolveMessage: true,
+		}}}},
+		Route: &alerting.Route{Receiver: "chat", GroupWait: "0s", GroupInterval: "1m"},
+	})
+	require.NoError(t, err)
+	rule := &alerting.Rule{UID: "errors", Title: "Errors", Interval: "1m"}
+	start := *now
+
+	s.Notify(ctx, rule, []*alerting.Alert{firing("api", start)})
+	flush(s)
+	reqs := rec.take()
+	require.Len(t, reqs, 1)
+	require.Equal(t, http.MethodPut, reqs[0].method)
+	require.Equal(t, "text/plain", reqs[0].header.Get("Content-Type"))
+	require.Equal(t, `[FIRING] api: api errors {"alertname":"Errors"}`, reqs[0].body)
+
+	*now = start.Add(time.Minute)
+	s.Notify(ctx, rule, []*alerting.Alert{resolved("api", start, *now)})
+	flush(s)
+	require.Empty(t, rec.take(), "resolve messages are disabled")
+}
+
+func TestService_Alertmanager(t *testing.T) {
+	s, rec, url, now := setupService(t)
+	ctx := context.Background()
+	_, err := s.SaveNotificationConfig(ctx, &alerting.SaveNotificationConfigCommand{
+		Alertmanagers: []*alerting.AlertmanagerConfig{{URL: url + "/"}},
+	})
+	require.NoError(t, err)
+	rule := &alerting.Rule{UID: "errors", Title: "Errors", DashboardUID: "dash", PanelID: 2, Interval: "1m"}
+	start := *now
+
+	s.Notify(ctx, rule, []*alerting.Alert{
+		firing("api", start),
+		{Labels: map[string]string{"job": "web"}, State: alerting.StatePending},
+	})
+	reqs := rec.take()
+	require.Len(t, reqs, 1)
+	require.Equal(t, "/api/v2/alerts", reqs[0].path)
+	var alerts []*notificationAlert
+	require.NoError(t, json.Unmarshal([]byte(reqs[0].body), &alerts))
+	require.Len(t, alerts, 1, "pending alerts aren't sent")
+	require.Equal(t, "errors", alerts[0].Labels[ruleUIDLabel])
+	require.Equal(t, "dash", alerts[0].Annotations["__dashboardUid__"])
+	require.Equal(t, "2", alerts[0].Annotations["__panelId__"])
+	require.True(t, start.Equal(alerts[0].StartsAt))
+	require.True(t, start.Add(4*time.Minute).Equal(alerts[0].EndsAt))
+	require.Empty(t, s.groups, "there is no route")
+
+	*now = start.Add(time.Minute)
+	s.Notify(ctx, rule, []*alerting.Alert{resolved("api", start, *now)})
+	reqs = rec.take()
+	require.Len(t, reqs, 1)
+	require.NoError(t, json.Unmarshal([]byte(reqs[0].body), &alerts))
+	require.True(t, now.Equal(alerts[0].EndsAt))
+
+	// Alerts resolved before are not sent again.
+	*now = start.Add(2 * time.Minute)
+	a := resolved("api", start, start.Add(time.Minute))
+	a.LastEvaluation = *now
+	s.Notify(ctx, rule, []*alerting.Alert{a})
+	require.Empty(t, rec.take())
+}
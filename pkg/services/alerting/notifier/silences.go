@@ -0,0 +1,194 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/util"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+func (s *Service) GetSilences(_ context.Context, query *alerting.GetSilencesQuery) ([]*alerting.Silence, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := s.now()
+	result := make([]*alerting.Silence, 0, len(s.silencesByID))
+	for _, silence := range s.silencesByID {
+		state := silence.State(now)
+		if query.State != "" && state != query.State {
+			continue
+		}
+		result = append(result, withStatus(silence, state))
+	}
+	order := map[alerting.SilenceState]int{alerting.SilenceStateActive: 0, alerting.SilenceStatePending: 1, alerting.SilenceStateExpired: 2}
+	sort.Slice(result, func(i, j int) bool {
+		if a, b := order[result[i].Status.State], order[result[j].Status.State]; a != b {
+			return a < b
+		}
+		return result[i].EndsAt.Before(result[j].EndsAt)
+	})
+	return result, nil
+}
+
+func (s *Service) GetSilence(_ context.Context, query *alerting.GetSilenceQuery) (*alerting.Silence, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	silence, ok := s.silencesByID[query.ID]
+	if !ok {
+		return nil, alerting.ErrSilenceNotFound
+	}
+	return withStatus(silence, silence.State(s.now())), nil
+}
+
+func (s *Service) AddSilence(_ context.Context, cmd *alerting.AddSilenceCommand) (*alerting.Silence, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	silence := &alerting.Silence{
+		ID:        util.GenerateShortUID(),
+		Matchers:  cmd.Matchers,
+		StartsAt:  cmd.StartsAt,
+		EndsAt:    cmd.EndsAt,
+		CreatedBy: cmd.CreatedBy,
+		Comment:   cmd.Comment,
+		UpdatedAt: now,
+	}
+	if silence.StartsAt.IsZero() || silence.StartsAt.Before(now) {
+		silence.StartsAt = now
+	}
+	if len(silence.Matchers) == 0 {
+		return nil, invalidSilence(nil, "at least one matcher is required")
+	}
+	for _, m := range silence.Matchers {
+		if m == nil {
+			return nil, invalidSilence(nil, "matchers can't be null")
+		}
+		if err := m.Compile(); err != nil {
+			return nil, invalidSilence(err, "%s", err)
+		}
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		return nil, invalidSilence(nil, "endsAt must be after startsAt")
+	}
+
+	if err := s.saveSilences(silence); err != nil {
+		return nil, err
+	}
+	s.log.Info("Added silence", "id", silence.ID, "endsAt", silence.EndsAt)
+	return withStatus(silence, silence.State(now)), nil
+}
+
+func (s *Service) ExpireSilence(_ context.Context, cmd *alerting.ExpireSilenceCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.silencesByID[cmd.ID]
+	if !ok {
+		return alerting.ErrSilenceNotFound
+	}
+	now := s.now()
+	if existing.State(now) == alerting.SilenceStateExpired {
+		return nil
+	}
+
+	silence := *existing
+	silence.EndsAt, silence.UpdatedAt = now, now
+	if silence.StartsAt.After(now) {
+		silence.StartsAt = now
+	}
+	if err := s.saveSilences(&silence); err != nil {
+		return err
+	}
+	s.log.Info("Expired silence", "id", silence.ID)
+	return nil
+}
+
+// saveSilences persists the silences with changed swapped in, then swaps it
+// in. The caller must hold the write lock.
+func (s *Service) saveSilences(changed *alerting.Silence) error {
+	silences := make([]*alerting.Silence, 0, len(s.silencesByID)+1)
+	for id, silence := range s.silencesByID {
+		if id != changed.ID {
+			silences = append(silences, silence)
+		}
+	}
+	silences = append(silences, changed)
+	if err := s.silencesStore.save(sortedSilences(silences)); err != nil {
+		return err
+	}
+	s.silencesByID[changed.ID] = changed
+	return nil
+}
+
+// gcSilences drops the silences that expired more than silenceRetention ago.
+func (s *Service) gcSilences(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := make([]*alerting.Silence, 0, len(s.silencesByID))
+	for _, silence := range s.silencesByID {
+		if now.Sub(silence.EndsAt) <= silenceRetention {
+			kept = append(kept, silence)
+		}
+	}
+	if len(kept) == len(s.silencesByID) {
+		return nil
+	}
+	if err := s.silencesStore.save(sortedSilences(kept)); err != nil {
+		return err
+	}
+	s.silencesByID = make(map[string]*alerting.Silence, len(kept))
+	for _, silence := range kept {
+		s.silencesByID[silence.ID] = silence
+	}
+	return nil
+}
+
+// activeSilences returns the silences that are active at now.
+func (s *Service) activeSilences(now time.Time) []*alerting.Silence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var active []*alerting.Silence
+	for _, silence := range s.silencesByID {
+		if silence.State(now) == alerting.SilenceStateActive {
+			active = append(active, silence)
+		}
+	}
+	return active
+}
+
+func muted(silences []*alerting.Silence, labels map[string]string, now time.Time) bool {
+	for _, silence := range silences {
+		if silence.Mutes(labels, now) {
+			return true
+		}
+	}
+	return false
+}
+
+func withStatus(silence *alerting.Silence, state alerting.SilenceState) *alerting.Silence {
+	result := *silence
+	result.Status = &alerting.SilenceStatus{State: state}
+	return &result
+}
+
+func sortedSilences(silences []*alerting.Silence) []*alerting.Silence {
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].ID < silences[j].ID
+	})
+	return silences
+}
+
+func invalidSilence(err error, format string, args ...any) error {
+	return alerting.ErrSilenceInvalid.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+		Error:  err,
+	})
+}
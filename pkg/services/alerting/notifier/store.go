@@ -0,0 +1,28 @@
+package notifier
+
+import (
+	"fmt"
+
+	"github.com/xquare-dashboard/pkg/infra/fs"
+)
+
+// fileStore persists a value as a JSON file. The notification config contains
+// the headers of the webhooks, the files are only readable by the server user.
+type fileStore struct {
+	path string
+}
+
+// load reads the file into v, it is left as it is when the file doesn't exist.
+func (f *fileStore) load(v any) error {
+	if err := fs.ReadJSON(f.path, v); err != nil {
+		return fmt.Errorf("failed to read %q: %w", f.path, err)
+	}
+	return nil
+}
+
+func (f *fileStore) save(v any) error {
+	if err := fs.WriteJSONAtomic(f.path, v, 0o600); err != nil {
+		return fmt.Errorf("failed to save %q: %w", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package notifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/services/alerting"
+)
+
+const (
+	statusFiring   = "firing"
+	statusResolved = "resolved"
+)
+
+// message is the notification of a group, it has the format of the messages
+// of Alertmanager's webhook receiver.
+type message struct {
+	Version           string            `json:"version"`
+	GroupKey          string            `json:"groupKey"`
+	Status            string            `json:"status"`
+	Receiver          string            `json:"receiver"`
+	GroupLabels       map[string]string `json:"groupLabels"`
+	CommonLabels      map[string]string `json:"commonLabels"`
+	CommonAnnotations map[string]string `json:"commonAnnotations"`
+	TruncatedAlerts   int               `json:"truncatedAlerts"`
+	Alerts            messageAlerts     `json:"alerts"`
+}
+
+type messageAlert struct {
+	Status      string            `json:"status"`
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+type messageAlerts []*messageAlert
+
+// Firing returns the firing alerts, for the body templates.
+func (as messageAlerts) Firing() messageAlerts {
+	return as.withStatus(statusFiring)
+}
+
+// Resolved returns the resolved alerts, for the body templates.
+func (as messageAlerts) Resolved() messageAlerts {
+	return as.withStatus(statusResolved)
+}
+
+func (as messageAlerts) withStatus(status string) messageAlerts {
+	result := messageAlerts{}
+	for _, a := range as {
+		if a.Status == status {
+			result = append(result, a)
+		}
+	}
+	return result
+}
+
+func newMessage(groupKey, receiver string, groupLabels map[string]string, firing, resolved []*notificationAlert) *message {
+	sortAlerts(firing)
+	sortAlerts(resolved)
+	msg := &message{
+		Version:     "4",
+		GroupKey:    groupKey,
+		Status:      statusResolved,
+		Receiver:    receiver,
+		GroupLabels: groupLabels,
+		Alerts:      make(messageAlerts, 0, len(firing)+len(resolved)),
+	}
+	if len(firing) > 0 {
+		msg.Status = statusFiring
+	}
+	all := append(append([]*notificationAlert{}, firing...), resolved...)
+	for i, a := range all {
+		status := statusResolved
+		if i < len(firing) {
+			status = statusFiring
+		}
+		msg.Alerts = append(msg.Alerts, &messageAlert{
+			Status:      status,
+			Labels:      a.Labels,
+			Annotations: a.Annotations,
+			StartsAt:    a.StartsAt,
+			EndsAt:      a.EndsAt,
+			Fingerprint: a.fingerprint(),
+		})
+	}
+	msg.CommonLabels = common(all, func(a *notificationAlert) map[string]string { return a.Labels })
+	msg.CommonAnnotations = common(all, func(a *notificationAlert) map[string]string { return a.Annotations })
+	return msg
+}
+
+// common returns the pairs all alerts have.
+func common(alerts []*notificationAlert, pairs func(*notificationAlert) map[string]string) map[string]string {
+	result := map[string]string{}
+	if len(alerts) == 0 {
+		return result
+	}
+	for k, v := range pairs(alerts[0]) {
+		result[k] = v
+	}
+	for _, a := range alerts[1:] {
+		p := pairs(a)
+		for k, v := range result {
+			if p[k] != v {
+				delete(result, k)
+			}
+		}
+	}
+	return result
+}
+
+var bodyFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":    strings.Join,
+	"toUpper": strings.ToUpper,
+	"toLower": strings.ToLower,
+}
+
+// parseBodyTemplate parses the body template of a webhook, it returns nil
+// for an empty body.
+func parseBodyTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("body").Option("missingkey=zero").Funcs(bodyFuncs).Parse(text)
+}
+
+func renderBody(wh *alerting.WebhookConfig, msg *message) ([]byte, error) {
+	tmpl, err := parseBodyTemplate(wh.Body)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl == nil {
+		return json.Marshal(msg)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, msg); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// send sends msg to the webhooks of receiver, it returns the first error
+// after trying all webhooks.
+func (s *Service) send(ctx context.Context, receiver *alerting.Receiver, msg *message) error {
+	var firstErr error
+	for _, wh := range receiver.Webhooks {
+		if wh.DisableResolveMessage && msg.Status == statusResolved {
+			continue
+		}
+		body, err := renderBody(wh, msg)
+		if err == nil {
+			err = s.do(ctx, wh.HTTPMethod, wh.URL, wh.Headers, body)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("webhook %s: %w", wh.URL, err)
+		}
+	}
+	return firstErr
+}
+
+// postAlerts posts alerts to the alerts API of an Alertmanager.
+func (s *Service) postAlerts(ctx context.Context, am *alerting.AlertmanagerConfig, alerts []*notificationAlert) error {
+	body, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	return s.do(ctx, http.MethodPost, strings.TrimSuffix(am.URL, "/")+"/api/v2/alerts", am.Headers, body)
+}
+
+func (s *Service) do(ctx context.Context, method, url string, headers map[string]string, body []byte) error {
+	if method == "" {
+		method = http.MethodPost
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			s.log.Warn("Failed to close response body", "error", err)
+		}
+	}()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
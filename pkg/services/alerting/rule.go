@@ -0,0 +1,188 @@
+package alerting
+
+import (
+	"time"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+// Rule is an alert rule. Its queries are evaluated at every interval, every
+// series of the condition whose last value isn't 0 is an alert.
+type Rule struct {
+	UID   string `json:"uid"`
+	Title string `json:"title"`
+	// DashboardUID and PanelID link the rule to the panel it watches. The
+	// queries of a linked panel are evaluated along with Data, so Data only
+	// needs the expressions on them.
+	DashboardUID string `json:"dashboardUid,omitempty"`
+	PanelID      int64  `json:"panelId,omitempty"`
+	// Data are queries and expressions in the format of MetricRequest.Queries.
+	Data []*simplejson.Json `json:"data"`
+	// Condition is the refId of the query or expression that decides whether
+	// the series are alerting.
+	Condition string `json:"condition"`
+	// Time is the range the queries are evaluated over. The range of a linked
+	// dashboard applies when empty, otherwise now-10m to now.
+	Time TimeRange `json:"time"`
+	// Interval is how often the rule is evaluated, e.g. 1m.
+	Interval string `json:"interval"`
+	// For is how long a series must be alerting before it fires, it fires at
+	// the first evaluation when empty.
+	For string `json:"for,omitempty"`
+	// Labels are added to the labels of every alert of the rule. Annotations
+	// are templates expanded per alert, e.g. {{ $labels.job }} is {{ $value }}.
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	// IsPaused stops the evaluation of the rule.
+	IsPaused bool `json:"isPaused"`
+	Version  int  `json:"version"`
+
+	Created time.Time `json:"created,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+func (r *Rule) GetUID() string      { return r.UID }
+func (r *Rule) GetInterval() string { return r.Interval }
+func (r *Rule) GetIsPaused() bool   { return r.IsPaused }
+
+// TimeRange is the time range of a rule, relative using Grafana time units,
+// e.g. now-10m.
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// State is the state of an alert.
+type State string
+
+const (
+	// StateNormal is a series whose condition isn't met.
+	StateNormal State = "Normal"
+	// StatePending is a series whose condition is met for less than the For
+	// duration of its rule.
+	StatePending State = "Pending"
+	// StateFiring is a series whose condition is met for the For duration.
+	StateFiring State = "Firing"
+	// StateNoData is a rule whose condition returned no series.
+	StateNoData State = "NoData"
+	// StateError is a rule that failed to be evaluated.
+	StateError State = "Error"
+)
+
+// Alert is the state of a series of a rule, or of the rule itself when it
+// returned no data or failed.
+type Alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	State       State             `json:"state"`
+	// Value is the last value of the series of the condition.
+	Value *float64 `json:"value,omitempty"`
+	Error string   `json:"error,omitempty"`
+	// ActiveAt is when the condition started to be met, FiredAt when the
+	// alert last started firing and ResolvedAt when it last stopped.
+	ActiveAt       time.Time `json:"activeAt,omitempty"`
+	FiredAt        time.Time `json:"firedAt,omitempty"`
+	ResolvedAt     time.Time `json:"resolvedAt,omitempty"`
+	LastEvaluation time.Time `json:"lastEvaluation"`
+}
+
+// Health of the last evaluation of a rule.
+const (
+	HealthUnknown = "unknown"
+	HealthOk      = "ok"
+	HealthNoData  = "nodata"
+	HealthError   = "error"
+)
+
+// RuleState is the result of the last evaluation of a rule with its alerts.
+type RuleState struct {
+	UID          string `json:"uid"`
+	Title        string `json:"title"`
+	DashboardUID string `json:"dashboardUid,omitempty"`
+	PanelID      int64  `json:"panelId,omitempty"`
+	Health       string `json:"health"`
+	LastError    string `json:"lastError,omitempty"`
+	// EvaluationTime is how long the last evaluation took, in seconds.
+	EvaluationTime float64   `json:"evaluationTime"`
+	LastEvaluation time.Time `json:"lastEvaluation,omitempty"`
+	Alerts         []*Alert  `json:"alerts"`
+}
+
+type GetRuleQuery struct {
+	UID string
+	// Identity must be allowed to query the datasources of the rule.
+	Identity *authn.Identity
+}
+
+type GetRulesQuery struct {
+	// DashboardUID and PanelID limit the result to the rules of a dashboard
+	// or a panel, when set.
+	DashboardUID string
+	PanelID      int64
+	// Identity limits the result to the rules whose datasources it may
+	// query.
+	Identity *authn.Identity
+}
+
+type GetStatesQuery struct {
+	// RuleUID limits the result to a rule and DashboardUID to the rules of a
+	// dashboard, when set.
+	RuleUID      string
+	DashboardUID string
+	// State limits the alerts to the ones in the state, when set.
+	State State
+	// Identity limits the result to the rules whose datasources it may
+	// query.
+	Identity *authn.Identity
+}
+
+// AddRuleCommand creates a rule. A UID is generated when none is given.
+type AddRuleCommand struct {
+	UID          string             `json:"uid"`
+	Title        string             `json:"title"`
+	DashboardUID string             `json:"dashboardUid"`
+	PanelID      int64              `json:"panelId"`
+	Data         []*simplejson.Json `json:"data"`
+	Condition    string             `json:"condition"`
+	Time         TimeRange          `json:"time"`
+	Interval     string             `json:"interval"`
+	For          string             `json:"for"`
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	IsPaused     bool               `json:"isPaused"`
+
+	// Identity must be allowed to query the datasources of the rule.
+	Identity *authn.Identity `json:"-"`
+}
+
+// UpdateRuleCommand replaces the rule with the given UID. The state of its
+// alerts is kept.
+type UpdateRuleCommand struct {
+	Title        string             `json:"title"`
+	DashboardUID string             `json:"dashboardUid"`
+	PanelID      int64              `json:"panelId"`
+	Data         []*simplejson.Json `json:"data"`
+	Condition    string             `json:"condition"`
+	Time         TimeRange          `json:"time"`
+	Interval     string             `json:"interval"`
+	For          string             `json:"for"`
+	Labels       map[string]string  `json:"labels"`
+	Annotations  map[string]string  `json:"annotations"`
+	IsPaused     bool               `json:"isPaused"`
+
+	// Version is the version the update is based on. When set, the update is
+	// rejected with ErrRuleVersionMismatch if the rule changed since.
+	Version int `json:"version"`
+
+	UID string `json:"-"`
+	// Identity must be allowed to query the datasources of the rule, before
+	// and after the update.
+	Identity *authn.Identity `json:"-"`
+}
+
+type DeleteRuleCommand struct {
+	UID string
+	// Identity must be allowed to query the datasources of the rule.
+	Identity *authn.Identity
+}
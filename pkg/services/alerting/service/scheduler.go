@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+
+	"github.com/xquare-dashboard/pkg/expr"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/services/query"
+)
+
+// Run evaluates the alert rules until ctx is done.
+func (s *Service) Run(ctx context.Context) error {
+	if !s.cfg.AlertingEnabled {
+		s.log.Info("Alerting is disabled, alert rules are not evaluated")
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.scheduler.Run(ctx, s.listRules, s.evaluate)
+}
+
+func (s *Service) listRules() []*alerting.Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store.List()
+}
+
+// evaluate runs the queries of rule and updates the state of its alerts with
+// the result of its condition at now.
+func (s *Service) evaluate(ctx context.Context, rule *alerting.Rule, now time.Time) {
+	start := time.Now()
+	results, err := s.conditionResults(ctx, rule)
+	health := alerting.HealthOk
+	switch {
+	case err != nil:
+		s.log.Warn("Failed to evaluate alert rule", "uid", rule.UID, "error", err)
+		health, results = alerting.HealthError, []result{{state: alerting.StateError, err: err}}
+	case len(results) == 0:
+		health, results = alerting.HealthNoData, []result{{state: alerting.StateNoData}}
+	}
+	// The rule was validated when it was saved.
+	pendingFor, _ := gtime.ParseDuration(rule.For)
+
+	s.stateMu.Lock()
+	st, ok := s.states[rule.UID]
+	if !ok {
+		// The rule was deleted during the evaluation.
+		s.stateMu.Unlock()
+		return
+	}
+	st.health = health
+	st.lastError = ""
+	if err != nil {
+		st.lastError = err.Error()
+	}
+	st.lastEvaluation = now
+	st.evaluationTime = time.Since(start)
+	st.update(rule, results, now, pendingFor)
+	alerts := st.snapshot(rule, "").Alerts
+	s.stateMu.Unlock()
+
+	s.notifier.Notify(ctx, rule, alerts)
+}
+
+// conditionResults runs the queries of rule and returns the last value of
+// every series of its condition. Series without value are left out.
+func (s *Service) conditionResults(ctx context.Context, rule *alerting.Rule) ([]result, error) {
+	req, err := s.metricRequest(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = query.WithoutCache(ctx)
+	if s.cfg.AlertingEvaluationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.AlertingEvaluationTimeout)
+		defer cancel()
+	}
+	resp, err := s.queryService.QueryData(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	dr, ok := resp.Responses[rule.Condition]
+	if !ok {
+		return nil, fmt.Errorf("condition %s returned no result", rule.Condition)
+	}
+	if dr.Error != nil {
+		return nil, dr.Error
+	}
+
+	var results []result
+	for _, v := range expr.FromFrames(dr.Frames).Values {
+		value, ok := expr.LastValue(v)
+		if !ok {
+			continue
+		}
+		state := alerting.StateNormal
+		if value != 0 {
+			state = alerting.StateFiring
+		}
+		results = append(results, result{labels: v.GetLabels(), state: state, value: &value})
+	}
+	return results, nil
+}
@@ -0,0 +1,377 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/rules"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+const (
+	defaultInterval = "1m"
+	defaultTimeFrom = "now-10m"
+	defaultTimeTo   = "now"
+)
+
+// Service stores alert rules in the data directory and evaluates them in the
+// background with the query service.
+type Service struct {
+	cfg              *setting.Cfg
+	log              log.Logger
+	scheduler        *rules.Scheduler[*alerting.Rule]
+	queryService     query.Service
+	dashboardService dashboards.DashboardService
+	notifier         alerting.Notifier
+
+	mu    sync.RWMutex
+	store *rules.Store[*alerting.Rule]
+
+	// states has the state of every stored rule.
+	stateMu sync.Mutex
+	states  map[string]*ruleState
+}
+
+var _ alerting.AlertingService = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg, queryService query.Service, dashboardService dashboards.DashboardService, notifier alerting.Notifier) (*Service, error) {
+	store, err := rules.NewStore(filepath.Join(cfg.DataPath, "alerting", "rules.json"), "alert rules", lessRule)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New("alerting")
+	s := &Service{
+		cfg:              cfg,
+		log:              logger,
+		scheduler:        rules.NewScheduler[*alerting.Rule](logger, cfg.AlertingMinInterval),
+		queryService:     queryService,
+		dashboardService: dashboardService,
+		notifier:         notifier,
+		store:            store,
+		states:           map[string]*ruleState{},
+	}
+	for _, rule := range store.List() {
+		s.states[rule.UID] = newRuleState()
+	}
+	return s, nil
+}
+
+func (s *Service) GetRule(ctx context.Context, query *alerting.GetRuleQuery) (*alerting.Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rule, ok := s.store.Get(query.UID)
+	if !ok {
+		return nil, alerting.ErrRuleNotFound
+	}
+	if err := s.checkPermission(ctx, query.Identity, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *Service) GetRules(ctx context.Context, query *alerting.GetRulesQuery) ([]*alerting.Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := s.store.List()
+	result := make([]*alerting.Rule, 0, len(all))
+	for _, rule := range all {
+		if query.DashboardUID != "" && rule.DashboardUID != query.DashboardUID {
+			continue
+		}
+		if query.PanelID != 0 && rule.PanelID != query.PanelID {
+			continue
+		}
+		if err := s.checkPermission(ctx, query.Identity, rule); err != nil {
+			continue
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+func (s *Service) AddRule(ctx context.Context, cmd *alerting.AddRuleCommand) (*alerting.Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uid := cmd.UID
+	if uid == "" {
+		var ok bool
+		if uid, ok = s.store.GenerateUID(); !ok {
+			return nil, alerting.ErrRuleFailedGenerateUniqueUid
+		}
+	}
+	if !util.IsValidShortUID(uid) || util.IsShortUIDTooLong(uid) {
+		return nil, alerting.ErrRuleUIDInvalid.Errorf("invalid uid %q", uid)
+	}
+	if _, ok := s.store.Get(uid); ok {
+		return nil, alerting.ErrRuleUidExists
+	}
+
+	now := time.Now()
+	rule := &alerting.Rule{
+		UID:          uid,
+		Title:        strings.TrimSpace(cmd.Title),
+		DashboardUID: cmd.DashboardUID,
+		PanelID:      cmd.PanelID,
+		Data:         cmd.Data,
+		Condition:    cmd.Condition,
+		Time:         cmd.Time,
+		Interval:     cmd.Interval,
+		For:          cmd.For,
+		Labels:       cmd.Labels,
+		Annotations:  cmd.Annotations,
+		IsPaused:     cmd.IsPaused,
+		Version:      1,
+		Created:      now,
+		Updated:      now,
+	}
+	if err := s.prepare(ctx, cmd.Identity, rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Save(rule); err != nil {
+		return nil, err
+	}
+	s.stateMu.Lock()
+	s.states[rule.UID] = newRuleState()
+	s.stateMu.Unlock()
+	s.log.Info("Added alert rule", "uid", rule.UID, "title", rule.Title)
+	return rule, nil
+}
+
+func (s *Service) UpdateRule(ctx context.Context, cmd *alerting.UpdateRuleCommand) (*alerting.Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.store.Get(cmd.UID)
+	if !ok {
+		return nil, alerting.ErrRuleNotFound
+	}
+	if err := s.checkPermission(ctx, cmd.Identity, existing); err != nil {
+		return nil, err
+	}
+	if cmd.Version != 0 && cmd.Version != existing.Version {
+		return nil, alerting.ErrRuleVersionMismatch
+	}
+
+	rule := &alerting.Rule{
+		UID:          existing.UID,
+		Title:        strings.TrimSpace(cmd.Title),
+		DashboardUID: cmd.DashboardUID,
+		PanelID:      cmd.PanelID,
+		Data:         cmd.Data,
+		Condition:    cmd.Condition,
+		Time:         cmd.Time,
+		Interval:     cmd.Interval,
+		For:          cmd.For,
+		Labels:       cmd.Labels,
+		Annotations:  cmd.Annotations,
+		IsPaused:     cmd.IsPaused,
+		Version:      existing.Version + 1,
+		Created:      existing.Created,
+		Updated:      time.Now(),
+	}
+	if err := s.prepare(ctx, cmd.Identity, rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Save(rule); err != nil {
+		return nil, err
+	}
+	s.log.Info("Updated alert rule", "uid", rule.UID, "title", rule.Title, "version", rule.Version)
+	return rule, nil
+}
+
+func (s *Service) DeleteRule(ctx context.Context, cmd *alerting.DeleteRuleCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.store.Get(cmd.UID)
+	if !ok {
+		return alerting.ErrRuleNotFound
+	}
+	if err := s.checkPermission(ctx, cmd.Identity, rule); err != nil {
+		return err
+	}
+	if err := s.store.Delete(cmd.UID); err != nil {
+		return err
+	}
+
+	s.stateMu.Lock()
+	delete(s.states, cmd.UID)
+	s.stateMu.Unlock()
+
+	s.log.Info("Deleted alert rule", "uid", cmd.UID, "title", rule.Title)
+	return nil
+}
+
+// prepare validates rule and fills in defaults. Rules are evaluated without
+// request, so identity must be allowed to query all their datasources.
+func (s *Service) prepare(ctx context.Context, identity *authn.Identity, rule *alerting.Rule) error {
+	if rule.Title == "" {
+		return invalidRule(nil, "title is required")
+	}
+	if rule.Labels == nil {
+		rule.Labels = map[string]string{}
+	}
+	if rule.Annotations == nil {
+		rule.Annotations = map[string]string{}
+	}
+
+	if rule.Interval == "" {
+		rule.Interval = defaultInterval
+	}
+	if _, err := rules.ParseInterval(rule.Interval, s.cfg.AlertingMinInterval); err != nil {
+		return invalidRule(err, "%s", err)
+	}
+	if rule.For != "" {
+		if pending, err := gtime.ParseDuration(rule.For); err != nil || pending < 0 {
+			return invalidRule(err, "invalid for %q", rule.For)
+		}
+	}
+	for name, text := range rule.Annotations {
+		if _, err := parseTemplate(text); err != nil {
+			return invalidRule(err, "invalid annotation %s: %s", name, err)
+		}
+	}
+
+	// Rules of a panel use the time range of its dashboard by default.
+	if rule.PanelID == 0 && rule.Time.From == "" && rule.Time.To == "" {
+		rule.Time = alerting.TimeRange{From: defaultTimeFrom, To: defaultTimeTo}
+	}
+	if rule.PanelID != 0 && rule.DashboardUID == "" {
+		return invalidRule(nil, "panelId requires a dashboardUid")
+	}
+	if rule.DashboardUID != "" && rule.PanelID == 0 {
+		if _, err := s.dashboardService.GetDashboard(ctx, &dashboards.GetDashboardQuery{UID: rule.DashboardUID}); err != nil {
+			return s.linkError(rule, err)
+		}
+	}
+
+	req, err := s.metricRequest(ctx, rule)
+	if err != nil {
+		return s.linkError(rule, err)
+	}
+	if len(req.Queries) == 0 {
+		return invalidRule(nil, "at least one query is required")
+	}
+	refIDs := make(map[string]bool, len(req.Queries))
+	for _, q := range req.Queries {
+		refID := q.Get("refId").MustString()
+		if refID == "" {
+			return invalidRule(nil, "every query needs a refId")
+		}
+		if refIDs[refID] {
+			return invalidRule(nil, "duplicate refId %s", refID)
+		}
+		refIDs[refID] = true
+	}
+	if !refIDs[rule.Condition] {
+		return invalidRule(nil, "condition %q is not the refId of a query", rule.Condition)
+	}
+	if err := s.queryService.CheckQueryPermission(ctx, identity, req); err != nil {
+		if errors.Is(err, datasources.ErrInvalidDatasourceID) {
+			return invalidRule(err, "datasource not found")
+		}
+		return err
+	}
+
+	tr, err := query.NewDataTimeRange(req.From, req.To, req.Timezone, req.WeekStart, req.FiscalYearStartMonth)
+	if err == nil {
+		_, _, err = tr.Parse()
+	}
+	return err
+}
+
+// checkPermission returns datasources.ErrDataSourceAccessDenied when
+// identity may not query one of the datasources of a saved rule. Queries
+// whose datasource can't be resolved anymore aren't checked, nor are the
+// queries of a linked panel that was deleted: they can't run either.
+func (s *Service) checkPermission(ctx context.Context, identity *authn.Identity, rule *alerting.Rule) error {
+	req, err := s.metricRequest(ctx, rule)
+	if err != nil {
+		req = dtos.MetricRequest{}
+		for _, q := range rule.Data {
+			if q != nil {
+				req.Queries = append(req.Queries, q)
+			}
+		}
+	}
+	if err := s.queryService.CheckQueryPermission(ctx, identity, req); errors.Is(err, datasources.ErrDataSourceAccessDenied) {
+		return err
+	}
+	return nil
+}
+
+// linkError turns the errors of the dashboard a rule is linked to into
+// validation errors of the rule.
+func (s *Service) linkError(rule *alerting.Rule, err error) error {
+	switch {
+	case errors.Is(err, dashboards.ErrDashboardNotFound):
+		return invalidRule(err, "dashboard %q not found", rule.DashboardUID)
+	case errors.Is(err, dashboards.ErrDashboardPanelNotFound):
+		return invalidRule(err, "panel %d not found in dashboard %q", rule.PanelID, rule.DashboardUID)
+	}
+	return err
+}
+
+// metricRequest builds the query request of a rule: the queries of its panel,
+// if any, followed by its own queries. Hidden queries are evaluated too, the
+// condition may be any of them.
+func (s *Service) metricRequest(ctx context.Context, rule *alerting.Rule) (dtos.MetricRequest, error) {
+	req := dtos.MetricRequest{From: rule.Time.From, To: rule.Time.To}
+	if rule.PanelID != 0 {
+		var err error
+		req, err = s.dashboardService.GetPanelQuery(ctx, &dashboards.PanelQueryCommand{
+			UID:     rule.DashboardUID,
+			PanelID: rule.PanelID,
+			From:    rule.Time.From,
+			To:      rule.Time.To,
+		})
+		if err != nil {
+			return dtos.MetricRequest{}, err
+		}
+	}
+	for _, q := range rule.Data {
+		if q == nil {
+			continue
+		}
+		q = q.DeepCopy()
+		q.Del("hide")
+		req.Queries = append(req.Queries, q)
+	}
+	return req, nil
+}
+
+func invalidRule(err error, format string, args ...any) error {
+	return alerting.ErrRuleInvalid.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+		Error:  err,
+	})
+}
+
+// lessRule sorts rules by title.
+func lessRule(a, b *alerting.Rule) bool {
+	if a.Title != b.Title {
+		return a.Title < b.Title
+	}
+	return a.UID < b.UID
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/alerting"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	dashboardservice "github.com/xquare-dashboard/pkg/services/dashboards/service"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/query/querytest"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+type fakeNotifier struct {
+	calls [][]*alerting.Alert
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, _ *alerting.Rule, alerts []*alerting.Alert) {
+	f.calls = append(f.calls, alerts)
+}
+
+func setupService(t *testing.T) (*Service, *querytest.FakeService, *dashboardservice.Service) {
+	t.Helper()
+	cfg := &setting.Cfg{DataPath: t.TempDir(), AlertingEnabled: true, AlertingMinInterval: 10 * time.Second}
+	dashService, err := dashboardservice.ProvideService(cfg)
+	require.NoError(t, err)
+	queryService := &querytest.FakeService{}
+	s, err := ProvideService(cfg, queryService, dashService, &fakeNotifier{})
+	require.NoError(t, err)
+	return s, queryService, dashService
+}
+
+func mustJSON(t *testing.T, s string) *simplejson.Json {
+	t.Helper()
+	j, err := simplejson.NewJson([]byte(s))
+	require.NoError(t, err)
+	return j
+}
+
+func TestService_CRUD(t *testing.T) {
+	s, _, _ := setupService(t)
+	ctx := context.Background()
+
+	rule, err := s.AddRule(ctx, &alerting.AddRuleCommand{
+		UID:       "errors",
+		Title:     "Errors",
+		Data:      []*simplejson.Json{mustJSON(t, `{"refId": "A", "datasource": {"uid": "loki"}, "expr": "sum(rate({app=\"api\"} |= \"error\" [5m]))"}`)},
+		Condition: "A",
+		For:       "5m",
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, rule.Version)
+	require.Equal(t, "1m", rule.Interval)
+	require.Equal(t, alerting.TimeRange{From: "now-10m", To: "now"}, rule.Time)
+
+	invalid := []alerting.AddRuleCommand{
+		{Title: " ", Data: rule.Data, Condition: "A"},
+		{Title: "No condition", Data: rule.Data, Condition: "B"},
+		{Title: "No queries", Condition: "A"},
+		{Title: "Interval", Data: rule.Data, Condition: "A", Interval: "15s"},
+		{Title: "For", Data: rule.Data, Condition: "A", For: "soon"},
+		{Title: "Annotation", Data: rule.Data, Condition: "A", Annotations: map[string]string{"summary": "{{ $labels.job"}},
+		{Title: "Dashboard", Data: rule.Data, Condition: "A", DashboardUID: "missing"},
+	}
+	for _, cmd := range invalid {
+		_, err := s.AddRule(ctx, &cmd)
+		require.ErrorIs(t, err, alerting.ErrRuleInvalid, cmd.Title)
+	}
+	_, err = s.AddRule(ctx, &alerting.AddRuleCommand{Title: "Time", Data: rule.Data, Condition: "A", Time: alerting.TimeRange{From: "now", To: "now-1h"}})
+	require.ErrorIs(t, err, query.ErrInvalidTimeRange)
+	_, err = s.AddRule(ctx, &alerting.AddRuleCommand{UID: "errors", Title: "Dup", Data: rule.Data, Condition: "A"})
+	require.ErrorIs(t, err, alerting.ErrRuleUidExists)
+
+	updated, err := s.UpdateRule(ctx, &alerting.UpdateRuleCommand{UID: "errors", Title: "API errors", Data: rule.Data, Condition: "A", Version: 1})
+	require.NoError(t, err)
+	require.Equal(t, 2, updated.Version)
+	require.Equal(t, rule.Created, updated.Created)
+	_, err = s.UpdateRule(ctx, &alerting.UpdateRuleCommand{UID: "errors", Title: "Errors", Data: rule.Data, Condition: "A", Version: 1})
+	require.ErrorIs(t, err, alerting.ErrRuleVersionMismatch)
+
+	// Rules are read back from the data directory.
+	reloaded, err := ProvideService(s.cfg, s.queryService, s.dashboardService, s.notifier)
+	require.NoError(t, err)
+	got, err := reloaded.GetRule(ctx, &alerting.GetRuleQuery{UID: "errors"})
+	require.NoError(t, err)
+	require.Equal(t, "API errors", got.Title)
+
+	require.NoError(t, s.DeleteRule(ctx, &alerting.DeleteRuleCommand{UID: "errors"}))
+	_, err = s.GetRule(ctx, &alerting.GetRuleQuery{UID: "errors"})
+	require.ErrorIs(t, err, alerting.ErrRuleNotFound)
+}
+
+func TestService_PanelRule(t *testing.T) {
+	s, queryService, dashService := setupService(t)
+	ctx := context.Background()
+
+	_, err := dashService.AddDashboard(ctx, &dashboards.AddDashboardCommand{
+		UID:   "api",
+		Title: "API",
+		Time:  dashboards.TimeRange{From: "now-1h", To: "now"},
+		Panels: []*dashboards.Panel{{
+			ID:         1,
+			Datasource: mustJSON(t, `{"uid": "prom"}`),
+			Targets:    []*simplejson.Json{mustJSON(t, `{"refId": "A", "expr": "rate(http_requests_total{code=~\"5..\"}[5m])"}`)},
+		}},
+	})
+	require.NoError(t, err)
+
+	_, err = s.AddRule(ctx, &alerting.AddRuleCommand{
+		Title: "Panel", DashboardUID: "api", PanelID: 2, Condition: "A",
+	})
+	require.ErrorIs(t, err, alerting.ErrRuleInvalid)
+
+	rule, err := s.AddRule(ctx, &alerting.AddRuleCommand{
+		Title:        "High error rate",
+		DashboardUID: "api",
+		PanelID:      1,
+		Data:         []*simplejson.Json{mustJSON(t, `{"refId": "B", "hide": true, "datasource": {"uid": "__expr__"}, "type": "threshold", "expression": "A", "conditions": [{"evaluator": {"type": "gt", "params": [1]}}]}`)},
+		Condition:    "B",
+	})
+	require.NoError(t, err)
+
+	rules, err := s.GetRules(ctx, &alerting.GetRulesQuery{DashboardUID: "api", PanelID: 1})
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+
+	queryService.Resp = backend.NewQueryDataResponse()
+	s.evaluate(ctx, rule, time.Now())
+	require.Len(t, queryService.Reqs, 1)
+	req := queryService.Reqs[0]
+	require.Equal(t, "now-1h", req.From)
+	require.Len(t, req.Queries, 2)
+	require.Equal(t, "prom", req.Queries[0].GetPath("datasource", "uid").MustString())
+	_, hidden := req.Queries[1].CheckGet("hide")
+	require.False(t, hidden)
+	require.True(t, rule.Data[0].Get("hide").MustBool())
+}
+
+func TestService_Permissions(t *testing.T) {
+	s, queryService, dashService := setupService(t)
+	ctx := context.Background()
+	queryService.DataSources = map[string]*datasources.DataSource{
+		"prom": {UID: "prom", Permissions: []*datasources.DataSourcePermission{{Team: "sre", Permission: datasources.PermissionQuery}}},
+	}
+	editor := &authn.Identity{Kind: authn.KindUser, ID: "editor", Role: authn.RoleEditor}
+	sre := &authn.Identity{Kind: authn.KindUser, ID: "sre", Role: authn.RoleEditor, Groups: []string{"sre"}}
+
+	_, err := dashService.AddDashboard(ctx, &dashboards.AddDashboardCommand{
+		UID:   "api",
+		Title: "API",
+		Panels: []*dashboards.Panel{{
+			ID:         1,
+			Datasource: mustJSON(t, `{"uid": "prom"}`),
+			Targets:    []*simplejson.Json{mustJSON(t, `{"refId": "A", "expr": "up"}`)},
+		}},
+	})
+	require.NoError(t, err)
+	prom := []*simplejson.Json{mustJSON(t, `{"refId": "A", "datasource": {"uid": "prom"}, "expr": "up"}`)}
+	loki := []*simplejson.Json{mustJSON(t, `{"refId": "A", "datasource": {"uid": "loki"}, "expr": "1"}`)}
+	threshold := []*simplejson.Json{mustJSON(t, `{"refId": "B", "datasource": {"uid": "__expr__"}, "type": "math", "expression": "$A > 0"}`)}
+
+	// Rules run without user, the user saving them must be allowed to run
+	// their queries and the queries of their panel.
+	_, err = s.AddRule(ctx, &alerting.AddRuleCommand{UID: "up", Title: "Up", Data: prom, Condition: "A", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	_, err = s.AddRule(ctx, &alerting.AddRuleCommand{Title: "Panel", DashboardUID: "api", PanelID: 1, Data: threshold, Condition: "B", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	_, err = s.AddRule(ctx, &alerting.AddRuleCommand{UID: "up", Title: "Up", Data: prom, Condition: "A", Identity: sre})
+	require.NoError(t, err)
+	_, err = s.AddRule(ctx, &alerting.AddRuleCommand{UID: "errors", Title: "Errors", Data: loki, Condition: "A", Identity: editor})
+	require.NoError(t, err)
+
+	_, err = s.UpdateRule(ctx, &alerting.UpdateRuleCommand{UID: "errors", Title: "Errors", Data: prom, Condition: "A", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	_, err = s.UpdateRule(ctx, &alerting.UpdateRuleCommand{UID: "up", Title: "Up", Data: loki, Condition: "A", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	require.ErrorIs(t, s.DeleteRule(ctx, &alerting.DeleteRuleCommand{UID: "up", Identity: editor}), datasources.ErrDataSourceAccessDenied)
+
+	// Reads leave out the rules with a query the user may not run.
+	_, err = s.GetRule(ctx, &alerting.GetRuleQuery{UID: "up", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	rules, err := s.GetRules(ctx, &alerting.GetRulesQuery{Identity: editor})
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	require.Equal(t, "errors", rules[0].UID)
+	rules, err = s.GetRules(ctx, &alerting.GetRulesQuery{Identity: sre})
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	states, err := s.GetStates(ctx, &alerting.GetStatesQuery{Identity: editor})
+	require.NoError(t, err)
+	require.Len(t, states, 1)
+	require.Equal(t, "errors", states[0].UID)
+}
+
+func TestService_Evaluate(t *testing.T) {
+	s, queryService, _ := setupService(t)
+	ctx := context.Background()
+
+	rule, err := s.AddRule(ctx, &alerting.AddRuleCommand{
+		Title:       "Errors",
+		Data:        []*simplejson.Json{mustJSON(t, `{"refId": "A", "datasource": {"uid": "prom"}}`)},
+		Condition:   "A",
+		For:         "2m",
+		Labels:      map[string]string{"severity": "page"},
+		Annotations: map[string]string{"summary": "{{ $labels.job }} errors at {{ $value }}"},
+	})
+	require.NoError(t, err)
+
+	respond := func(values map[string]*float64) {
+		frames := data.Frames{}
+		for job, v := range values {
+			frames = append(frames, data.NewFrame("",
+				data.NewField("Time", nil, []time.Time{time.Unix(0, 0), time.Unix(60, 0)}),
+				data.NewField("Value", data.Labels{"job": job}, []*float64{v, nil}),
+			))
+		}
+		queryService.Resp = backend.NewQueryDataResponse()
+		queryService.Resp.Responses["A"] = backend.DataResponse{Frames: frames}
+		queryService.Err = nil
+	}
+	evaluate := func(now time.Time) *alerting.RuleState {
+		s.evaluate(ctx, rule, now)
+		states, err := s.GetStates(ctx, &alerting.GetStatesQuery{RuleUID: rule.UID})
+		require.NoError(t, err)
+		require.Len(t, states, 1)
+		return states[0]
+	}
+	one, zero := 1.0, 0.0
+	start := time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC)
+
+	respond(map[string]*float64{"api": &one, "web": &zero})
+	state := evaluate(start)
+	require.Equal(t, alerting.HealthOk, state.Health)
+	require.Len(t, state.Alerts, 2)
+	api, web := state.Alerts[0], state.Alerts[1]
+	require.Equal(t, alerting.StatePending, api.State)
+	require.Equal(t, map[string]string{"alertname": "Errors", "job": "api", "severity": "page"}, api.Labels)
+	require.Equal(t, "api errors at 1", api.Annotations["summary"])
+	require.Equal(t, start, api.ActiveAt)
+	require.Equal(t, alerting.StateNormal, web.State)
+
+	state = evaluate(start.Add(time.Minute))
+	require.Equal(t, alerting.StatePending, state.Alerts[0].State)
+
+	state = evaluate(start.Add(2 * time.Minute))
+	require.Equal(t, alerting.StateFiring, state.Alerts[0].State)
+	require.Equal(t, start, state.Alerts[0].ActiveAt)
+	require.Equal(t, start.Add(2*time.Minute), state.Alerts[0].FiredAt)
+	notified := s.notifier.(*fakeNotifier).calls
+	require.Len(t, notified, 3)
+	require.Equal(t, state.Alerts, notified[2])
+
+	respond(map[string]*float64{"api": &zero, "web": &zero})
+	state = evaluate(start.Add(3 * time.Minute))
+	require.Equal(t, alerting.StateNormal, state.Alerts[0].State)
+	require.Equal(t, start.Add(3*time.Minute), state.Alerts[0].ResolvedAt)
+
+	respond(map[string]*float64{})
+	state = evaluate(start.Add(4 * time.Minute))
+	require.Equal(t, alerting.HealthNoData, state.Health)
+	require.Len(t, state.Alerts, 1)
+	require.Equal(t, alerting.StateNoData, state.Alerts[0].State)
+	require.Equal(t, map[string]string{"alertname": "Errors", "severity": "page"}, state.Alerts[0].Labels)
+
+	queryService.Err = errors.New("prometheus is down")
+	state = evaluate(start.Add(5 * time.Minute))
+	require.Equal(t, alerting.HealthError, state.Health)
+	require.Equal(t, "prometheus is down", state.LastError)
+	require.Len(t, state.Alerts, 1)
+	require.Equal(t, alerting.StateError, state.Alerts[0].State)
+
+	// A firing series that disappears is resolved once, then dropped.
+	respond(map[string]*float64{"api": &one})
+	evaluate(start.Add(6 * time.Minute))
+	evaluate(start.Add(8 * time.Minute))
+	respond(map[string]*float64{"web": &zero})
+	state = evaluate(start.Add(9 * time.Minute))
+	require.Len(t, state.Alerts, 2)
+	require.Equal(t, "api", state.Alerts[0].Labels["job"])
+	require.Equal(t, alerting.StateNormal, state.Alerts[0].State)
+	require.Equal(t, start.Add(9*time.Minute), state.Alerts[0].ResolvedAt)
+	state = evaluate(start.Add(10 * time.Minute))
+	require.Len(t, state.Alerts, 1)
+	require.Equal(t, "web", state.Alerts[0].Labels["job"])
+
+	firing, err := s.GetStates(ctx, &alerting.GetStatesQuery{State: alerting.StateFiring})
+	require.NoError(t, err)
+	require.Empty(t, firing[0].Alerts)
+}
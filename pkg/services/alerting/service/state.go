@@ -0,0 +1,207 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/xquare-dashboard/pkg/services/alerting"
+)
+
+// ruleState holds the alerts of a rule between evaluations, keyed by their
+// labels.
+type ruleState struct {
+	health         string
+	lastError      string
+	lastEvaluation time.Time
+	evaluationTime time.Duration
+	alerts         map[string]*alerting.Alert
+}
+
+func newRuleState() *ruleState {
+	return &ruleState{alerts: map[string]*alerting.Alert{}}
+}
+
+// result is the outcome of an evaluation for one series of a rule, or for
+// the rule itself when it returned no data or failed.
+type result struct {
+	labels data.Labels
+	// state is StateFiring when the condition is met, the alert may have to
+	// be pending first.
+	state alerting.State
+	value *float64
+	err   error
+}
+
+// update applies the results of an evaluation at now to the alerts. Alerts
+// are replaced, never mutated, so that returned alerts stay unchanged.
+// Firing alerts without result are resolved and kept for one evaluation.
+func (st *ruleState) update(rule *alerting.Rule, results []result, now time.Time, pendingFor time.Duration) {
+	seen := make(map[string]bool, len(results))
+	for _, r := range results {
+		labels := alertLabels(rule, r.labels)
+		key := data.Labels(labels).String()
+		seen[key] = true
+		prev := st.alerts[key]
+
+		a := &alerting.Alert{Labels: labels, Value: r.value, LastEvaluation: now}
+		if prev != nil {
+			a.ActiveAt, a.FiredAt, a.ResolvedAt = prev.ActiveAt, prev.FiredAt, prev.ResolvedAt
+		}
+		switch r.state {
+		case alerting.StateFiring:
+			if prev == nil || (prev.State != alerting.StatePending && prev.State != alerting.StateFiring) {
+				a.ActiveAt, a.FiredAt, a.ResolvedAt = now, time.Time{}, time.Time{}
+			}
+			a.State = alerting.StatePending
+			if !a.FiredAt.IsZero() || now.Sub(a.ActiveAt) >= pendingFor {
+				a.State = alerting.StateFiring
+				if a.FiredAt.IsZero() {
+					a.FiredAt = now
+				}
+			}
+		case alerting.StateNormal:
+			a.State = alerting.StateNormal
+			a.ActiveAt = time.Time{}
+			if prev != nil && prev.State != alerting.StateNormal && !prev.FiredAt.IsZero() {
+				a.ResolvedAt = now
+			}
+		default:
+			a.State = r.state
+			if prev == nil || prev.State != r.state {
+				a.ActiveAt, a.FiredAt, a.ResolvedAt = now, now, time.Time{}
+			}
+			if r.err != nil {
+				a.Error = r.err.Error()
+			}
+		}
+		a.Annotations = expandAnnotations(rule.Annotations, a)
+		st.alerts[key] = a
+	}
+
+	for key, prev := range st.alerts {
+		if seen[key] {
+			continue
+		}
+		if prev.State == alerting.StateNormal || prev.FiredAt.IsZero() {
+			delete(st.alerts, key)
+			continue
+		}
+		resolved := *prev
+		resolved.State = alerting.StateNormal
+		resolved.ActiveAt = time.Time{}
+		resolved.ResolvedAt = now
+		resolved.LastEvaluation = now
+		resolved.Value = nil
+		resolved.Error = ""
+		st.alerts[key] = &resolved
+	}
+}
+
+// alertLabels returns the labels of the alert of a series: the labels of the
+// series, the labels of the rule and its title as alertname.
+func alertLabels(rule *alerting.Rule, series data.Labels) map[string]string {
+	labels := make(map[string]string, len(series)+len(rule.Labels)+1)
+	for k, v := range series {
+		labels[k] = v
+	}
+	for k, v := range rule.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = rule.Title
+	return labels
+}
+
+// snapshot returns the state of the rule with its alerts sorted by labels,
+// limited to the alerts in the given state when set.
+func (st *ruleState) snapshot(rule *alerting.Rule, state alerting.State) *alerting.RuleState {
+	rs := &alerting.RuleState{
+		UID:          rule.UID,
+		Title:        rule.Title,
+		DashboardUID: rule.DashboardUID,
+		PanelID:      rule.PanelID,
+		Health:       alerting.HealthUnknown,
+		Alerts:       []*alerting.Alert{},
+	}
+	if st == nil {
+		return rs
+	}
+	if st.health != "" {
+		rs.Health = st.health
+	}
+	rs.LastError = st.lastError
+	rs.LastEvaluation = st.lastEvaluation
+	rs.EvaluationTime = st.evaluationTime.Seconds()
+
+	keys := make([]string, 0, len(st.alerts))
+	for key, a := range st.alerts {
+		if state == "" || a.State == state {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		rs.Alerts = append(rs.Alerts, st.alerts[key])
+	}
+	return rs
+}
+
+func (s *Service) GetStates(ctx context.Context, query *alerting.GetStatesQuery) ([]*alerting.RuleState, error) {
+	rules, err := s.GetRules(ctx, &alerting.GetRulesQuery{DashboardUID: query.DashboardUID, Identity: query.Identity})
+	if err != nil {
+		return nil, err
+	}
+
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	result := make([]*alerting.RuleState, 0, len(rules))
+	for _, rule := range rules {
+		if query.RuleUID != "" && rule.UID != query.RuleUID {
+			continue
+		}
+		result = append(result, s.states[rule.UID].snapshot(rule, query.State))
+	}
+	return result, nil
+}
+
+// templatePrelude makes the labels and the value of an alert available the
+// way Prometheus does, as $labels and $value.
+const templatePrelude = "{{ $labels := .Labels }}{{ $value := .Value }}"
+
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("").Option("missingkey=zero").Parse(templatePrelude + text)
+}
+
+// expandAnnotations expands the annotation templates of a rule for an alert.
+// Annotations that fail to expand are kept as they are.
+func expandAnnotations(annotations map[string]string, a *alerting.Alert) map[string]string {
+	result := make(map[string]string, len(annotations))
+	value := math.NaN()
+	if a.Value != nil {
+		value = *a.Value
+	}
+	tmplData := struct {
+		Labels map[string]string
+		Value  string
+	}{Labels: a.Labels, Value: strconv.FormatFloat(value, 'g', -1, 64)}
+
+	for name, text := range annotations {
+		result[name] = text
+		tmpl, err := parseTemplate(text)
+		if err != nil {
+			continue
+		}
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, tmplData); err == nil {
+			result[name] = sb.String()
+		}
+	}
+	return result
+}
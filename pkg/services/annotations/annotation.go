@@ -0,0 +1,103 @@
+package annotations
+
+import (
+	"time"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+)
+
+// Annotation marks a point or a range in time, e.g. a deploy or an incident.
+// Times are epoch milliseconds.
+type Annotation struct {
+	// ID is 0 for the annotations of annotation queries, they aren't stored.
+	ID int64 `json:"id"`
+	// DashboardUID and PanelID scope the annotation to a dashboard or one of
+	// its panels. Annotations without dashboard are shown everywhere.
+	DashboardUID string   `json:"dashboardUid,omitempty"`
+	PanelID      int64    `json:"panelId,omitempty"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd"`
+	Title        string   `json:"title,omitempty"`
+	Text         string   `json:"text"`
+	Tags         []string `json:"tags"`
+
+	Created time.Time `json:"created,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+type GetAnnotationQuery struct {
+	ID int64
+}
+
+// GetAnnotationsQuery filters annotations, empty fields match all.
+type GetAnnotationsQuery struct {
+	// From and To limit the result to the annotations overlapping the range.
+	From int64
+	To   int64
+	// DashboardUID and PanelID limit the result to the annotations shown on a
+	// dashboard or a panel: its own, the global ones and, for a panel, the
+	// ones of its dashboard.
+	DashboardUID string
+	PanelID      int64
+	// Tags limits the result to the annotations with all of the tags, or any
+	// of them with MatchAny.
+	Tags     []string
+	MatchAny bool
+	// Limit is the maximum number of annotations, the latest are returned.
+	Limit int
+}
+
+// AddAnnotationCommand creates an annotation. Time is now when empty,
+// TimeEnd is Time when empty.
+type AddAnnotationCommand struct {
+	DashboardUID string   `json:"dashboardUid"`
+	PanelID      int64    `json:"panelId"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd"`
+	Title        string   `json:"title"`
+	Text         string   `json:"text"`
+	Tags         []string `json:"tags"`
+}
+
+// UpdateAnnotationCommand replaces the annotation with the given ID.
+type UpdateAnnotationCommand struct {
+	DashboardUID string   `json:"dashboardUid"`
+	PanelID      int64    `json:"panelId"`
+	Time         int64    `json:"time"`
+	TimeEnd      int64    `json:"timeEnd"`
+	Title        string   `json:"title"`
+	Text         string   `json:"text"`
+	Tags         []string `json:"tags"`
+
+	ID int64 `json:"-"`
+}
+
+type DeleteAnnotationCommand struct {
+	ID int64
+}
+
+// AnnotationQuery is a Loki log query whose lines become annotations.
+type AnnotationQuery struct {
+	// Datasource references the Loki datasource, like the queries of a
+	// MetricRequest, e.g. {"uid": "loki"}.
+	Datasource *simplejson.Json `json:"datasource"`
+	// Expr is a LogQL log query, e.g. {app="deploy"} |= "finished".
+	Expr string `json:"expr"`
+	// TitleFormat and TextFormat are patterns of the title and the text like
+	// legend formats, e.g. {{app}} deployed to {{env}}. The text is the log
+	// line when TextFormat is empty.
+	TitleFormat string `json:"titleFormat"`
+	TextFormat  string `json:"textFormat"`
+	// TagKeys are the labels whose values become the tags.
+	TagKeys []string `json:"tagKeys"`
+	// MaxLines is the maximum number of lines read, 100 by default.
+	MaxLines int64 `json:"maxLines"`
+}
+
+// QueryAnnotationsCommand runs annotation queries over a time range, given
+// like the range of a MetricRequest.
+type QueryAnnotationsCommand struct {
+	From    string             `json:"from"`
+	To      string             `json:"to"`
+	Queries []*AnnotationQuery `json:"queries"`
+}
@@ -0,0 +1,27 @@
+package annotations
+
+import (
+	"context"
+)
+
+// AnnotationsService interface for interacting with annotations.
+type AnnotationsService interface {
+	// GetAnnotation gets an annotation.
+	GetAnnotation(ctx context.Context, query *GetAnnotationQuery) (*Annotation, error)
+
+	// GetAnnotations gets annotations, latest first.
+	GetAnnotations(ctx context.Context, query *GetAnnotationsQuery) ([]*Annotation, error)
+
+	// AddAnnotation adds a new annotation.
+	AddAnnotation(ctx context.Context, cmd *AddAnnotationCommand) (*Annotation, error)
+
+	// UpdateAnnotation updates an existing annotation.
+	UpdateAnnotation(ctx context.Context, cmd *UpdateAnnotationCommand) (*Annotation, error)
+
+	// DeleteAnnotation deletes an annotation.
+	DeleteAnnotation(ctx context.Context, cmd *DeleteAnnotationCommand) error
+
+	// QueryAnnotations runs annotation queries and returns the annotations
+	// of the matching log lines, latest first.
+	QueryAnnotations(ctx context.Context, cmd *QueryAnnotationsCommand) ([]*Annotation, error)
+}
@@ -0,0 +1,13 @@
+package annotations
+
+import (
+	"errors"
+
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+var (
+	ErrAnnotationNotFound     = errors.New("annotation not found")
+	ErrAnnotationInvalid      = errutil.ValidationFailed("annotations.invalid").MustTemplate("invalid annotation: {{ .Public.Reason }}", errutil.WithPublic("Invalid annotation: {{ .Public.Reason }}"))
+	ErrAnnotationQueryInvalid = errutil.ValidationFailed("annotations.queryInvalid").MustTemplate("invalid annotation query: {{ .Public.Reason }}", errutil.WithPublic("Invalid annotation query: {{ .Public.Reason }}"))
+)
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/annotations"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+const defaultMaxLines = 100
+
+// labelFormat matches the label references of a format, like the legend
+// formats of the Loki datasource: {{app}} deployed to {{ env }}.
+var labelFormat = regexp.MustCompile(`\{\{\s*(.+?)\s*\}\}`)
+
+// QueryAnnotations runs the log queries of cmd over its time range as range
+// queries and turns every returned line into an annotation.
+func (s *Service) QueryAnnotations(ctx context.Context, cmd *annotations.QueryAnnotationsCommand) ([]*annotations.Annotation, error) {
+	if len(cmd.Queries) == 0 {
+		return nil, invalidQuery(nil, "at least one query is required")
+	}
+	tr, err := query.NewDataTimeRange(cmd.From, cmd.To, "", "", 0)
+	if err == nil {
+		_, _, err = tr.Parse()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	req := dtos.MetricRequest{From: cmd.From, To: cmd.To}
+	for i, q := range cmd.Queries {
+		if q == nil || strings.TrimSpace(q.Expr) == "" {
+			return nil, invalidQuery(nil, "every query needs an expr")
+		}
+		uid := ""
+		if q.Datasource != nil {
+			uid = q.Datasource.Get("uid").MustString()
+		}
+		if uid == "" {
+			return nil, invalidQuery(nil, "every query needs a datasource uid")
+		}
+		ds, err := s.dataSourceService.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: uid})
+		if err != nil {
+			if errors.Is(err, datasources.ErrDataSourceNotFound) {
+				return nil, invalidQuery(err, "datasource %q not found", uid)
+			}
+			return nil, err
+		}
+		if ds.Type != datasources.LokiType {
+			return nil, invalidQuery(nil, "datasource %q is not a Loki datasource", uid)
+		}
+		maxLines := q.MaxLines
+		if maxLines <= 0 {
+			maxLines = defaultMaxLines
+		}
+
+		rq := simplejson.New()
+		rq.Set("refId", refID(i))
+		rq.Set("datasource", map[string]any{"uid": ds.UID, "type": ds.Type})
+		rq.Set("expr", q.Expr)
+		rq.Set("queryType", "range")
+		rq.Set("maxLines", maxLines)
+		req.Queries = append(req.Queries, rq)
+	}
+
+	resp, err := s.queryService.QueryData(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*annotations.Annotation, 0)
+	for i, q := range cmd.Queries {
+		dr, ok := resp.Responses[refID(i)]
+		if !ok {
+			continue
+		}
+		if dr.Error != nil {
+			return nil, dr.Error
+		}
+		for _, frame := range dr.Frames {
+			items, err := frameAnnotations(frame, q)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, items...)
+		}
+	}
+	sortAnnotations(result)
+	return result, nil
+}
+
+func refID(i int) string {
+	return "A" + strconv.Itoa(i)
+}
+
+// frameAnnotations returns the annotations of the lines of a logs frame: a
+// JSON field with the labels, a time field and a string field with the line.
+func frameAnnotations(frame *data.Frame, q *annotations.AnnotationQuery) ([]*annotations.Annotation, error) {
+	if len(frame.Fields) == 0 || frame.Rows() == 0 {
+		return nil, nil
+	}
+	var labelsField, timeField, lineField *data.Field
+	for _, f := range frame.Fields {
+		switch {
+		case f.Type() == data.FieldTypeJSON && f.Name == "labels":
+			labelsField = f
+		case f.Type() == data.FieldTypeTime && timeField == nil:
+			timeField = f
+		case f.Type() == data.FieldTypeString && (f.Name == "Line" || f.Name == "body"):
+			lineField = f
+		}
+	}
+	if labelsField == nil || timeField == nil || lineField == nil {
+		return nil, invalidQuery(nil, "%s is not a log query", q.Expr)
+	}
+
+	items := make([]*annotations.Annotation, 0, frame.Rows())
+	for i := 0; i < frame.Rows(); i++ {
+		labels := map[string]string{}
+		if raw, ok := labelsField.At(i).(json.RawMessage); ok && len(raw) > 0 {
+			if err := json.Unmarshal(raw, &labels); err != nil {
+				return nil, fmt.Errorf("invalid labels of a log line: %w", err)
+			}
+		}
+		ts, _ := timeField.At(i).(time.Time)
+		line, _ := lineField.At(i).(string)
+
+		a := &annotations.Annotation{
+			Time:    ts.UnixMilli(),
+			TimeEnd: ts.UnixMilli(),
+			Title:   formatLabels(q.TitleFormat, labels),
+			Text:    line,
+			Tags:    []string{},
+		}
+		if q.TextFormat != "" {
+			a.Text = formatLabels(q.TextFormat, labels)
+		}
+		for _, key := range q.TagKeys {
+			if v := labels[key]; v != "" {
+				a.Tags = append(a.Tags, v)
+			}
+		}
+		items = append(items, a)
+	}
+	return items, nil
+}
+
+// formatLabels replaces the label references of format with their values,
+// missing labels are replaced by nothing.
+func formatLabels(format string, labels map[string]string) string {
+	return labelFormat.ReplaceAllStringFunc(format, func(ref string) string {
+		return labels[labelFormat.FindStringSubmatch(ref)[1]]
+	})
+}
+
+func invalidQuery(err error, format string, args ...any) error {
+	return annotations.ErrAnnotationQueryInvalid.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+		Error:  err,
+	})
+}
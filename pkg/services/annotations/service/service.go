@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/annotations"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+const defaultLimit = 100
+
+// Service stores annotations in the data directory and runs annotation
+// queries against Loki with the query service.
+//
+// Stored annotations are never mutated in place, saving an annotation always
+// replaces the stored value. Callers must treat returned annotations as
+// read-only.
+type Service struct {
+	log               log.Logger
+	store             *fileStore
+	queryService      query.Service
+	dashboardService  dashboards.DashboardService
+	dataSourceService datasources.DataSourceService
+
+	mu     sync.RWMutex
+	byID   map[int64]*annotations.Annotation
+	nextID int64
+}
+
+var _ annotations.AnnotationsService = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg, queryService query.Service, dashboardService dashboards.DashboardService,
+	dataSourceService datasources.DataSourceService) (*Service, error) {
+	s := &Service{
+		log:               log.New("annotations"),
+		store:             &fileStore{path: filepath.Join(cfg.DataPath, "annotations", "annotations.json")},
+		queryService:      queryService,
+		dashboardService:  dashboardService,
+		dataSourceService: dataSourceService,
+		byID:              map[int64]*annotations.Annotation{},
+		nextID:            1,
+	}
+
+	items, err := s.store.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range items {
+		s.byID[a.ID] = a
+		s.nextID = max(s.nextID, a.ID+1)
+	}
+	return s, nil
+}
+
+func (s *Service) GetAnnotation(_ context.Context, query *annotations.GetAnnotationQuery) (*annotations.Annotation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	a, ok := s.byID[query.ID]
+	if !ok {
+		return nil, annotations.ErrAnnotationNotFound
+	}
+	return a, nil
+}
+
+func (s *Service) GetAnnotations(_ context.Context, query *annotations.GetAnnotationsQuery) ([]*annotations.Annotation, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*annotations.Annotation, 0)
+	for _, a := range s.byID {
+		if matches(a, query) {
+			result = append(result, a)
+		}
+	}
+	sortAnnotations(result)
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func matches(a *annotations.Annotation, query *annotations.GetAnnotationsQuery) bool {
+	if query.From > 0 && a.TimeEnd < query.From {
+		return false
+	}
+	if query.To > 0 && a.Time > query.To {
+		return false
+	}
+	// Global annotations are shown on every dashboard, and the annotations of
+	// a dashboard on all of its panels.
+	if query.DashboardUID != "" && a.DashboardUID != "" && a.DashboardUID != query.DashboardUID {
+		return false
+	}
+	if query.PanelID != 0 && a.PanelID != 0 && a.PanelID != query.PanelID {
+		return false
+	}
+	if len(query.Tags) == 0 {
+		return true
+	}
+	for _, tag := range query.Tags {
+		has := slices.Contains(a.Tags, tag)
+		if has && query.MatchAny {
+			return true
+		}
+		if !has && !query.MatchAny {
+			return false
+		}
+	}
+	return !query.MatchAny
+}
+
+func (s *Service) AddAnnotation(ctx context.Context, cmd *annotations.AddAnnotationCommand) (*annotations.Annotation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	a := &annotations.Annotation{
+		ID:           s.nextID,
+		DashboardUID: cmd.DashboardUID,
+		PanelID:      cmd.PanelID,
+		Time:         cmd.Time,
+		TimeEnd:      cmd.TimeEnd,
+		Title:        strings.TrimSpace(cmd.Title),
+		Text:         cmd.Text,
+		Tags:         cmd.Tags,
+		Created:      now,
+		Updated:      now,
+	}
+	if a.Time == 0 {
+		a.Time = now.UnixMilli()
+	}
+	if err := s.prepare(ctx, a); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(a); err != nil {
+		return nil, err
+	}
+	s.nextID++
+	s.log.Debug("Added annotation", "id", a.ID, "dashboardUid", a.DashboardUID)
+	return a, nil
+}
+
+func (s *Service) UpdateAnnotation(ctx context.Context, cmd *annotations.UpdateAnnotationCommand) (*annotations.Annotation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.byID[cmd.ID]
+	if !ok {
+		return nil, annotations.ErrAnnotationNotFound
+	}
+
+	a := &annotations.Annotation{
+		ID:           existing.ID,
+		DashboardUID: cmd.DashboardUID,
+		PanelID:      cmd.PanelID,
+		Time:         cmd.Time,
+		TimeEnd:      cmd.TimeEnd,
+		Title:        strings.TrimSpace(cmd.Title),
+		Text:         cmd.Text,
+		Tags:         cmd.Tags,
+		Created:      existing.Created,
+		Updated:      time.Now(),
+	}
+	if a.Time == 0 {
+		a.Time = existing.Time
+	}
+	if err := s.prepare(ctx, a); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(a); err != nil {
+		return nil, err
+	}
+	s.log.Debug("Updated annotation", "id", a.ID, "dashboardUid", a.DashboardUID)
+	return a, nil
+}
+
+func (s *Service) DeleteAnnotation(_ context.Context, cmd *annotations.DeleteAnnotationCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.byID[cmd.ID]; !ok {
+		return annotations.ErrAnnotationNotFound
+	}
+	items := make([]*annotations.Annotation, 0, len(s.byID)-1)
+	for id, a := range s.byID {
+		if id != cmd.ID {
+			items = append(items, a)
+		}
+	}
+	sortByID(items)
+	if err := s.store.save(items); err != nil {
+		return err
+	}
+	delete(s.byID, cmd.ID)
+	s.log.Debug("Deleted annotation", "id", cmd.ID)
+	return nil
+}
+
+// commit persists the annotations with a swapped in, then swaps it in. The
+// caller must hold the write lock.
+func (s *Service) commit(a *annotations.Annotation) error {
+	items := make([]*annotations.Annotation, 0, len(s.byID)+1)
+	for id, existing := range s.byID {
+		if id != a.ID {
+			items = append(items, existing)
+		}
+	}
+	items = append(items, a)
+	sortByID(items)
+	if err := s.store.save(items); err != nil {
+		return err
+	}
+	s.byID[a.ID] = a
+	return nil
+}
+
+// prepare validates a and fills in defaults.
+func (s *Service) prepare(ctx context.Context, a *annotations.Annotation) error {
+	if a.Time < 0 {
+		return invalidAnnotation(nil, "invalid time %d", a.Time)
+	}
+	if a.TimeEnd == 0 {
+		a.TimeEnd = a.Time
+	}
+	if a.TimeEnd < a.Time {
+		return invalidAnnotation(nil, "timeEnd must not be before time")
+	}
+	if a.Text == "" && a.Title == "" {
+		return invalidAnnotation(nil, "text is required")
+	}
+
+	tags := make([]string, 0, len(a.Tags))
+	for _, tag := range a.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	a.Tags = tags
+
+	if a.PanelID != 0 && a.DashboardUID == "" {
+		return invalidAnnotation(nil, "panelId requires a dashboardUid")
+	}
+	if a.DashboardUID != "" {
+		if _, err := s.dashboardService.GetDashboard(ctx, &dashboards.GetDashboardQuery{UID: a.DashboardUID}); err != nil {
+			if errors.Is(err, dashboards.ErrDashboardNotFound) {
+				return invalidAnnotation(err, "dashboard %q not found", a.DashboardUID)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func invalidAnnotation(err error, format string, args ...any) error {
+	return annotations.ErrAnnotationInvalid.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+		Error:  err,
+	})
+}
+
+// sortAnnotations sorts annotations latest first.
+func sortAnnotations(items []*annotations.Annotation) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Time != items[j].Time {
+			return items[i].Time > items[j].Time
+		}
+		return items[i].ID > items[j].ID
+	})
+}
+
+func sortByID(items []*annotations.Annotation) {
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID < items[j].ID
+	})
+}
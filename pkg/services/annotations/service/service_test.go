@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/annotations"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	dashboardservice "github.com/xquare-dashboard/pkg/services/dashboards/service"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	datasourceservice "github.com/xquare-dashboard/pkg/services/datasources/service"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/query/querytest"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func setupService(t *testing.T) (*Service, *querytest.FakeService) {
+	t.Helper()
+	ctx := context.Background()
+	cfg := &setting.Cfg{DataPath: t.TempDir()}
+	dashService, err := dashboardservice.ProvideService(cfg)
+	require.NoError(t, err)
+	for _, cmd := range []*dashboards.AddDashboardCommand{
+		{UID: "logs", Title: "Logs", Panels: []*dashboards.Panel{{ID: 1, Title: "Errors"}, {ID: 2, Title: "Warnings"}}},
+		{UID: "metrics", Title: "Metrics"},
+	} {
+		_, err = dashService.AddDashboard(ctx, cmd)
+		require.NoError(t, err)
+	}
+	dsService, err := datasourceservice.ProvideService(cfg)
+	require.NoError(t, err)
+	for _, cmd := range []*datasources.AddDataSourceCommand{
+		{Name: "Loki", Type: datasources.LokiType, URL: "http://loki:3100", UID: "loki"},
+		{Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://prometheus:9090", UID: "prom"},
+	} {
+		_, err = dsService.AddDataSource(ctx, cmd)
+		require.NoError(t, err)
+	}
+	queryService := &querytest.FakeService{}
+	s, err := ProvideService(cfg, queryService, dashService, dsService)
+	require.NoError(t, err)
+	return s, queryService
+}
+
+func TestService_CRUD(t *testing.T) {
+	s, _ := setupService(t)
+	ctx := context.Background()
+
+	invalid := []*annotations.AddAnnotationCommand{
+		{Time: 1000},
+		{Time: 2000, TimeEnd: 1000, Text: "deploy"},
+		{PanelID: 1, Text: "deploy"},
+		{DashboardUID: "missing", Text: "deploy"},
+	}
+	for _, cmd := range invalid {
+		_, err := s.AddAnnotation(ctx, cmd)
+		require.ErrorIs(t, err, annotations.ErrAnnotationInvalid, cmd)
+	}
+
+	a, err := s.AddAnnotation(ctx, &annotations.AddAnnotationCommand{
+		DashboardUID: "logs", PanelID: 1, Time: 1000, Text: "deploy", Tags: []string{"deploy", " deploy", "v2"},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 1, a.ID)
+	require.EqualValues(t, 1000, a.TimeEnd)
+	require.Equal(t, []string{"deploy", "v2"}, a.Tags)
+
+	b, err := s.AddAnnotation(ctx, &annotations.AddAnnotationCommand{Text: "outage"})
+	require.NoError(t, err)
+	require.EqualValues(t, 2, b.ID)
+	require.InDelta(t, time.Now().UnixMilli(), b.Time, float64(time.Minute.Milliseconds()))
+
+	updated, err := s.UpdateAnnotation(ctx, &annotations.UpdateAnnotationCommand{
+		ID: a.ID, DashboardUID: "logs", TimeEnd: 5000, Text: "deploy v2", Tags: []string{"deploy"},
+	})
+	require.NoError(t, err)
+	require.EqualValues(t, 1000, updated.Time)
+	require.Equal(t, a.Created, updated.Created)
+	_, err = s.UpdateAnnotation(ctx, &annotations.UpdateAnnotationCommand{ID: 10, Text: "x"})
+	require.ErrorIs(t, err, annotations.ErrAnnotationNotFound)
+
+	cfg := &setting.Cfg{DataPath: filepath.Dir(filepath.Dir(s.store.path))}
+	reloaded, err := ProvideService(cfg, s.queryService, s.dashboardService, s.dataSourceService)
+	require.NoError(t, err)
+	got, err := reloaded.GetAnnotation(ctx, &annotations.GetAnnotationQuery{ID: a.ID})
+	require.NoError(t, err)
+	require.Equal(t, "deploy v2", got.Text)
+	c, err := reloaded.AddAnnotation(ctx, &annotations.AddAnnotationCommand{Text: "restart"})
+	require.NoError(t, err)
+	require.EqualValues(t, 3, c.ID)
+
+	require.NoError(t, s.DeleteAnnotation(ctx, &annotations.DeleteAnnotationCommand{ID: a.ID}))
+	_, err = s.GetAnnotation(ctx, &annotations.GetAnnotationQuery{ID: a.ID})
+	require.ErrorIs(t, err, annotations.ErrAnnotationNotFound)
+	require.ErrorIs(t, s.DeleteAnnotation(ctx, &annotations.DeleteAnnotationCommand{ID: a.ID}), annotations.ErrAnnotationNotFound)
+}
+
+func TestService_GetAnnotations(t *testing.T) {
+	s, _ := setupService(t)
+	ctx := context.Background()
+
+	for _, cmd := range []*annotations.AddAnnotationCommand{
+		{DashboardUID: "logs", PanelID: 1, Time: 1000, TimeEnd: 3000, Text: "1", Tags: []string{"deploy", "api"}},
+		{DashboardUID: "logs", Time: 2000, Text: "2", Tags: []string{"deploy"}},
+		{Time: 4000, Text: "3", Tags: []string{"outage"}},
+		{DashboardUID: "logs", PanelID: 2, Time: 5000, Text: "4"},
+		{DashboardUID: "metrics", Time: 6000, Text: "5"},
+	} {
+		_, err := s.AddAnnotation(ctx, cmd)
+		require.NoError(t, err)
+	}
+
+	texts := func(query *annotations.GetAnnotationsQuery) []string {
+		items, err := s.GetAnnotations(ctx, query)
+		require.NoError(t, err)
+		result := []string{}
+		for _, a := range items {
+			result = append(result, a.Text)
+		}
+		return result
+	}
+
+	require.Equal(t, []string{"5", "4", "3", "2", "1"}, texts(&annotations.GetAnnotationsQuery{}))
+	require.Equal(t, []string{"2", "1"}, texts(&annotations.GetAnnotationsQuery{From: 2000, To: 3500}))
+	require.Equal(t, []string{"1"}, texts(&annotations.GetAnnotationsQuery{From: 2500, To: 3500}))
+	// Global annotations are on every dashboard, dashboard annotations on
+	// every panel of the dashboard.
+	require.Equal(t, []string{"4", "3", "2", "1"}, texts(&annotations.GetAnnotationsQuery{DashboardUID: "logs"}))
+	require.Equal(t, []string{"3", "2", "1"}, texts(&annotations.GetAnnotationsQuery{DashboardUID: "logs", PanelID: 1}))
+	require.Equal(t, []string{"5", "3"}, texts(&annotations.GetAnnotationsQuery{DashboardUID: "metrics"}))
+	require.Equal(t, []string{"1"}, texts(&annotations.GetAnnotationsQuery{Tags: []string{"deploy", "api"}}))
+	require.Equal(t, []string{"3", "1"}, texts(&annotations.GetAnnotationsQuery{Tags: []string{"api", "outage"}, MatchAny: true}))
+	require.Equal(t, []string{"5"}, texts(&annotations.GetAnnotationsQuery{Limit: 1}))
+}
+
+func logsFrame(t *testing.T, lines map[time.Time]map[string]string) *data.Frame {
+	t.Helper()
+	labelsField := data.NewFieldFromFieldType(data.FieldTypeJSON, 0)
+	labelsField.Name = "labels"
+	timeField := data.NewFieldFromFieldType(data.FieldTypeTime, 0)
+	timeField.Name = "Time"
+	lineField := data.NewFieldFromFieldType(data.FieldTypeString, 0)
+	lineField.Name = "Line"
+	for ts, labels := range lines {
+		raw, err := json.Marshal(labels)
+		require.NoError(t, err)
+		labelsField.Append(json.RawMessage(raw))
+		timeField.Append(ts)
+		lineField.Append("level=error msg=" + labels["msg"])
+	}
+	return data.NewFrame("", labelsField, timeField, lineField)
+}
+
+func TestService_QueryAnnotations(t *testing.T) {
+	s, queryService := setupService(t)
+	ctx := context.Background()
+	lokiDS := simplejson.NewFromAny(map[string]any{"uid": "loki"})
+
+	invalid := []*annotations.QueryAnnotationsCommand{
+		{From: "now-1h", To: "now"},
+		{From: "now-1h", To: "now", Queries: []*annotations.AnnotationQuery{{Datasource: lokiDS}}},
+		{From: "now-1h", To: "now", Queries: []*annotations.AnnotationQuery{{Expr: `{app="api"}`}}},
+		{From: "now-1h", To: "now", Queries: []*annotations.AnnotationQuery{{Expr: `{app="api"}`, Datasource: simplejson.NewFromAny(map[string]any{"uid": "missing"})}}},
+		{From: "now-1h", To: "now", Queries: []*annotations.AnnotationQuery{{Expr: "up", Datasource: simplejson.NewFromAny(map[string]any{"uid": "prom"})}}},
+	}
+	for _, cmd := range invalid {
+		_, err := s.QueryAnnotations(ctx, cmd)
+		require.ErrorIs(t, err, annotations.ErrAnnotationQueryInvalid)
+	}
+	_, err := s.QueryAnnotations(ctx, &annotations.QueryAnnotationsCommand{From: "now", To: "now-1h", Queries: []*annotations.AnnotationQuery{{Expr: `{app="api"}`, Datasource: lokiDS}}})
+	require.ErrorIs(t, err, query.ErrInvalidTimeRange)
+	require.Empty(t, queryService.Reqs)
+
+	now := time.UnixMilli(1700000000000)
+	queryService.Resp = &backend.QueryDataResponse{Responses: backend.Responses{
+		"A0": {Frames: data.Frames{logsFrame(t, map[time.Time]map[string]string{
+			now.Add(-time.Minute): {"app": "api", "env": "prod", "msg": "timeout"},
+			now:                   {"app": "api", "msg": "panic"},
+		})}},
+	}}
+	items, err := s.QueryAnnotations(ctx, &annotations.QueryAnnotationsCommand{
+		From: "now-1h",
+		To:   "now",
+		Queries: []*annotations.AnnotationQuery{{
+			Datasource:  lokiDS,
+			Expr:        `{app="api"} |= "error"`,
+			TitleFormat: "{{app}} in {{ env }}",
+			TagKeys:     []string{"app", "env"},
+		}},
+	})
+	require.NoError(t, err)
+	require.Len(t, queryService.Reqs, 1)
+	q := queryService.Reqs[0].Queries[0]
+	require.Equal(t, "A0", q.Get("refId").MustString())
+	require.Equal(t, "range", q.Get("queryType").MustString())
+	require.EqualValues(t, 100, q.Get("maxLines").MustInt64())
+
+	require.Len(t, items, 2)
+	require.Equal(t, now.UnixMilli(), items[0].Time)
+	require.Equal(t, "api in ", items[0].Title)
+	require.Equal(t, "level=error msg=panic", items[0].Text)
+	require.Equal(t, []string{"api"}, items[0].Tags)
+	require.Equal(t, "api in prod", items[1].Title)
+	require.Equal(t, []string{"api", "prod"}, items[1].Tags)
+
+	queryService.Resp = &backend.QueryDataResponse{Responses: backend.Responses{
+		"A0": {Frames: data.Frames{data.NewFrame("", data.NewField("Value", nil, []float64{1}), data.NewField("Time", nil, []time.Time{now}))}},
+	}}
+	_, err = s.QueryAnnotations(ctx, &annotations.QueryAnnotationsCommand{
+		From: "now-1h", To: "now", Queries: []*annotations.AnnotationQuery{{Datasource: lokiDS, Expr: `count_over_time({app="api"}[1m])`}},
+	})
+	require.ErrorIs(t, err, annotations.ErrAnnotationQueryInvalid)
+}
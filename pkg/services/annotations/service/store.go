@@ -0,0 +1,28 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/xquare-dashboard/pkg/infra/fs"
+	"github.com/xquare-dashboard/pkg/services/annotations"
+)
+
+// fileStore persists the annotations as a JSON file.
+type fileStore struct {
+	path string
+}
+
+func (f *fileStore) load() ([]*annotations.Annotation, error) {
+	var items []*annotations.Annotation
+	if err := fs.ReadJSON(f.path, &items); err != nil {
+		return nil, fmt.Errorf("failed to read annotations from %q: %w", f.path, err)
+	}
+	return items, nil
+}
+
+func (f *fileStore) save(items []*annotations.Annotation) error {
+	if err := fs.WriteJSONAtomic(f.path, items, 0o640); err != nil {
+		return fmt.Errorf("failed to save annotations to %q: %w", f.path, err)
+	}
+	return nil
+}
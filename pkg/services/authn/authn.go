@@ -0,0 +1,58 @@
+package authn
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Names of the clients.
+const (
+	ClientAPIKey    = "auth.client.api-key"
+	ClientJWT       = "auth.client.jwt"
+	ClientSession   = "auth.client.session"
+	ClientOAuth     = "auth.client.oauth"
+	ClientBasic     = "auth.client.basic"
+	ClientAnonymous = "auth.client.anonymous"
+)
+
+// SessionCookieName is the cookie that carries the session token.
+const SessionCookieName = "xquare_session"
+
+// Service authenticates requests.
+type Service interface {
+	// Authenticate authenticates r with the first client r carries the
+	// credentials of. It returns nil without error when r carries no
+	// credentials and anonymous access is disabled.
+	Authenticate(ctx context.Context, r *http.Request) (*Identity, error)
+
+	// OAuthLoginURL returns the URL of the identity provider to log in at.
+	// state and verifier, the PKCE code verifier, must be kept by the client
+	// for the callback. It returns ErrOAuthDisabled when OAuth login is not
+	// configured.
+	OAuthLoginURL(state, verifier string) (string, error)
+
+	// OAuthLogin exchanges the code of the OAuth callback for an ID token
+	// and starts a session for its user.
+	OAuthLogin(ctx context.Context, code, verifier string) (*Session, error)
+
+	// Logout ends the session with the given token.
+	Logout(ctx context.Context, token string) error
+}
+
+// Client authenticates requests with one kind of credentials.
+type Client interface {
+	Name() string
+	// Test returns whether r carries the credentials of the client.
+	Test(ctx context.Context, r *http.Request) bool
+	// Authenticate returns the identity of the credentials of r, or an error
+	// when they are invalid.
+	Authenticate(ctx context.Context, r *http.Request) (*Identity, error)
+}
+
+// Session is a login session, its token is sent in the session cookie.
+type Session struct {
+	Token    string
+	Identity *Identity
+	Expires  time.Time
+}
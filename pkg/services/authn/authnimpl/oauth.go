@@ -0,0 +1,45 @@
+package authnimpl
+
+import (
+	"context"
+	"net/http"
+
+	"golang.org/x/oauth2"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/authn/jwt"
+)
+
+// oauthLogin is the OAuth2 authorization code login with PKCE. The user is
+// the one of the ID token the provider returns with the access token.
+type oauthLogin struct {
+	config   *oauth2.Config
+	client   *http.Client
+	verifier *jwt.Verifier
+	mapping  jwt.ClaimMapping
+}
+
+func (o *oauthLogin) loginURL(state, verifier string) string {
+	return o.config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier))
+}
+
+func (o *oauthLogin) exchange(ctx context.Context, code, verifier string) (*authn.Identity, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, o.client)
+	token, err := o.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, authn.ErrOAuthLoginFailed.Errorf("failed to exchange the code: %w", err)
+	}
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return nil, authn.ErrOAuthLoginFailed.Errorf("the token response has no id_token")
+	}
+	claims, err := o.verifier.Verify(ctx, idToken)
+	if err != nil {
+		return nil, authn.ErrOAuthLoginFailed.Errorf("invalid id_token: %w", err)
+	}
+	identity, err := o.mapping.Identity(claims, authn.ClientOAuth)
+	if err != nil {
+		return nil, authn.ErrOAuthLoginFailed.Errorf("invalid id_token: %w", err)
+	}
+	return identity, nil
+}
@@ -0,0 +1,175 @@
+package authnimpl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"golang.org/x/oauth2"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/authn/clients"
+	"github.com/xquare-dashboard/pkg/services/authn/jwt"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+// defaultAdminPassword is the well-known password the admin can not use.
+const defaultAdminPassword = "admin"
+
+// Service authenticates requests with the first client they carry the
+// credentials of: API token keys, then JWTs and the sessions of OAuth logins
+// when configured, then the basic auth credentials of the admin when its
+// password is set, then anonymous access when enabled.
+type Service struct {
+	log     log.Logger
+	clients []authn.Client
+
+	// oauth and sessions are nil when OAuth login is disabled.
+	oauth    *oauthLogin
+	sessions *sessionStore
+}
+
+var _ authn.Service = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg, serviceAccountService serviceaccounts.ServiceAccountService,
+	httpClientProvider httpclient.Provider) (*Service, error) {
+	s := &Service{log: log.New("authn")}
+
+	s.clients = append(s.clients, clients.ProvideAPIKey(serviceAccountService))
+	if err := s.setupJWT(cfg, httpClientProvider); err != nil {
+		return nil, err
+	}
+	// The admin can only sign in with a password of its own.
+	if cfg.AdminPassword == "" || cfg.AdminPassword == defaultAdminPassword {
+		s.log.Warn("Basic auth of the admin is disabled, set ADMIN_PASSWORD to enable it")
+	} else {
+		s.clients = append(s.clients, clients.ProvideBasic(cfg.AdminUser, cfg.AdminPassword))
+	}
+	if cfg.AnonymousEnabled {
+		role := authn.Role(cfg.AnonymousRole)
+		if !role.IsValid() {
+			return nil, fmt.Errorf("invalid AUTH_ANONYMOUS_ROLE %q", cfg.AnonymousRole)
+		}
+		s.clients = append(s.clients, clients.ProvideAnonymous(role))
+	}
+	return s, nil
+}
+
+func (s *Service) Authenticate(ctx context.Context, r *http.Request) (*authn.Identity, error) {
+	for _, c := range s.clients {
+		if !c.Test(ctx, r) {
+			continue
+		}
+		identity, err := c.Authenticate(ctx, r)
+		if err != nil {
+			s.log.Debug("Failed to authenticate request", "client", c.Name(), "error", err)
+			return nil, err
+		}
+		return identity, nil
+	}
+	return nil, nil
+}
+
+// setupJWT adds the JWT client and the OAuth login when their keys are
+// configured.
+func (s *Service) setupJWT(cfg *setting.Cfg, httpClientProvider httpclient.Provider) error {
+	if cfg.AuthJWTJWKSURL == "" && cfg.AuthJWTJWKSFile == "" {
+		if cfg.AuthOAuthClientID != "" {
+			return errors.New("AUTH_OAUTH_CLIENT_ID requires AUTH_JWT_JWKS_URL or AUTH_JWT_JWKS_FILE to verify ID tokens")
+		}
+		return nil
+	}
+
+	client, err := httpClientProvider.New()
+	if err != nil {
+		return err
+	}
+	var keys jwt.KeySet
+	if cfg.AuthJWTJWKSFile != "" {
+		if keys, err = jwt.LoadKeySetFile(cfg.AuthJWTJWKSFile); err != nil {
+			return err
+		}
+	} else {
+		keys = jwt.NewRemoteKeySet(cfg.AuthJWTJWKSURL, client)
+	}
+	mapping := jwt.ClaimMapping{
+		Login:       cfg.AuthJWTLoginClaim,
+		Email:       cfg.AuthJWTEmailClaim,
+		Name:        cfg.AuthJWTNameClaim,
+		Groups:      cfg.AuthJWTGroupsClaim,
+		Role:        cfg.AuthJWTRoleClaim,
+		DefaultRole: authn.Role(cfg.AuthJWTDefaultRole),
+	}
+	if !mapping.DefaultRole.IsValid() {
+		return fmt.Errorf("invalid AUTH_JWT_DEFAULT_ROLE %q", cfg.AuthJWTDefaultRole)
+	}
+	verifier, err := jwt.NewVerifier(keys, cfg.AuthJWTIssuer, cfg.AuthJWTAudience)
+	if err != nil {
+		return fmt.Errorf("AUTH_JWT_JWKS_URL and AUTH_JWT_JWKS_FILE require AUTH_JWT_ISSUER and AUTH_JWT_AUDIENCE: %w", err)
+	}
+	s.clients = append(s.clients, clients.ProvideJWT(verifier, mapping))
+
+	if cfg.AuthOAuthClientID == "" {
+		return nil
+	}
+	if cfg.AuthOAuthAuthURL == "" || cfg.AuthOAuthTokenURL == "" || cfg.AuthOAuthRedirectURL == "" {
+		return errors.New("AUTH_OAUTH_CLIENT_ID requires AUTH_OAUTH_AUTH_URL, AUTH_OAUTH_TOKEN_URL and AUTH_OAUTH_REDIRECT_URL")
+	}
+	// ID tokens are issued for the client.
+	idTokenVerifier, err := jwt.NewVerifier(keys, cfg.AuthJWTIssuer, cfg.AuthOAuthClientID)
+	if err != nil {
+		return err
+	}
+	s.oauth = &oauthLogin{
+		config: &oauth2.Config{
+			ClientID:     cfg.AuthOAuthClientID,
+			ClientSecret: cfg.AuthOAuthClientSecret,
+			Endpoint:     oauth2.Endpoint{AuthURL: cfg.AuthOAuthAuthURL, TokenURL: cfg.AuthOAuthTokenURL},
+			RedirectURL:  cfg.AuthOAuthRedirectURL,
+			Scopes:       cfg.AuthOAuthScopes,
+		},
+		client:   client,
+		verifier: idTokenVerifier,
+		mapping:  mapping,
+	}
+	if s.sessions, err = newSessionStore(filepath.Join(cfg.DataPath, "auth", "sessions.json"), cfg.AuthSessionLifetime); err != nil {
+		return err
+	}
+	s.clients = append(s.clients, clients.ProvideSession(s.sessions))
+	return nil
+}
+
+func (s *Service) OAuthLoginURL(state, verifier string) (string, error) {
+	if s.oauth == nil {
+		return "", authn.ErrOAuthDisabled
+	}
+	return s.oauth.loginURL(state, verifier), nil
+}
+
+func (s *Service) OAuthLogin(ctx context.Context, code, verifier string) (*authn.Session, error) {
+	if s.oauth == nil {
+		return nil, authn.ErrOAuthDisabled
+	}
+	identity, err := s.oauth.exchange(ctx, code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.sessions.create(identity)
+	if err != nil {
+		return nil, err
+	}
+	s.log.Info("User logged in", "identity", identity, "login", identity.Login)
+	return session, nil
+}
+
+func (s *Service) Logout(_ context.Context, token string) error {
+	if s.sessions == nil {
+		return nil
+	}
+	return s.sessions.delete(token)
+}
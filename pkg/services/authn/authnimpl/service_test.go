@@ -0,0 +1,291 @@
+package authnimpl
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+	serviceaccountsservice "github.com/xquare-dashboard/pkg/services/serviceaccounts/service"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func TestService_Authenticate(t *testing.T) {
+	ctx := context.Background()
+	cfg := &setting.Cfg{DataPath: t.TempDir(), AdminUser: "admin", AdminPassword: "secret"}
+	saService, err := serviceaccountsservice.ProvideService(cfg)
+	require.NoError(t, err)
+	_, err = saService.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{UID: "ci", Name: "CI", Role: authn.RoleEditor})
+	require.NoError(t, err)
+	token, err := saService.AddToken(ctx, &serviceaccounts.AddTokenCommand{ServiceAccountUID: "ci", Name: "deploy"})
+	require.NoError(t, err)
+
+	s, err := ProvideService(cfg, saService, httpclient.NewProvider())
+	require.NoError(t, err)
+
+	t.Run("bearer token", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/user", nil)
+		r.Header.Set("Authorization", "Bearer "+token.Key)
+		identity, err := s.Authenticate(ctx, r)
+		require.NoError(t, err)
+		require.Equal(t, &authn.Identity{
+			Kind:            authn.KindServiceAccount,
+			ID:              "ci",
+			Login:           "ci",
+			Name:            "CI",
+			Role:            authn.RoleEditor,
+			AuthenticatedBy: authn.ClientAPIKey,
+		}, identity)
+	})
+
+	t.Run("token as basic auth password", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/user", nil)
+		r.SetBasicAuth("api_key", token.Key)
+		identity, err := s.Authenticate(ctx, r)
+		require.NoError(t, err)
+		require.Equal(t, "service-account:ci", identity.String())
+	})
+
+	t.Run("unknown token", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/user", nil)
+		r.Header.Set("Authorization", "Bearer "+serviceaccounts.KeyPrefix+"unknown")
+		_, err := s.Authenticate(ctx, r)
+		require.ErrorIs(t, err, serviceaccounts.ErrTokenUnknown)
+	})
+
+	t.Run("admin", func(t *testing.T) {
+		r := httptest.NewRequest("GET", "/api/user", nil)
+		r.SetBasicAuth("admin", "secret")
+		identity, err := s.Authenticate(ctx, r)
+		require.NoError(t, err)
+		require.Equal(t, authn.RoleAdmin, identity.Role)
+		require.Equal(t, authn.ClientBasic, identity.AuthenticatedBy)
+
+		r.SetBasicAuth("admin", "wrong")
+		_, err = s.Authenticate(ctx, r)
+		require.ErrorIs(t, err, authn.ErrInvalidCredentials)
+	})
+
+	t.Run("admin without password", func(t *testing.T) {
+		for _, password := range []string{"", "admin"} {
+			s, err := ProvideService(&setting.Cfg{AdminUser: "admin", AdminPassword: password}, saService, httpclient.NewProvider())
+			require.NoError(t, err)
+
+			r := httptest.NewRequest("GET", "/api/user", nil)
+			r.SetBasicAuth("admin", password)
+			identity, err := s.Authenticate(ctx, r)
+			require.NoError(t, err)
+			require.Nil(t, identity, "password %q", password)
+		}
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		identity, err := s.Authenticate(ctx, httptest.NewRequest("GET", "/api/user", nil))
+		require.NoError(t, err)
+		require.Nil(t, identity)
+
+		anonymous, err := ProvideService(&setting.Cfg{AnonymousEnabled: true, AnonymousRole: "Viewer"}, saService, httpclient.NewProvider())
+		require.NoError(t, err)
+		identity, err = anonymous.Authenticate(ctx, httptest.NewRequest("GET", "/api/user", nil))
+		require.NoError(t, err)
+		require.Equal(t, authn.KindAnonymous, identity.Kind)
+		require.True(t, identity.HasRole(authn.RoleViewer))
+		require.False(t, identity.HasRole(authn.RoleEditor))
+
+		_, err = ProvideService(&setting.Cfg{AnonymousEnabled: true, AnonymousRole: "Guest"}, saService, httpclient.NewProvider())
+		require.Error(t, err)
+	})
+}
+
+func TestService_JWT(t *testing.T) {
+	ctx := context.Background()
+	key, cfg := jwtConfig(t)
+	saService, err := serviceaccountsservice.ProvideService(cfg)
+	require.NoError(t, err)
+	s, err := ProvideService(cfg, saService, httpclient.NewProvider())
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/api/user", nil)
+	r.Header.Set("Authorization", "Bearer "+sign(t, key, map[string]any{
+		"iss":    "https://idp.example.com",
+		"aud":    "xquare",
+		"sub":    "1234",
+		"email":  "alice@example.com",
+		"groups": []string{"ops"},
+		"role":   "Admin",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}))
+	identity, err := s.Authenticate(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, &authn.Identity{
+		Kind:            authn.KindUser,
+		ID:              "1234",
+		Login:           "alice@example.com",
+		Email:           "alice@example.com",
+		Role:            authn.RoleAdmin,
+		Groups:          []string{"ops"},
+		AuthenticatedBy: authn.ClientJWT,
+	}, identity)
+
+	r.Header.Set("Authorization", "Bearer "+sign(t, key, map[string]any{
+		"iss": "https://idp.example.com",
+		"aud": "xquare",
+		"sub": "1234",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	}))
+	_, err = s.Authenticate(ctx, r)
+	require.ErrorIs(t, err, authn.ErrJWTInvalid)
+
+	_, err = s.OAuthLoginURL("state", "verifier")
+	require.ErrorIs(t, err, authn.ErrOAuthDisabled)
+
+	t.Run("issuer and audience are required", func(t *testing.T) {
+		_, cfg := jwtConfig(t)
+		cfg.AuthJWTIssuer = ""
+		_, err := ProvideService(cfg, saService, httpclient.NewProvider())
+		require.Error(t, err)
+
+		_, cfg = jwtConfig(t)
+		cfg.AuthJWTAudience = ""
+		_, err = ProvideService(cfg, saService, httpclient.NewProvider())
+		require.Error(t, err)
+	})
+}
+
+func TestService_OAuthLogin(t *testing.T) {
+	ctx := context.Background()
+	key, cfg := jwtConfig(t)
+	var codeVerifier string
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		if r.Form.Get("code") != "the-code" {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		codeVerifier = r.Form.Get("code_verifier")
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token": sign(t, key, map[string]any{
+				"iss":   "https://idp.example.com",
+				"aud":   "dashboard",
+				"sub":   "1234",
+				"email": "alice@example.com",
+				"exp":   time.Now().Add(time.Hour).Unix(),
+			}),
+		})
+	}))
+	t.Cleanup(tokenServer.Close)
+	// ID tokens are issued for the client, not for AUTH_JWT_AUDIENCE.
+	cfg.AuthOAuthClientID = "dashboard"
+	cfg.AuthOAuthAuthURL = "https://idp.example.com/authorize"
+	cfg.AuthOAuthTokenURL = tokenServer.URL
+	cfg.AuthOAuthRedirectURL = "https://xquare.example.com/login/oauth/callback"
+	cfg.AuthOAuthScopes = []string{"openid", "email"}
+	cfg.AuthSessionLifetime = time.Hour
+	saService, err := serviceaccountsservice.ProvideService(cfg)
+	require.NoError(t, err)
+	s, err := ProvideService(cfg, saService, httpclient.NewProvider())
+	require.NoError(t, err)
+
+	loginURL, err := s.OAuthLoginURL("the-state", "the-verifier")
+	require.NoError(t, err)
+	u, err := url.Parse(loginURL)
+	require.NoError(t, err)
+	require.Equal(t, "the-state", u.Query().Get("state"))
+	require.Equal(t, "S256", u.Query().Get("code_challenge_method"))
+	require.Equal(t, "openid email", u.Query().Get("scope"))
+
+	_, err = s.OAuthLogin(ctx, "wrong-code", "the-verifier")
+	require.ErrorIs(t, err, authn.ErrOAuthLoginFailed)
+
+	session, err := s.OAuthLogin(ctx, "the-code", "the-verifier")
+	require.NoError(t, err)
+	require.Equal(t, "the-verifier", codeVerifier)
+	require.Equal(t, "alice@example.com", session.Identity.Login)
+	require.Equal(t, authn.ClientOAuth, session.Identity.AuthenticatedBy)
+
+	r := httptest.NewRequest("GET", "/api/user", nil)
+	r.AddCookie(&http.Cookie{Name: authn.SessionCookieName, Value: session.Token})
+	identity, err := s.Authenticate(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, session.Identity, identity)
+
+	// Sessions survive restarts.
+	reloaded, err := ProvideService(cfg, saService, httpclient.NewProvider())
+	require.NoError(t, err)
+	identity, err = reloaded.Authenticate(ctx, r)
+	require.NoError(t, err)
+	require.Equal(t, session.Identity, identity)
+
+	// Requests with an ended session carry no credentials.
+	require.NoError(t, reloaded.Logout(ctx, session.Token))
+	identity, err = reloaded.Authenticate(ctx, r)
+	require.NoError(t, err)
+	require.Nil(t, identity)
+
+	cfg.AuthJWTJWKSFile = ""
+	_, err = ProvideService(cfg, saService, httpclient.NewProvider())
+	require.Error(t, err)
+}
+
+// jwtConfig returns a signing key and a config with the JWKS file of its
+// public key.
+func jwtConfig(t *testing.T) (*rsa.PrivateKey, *setting.Cfg) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	b, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	require.NoError(t, err)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "jwks.json")
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+	return key, &setting.Cfg{
+		DataPath:           dir,
+		AuthJWTJWKSFile:    path,
+		AuthJWTIssuer:      "https://idp.example.com",
+		AuthJWTAudience:    "xquare",
+		AuthJWTLoginClaim:  "email",
+		AuthJWTEmailClaim:  "email",
+		AuthJWTNameClaim:   "name",
+		AuthJWTGroupsClaim: "groups",
+		AuthJWTRoleClaim:   "role",
+		AuthJWTDefaultRole: "Viewer",
+	}
+}
+
+// sign returns an RS256 JWT with claims.
+func sign(t *testing.T, key *rsa.PrivateKey, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
@@ -0,0 +1,122 @@
+package authnimpl
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/infra/fs"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/authn/clients"
+)
+
+// storedSession is a session with the hash of its token.
+type storedSession struct {
+	// Hash is the hex encoded SHA-256 of the token.
+	Hash     string          `json:"hash"`
+	Identity *authn.Identity `json:"identity"`
+	Expires  time.Time       `json:"expires"`
+}
+
+// sessionStore keeps the sessions of OAuth logins in memory and persists them
+// as a JSON file, so that they survive restarts.
+type sessionStore struct {
+	path     string
+	lifetime time.Duration
+	now      func() time.Time
+
+	mu     sync.RWMutex
+	byHash map[string]*storedSession
+}
+
+var _ clients.SessionStore = (*sessionStore)(nil)
+
+func newSessionStore(path string, lifetime time.Duration) (*sessionStore, error) {
+	s := &sessionStore{path: path, lifetime: lifetime, now: time.Now, byHash: map[string]*storedSession{}}
+
+	var sessions []*storedSession
+	if err := fs.ReadJSON(path, &sessions); err != nil {
+		return nil, fmt.Errorf("failed to read sessions from %q: %w", path, err)
+	}
+	for _, session := range sessions {
+		s.byHash[session.Hash] = session
+	}
+	return s, nil
+}
+
+func (s *sessionStore) Lookup(_ context.Context, token string) *authn.Identity {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.byHash[hashToken(token)]
+	if !ok || !s.now().Before(session.Expires) {
+		return nil
+	}
+	return session.Identity
+}
+
+// create starts a session for identity.
+func (s *sessionStore) create(identity *authn.Identity) (*authn.Session, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate session token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+	session := &storedSession{Hash: hashToken(token), Identity: identity, Expires: s.now().Add(s.lifetime)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byHash[session.Hash] = session
+	if err := s.save(); err != nil {
+		delete(s.byHash, session.Hash)
+		return nil, err
+	}
+	return &authn.Session{Token: token, Identity: identity, Expires: session.Expires}, nil
+}
+
+// delete ends the session with token, if any.
+func (s *sessionStore) delete(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash := hashToken(token)
+	session, ok := s.byHash[hash]
+	if !ok {
+		return nil
+	}
+	delete(s.byHash, hash)
+	if err := s.save(); err != nil {
+		s.byHash[hash] = session
+		return err
+	}
+	return nil
+}
+
+// save persists the sessions and drops the expired ones. s.mu must be held.
+func (s *sessionStore) save() error {
+	now := s.now()
+	sessions := make([]*storedSession, 0, len(s.byHash))
+	for hash, session := range s.byHash {
+		if !now.Before(session.Expires) {
+			delete(s.byHash, hash)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	if err := fs.WriteJSONAtomic(s.path, sessions, 0o600); err != nil {
+		return fmt.Errorf("failed to save sessions to %q: %w", s.path, err)
+	}
+	return nil
+}
+
+// hashToken returns the hex encoded SHA-256 of token. Tokens are random, a
+// salt or a slow hash would not make them harder to guess.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,38 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+var _ authn.Client = (*Anonymous)(nil)
+
+// Anonymous authenticates every request as the anonymous user. It must come
+// after the other clients.
+type Anonymous struct {
+	role authn.Role
+}
+
+func ProvideAnonymous(role authn.Role) *Anonymous {
+	return &Anonymous{role: role}
+}
+
+func (c *Anonymous) Name() string {
+	return authn.ClientAnonymous
+}
+
+func (c *Anonymous) Test(_ context.Context, _ *http.Request) bool {
+	return true
+}
+
+func (c *Anonymous) Authenticate(_ context.Context, _ *http.Request) (*authn.Identity, error) {
+	return &authn.Identity{
+		Kind:            authn.KindAnonymous,
+		ID:              "anonymous",
+		Login:           "anonymous",
+		Role:            c.role,
+		AuthenticatedBy: authn.ClientAnonymous,
+	}, nil
+}
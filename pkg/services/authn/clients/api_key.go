@@ -0,0 +1,72 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+)
+
+// basicAuthUsername is the basic auth username of token keys, for tools that
+// only support basic auth.
+const basicAuthUsername = "api_key"
+
+var _ authn.Client = (*APIKey)(nil)
+
+// APIKey authenticates service accounts with the keys of their tokens, sent
+// as Authorization: Bearer <key> or as the basic auth password of the
+// api_key user.
+type APIKey struct {
+	serviceAccountService serviceaccounts.ServiceAccountService
+}
+
+func ProvideAPIKey(serviceAccountService serviceaccounts.ServiceAccountService) *APIKey {
+	return &APIKey{serviceAccountService: serviceAccountService}
+}
+
+func (c *APIKey) Name() string {
+	return authn.ClientAPIKey
+}
+
+func (c *APIKey) Test(_ context.Context, r *http.Request) bool {
+	return getTokenKey(r) != ""
+}
+
+func (c *APIKey) Authenticate(ctx context.Context, r *http.Request) (*authn.Identity, error) {
+	sa, err := c.serviceAccountService.AuthenticateToken(ctx, getTokenKey(r))
+	if err != nil {
+		return nil, err
+	}
+	return &authn.Identity{
+		Kind:            authn.KindServiceAccount,
+		ID:              sa.UID,
+		Login:           sa.UID,
+		Name:            sa.Name,
+		Role:            sa.Role,
+		AuthenticatedBy: authn.ClientAPIKey,
+	}, nil
+}
+
+// getTokenKey returns the token key of r, or an empty string when r carries
+// none. Bearer tokens without the key prefix are left to other clients.
+func getTokenKey(r *http.Request) string {
+	if token, ok := bearerToken(r); ok && strings.HasPrefix(token, serviceaccounts.KeyPrefix) {
+		return token
+	}
+	if username, password, ok := r.BasicAuth(); ok && username == basicAuthUsername {
+		return password
+	}
+	return ""
+}
+
+// bearerToken returns the token of the Authorization: Bearer header of r.
+func bearerToken(r *http.Request) (string, bool) {
+	header := r.Header.Get("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimSpace(header[len("Bearer "):])
+	return token, token != ""
+}
@@ -0,0 +1,42 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/authn/jwt"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+)
+
+var _ authn.Client = (*JWT)(nil)
+
+// JWT authenticates users with the JWTs of an identity provider, sent as
+// Authorization: Bearer <jwt>.
+type JWT struct {
+	verifier *jwt.Verifier
+	mapping  jwt.ClaimMapping
+}
+
+func ProvideJWT(verifier *jwt.Verifier, mapping jwt.ClaimMapping) *JWT {
+	return &JWT{verifier: verifier, mapping: mapping}
+}
+
+func (c *JWT) Name() string {
+	return authn.ClientJWT
+}
+
+func (c *JWT) Test(_ context.Context, r *http.Request) bool {
+	token, ok := bearerToken(r)
+	return ok && !strings.HasPrefix(token, serviceaccounts.KeyPrefix)
+}
+
+func (c *JWT) Authenticate(ctx context.Context, r *http.Request) (*authn.Identity, error) {
+	token, _ := bearerToken(r)
+	claims, err := c.verifier.Verify(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return c.mapping.Identity(claims, authn.ClientJWT)
+}
@@ -0,0 +1,53 @@
+package clients
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+var _ authn.Client = (*Session)(nil)
+
+// SessionStore looks up login sessions.
+type SessionStore interface {
+	// Lookup returns the identity of the session with the given token, nil
+	// when there is no such session or it has expired.
+	Lookup(ctx context.Context, token string) *authn.Identity
+}
+
+// Session authenticates users with the session cookie of an OAuth login.
+// Requests with an unknown or expired session are treated as requests
+// without credentials, so that the user can log in again.
+type Session struct {
+	sessions SessionStore
+}
+
+func ProvideSession(sessions SessionStore) *Session {
+	return &Session{sessions: sessions}
+}
+
+func (c *Session) Name() string {
+	return authn.ClientSession
+}
+
+func (c *Session) Test(ctx context.Context, r *http.Request) bool {
+	token := sessionToken(r)
+	return token != "" && c.sessions.Lookup(ctx, token) != nil
+}
+
+func (c *Session) Authenticate(ctx context.Context, r *http.Request) (*authn.Identity, error) {
+	identity := c.sessions.Lookup(ctx, sessionToken(r))
+	if identity == nil {
+		return nil, authn.ErrSessionInvalid.Errorf("the session ended")
+	}
+	return identity, nil
+}
+
+func sessionToken(r *http.Request) string {
+	cookie, err := r.Cookie(authn.SessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return cookie.Value
+}
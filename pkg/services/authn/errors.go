@@ -0,0 +1,15 @@
+package authn
+
+import (
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+var (
+	ErrUnauthenticated    = errutil.Unauthorized("auth.unauthenticated", errutil.WithPublicMessage("Authentication required."))
+	ErrInvalidCredentials = errutil.Unauthorized("auth.invalidCredentials", errutil.WithPublicMessage("Invalid username or password."))
+	ErrJWTInvalid         = errutil.Unauthorized("auth.jwtInvalid", errutil.WithPublicMessage("Invalid JWT."))
+	ErrSessionInvalid     = errutil.Unauthorized("auth.sessionInvalid", errutil.WithPublicMessage("Invalid or expired session, log in again."))
+	ErrOAuthDisabled      = errutil.NotFound("auth.oauthDisabled", errutil.WithPublicMessage("OAuth login is not enabled."))
+	ErrOAuthLoginFailed   = errutil.Unauthorized("auth.oauthLoginFailed", errutil.WithPublicMessage("OAuth login failed, try again."))
+	ErrRoleRequired       = errutil.Forbidden("auth.roleRequired").MustTemplate("{{ .Public.Role }} role required", errutil.WithPublic("The {{ .Public.Role }} role is required."))
+)
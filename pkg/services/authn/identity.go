@@ -0,0 +1,64 @@
+package authn
+
+// Role is the role of an identity. Every role has the permissions of the
+// roles before it: viewers query and read, editors also change dashboards,
+// annotations and rules, admins also manage datasources, notifications and
+// service accounts.
+type Role string
+
+const (
+	RoleViewer Role = "Viewer"
+	RoleEditor Role = "Editor"
+	RoleAdmin  Role = "Admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleAdmin:  3,
+}
+
+func (r Role) IsValid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Includes returns whether r has the permissions of other.
+func (r Role) Includes(other Role) bool {
+	return r.IsValid() && roleRanks[r] >= roleRanks[other]
+}
+
+// Kinds of identities.
+const (
+	KindUser           = "user"
+	KindServiceAccount = "service-account"
+	KindAnonymous      = "anonymous"
+)
+
+// Identity is who a request was made by.
+type Identity struct {
+	Kind string `json:"kind"`
+	// ID identifies the identity within its kind, e.g. the uid of a service
+	// account.
+	ID    string `json:"id"`
+	Login string `json:"login"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	Role  Role   `json:"role"`
+	// Groups are the groups of a user in the identity provider.
+	Groups []string `json:"groups,omitempty"`
+	// AuthenticatedBy is the name of the client that authenticated the
+	// identity.
+	AuthenticatedBy string `json:"authenticatedBy"`
+}
+
+// String returns the kind and the ID of the identity, e.g.
+// service-account:ci.
+func (i *Identity) String() string {
+	return i.Kind + ":" + i.ID
+}
+
+// HasRole returns whether the identity has the permissions of role.
+func (i *Identity) HasRole(role Role) bool {
+	return i != nil && i.Role.Includes(role)
+}
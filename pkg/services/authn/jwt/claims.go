@@ -0,0 +1,73 @@
+package jwt
+
+import (
+	"strings"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+// ClaimMapping names the claims identities are made of. Nested claims are
+// separated by dots, e.g. realm_access.roles.
+type ClaimMapping struct {
+	Login  string
+	Email  string
+	Name   string
+	Groups string
+	// Role is a claim with a role, or an array of which the highest role is
+	// used. Users without role claim get DefaultRole.
+	Role        string
+	DefaultRole authn.Role
+}
+
+// Identity returns the user identity of claims, or authn.ErrJWTInvalid when
+// they have no subject or login.
+func (m ClaimMapping) Identity(claims Claims, authenticatedBy string) (*authn.Identity, error) {
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, authn.ErrJWTInvalid.Errorf("the token has no sub claim")
+	}
+	login, _ := claims.lookup(m.Login).(string)
+	if login == "" {
+		return nil, authn.ErrJWTInvalid.Errorf("the token has no %s claim", m.Login)
+	}
+
+	identity := &authn.Identity{
+		Kind:            authn.KindUser,
+		ID:              sub,
+		Login:           login,
+		Role:            m.DefaultRole,
+		AuthenticatedBy: authenticatedBy,
+	}
+	identity.Email, _ = claims.lookup(m.Email).(string)
+	identity.Name, _ = claims.lookup(m.Name).(string)
+	identity.Groups = toStrings(claims.lookup(m.Groups))
+
+	var role authn.Role
+	for _, r := range toStrings(claims.lookup(m.Role)) {
+		for _, valid := range []authn.Role{authn.RoleViewer, authn.RoleEditor, authn.RoleAdmin} {
+			if strings.EqualFold(r, string(valid)) && !role.Includes(valid) {
+				role = valid
+			}
+		}
+	}
+	if role != "" {
+		identity.Role = role
+	}
+	return identity, nil
+}
+
+// lookup returns the claim at a dot separated path, nil when it's missing.
+func (c Claims) lookup(path string) any {
+	if path == "" {
+		return nil
+	}
+	var v any = map[string]any(c)
+	for _, name := range strings.Split(path, ".") {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[name]
+	}
+	return v
+}
@@ -0,0 +1,235 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// keysTTL is how long fetched keys are used before they are fetched again.
+	keysTTL = time.Hour
+	// refetchInterval is how often the keys may be fetched again for a key ID
+	// they don't contain, for keys rotated in the meantime.
+	refetchInterval = time.Minute
+)
+
+var errKeyNotFound = errors.New("key not found")
+
+// key is a public key of a JSON Web Key Set.
+type key struct {
+	id  string
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet returns the public keys JWTs are verified with.
+type KeySet interface {
+	// key returns the key with the given ID. An empty id matches the only
+	// key of a set with one key.
+	key(ctx context.Context, id string) (*key, error)
+}
+
+// LoadKeySetFile reads a JSON Web Key Set from a file.
+func LoadKeySetFile(path string) (KeySet, error) {
+	// nolint:gosec
+	// The path is built from the server configuration.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseKeySet(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS file %q: %w", path, err)
+	}
+	return staticKeySet(keys), nil
+}
+
+type staticKeySet []*key
+
+func (s staticKeySet) key(_ context.Context, id string) (*key, error) {
+	return findKey(s, id)
+}
+
+// NewRemoteKeySet returns a KeySet that fetches the JSON Web Key Set from
+// url with client.
+func NewRemoteKeySet(url string, client *http.Client) KeySet {
+	return &remoteKeySet{url: url, client: client, now: time.Now}
+}
+
+type remoteKeySet struct {
+	url    string
+	client *http.Client
+	now    func() time.Time
+
+	mu        sync.Mutex
+	keys      []*key
+	fetchedAt time.Time
+}
+
+func (s *remoteKeySet) key(ctx context.Context, id string) (*key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if s.keys != nil && now.Sub(s.fetchedAt) < keysTTL {
+		k, err := findKey(s.keys, id)
+		if err == nil || now.Sub(s.fetchedAt) < refetchInterval {
+			return k, err
+		}
+	}
+
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		if s.keys != nil {
+			// Keep using the keys fetched before until the endpoint is back.
+			return findKey(s.keys, id)
+		}
+		return nil, err
+	}
+	s.keys, s.fetchedAt = keys, now
+	return findKey(s.keys, id)
+}
+
+func (s *remoteKeySet) fetch(ctx context.Context) ([]*key, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+	keys, err := parseKeySet(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JWKS from %q: %w", s.url, err)
+	}
+	return keys, nil
+}
+
+func findKey(keys []*key, id string) (*key, error) {
+	if id == "" {
+		if len(keys) == 1 {
+			return keys[0], nil
+		}
+		return nil, fmt.Errorf("%w: the token has no key ID", errKeyNotFound)
+	}
+	for _, k := range keys {
+		if k.id == id {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %q", errKeyNotFound, id)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseKeySet returns the RSA and EC signature keys of a JSON Web Key Set,
+// other keys are skipped.
+func parseKeySet(b []byte) ([]*key, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make([]*key, 0, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var pub crypto.PublicKey
+		var err error
+		switch jwk.Kty {
+		case "RSA":
+			pub, err = rsaKey(jwk)
+		case "EC":
+			pub, err = ecKey(jwk)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", jwk.Kid, err)
+		}
+		keys = append(keys, &key{id: jwk.Kid, alg: jwk.Alg, key: pub})
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no RSA or EC signature keys")
+	}
+	return keys, nil
+}
+
+func rsaKey(jwk jsonWebKey) (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil || len(n) == 0 {
+		return nil, errors.New("invalid modulus")
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid exponent")
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func ecKey(jwk jsonWebKey) (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch jwk.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", jwk.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, errors.New("invalid x")
+	}
+	y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if err != nil {
+		return nil, errors.New("invalid y")
+	}
+	pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	if _, err := pub.ECDH(); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
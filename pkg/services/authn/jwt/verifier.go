@@ -0,0 +1,207 @@
+// Package jwt verifies JWTs signed with the keys of a JSON Web Key Set and
+// maps their claims to identities.
+package jwt
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers SHA-256 for crypto.Hash
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for crypto.Hash
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+// leeway is the clock skew tolerated when checking exp, nbf and iat.
+const leeway = time.Minute
+
+// Claims are the claims of a verified JWT.
+type Claims map[string]any
+
+// Verifier verifies the signature, the expiry, the issuer and the audience
+// of JWTs.
+type Verifier struct {
+	keys     KeySet
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewVerifier returns a Verifier of the JWTs signed with keys for audience by
+// issuer. Both are required, tokens of any issuer signed with the same keys
+// must not be accepted.
+func NewVerifier(keys KeySet, issuer, audience string) (*Verifier, error) {
+	if issuer == "" || audience == "" {
+		return nil, errors.New("the issuer and the audience of JWTs are required")
+	}
+	return &Verifier{keys: keys, issuer: issuer, audience: audience, now: time.Now}, nil
+}
+
+// Verify returns the claims of token, or authn.ErrJWTInvalid.
+func (v *Verifier) Verify(ctx context.Context, token string) (Claims, error) {
+	claims, err := v.verify(ctx, token)
+	if err != nil {
+		return nil, authn.ErrJWTInvalid.Errorf("invalid JWT: %w", err)
+	}
+	return claims, nil
+}
+
+func (v *Verifier) verify(ctx context.Context, token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+
+	k, err := v.keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if k.alg != "" && k.alg != header.Alg {
+		return nil, fmt.Errorf("the key %q is for %s, not %s", k.id, k.alg, header.Alg)
+	}
+	if err := verifySignature(header.Alg, k.key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed claims: %w", err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	now := v.now()
+	exp, ok := claims.time("exp")
+	if !ok {
+		return errors.New("the token has no exp")
+	}
+	if !now.Before(exp.Add(leeway)) {
+		return fmt.Errorf("the token expired at %s", exp)
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return fmt.Errorf("the token is not valid before %s", nbf)
+	}
+	if iat, ok := claims.time("iat"); ok && now.Add(leeway).Before(iat) {
+		return fmt.Errorf("the token was issued in the future at %s", iat)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != v.issuer {
+		return fmt.Errorf("unexpected issuer %q", iss)
+	}
+	if !slices.Contains(claims.strings("aud"), v.audience) {
+		return fmt.Errorf("the token is not for the audience %q", v.audience)
+	}
+	return nil
+}
+
+// verifySignature verifies the signature of the RS, PS and ES algorithms.
+func verifySignature(alg string, key crypto.PublicKey, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch alg[min(len(alg), 2):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		switch alg[:2] {
+		case "RS":
+			return rsa.VerifyPKCS1v15(pub, hash, digest, signature)
+		case "PS":
+			return rsa.VerifyPSS(pub, hash, digest, signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if alg[:2] != "ES" {
+			break
+		}
+		if len(signature) != 2*size {
+			return errors.New("invalid signature size")
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("the algorithm %q doesn't match the key", alg)
+}
+
+func decodeSegment(segment string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// time returns a NumericDate claim.
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(int64(f * 1000)), true
+}
+
+// strings returns a claim that is a string or an array of strings.
+func (c Claims) strings(name string) []string {
+	return toStrings(c[name])
+}
+
+func toStrings(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []any:
+		result := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
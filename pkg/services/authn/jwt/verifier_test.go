@@ -0,0 +1,165 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+func TestVerifier_Verify(t *testing.T) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keys, err := LoadKeySetFile(writeKeySet(t, "key-1", &key.PublicKey))
+	require.NoError(t, err)
+	now := time.Now()
+	v, err := NewVerifier(keys, "https://idp.example.com", "xquare")
+	require.NoError(t, err)
+
+	valid := map[string]any{
+		"iss": "https://idp.example.com",
+		"aud": []string{"other", "xquare"},
+		"sub": "1234",
+		"exp": now.Add(time.Hour).Unix(),
+		"iat": now.Unix(),
+	}
+	with := func(name string, value any) map[string]any {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[name] = value
+		return claims
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		claims, err := v.Verify(ctx, sign(t, key, "key-1", valid))
+		require.NoError(t, err)
+		require.Equal(t, "1234", claims["sub"])
+	})
+
+	for name, token := range map[string]string{
+		"expired":        sign(t, key, "key-1", with("exp", now.Add(-time.Hour).Unix())),
+		"not yet valid":  sign(t, key, "key-1", with("nbf", now.Add(time.Hour).Unix())),
+		"no exp":         sign(t, key, "key-1", with("exp", nil)),
+		"wrong issuer":   sign(t, key, "key-1", with("iss", "https://evil.example.com")),
+		"wrong audience": sign(t, key, "key-1", with("aud", "other")),
+		"no audience":    sign(t, key, "key-1", with("aud", nil)),
+		"no issuer":      sign(t, key, "key-1", with("iss", nil)),
+		"unknown key":    sign(t, key, "key-2", valid),
+		"tampered":       strings.Replace(sign(t, key, "key-1", valid), ".", ".e30", 1),
+		"malformed":      "not-a-jwt",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := v.Verify(ctx, token)
+			require.ErrorIs(t, err, authn.ErrJWTInvalid)
+		})
+	}
+
+	t.Run("single audience", func(t *testing.T) {
+		_, err := v.Verify(ctx, sign(t, key, "key-1", with("aud", "xquare")))
+		require.NoError(t, err)
+	})
+
+	t.Run("issuer and audience are required", func(t *testing.T) {
+		_, err := NewVerifier(keys, "", "xquare")
+		require.Error(t, err)
+		_, err = NewVerifier(keys, "https://idp.example.com", "")
+		require.Error(t, err)
+	})
+
+	t.Run("other signing key", func(t *testing.T) {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		_, err = v.Verify(ctx, sign(t, other, "key-1", valid))
+		require.ErrorIs(t, err, authn.ErrJWTInvalid)
+	})
+}
+
+func TestClaimMapping_Identity(t *testing.T) {
+	m := ClaimMapping{
+		Login:       "preferred_username",
+		Email:       "email",
+		Name:        "name",
+		Groups:      "groups",
+		Role:        "realm_access.roles",
+		DefaultRole: authn.RoleViewer,
+	}
+	var claims Claims
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"sub": "1234",
+		"preferred_username": "alice",
+		"email": "alice@example.com",
+		"name": "Alice",
+		"groups": ["ops", "dev"],
+		"realm_access": {"roles": ["offline_access", "editor", "viewer"]}
+	}`), &claims))
+
+	identity, err := m.Identity(claims, authn.ClientJWT)
+	require.NoError(t, err)
+	require.Equal(t, &authn.Identity{
+		Kind:            authn.KindUser,
+		ID:              "1234",
+		Login:           "alice",
+		Name:            "Alice",
+		Email:           "alice@example.com",
+		Role:            authn.RoleEditor,
+		Groups:          []string{"ops", "dev"},
+		AuthenticatedBy: authn.ClientJWT,
+	}, identity)
+
+	delete(claims, "realm_access")
+	identity, err = m.Identity(claims, authn.ClientJWT)
+	require.NoError(t, err)
+	require.Equal(t, authn.RoleViewer, identity.Role)
+
+	delete(claims, "preferred_username")
+	_, err = m.Identity(claims, authn.ClientJWT)
+	require.ErrorIs(t, err, authn.ErrJWTInvalid)
+}
+
+// writeKeySet writes a JSON Web Key Set with pub to a temporary file and
+// returns its path.
+func writeKeySet(t *testing.T, kid string, pub *rsa.PublicKey) string {
+	t.Helper()
+	b, err := json.Marshal(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, b, 0o600))
+	return path
+}
+
+// sign returns an RS256 JWT with claims.
+func sign(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
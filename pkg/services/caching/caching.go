@@ -0,0 +1,246 @@
+// Package caching caches datasource query results.
+package caching
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/infra/metrics"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+// CacheStatus tells how a query was answered, it is reported in the X-Cache header.
+type CacheStatus string
+
+const (
+	StatusHit    CacheStatus = "HIT"
+	StatusMiss   CacheStatus = "MISS"
+	StatusBypass CacheStatus = "BYPASS"
+)
+
+// Merge returns the status of a response made of responses with the statuses
+// s and other: HIT or BYPASS when both are, MISS otherwise. The empty status
+// is the status of no response.
+func (s CacheStatus) Merge(other CacheStatus) CacheStatus {
+	switch {
+	case s == "":
+		return other
+	case other == "" || s == other:
+		return s
+	default:
+		return StatusMiss
+	}
+}
+
+const (
+	// HeaderCache reports the CacheStatus of a query response.
+	HeaderCache = "X-Cache"
+	// HeaderCacheSkip makes a request bypass the cache when set to "true".
+	HeaderCacheSkip = "X-Cache-Skip"
+)
+
+// cacheTTLKey is the jsonData key of the per datasource TTL. A TTL of 0 disables
+// caching for the datasource.
+const cacheTTLKey = "cacheTTL"
+
+// volatileQueryKeys are set by the frontend per request and don't change the result.
+var volatileQueryKeys = []string{"requestId", "key", "datasource", "datasourceId"}
+
+// QueryFunc runs a query against the datasource.
+type QueryFunc func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error)
+
+type Service struct {
+	log      log.Logger
+	enabled  bool
+	ttl      time.Duration
+	storage  CacheStorage
+	requests *prometheus.CounterVec
+}
+
+func ProvideService(cfg *setting.Cfg, registerer prometheus.Registerer) (*Service, error) {
+	storage := newLRUStorage(cfg.QueryCacheMaxSize)
+	s := NewService(cfg, storage)
+
+	collectors := []prometheus.Collector{
+		s.requests,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "query_cache",
+			Name:      "items",
+			Help:      "Number of query results in the in-memory query cache.",
+		}, func() float64 {
+			items, _ := storage.stats()
+			return float64(items)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "query_cache",
+			Name:      "size_bytes",
+			Help:      "Size of the query results in the in-memory query cache.",
+		}, func() float64 {
+			_, size := storage.stats()
+			return float64(size)
+		}),
+	}
+	for _, c := range collectors {
+		if err := registerer.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// NewService returns a query cache backed by storage, for example a Redis client
+// wrapped in CacheStorage. Its metrics are not registered.
+func NewService(cfg *setting.Cfg, storage CacheStorage) *Service {
+	return &Service{
+		log:     log.New("query_cache"),
+		enabled: cfg.QueryCacheEnabled,
+		ttl:     cfg.QueryCacheTTL,
+		storage: storage,
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "query_cache",
+			Name:      "requests_total",
+			Help:      "Number of query requests by datasource type and cache status.",
+		}, []string{"datasource_type", "status"}),
+	}
+}
+
+// QueryData answers req from the cache when possible and otherwise runs query and
+// caches its response. The cache key has the time ranges aligned to the query
+// interval, so requests made within the same interval share a cache entry.
+// Responses containing errors are never cached.
+func (s *Service) QueryData(ctx context.Context, ds *datasources.DataSource, req *backend.QueryDataRequest, skip bool, query QueryFunc) (*backend.QueryDataResponse, CacheStatus, error) {
+	ttl := s.ttlFor(ds)
+	if !s.enabled || skip || ttl <= 0 {
+		s.requests.WithLabelValues(string(ds.Type), string(StatusBypass)).Inc()
+		resp, err := query(ctx, req)
+		return resp, StatusBypass, err
+	}
+
+	key, err := cacheKey(ds, req)
+	if err != nil {
+		s.log.Warn("Failed to build query cache key", "error", err)
+		resp, err := query(ctx, req)
+		return resp, StatusBypass, err
+	}
+
+	if cached, err := s.storage.Get(ctx, key); err == nil {
+		resp := &backend.QueryDataResponse{}
+		if err := json.Unmarshal(cached, resp); err == nil {
+			s.requests.WithLabelValues(string(ds.Type), string(StatusHit)).Inc()
+			return resp, StatusHit, nil
+		}
+		s.log.Warn("Failed to decode cached query response", "error", err)
+	} else if !errors.Is(err, ErrCacheItemNotFound) {
+		s.log.Warn("Failed to read query cache", "error", err)
+	}
+
+	s.requests.WithLabelValues(string(ds.Type), string(StatusMiss)).Inc()
+	resp, err := query(ctx, req)
+	if err != nil || !cacheable(resp) {
+		return resp, StatusMiss, err
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		s.log.Warn("Failed to encode query response for the cache", "error", err)
+		return resp, StatusMiss, nil
+	}
+	if err := s.storage.Set(ctx, key, b, ttl); err != nil {
+		s.log.Warn("Failed to write query cache", "error", err)
+	}
+	return resp, StatusMiss, nil
+}
+
+func (s *Service) ttlFor(ds *datasources.DataSource) time.Duration {
+	if ds.JsonData == nil {
+		return s.ttl
+	}
+	raw, ok := ds.JsonData.CheckGet(cacheTTLKey)
+	if !ok {
+		return s.ttl
+	}
+	ttl, err := time.ParseDuration(raw.MustString())
+	if err != nil {
+		s.log.Warn("Invalid datasource cache TTL, using the default", "uid", ds.UID, "cacheTTL", raw.Interface(), "error", err)
+		return s.ttl
+	}
+	return ttl
+}
+
+func cacheable(resp *backend.QueryDataResponse) bool {
+	if resp == nil {
+		return false
+	}
+	for _, r := range resp.Responses {
+		if r.Error != nil || r.Status >= backend.StatusBadRequest {
+			return false
+		}
+	}
+	return true
+}
+
+// alignTimeRanges moves the time range of every query down to a multiple of its interval.
+func alignTimeRanges(queries []backend.DataQuery) {
+	for i := range queries {
+		q := &queries[i]
+		step := q.Interval
+		if step <= 0 {
+			step = time.Second
+		}
+		q.TimeRange.From = q.TimeRange.From.Truncate(step)
+		q.TimeRange.To = q.TimeRange.To.Truncate(step)
+	}
+}
+
+// cacheKey identifies the datasource settings and the normalized queries of req.
+// The queries are normalized on a copy, req is left as it is.
+func cacheKey(ds *datasources.DataSource, req *backend.QueryDataRequest) (string, error) {
+	queries := make([]backend.DataQuery, len(req.Queries))
+	copy(queries, req.Queries)
+	alignTimeRanges(queries)
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].RefID < queries[j].RefID
+	})
+
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "%s\x00%d\x00", ds.UID, ds.Updated.UnixNano())
+	for _, q := range queries {
+		model, err := normalizeQueryJSON(q.JSON)
+		if err != nil {
+			return "", err
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00%d\x00%d\x00%d\x00%d\x00%s\x00",
+			q.RefID, q.QueryType, q.MaxDataPoints, q.Interval,
+			q.TimeRange.From.UnixNano(), q.TimeRange.To.UnixNano(), model)
+	}
+	return "query:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// normalizeQueryJSON drops volatile keys and re-encodes the query with sorted keys.
+func normalizeQueryJSON(raw json.RawMessage) ([]byte, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var model map[string]any
+	if err := json.Unmarshal(raw, &model); err != nil {
+		return nil, err
+	}
+	for _, k := range volatileQueryKeys {
+		delete(model, k)
+	}
+	return json.Marshal(model)
+}
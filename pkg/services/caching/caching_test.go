@@ -0,0 +1,152 @@
+package caching
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func TestService_QueryData(t *testing.T) {
+	cfg := &setting.Cfg{QueryCacheEnabled: true, QueryCacheTTL: time.Minute, QueryCacheMaxSize: 1 << 20}
+	ds := &datasources.DataSource{UID: "prom", Type: datasources.PrometheusType, Updated: time.Now()}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	newRequest := func(offset time.Duration, model string) *backend.QueryDataRequest {
+		return &backend.QueryDataRequest{Queries: []backend.DataQuery{{
+			RefID:     "A",
+			Interval:  15 * time.Second,
+			TimeRange: backend.TimeRange{From: now.Add(-time.Hour + offset), To: now.Add(offset)},
+			JSON:      json.RawMessage(model),
+		}}}
+	}
+
+	t.Run("serves repeated queries from the cache", func(t *testing.T) {
+		s := newTestService(t, cfg)
+		q := &fakeQuery{}
+
+		resp, status, err := s.QueryData(context.Background(), ds, newRequest(0, `{"expr":"up","requestId":"1"}`), false, q.run)
+		require.NoError(t, err)
+		require.Equal(t, StatusMiss, status)
+		require.Len(t, resp.Responses["A"].Frames, 1)
+
+		// A later request within the same interval with a different request id.
+		resp, status, err = s.QueryData(context.Background(), ds, newRequest(5*time.Second, `{"requestId":"2","expr":"up"}`), false, q.run)
+		require.NoError(t, err)
+		require.Equal(t, StatusHit, status)
+		require.Len(t, resp.Responses["A"].Frames, 1)
+		require.Equal(t, 1, q.calls)
+
+		_, status, err = s.QueryData(context.Background(), ds, newRequest(0, `{"expr":"down"}`), false, q.run)
+		require.NoError(t, err)
+		require.Equal(t, StatusMiss, status)
+		require.Equal(t, 2, q.calls)
+	})
+
+	t.Run("does not change the time range of the request", func(t *testing.T) {
+		s := newTestService(t, cfg)
+		q := &fakeQuery{}
+
+		req := newRequest(5*time.Second, `{"expr":"up"}`)
+		_, _, err := s.QueryData(context.Background(), ds, req, false, q.run)
+		require.NoError(t, err)
+		require.Equal(t, backend.TimeRange{From: now.Add(-time.Hour + 5*time.Second), To: now.Add(5 * time.Second)}, req.Queries[0].TimeRange)
+		require.Equal(t, req.Queries[0].TimeRange, q.timeRange)
+	})
+
+	t.Run("changed datasource settings invalidate the cache", func(t *testing.T) {
+		s := newTestService(t, cfg)
+		q := &fakeQuery{}
+
+		_, _, err := s.QueryData(context.Background(), ds, newRequest(0, `{"expr":"up"}`), false, q.run)
+		require.NoError(t, err)
+
+		updated := *ds
+		updated.Updated = ds.Updated.Add(time.Second)
+		_, status, err := s.QueryData(context.Background(), &updated, newRequest(0, `{"expr":"up"}`), false, q.run)
+		require.NoError(t, err)
+		require.Equal(t, StatusMiss, status)
+	})
+
+	t.Run("does not cache errors", func(t *testing.T) {
+		s := newTestService(t, cfg)
+		q := &fakeQuery{err: errors.New("boom")}
+
+		for i := 0; i < 2; i++ {
+			_, status, _ := s.QueryData(context.Background(), ds, newRequest(0, `{"expr":"up"}`), false, q.run)
+			require.Equal(t, StatusMiss, status)
+		}
+		require.Equal(t, 2, q.calls)
+	})
+
+	t.Run("bypasses the cache", func(t *testing.T) {
+		s := newTestService(t, cfg)
+		q := &fakeQuery{}
+
+		_, status, err := s.QueryData(context.Background(), ds, newRequest(0, `{"expr":"up"}`), true, q.run)
+		require.NoError(t, err)
+		require.Equal(t, StatusBypass, status)
+
+		noCache := *ds
+		noCache.JsonData = simplejson.NewFromAny(map[string]any{"cacheTTL": "0s"})
+		_, status, err = s.QueryData(context.Background(), &noCache, newRequest(0, `{"expr":"up"}`), false, q.run)
+		require.NoError(t, err)
+		require.Equal(t, StatusBypass, status)
+	})
+}
+
+func TestCacheStatus_Merge(t *testing.T) {
+	tests := []struct {
+		a, b, want CacheStatus
+	}{
+		{"", StatusHit, StatusHit},
+		{StatusHit, "", StatusHit},
+		{StatusHit, StatusHit, StatusHit},
+		{StatusBypass, StatusBypass, StatusBypass},
+		{StatusHit, StatusMiss, StatusMiss},
+		{StatusHit, StatusBypass, StatusMiss},
+		{StatusMiss, StatusBypass, StatusMiss},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.want, tt.a.Merge(tt.b), "%q merged with %q", tt.a, tt.b)
+	}
+}
+
+func newTestService(t *testing.T, cfg *setting.Cfg) *Service {
+	t.Helper()
+	s, err := ProvideService(cfg, prometheus.NewRegistry())
+	require.NoError(t, err)
+	return s
+}
+
+type fakeQuery struct {
+	calls     int
+	err       error
+	timeRange backend.TimeRange
+}
+
+func (f *fakeQuery) run(_ context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	f.calls++
+	f.timeRange = req.Queries[0].TimeRange
+	resp := backend.NewQueryDataResponse()
+	for _, q := range req.Queries {
+		if f.err != nil {
+			resp.Responses[q.RefID] = backend.DataResponse{Error: f.err}
+			continue
+		}
+		resp.Responses[q.RefID] = backend.DataResponse{Frames: data.Frames{
+			data.NewFrame("up", data.NewField("value", nil, []float64{1})),
+		}}
+	}
+	return resp, nil
+}
@@ -0,0 +1,118 @@
+package caching
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCacheItemNotFound is returned if the cache does not contain the item.
+var ErrCacheItemNotFound = errors.New("cache item not found")
+
+// CacheStorage is the storage behind the query cache. It has the shape of a
+// Redis GET/SET EX/DEL so it can be backed by Redis or a compatible server.
+type CacheStorage interface {
+	// Get gets the cache value as an byte array, or ErrCacheItemNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Set saves the value as an byte array. An expire of 0 means no expiry.
+	Set(ctx context.Context, key string, value []byte, expire time.Duration) error
+
+	// Delete object from cache.
+	Delete(ctx context.Context, key string) error
+}
+
+// lruStorage is an in-memory CacheStorage that evicts the least recently used
+// items once the total size of the values exceeds maxSize bytes.
+type lruStorage struct {
+	maxSize int64
+
+	mu    sync.Mutex
+	size  int64
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type lruItem struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+var _ CacheStorage = (*lruStorage)(nil)
+
+func newLRUStorage(maxSize int64) *lruStorage {
+	return &lruStorage{
+		maxSize: maxSize,
+		ll:      list.New(),
+		items:   make(map[string]*list.Element),
+	}
+}
+
+func (s *lruStorage) Get(_ context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, ErrCacheItemNotFound
+	}
+	item := el.Value.(*lruItem)
+	if !item.expires.IsZero() && time.Now().After(item.expires) {
+		s.remove(el)
+		return nil, ErrCacheItemNotFound
+	}
+	s.ll.MoveToFront(el)
+	return item.value, nil
+}
+
+func (s *lruStorage) Set(_ context.Context, key string, value []byte, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	// Items larger than the whole cache would only flush it.
+	if int64(len(value)) > s.maxSize {
+		return nil
+	}
+
+	item := &lruItem{key: key, value: value}
+	if expire > 0 {
+		item.expires = time.Now().Add(expire)
+	}
+	s.items[key] = s.ll.PushFront(item)
+	s.size += int64(len(value))
+
+	for s.size > s.maxSize {
+		s.remove(s.ll.Back())
+	}
+	return nil
+}
+
+func (s *lruStorage) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+// stats returns the number of items and their total size.
+func (s *lruStorage) stats() (int, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items), s.size
+}
+
+// remove must be called with the lock held.
+func (s *lruStorage) remove(el *list.Element) {
+	item := s.ll.Remove(el).(*lruItem)
+	delete(s.items, item.key)
+	s.size -= int64(len(item.value))
+}
@@ -0,0 +1,39 @@
+package caching
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("evicts the least recently used items", func(t *testing.T) {
+		s := newLRUStorage(10)
+		require.NoError(t, s.Set(ctx, "a", []byte("aaaa"), 0))
+		require.NoError(t, s.Set(ctx, "b", []byte("bbbb"), 0))
+		_, err := s.Get(ctx, "a")
+		require.NoError(t, err)
+
+		require.NoError(t, s.Set(ctx, "c", []byte("cccc"), 0))
+		_, err = s.Get(ctx, "b")
+		require.ErrorIs(t, err, ErrCacheItemNotFound)
+		_, err = s.Get(ctx, "a")
+		require.NoError(t, err)
+
+		items, size := s.stats()
+		require.Equal(t, 2, items)
+		require.EqualValues(t, 8, size)
+	})
+
+	t.Run("expires items", func(t *testing.T) {
+		s := newLRUStorage(10)
+		require.NoError(t, s.Set(ctx, "a", []byte("a"), time.Nanosecond))
+		time.Sleep(time.Millisecond)
+		_, err := s.Get(ctx, "a")
+		require.ErrorIs(t, err, ErrCacheItemNotFound)
+	})
+}
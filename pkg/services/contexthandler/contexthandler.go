@@ -7,16 +7,19 @@ import (
 	"net/http"
 
 	"github.com/xquare-dashboard/pkg/api/response"
+	"github.com/xquare-dashboard/pkg/services/authn"
 	"github.com/xquare-dashboard/pkg/services/contexthandler/ctxkey"
 	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
 	"github.com/xquare-dashboard/pkg/web"
 )
 
-func ProvideService() *ContextHandler {
-	return &ContextHandler{}
+func ProvideService(authnService authn.Service) *ContextHandler {
+	return &ContextHandler{authnService: authnService}
 }
 
-type ContextHandler struct{}
+type ContextHandler struct {
+	authnService authn.Service
+}
 
 type reqContextKey = ctxkey.Key
 
@@ -41,13 +44,16 @@ func CopyWithReqContext(ctx context.Context) context.Context {
 		Resp: web.NewResponseWriter(origReqCtx.Req.Method, response.CreateNormalResponse(http.Header{}, []byte{}, 0)),
 	}
 	reqCtx := &contextmodel.ReqContext{
-		Context: webCtx,
-		Logger:  origReqCtx.Logger,
+		Context:  webCtx,
+		Identity: origReqCtx.Identity,
+		Logger:   origReqCtx.Logger,
 	}
 	return context.WithValue(ctx, reqContextKey{}, reqCtx)
 }
 
-// Middleware provides a middleware to initialize the request context.
+// Middleware provides a middleware to initialize the request context and
+// authenticate the request. Requests with invalid credentials are rejected
+// with 401, requests without credentials continue without identity.
 func (h *ContextHandler) Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
@@ -64,6 +70,16 @@ func (h *ContextHandler) Middleware(next http.Handler) http.Handler {
 		// This modifies both r and reqContext.Req since they point to the same value
 		*reqContext.Req = *reqContext.Req.WithContext(ctx)
 
+		identity, err := h.authnService.Authenticate(ctx, reqContext.Req)
+		if err != nil {
+			response.ErrOrFallback(http.StatusUnauthorized, "Unauthorized", err).WriteTo(reqContext)
+			return
+		}
+		if identity != nil {
+			reqContext.Identity = identity
+			reqContext.Logger = reqContext.Logger.New("identity", identity.String())
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -2,14 +2,18 @@ package contextmodel
 
 import (
 	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/authn"
 	"github.com/xquare-dashboard/pkg/web"
 	"net/http"
 )
 
 type ReqContext struct {
 	*web.Context
-	Logger log.Logger
-	Error  error
+	// Identity is who made the request, nil when the request carries no
+	// credentials and anonymous access is disabled.
+	Identity *authn.Identity
+	Logger   log.Logger
+	Error    error
 }
 
 // WriteErr writes an error response based on errutil.Error.
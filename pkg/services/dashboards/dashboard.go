@@ -0,0 +1,218 @@
+package dashboards
+
+import (
+	"time"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/templating"
+)
+
+// Dashboard is a stored dashboard definition.
+type Dashboard struct {
+	UID   string    `json:"uid"`
+	Title string    `json:"title"`
+	Tags  []string  `json:"tags"`
+	Time  TimeRange `json:"time"`
+	TimeSettings
+	Templating Templating `json:"templating"`
+	Panels     []*Panel   `json:"panels"`
+	Version    int        `json:"version"`
+
+	Created time.Time `json:"created,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// Panel returns the panel with the given ID.
+func (d *Dashboard) Panel(id int64) (*Panel, bool) {
+	for _, p := range d.Panels {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// TimeRange is the default time range of a dashboard, in epoch milliseconds or
+// relative using Grafana time units, e.g. now-6h.
+type TimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// TimeSettings define how the relative times of the panel queries are rounded.
+type TimeSettings struct {
+	// Timezone is an IANA timezone name, now/d is the start of the day in it.
+	Timezone string `json:"timezone,omitempty"`
+	// WeekStart is the first day of the week for now/w, e.g. sunday.
+	WeekStart string `json:"weekStart,omitempty"`
+	// FiscalYearStartMonth is the first month of the fiscal year, 0 is January.
+	FiscalYearStartMonth int `json:"fiscalYearStartMonth,omitempty"`
+}
+
+type Templating struct {
+	List []*TemplateVariable `json:"list"`
+}
+
+// Variable returns the variable with the given name.
+func (t Templating) Variable(name string) (*TemplateVariable, bool) {
+	for _, v := range t.List {
+		if v != nil && v.Name == name {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// TemplateVariable is a dashboard variable that can be referenced in the
+// queries of the panels.
+type TemplateVariable struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Label string `json:"label,omitempty"`
+	// Datasource and Query define how query variables are resolved.
+	Datasource *simplejson.Json `json:"datasource,omitempty"`
+	Query      *simplejson.Json `json:"query,omitempty"`
+	Current    *simplejson.Json `json:"current,omitempty"`
+	Options    *simplejson.Json `json:"options,omitempty"`
+	Multi      bool             `json:"multi,omitempty"`
+	IncludeAll bool             `json:"includeAll,omitempty"`
+	// AllValue is used instead of all the options when "All" is selected.
+	AllValue string `json:"allValue,omitempty"`
+}
+
+// CurrentValue returns the current value of the variable, with the values of
+// its options when "All" is selected. It is false when nothing is selected.
+func (v *TemplateVariable) CurrentValue() (templating.Value, bool) {
+	if v.Current == nil {
+		return templating.Value{}, false
+	}
+	value, ok := v.Current.CheckGet("value")
+	if !ok {
+		return templating.Value{}, false
+	}
+	values, err := value.StringArray()
+	if err != nil {
+		values = []string{value.MustString()}
+	}
+
+	current := templating.NewValue(values...)
+	if current.All {
+		current.AllValue = v.AllValue
+		current.Options = v.OptionValues()
+	}
+	return current, true
+}
+
+// OptionValues returns the values of the options of the variable, without "All".
+func (v *TemplateVariable) OptionValues() []string {
+	if v.Options == nil {
+		return nil
+	}
+	var values []string
+	for _, option := range v.Options.MustArray() {
+		if value := simplejson.NewFromAny(option).Get("value").MustString(); value != templating.AllValue {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// Panel is a visualization of the result of its targets. Targets are queries
+// in the format of MetricRequest.Queries, a target without datasource uses
+// the datasource of the panel.
+type Panel struct {
+	ID            int64              `json:"id"`
+	Title         string             `json:"title"`
+	Type          string             `json:"type"`
+	Datasource    *simplejson.Json   `json:"datasource,omitempty"`
+	Targets       []*simplejson.Json `json:"targets"`
+	MaxDataPoints int64              `json:"maxDataPoints,omitempty"`
+	// Interval is the minimum interval of the queries, e.g. 1m.
+	Interval    string           `json:"interval,omitempty"`
+	GridPos     *simplejson.Json `json:"gridPos,omitempty"`
+	Options     *simplejson.Json `json:"options,omitempty"`
+	FieldConfig *simplejson.Json `json:"fieldConfig,omitempty"`
+}
+
+// DashboardVersion describes a saved version of a dashboard.
+type DashboardVersion struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	Message string    `json:"message"`
+}
+
+type GetDashboardQuery struct {
+	UID string
+}
+
+type GetDashboardsQuery struct {
+	// Query limits the result to dashboards whose title contains it, ignoring case.
+	Query string
+	// Tag limits the result to dashboards with the tag, when set.
+	Tag string
+}
+
+// AddDashboardCommand creates a dashboard. A UID is generated when none is given,
+// panels without ID are numbered after the highest panel ID.
+type AddDashboardCommand struct {
+	UID   string    `json:"uid"`
+	Title string    `json:"title"`
+	Tags  []string  `json:"tags"`
+	Time  TimeRange `json:"time"`
+	TimeSettings
+	Templating Templating `json:"templating"`
+	Panels     []*Panel   `json:"panels"`
+	// Message describes the change in the version history.
+	Message string `json:"message"`
+}
+
+// UpdateDashboardCommand replaces the dashboard with the given UID and saves
+// the previous definition as a version.
+type UpdateDashboardCommand struct {
+	Title string    `json:"title"`
+	Tags  []string  `json:"tags"`
+	Time  TimeRange `json:"time"`
+	TimeSettings
+	Templating Templating `json:"templating"`
+	Panels     []*Panel   `json:"panels"`
+	Message    string     `json:"message"`
+
+	// Version is the version the update is based on. When set, the update is
+	// rejected with ErrDashboardVersionMismatch if the dashboard changed since.
+	Version int `json:"version"`
+
+	UID string `json:"-"`
+}
+
+type DeleteDashboardCommand struct {
+	UID string
+}
+
+type GetDashboardVersionsQuery struct {
+	UID string
+}
+
+type GetDashboardVersionQuery struct {
+	UID     string
+	Version int
+}
+
+// RestoreDashboardVersionCommand saves an earlier version as the latest version.
+type RestoreDashboardVersionCommand struct {
+	Version int    `json:"version"`
+	UID     string `json:"-"`
+}
+
+// PanelQueryCommand queries a stored panel. Empty fields default to the time
+// range of the dashboard and the settings of the panel, Variables override the
+// current values of the dashboard variables.
+type PanelQueryCommand struct {
+	From          string               `json:"from"`
+	To            string               `json:"to"`
+	MaxDataPoints int64                `json:"maxDataPoints"`
+	IntervalMs    int64                `json:"intervalMs"`
+	Variables     templating.Variables `json:"variables"`
+
+	UID     string `json:"-"`
+	PanelID int64  `json:"-"`
+}
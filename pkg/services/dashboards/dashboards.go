@@ -0,0 +1,37 @@
+package dashboards
+
+import (
+	"context"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+)
+
+// DashboardService interface for interacting with dashboards.
+type DashboardService interface {
+	// GetDashboard gets the latest version of a dashboard.
+	GetDashboard(ctx context.Context, query *GetDashboardQuery) (*Dashboard, error)
+
+	// GetDashboards gets dashboards sorted by title.
+	GetDashboards(ctx context.Context, query *GetDashboardsQuery) ([]*Dashboard, error)
+
+	// AddDashboard adds a new dashboard.
+	AddDashboard(ctx context.Context, cmd *AddDashboardCommand) (*Dashboard, error)
+
+	// UpdateDashboard updates an existing dashboard.
+	UpdateDashboard(ctx context.Context, cmd *UpdateDashboardCommand) (*Dashboard, error)
+
+	// DeleteDashboard deletes a dashboard and its versions.
+	DeleteDashboard(ctx context.Context, cmd *DeleteDashboardCommand) error
+
+	// GetDashboardVersions lists the saved versions of a dashboard, newest first.
+	GetDashboardVersions(ctx context.Context, query *GetDashboardVersionsQuery) ([]*DashboardVersion, error)
+
+	// GetDashboardVersion gets a saved version of a dashboard.
+	GetDashboardVersion(ctx context.Context, query *GetDashboardVersionQuery) (*Dashboard, error)
+
+	// RestoreDashboardVersion saves an earlier version as the latest version.
+	RestoreDashboardVersion(ctx context.Context, cmd *RestoreDashboardVersionCommand) (*Dashboard, error)
+
+	// GetPanelQuery builds the query request of a stored panel.
+	GetPanelQuery(ctx context.Context, cmd *PanelQueryCommand) (dtos.MetricRequest, error)
+}
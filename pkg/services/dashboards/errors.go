@@ -0,0 +1,21 @@
+package dashboards
+
+import (
+	"errors"
+
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+var (
+	ErrDashboardNotFound                = errors.New("dashboard not found")
+	ErrDashboardUidExists               = errors.New("dashboard with the same uid already exists")
+	ErrDashboardWithSameNameExists      = errors.New("a dashboard with the same name already exists")
+	ErrDashboardVersionMismatch         = errors.New("the dashboard has been changed by someone else")
+	ErrDashboardVersionNotFound         = errors.New("dashboard version not found")
+	ErrDashboardPanelNotFound           = errors.New("dashboard panel not found")
+	ErrDashboardFailedGenerateUniqueUid = errors.New("failed to generate unique dashboard ID")
+	ErrDashboardTitleEmpty              = errutil.ValidationFailed("dashboard.titleEmpty", errutil.WithPublicMessage("Dashboard title cannot be empty."))
+	ErrDashboardUIDInvalid              = errutil.ValidationFailed("dashboard.uidInvalid", errutil.WithPublicMessage("Invalid dashboard uid."))
+	ErrDashboardPanelIDDuplicate        = errutil.ValidationFailed("dashboard.panelIdDuplicate", errutil.WithPublicMessage("Panel IDs must be unique within a dashboard."))
+	ErrDashboardPanelQueryInvalid       = errutil.ValidationFailed("dashboard.panelQueryInvalid", errutil.WithPublicMessage("Invalid panel query."))
+)
@@ -0,0 +1,348 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util"
+)
+
+// maxVersions is the number of versions kept per dashboard, older versions
+// are dropped when a dashboard is saved.
+const maxVersions = 20
+
+const (
+	defaultTimeFrom = "now-6h"
+	defaultTimeTo   = "now"
+)
+
+// Service stores dashboards with their version history in the data directory.
+//
+// Stored dashboards are never mutated in place, saving a dashboard always
+// replaces the stored value. Callers may therefore hold on to returned
+// dashboards but must treat them as read-only.
+type Service struct {
+	log   log.Logger
+	store *fileStore
+
+	mu    sync.RWMutex
+	byUID map[string]*history
+}
+
+var _ dashboards.DashboardService = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg) (*Service, error) {
+	s := &Service{
+		log:   log.New("dashboards"),
+		store: &fileStore{dir: filepath.Join(cfg.DataPath, "dashboards")},
+	}
+
+	stored, err := s.store.load()
+	if err != nil {
+		return nil, err
+	}
+	s.byUID = stored
+	return s, nil
+}
+
+func (s *Service) GetDashboard(_ context.Context, query *dashboards.GetDashboardQuery) (*dashboards.Dashboard, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.byUID[query.UID]
+	if !ok {
+		return nil, dashboards.ErrDashboardNotFound
+	}
+	return h.latest(), nil
+}
+
+func (s *Service) GetDashboards(_ context.Context, query *dashboards.GetDashboardsQuery) ([]*dashboards.Dashboard, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	search := strings.ToLower(query.Query)
+	result := make([]*dashboards.Dashboard, 0, len(s.byUID))
+	for _, h := range s.byUID {
+		dash := h.latest()
+		if search != "" && !strings.Contains(strings.ToLower(dash.Title), search) {
+			continue
+		}
+		if query.Tag != "" && !slices.Contains(dash.Tags, query.Tag) {
+			continue
+		}
+		result = append(result, dash)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Title < result[j].Title
+	})
+	return result, nil
+}
+
+func (s *Service) AddDashboard(_ context.Context, cmd *dashboards.AddDashboardCommand) (*dashboards.Dashboard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uid := cmd.UID
+	if uid == "" {
+		var err error
+		if uid, err = s.generateNewUID(); err != nil {
+			return nil, err
+		}
+	}
+	if !util.IsValidShortUID(uid) || util.IsShortUIDTooLong(uid) {
+		return nil, dashboards.ErrDashboardUIDInvalid.Errorf("invalid uid %q", uid)
+	}
+	if _, ok := s.byUID[uid]; ok {
+		return nil, dashboards.ErrDashboardUidExists
+	}
+
+	now := time.Now()
+	dash := &dashboards.Dashboard{
+		UID:          uid,
+		Title:        strings.TrimSpace(cmd.Title),
+		Tags:         cmd.Tags,
+		Time:         cmd.Time,
+		TimeSettings: cmd.TimeSettings,
+		Templating:   cmd.Templating,
+		Panels:       cmd.Panels,
+		Version:      1,
+		Created:      now,
+		Updated:      now,
+	}
+	if err := s.prepare(dash); err != nil {
+		return nil, err
+	}
+
+	h := &history{Versions: []*savedVersion{{Message: cmd.Message, Dashboard: dash}}}
+	if err := s.commit(uid, h); err != nil {
+		return nil, err
+	}
+	s.log.Info("Added dashboard", "uid", dash.UID, "title", dash.Title)
+	return dash, nil
+}
+
+func (s *Service) UpdateDashboard(_ context.Context, cmd *dashboards.UpdateDashboardCommand) (*dashboards.Dashboard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.byUID[cmd.UID]
+	if !ok {
+		return nil, dashboards.ErrDashboardNotFound
+	}
+	existing := h.latest()
+	if cmd.Version != 0 && cmd.Version != existing.Version {
+		return nil, dashboards.ErrDashboardVersionMismatch
+	}
+
+	dash := &dashboards.Dashboard{
+		UID:          existing.UID,
+		Title:        strings.TrimSpace(cmd.Title),
+		Tags:         cmd.Tags,
+		Time:         cmd.Time,
+		TimeSettings: cmd.TimeSettings,
+		Templating:   cmd.Templating,
+		Panels:       cmd.Panels,
+		Version:      existing.Version + 1,
+		Created:      existing.Created,
+		Updated:      time.Now(),
+	}
+	if err := s.prepare(dash); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(dash.UID, h.with(dash, cmd.Message)); err != nil {
+		return nil, err
+	}
+	s.log.Info("Updated dashboard", "uid", dash.UID, "title", dash.Title, "version", dash.Version)
+	return dash, nil
+}
+
+func (s *Service) DeleteDashboard(_ context.Context, cmd *dashboards.DeleteDashboardCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.byUID[cmd.UID]
+	if !ok {
+		return dashboards.ErrDashboardNotFound
+	}
+	if err := s.store.delete(cmd.UID); err != nil {
+		return err
+	}
+	delete(s.byUID, cmd.UID)
+	s.log.Info("Deleted dashboard", "uid", cmd.UID, "title", h.latest().Title)
+	return nil
+}
+
+func (s *Service) GetDashboardVersions(_ context.Context, query *dashboards.GetDashboardVersionsQuery) ([]*dashboards.DashboardVersion, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.byUID[query.UID]
+	if !ok {
+		return nil, dashboards.ErrDashboardNotFound
+	}
+	result := make([]*dashboards.DashboardVersion, 0, len(h.Versions))
+	for i := len(h.Versions) - 1; i >= 0; i-- {
+		v := h.Versions[i]
+		result = append(result, &dashboards.DashboardVersion{
+			Version: v.Dashboard.Version,
+			Created: v.Dashboard.Updated,
+			Message: v.Message,
+		})
+	}
+	return result, nil
+}
+
+func (s *Service) GetDashboardVersion(_ context.Context, query *dashboards.GetDashboardVersionQuery) (*dashboards.Dashboard, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	h, ok := s.byUID[query.UID]
+	if !ok {
+		return nil, dashboards.ErrDashboardNotFound
+	}
+	return h.version(query.Version)
+}
+
+func (s *Service) RestoreDashboardVersion(_ context.Context, cmd *dashboards.RestoreDashboardVersionCommand) (*dashboards.Dashboard, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.byUID[cmd.UID]
+	if !ok {
+		return nil, dashboards.ErrDashboardNotFound
+	}
+	old, err := h.version(cmd.Version)
+	if err != nil {
+		return nil, err
+	}
+	existing := h.latest()
+
+	dash := *old
+	dash.Version = existing.Version + 1
+	dash.Created = existing.Created
+	dash.Updated = time.Now()
+	// The title may have been taken by another dashboard in the meantime.
+	if err := s.prepare(&dash); err != nil {
+		return nil, err
+	}
+
+	if err := s.commit(dash.UID, h.with(&dash, fmt.Sprintf("Restored from version %d", cmd.Version))); err != nil {
+		return nil, err
+	}
+	s.log.Info("Restored dashboard version", "uid", dash.UID, "restoredVersion", cmd.Version, "version", dash.Version)
+	return &dash, nil
+}
+
+func (s *Service) GetPanelQuery(_ context.Context, cmd *dashboards.PanelQueryCommand) (dtos.MetricRequest, error) {
+	s.mu.RLock()
+	h, ok := s.byUID[cmd.UID]
+	s.mu.RUnlock()
+	if !ok {
+		return dtos.MetricRequest{}, dashboards.ErrDashboardNotFound
+	}
+
+	dash := h.latest()
+	panel, ok := dash.Panel(cmd.PanelID)
+	if !ok {
+		return dtos.MetricRequest{}, dashboards.ErrDashboardPanelNotFound
+	}
+	return panelMetricRequest(dash, panel, cmd)
+}
+
+// commit persists h and swaps it in. The caller must hold the write lock.
+func (s *Service) commit(uid string, h *history) error {
+	if err := s.store.save(uid, h); err != nil {
+		return err
+	}
+	s.byUID[uid] = h
+	return nil
+}
+
+// prepare validates dash against the other dashboards and fills in defaults.
+// The caller must hold the lock.
+func (s *Service) prepare(dash *dashboards.Dashboard) error {
+	if dash.Title == "" {
+		return dashboards.ErrDashboardTitleEmpty.Errorf("dashboard title is required")
+	}
+	for uid, h := range s.byUID {
+		if uid != dash.UID && strings.EqualFold(h.latest().Title, dash.Title) {
+			return dashboards.ErrDashboardWithSameNameExists
+		}
+	}
+
+	if dash.Time.From == "" {
+		dash.Time.From = defaultTimeFrom
+	}
+	if dash.Time.To == "" {
+		dash.Time.To = defaultTimeTo
+	}
+	if _, err := query.NewDataTimeRange(dash.Time.From, dash.Time.To, dash.Timezone, dash.WeekStart, dash.FiscalYearStartMonth); err != nil {
+		return err
+	}
+	if dash.Tags == nil {
+		dash.Tags = []string{}
+	}
+	if dash.Panels == nil {
+		dash.Panels = []*dashboards.Panel{}
+	}
+
+	var maxID int64
+	ids := make(map[int64]bool, len(dash.Panels))
+	for _, p := range dash.Panels {
+		if p.ID == 0 {
+			continue
+		}
+		if ids[p.ID] {
+			return dashboards.ErrDashboardPanelIDDuplicate.Errorf("duplicate panel id %d", p.ID)
+		}
+		ids[p.ID] = true
+		maxID = max(maxID, p.ID)
+	}
+	for _, p := range dash.Panels {
+		if p.ID == 0 {
+			maxID++
+			p.ID = maxID
+		}
+	}
+	return nil
+}
+
+func (s *Service) generateNewUID() (string, error) {
+	for i := 0; i < 3; i++ {
+		uid := util.GenerateShortUID()
+		if _, ok := s.byUID[uid]; !ok {
+			return uid, nil
+		}
+	}
+	return "", dashboards.ErrDashboardFailedGenerateUniqueUid
+}
+
+// with returns a copy of h with dash saved as the latest version.
+func (h *history) with(dash *dashboards.Dashboard, message string) *history {
+	versions := append(slices.Clone(h.Versions), &savedVersion{Message: message, Dashboard: dash})
+	if len(versions) > maxVersions {
+		versions = versions[len(versions)-maxVersions:]
+	}
+	return &history{Versions: versions}
+}
+
+func (h *history) version(version int) (*dashboards.Dashboard, error) {
+	for _, v := range h.Versions {
+		if v.Dashboard.Version == version {
+			return v.Dashboard, nil
+		}
+	}
+	return nil, dashboards.ErrDashboardVersionNotFound
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	"github.com/xquare-dashboard/pkg/services/templating"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func TestService_CRUD(t *testing.T) {
+	cfg := &setting.Cfg{DataPath: t.TempDir()}
+	s, err := ProvideService(cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	dash, err := s.AddDashboard(ctx, &dashboards.AddDashboardCommand{
+		UID:   "logs",
+		Title: "Logs",
+		Panels: []*dashboards.Panel{
+			{ID: 3, Title: "Errors"},
+			{Title: "Volume"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, dash.Version)
+	require.Equal(t, dashboards.TimeRange{From: "now-6h", To: "now"}, dash.Time)
+	require.EqualValues(t, 4, dash.Panels[1].ID)
+
+	_, err = s.AddDashboard(ctx, &dashboards.AddDashboardCommand{Title: "logs"})
+	require.ErrorIs(t, err, dashboards.ErrDashboardWithSameNameExists)
+	_, err = s.AddDashboard(ctx, &dashboards.AddDashboardCommand{Title: " "})
+	require.ErrorIs(t, err, dashboards.ErrDashboardTitleEmpty)
+	_, err = s.AddDashboard(ctx, &dashboards.AddDashboardCommand{Title: "Dup", Panels: []*dashboards.Panel{{ID: 1}, {ID: 1}}})
+	require.ErrorIs(t, err, dashboards.ErrDashboardPanelIDDuplicate)
+
+	updated, err := s.UpdateDashboard(ctx, &dashboards.UpdateDashboardCommand{UID: "logs", Title: "Logs v2", Version: 1, Message: "rename"})
+	require.NoError(t, err)
+	require.Equal(t, 2, updated.Version)
+	require.Equal(t, dash.Created, updated.Created)
+
+	_, err = s.UpdateDashboard(ctx, &dashboards.UpdateDashboardCommand{UID: "logs", Title: "Logs v3", Version: 1})
+	require.ErrorIs(t, err, dashboards.ErrDashboardVersionMismatch)
+
+	versions, err := s.GetDashboardVersions(ctx, &dashboards.GetDashboardVersionsQuery{UID: "logs"})
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	require.Equal(t, 2, versions[0].Version)
+	require.Equal(t, "rename", versions[0].Message)
+
+	restored, err := s.RestoreDashboardVersion(ctx, &dashboards.RestoreDashboardVersionCommand{UID: "logs", Version: 1})
+	require.NoError(t, err)
+	require.Equal(t, 3, restored.Version)
+	require.Equal(t, "Logs", restored.Title)
+	require.Len(t, restored.Panels, 2)
+
+	t.Run("dashboards are persisted", func(t *testing.T) {
+		reloaded, err := ProvideService(cfg)
+		require.NoError(t, err)
+		got, err := reloaded.GetDashboard(ctx, &dashboards.GetDashboardQuery{UID: "logs"})
+		require.NoError(t, err)
+		require.Equal(t, 3, got.Version)
+
+		old, err := reloaded.GetDashboardVersion(ctx, &dashboards.GetDashboardVersionQuery{UID: "logs", Version: 2})
+		require.NoError(t, err)
+		require.Equal(t, "Logs v2", old.Title)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteDashboard(ctx, &dashboards.DeleteDashboardCommand{UID: "logs"}))
+		_, err := s.GetDashboard(ctx, &dashboards.GetDashboardQuery{UID: "logs"})
+		require.ErrorIs(t, err, dashboards.ErrDashboardNotFound)
+
+		reloaded, err := ProvideService(cfg)
+		require.NoError(t, err)
+		dashs, err := reloaded.GetDashboards(ctx, &dashboards.GetDashboardsQuery{})
+		require.NoError(t, err)
+		require.Empty(t, dashs)
+	})
+}
+
+func TestService_GetPanelQuery(t *testing.T) {
+	s, err := ProvideService(&setting.Cfg{DataPath: t.TempDir()})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = s.AddDashboard(ctx, &dashboards.AddDashboardCommand{
+		UID:   "prom",
+		Title: "Prometheus",
+		Time:  dashboards.TimeRange{From: "now-1h", To: "now"},
+		Panels: []*dashboards.Panel{{
+			ID:         1,
+			Datasource: simplejson.NewFromAny(map[string]any{"uid": "prometheus"}),
+			Interval:   "30s",
+			Targets: []*simplejson.Json{
+				simplejson.NewFromAny(map[string]any{"expr": "up"}),
+				simplejson.NewFromAny(map[string]any{"expr": "down", "hide": true}),
+				simplejson.NewFromAny(map[string]any{"refId": "C", "expr": "rate(x[1m])", "datasource": map[string]any{"uid": "other"}}),
+			},
+		}},
+	})
+	require.NoError(t, err)
+
+	req, err := s.GetPanelQuery(ctx, &dashboards.PanelQueryCommand{UID: "prom", PanelID: 1})
+	require.NoError(t, err)
+	require.Equal(t, "now-1h", req.From)
+	require.Equal(t, "now", req.To)
+	require.Len(t, req.Queries, 2)
+
+	a := req.Queries[0]
+	require.Equal(t, "A", a.Get("refId").MustString())
+	require.Equal(t, "prometheus", a.GetPath("datasource", "uid").MustString())
+	require.EqualValues(t, defaultMaxDataPoints, a.Get("maxDataPoints").MustInt64())
+	// 1h over 1000 data points is less than the panel interval.
+	require.EqualValues(t, 30000, a.Get("intervalMs").MustInt64())
+	require.Equal(t, "other", req.Queries[1].GetPath("datasource", "uid").MustString())
+
+	req, err = s.GetPanelQuery(ctx, &dashboards.PanelQueryCommand{UID: "prom", PanelID: 1, From: "now-7d", MaxDataPoints: 100})
+	require.NoError(t, err)
+	require.Equal(t, "now-7d", req.From)
+	require.EqualValues(t, 100, req.Queries[0].Get("maxDataPoints").MustInt64())
+	require.Greater(t, req.Queries[0].Get("intervalMs").MustInt64(), int64(30000))
+
+	t.Run("variables", func(t *testing.T) {
+		_, err := s.AddDashboard(ctx, &dashboards.AddDashboardCommand{
+			UID:   "vars",
+			Title: "Variables",
+			Templating: dashboards.Templating{List: []*dashboards.TemplateVariable{
+				{Name: "namespace", Current: simplejson.NewFromAny(map[string]any{"value": "prod"})},
+				{
+					Name:       "pod",
+					IncludeAll: true,
+					Current:    simplejson.NewFromAny(map[string]any{"value": []any{"$__all"}}),
+					Options: simplejson.NewFromAny([]any{
+						map[string]any{"value": "$__all"},
+						map[string]any{"value": "api-0"},
+						map[string]any{"value": "api-1"},
+					}),
+				},
+			}},
+			Panels: []*dashboards.Panel{{ID: 1, Targets: []*simplejson.Json{simplejson.NewFromAny(map[string]any{"expr": "up"})}}},
+		})
+		require.NoError(t, err)
+
+		req, err := s.GetPanelQuery(ctx, &dashboards.PanelQueryCommand{UID: "vars", PanelID: 1})
+		require.NoError(t, err)
+		require.Equal(t, templating.Variables{
+			"namespace": templating.NewValue("prod"),
+			"pod":       {All: true, Options: []string{"api-0", "api-1"}},
+		}, req.Variables)
+
+		req, err = s.GetPanelQuery(ctx, &dashboards.PanelQueryCommand{UID: "vars", PanelID: 1, Variables: templating.Variables{
+			"namespace": templating.NewValue("staging"),
+			"pod":       templating.NewValue(templating.AllValue),
+		}})
+		require.NoError(t, err)
+		require.Equal(t, templating.NewValue("staging"), req.Variables["namespace"])
+		require.Equal(t, []string{"api-0", "api-1"}, req.Variables["pod"].Options)
+	})
+
+	_, err = s.GetPanelQuery(ctx, &dashboards.PanelQueryCommand{UID: "prom", PanelID: 2})
+	require.ErrorIs(t, err, dashboards.ErrDashboardPanelNotFound)
+	_, err = s.GetPanelQuery(ctx, &dashboards.PanelQueryCommand{UID: "prom", PanelID: 1, From: "yesterday-ish"})
+	require.ErrorIs(t, err, dashboards.ErrDashboardPanelQueryInvalid)
+}
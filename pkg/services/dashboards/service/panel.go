@@ -0,0 +1,138 @@
+package service
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/templating"
+	"github.com/xquare-dashboard/pkg/tsdb/intervalv2"
+)
+
+// defaultMaxDataPoints is used for panels that don't set maxDataPoints, it is
+// about the width in pixels of a full width panel.
+const defaultMaxDataPoints = 1000
+
+// panelMetricRequest builds the query request of a panel, the way the frontend
+// would: hidden targets are skipped and targets inherit the datasource, the max
+// data points and the interval of the panel.
+func panelMetricRequest(dash *dashboards.Dashboard, panel *dashboards.Panel, cmd *dashboards.PanelQueryCommand) (dtos.MetricRequest, error) {
+	from, to := cmd.From, cmd.To
+	if from == "" {
+		from = dash.Time.From
+	}
+	if to == "" {
+		to = dash.Time.To
+	}
+
+	maxDataPoints := cmd.MaxDataPoints
+	if maxDataPoints <= 0 {
+		maxDataPoints = panel.MaxDataPoints
+	}
+	if maxDataPoints <= 0 {
+		maxDataPoints = defaultMaxDataPoints
+	}
+
+	intervalMs := cmd.IntervalMs
+	if intervalMs <= 0 {
+		var err error
+		if intervalMs, err = panelInterval(dash, panel, from, to, maxDataPoints); err != nil {
+			return dtos.MetricRequest{}, err
+		}
+	}
+
+	queries := make([]*simplejson.Json, 0, len(panel.Targets))
+	for i, target := range panel.Targets {
+		if target == nil || target.Get("hide").MustBool() {
+			continue
+		}
+		q := target.DeepCopy()
+		if _, ok := q.CheckGet("refId"); !ok {
+			q.Set("refId", refID(i))
+		}
+		if _, ok := q.CheckGet("datasource"); !ok && panel.Datasource != nil {
+			q.Set("datasource", panel.Datasource.Interface())
+		}
+		if _, ok := q.CheckGet("maxDataPoints"); !ok {
+			q.Set("maxDataPoints", maxDataPoints)
+		}
+		if _, ok := q.CheckGet("intervalMs"); !ok {
+			q.Set("intervalMs", intervalMs)
+		}
+		queries = append(queries, q)
+	}
+	if len(queries) == 0 {
+		return dtos.MetricRequest{}, dashboards.ErrDashboardPanelQueryInvalid.Errorf("panel %d has no queries", panel.ID)
+	}
+
+	return dtos.MetricRequest{
+		From:                 from,
+		To:                   to,
+		Timezone:             dash.Timezone,
+		WeekStart:            dash.WeekStart,
+		FiscalYearStartMonth: dash.FiscalYearStartMonth,
+		Queries:              queries,
+		Variables:            panelVariables(dash, cmd),
+	}, nil
+}
+
+// panelVariables returns the current values of the dashboard variables with
+// the values of the command applied on top.
+func panelVariables(dash *dashboards.Dashboard, cmd *dashboards.PanelQueryCommand) templating.Variables {
+	vars := templating.Variables{}
+	for _, v := range dash.Templating.List {
+		if v == nil || v.Name == "" {
+			continue
+		}
+		if value, ok := v.CurrentValue(); ok {
+			vars[v.Name] = value
+		}
+	}
+	for name, value := range cmd.Variables {
+		// "All" stands for what the dashboard variable defines, unless the
+		// caller says otherwise.
+		if v, ok := dash.Templating.Variable(name); ok && value.All && value.AllValue == "" && value.Options == nil {
+			value.AllValue, value.Options = v.AllValue, v.OptionValues()
+		}
+		vars[name] = value
+	}
+	return vars
+}
+
+// panelInterval calculates the interval of the panel queries from the time range
+// and the max data points, it is never less than the interval of the panel.
+func panelInterval(dash *dashboards.Dashboard, panel *dashboards.Panel, from, to string, maxDataPoints int64) (int64, error) {
+	var minInterval time.Duration
+	if panel.Interval != "" {
+		var err error
+		if minInterval, err = intervalv2.ParseIntervalStringToTimeDuration(panel.Interval); err != nil {
+			return 0, dashboards.ErrDashboardPanelQueryInvalid.Errorf("invalid interval %q of panel %d: %w", panel.Interval, panel.ID, err)
+		}
+	}
+
+	tr, err := query.NewDataTimeRange(from, to, dash.Timezone, dash.WeekStart, dash.FiscalYearStartMonth)
+	if err != nil {
+		return 0, dashboards.ErrDashboardPanelQueryInvalid.Errorf("%w", err)
+	}
+	fromTime, toTime, err := tr.Parse()
+	if err != nil {
+		return 0, dashboards.ErrDashboardPanelQueryInvalid.Errorf("%w", err)
+	}
+
+	interval := intervalv2.NewCalculator().Calculate(backend.TimeRange{From: fromTime, To: toTime}, minInterval, maxDataPoints)
+	return max(interval.Milliseconds(), 1), nil
+}
+
+// refID returns the refId the frontend assigns to the i-th target: A, B, ... Z, AA, AB, ...
+func refID(i int) string {
+	id := ""
+	for i >= 0 {
+		id = string(rune('A'+i%26)) + id
+		i = i/26 - 1
+	}
+	return id
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xquare-dashboard/pkg/infra/fs"
+	"github.com/xquare-dashboard/pkg/services/dashboards"
+)
+
+// history holds the saved versions of a dashboard, oldest first. The last
+// version is the current dashboard.
+type history struct {
+	Versions []*savedVersion `json:"versions"`
+}
+
+type savedVersion struct {
+	Message   string                `json:"message"`
+	Dashboard *dashboards.Dashboard `json:"dashboard"`
+}
+
+func (h *history) latest() *dashboards.Dashboard {
+	return h.Versions[len(h.Versions)-1].Dashboard
+}
+
+// fileStore persists every dashboard with its history in a JSON file named
+// after the dashboard UID. UIDs are validated to be safe file names.
+type fileStore struct {
+	dir string
+}
+
+func (f *fileStore) load() (map[string]*history, error) {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]*history{}, nil
+		}
+		return nil, err
+	}
+
+	result := make(map[string]*history, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		path := filepath.Join(f.dir, e.Name())
+		h := &history{}
+		if err := fs.ReadJSON(path, h); err != nil {
+			return nil, fmt.Errorf("failed to read dashboard from %q: %w", path, err)
+		}
+		if len(h.Versions) == 0 {
+			continue
+		}
+		result[strings.TrimSuffix(e.Name(), ".json")] = h
+	}
+	return result, nil
+}
+
+func (f *fileStore) save(uid string, h *history) error {
+	if err := fs.WriteJSONAtomic(f.path(uid), h, 0o640); err != nil {
+		return fmt.Errorf("failed to save dashboard %q: %w", uid, err)
+	}
+	return nil
+}
+
+func (f *fileStore) delete(uid string) error {
+	if err := os.Remove(f.path(uid)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete dashboard %q: %w", uid, err)
+	}
+	return nil
+}
+
+func (f *fileStore) path(uid string) string {
+	return filepath.Join(f.dir, uid+".json")
+}
@@ -1,39 +1,104 @@
 package datasources
 
 import (
+	"time"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
 	"github.com/xquare-dashboard/pkg/util/errutil"
-	"os"
 )
 
 type DataSourceType string
-type DataSource struct {
-	Type DataSourceType
-	URL  string
-}
 
 const (
 	LokiType       DataSourceType = "loki"
 	PrometheusType DataSourceType = "prometheus"
 )
 
-var Loki = DataSource{
-	Type: LokiType,
-	URL:  os.Getenv("LOKI_URL"),
+// IsValid reports whether a backend exists for the datasource type.
+func (t DataSourceType) IsValid() bool {
+	switch t {
+	case LokiType, PrometheusType:
+		return true
+	}
+	return false
+}
+
+type DataSource struct {
+	ID      int64          `json:"id"`
+	UID     string         `json:"uid"`
+	Name    string         `json:"name"`
+	Type    DataSourceType `json:"type"`
+	URL     string         `json:"url"`
+	Version int            `json:"version"`
+
+	BasicAuth     bool   `json:"basicAuth"`
+	BasicAuthUser string `json:"basicAuthUser"`
+	IsDefault     bool   `json:"isDefault"`
+
+	JsonData       *simplejson.Json  `json:"jsonData"`
+	SecureJsonData map[string]string `json:"secureJsonData"`
+	ReadOnly       bool              `json:"readOnly"`
+	// Permissions restrict access to the datasource, see PermissionOf.
+	Permissions []*DataSourcePermission `json:"permissions,omitempty"`
+
+	Created time.Time `json:"created,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
 }
 
-var Prometheus = DataSource{
-	Type: PrometheusType,
-	URL:  os.Getenv("PROMETHEUS_URL"),
+// GetDataSourceQuery looks up a single datasource. UID takes precedence
+// over Name, which takes precedence over ID.
+type GetDataSourceQuery struct {
+	ID   int64
+	UID  string
+	Name string
 }
 
-func GetDataSource(dsType DataSourceType) (*DataSource, error) {
-	if dsType == LokiType {
-		return &Loki, nil
-	} else if dsType == PrometheusType {
-		return &Prometheus, nil
-	} else {
-		return nil, ErrInvalidDatasourceID
-	}
+type GetDataSourcesQuery struct {
+	// Type limits the result to datasources of the given type, when set.
+	Type DataSourceType
+}
+
+// AddDataSourceCommand creates a datasource through the API.
+// A UID is generated when none is given.
+type AddDataSourceCommand struct {
+	Name           string            `json:"name"`
+	Type           DataSourceType    `json:"type"`
+	URL            string            `json:"url"`
+	UID            string            `json:"uid"`
+	BasicAuth      bool              `json:"basicAuth"`
+	BasicAuthUser  string            `json:"basicAuthUser"`
+	IsDefault      bool              `json:"isDefault"`
+	JsonData       *simplejson.Json  `json:"jsonData"`
+	SecureJsonData map[string]string `json:"secureJsonData"`
+
+	Permissions []*DataSourcePermission `json:"permissions"`
+}
+
+// UpdateDataSourceCommand replaces the settings of the datasource with the given UID.
+// Secure fields that are not part of the command keep their current value,
+// and so do the permissions when the command has none, an empty list removes
+// them.
+type UpdateDataSourceCommand struct {
+	Name           string            `json:"name"`
+	Type           DataSourceType    `json:"type"`
+	URL            string            `json:"url"`
+	BasicAuth      bool              `json:"basicAuth"`
+	BasicAuthUser  string            `json:"basicAuthUser"`
+	IsDefault      bool              `json:"isDefault"`
+	JsonData       *simplejson.Json  `json:"jsonData"`
+	SecureJsonData map[string]string `json:"secureJsonData"`
+
+	Permissions []*DataSourcePermission `json:"permissions"`
+
+	// Version is the version the update is based on. When set, the update is
+	// rejected with ErrDataSourceUpdatingOldVersion if the datasource changed since.
+	Version int `json:"version"`
+
+	UID string `json:"-"`
+}
+
+type DeleteDataSourceCommand struct {
+	UID string
 }
 
 var (
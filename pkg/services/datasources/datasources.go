@@ -0,0 +1,29 @@
+package datasources
+
+import (
+	"context"
+)
+
+// DataSourceService interface for interacting with datasources.
+type DataSourceService interface {
+	// GetDataSource gets a datasource.
+	GetDataSource(ctx context.Context, query *GetDataSourceQuery) (*DataSource, error)
+
+	// GetDataSources gets datasources.
+	GetDataSources(ctx context.Context, query *GetDataSourcesQuery) ([]*DataSource, error)
+
+	// GetDefaultDataSource gets the datasource flagged as default for the given type.
+	GetDefaultDataSource(ctx context.Context, dsType DataSourceType) (*DataSource, error)
+
+	// AddDataSource adds a new datasource.
+	AddDataSource(ctx context.Context, cmd *AddDataSourceCommand) (*DataSource, error)
+
+	// UpdateDataSource updates an existing datasource.
+	UpdateDataSource(ctx context.Context, cmd *UpdateDataSourceCommand) (*DataSource, error)
+
+	// DeleteDataSource deletes an existing datasource.
+	DeleteDataSource(ctx context.Context, cmd *DeleteDataSourceCommand) error
+
+	// ProvisionDataSources replaces the set of provisioned datasources.
+	ProvisionDataSources(ctx context.Context, dataSources []*DataSource) error
+}
@@ -11,10 +11,14 @@ var (
 	ErrDataSourceNameExists              = errors.New("data source with the same name already exists")
 	ErrDataSourceUidExists               = errors.New("data source with the same uid already exists")
 	ErrDataSourceUpdatingOldVersion      = errors.New("trying to update old version of datasource")
-	ErrDataSourceAccessDenied            = errors.New("data source access denied")
 	ErrDataSourceFailedGenerateUniqueUid = errors.New("failed to generate unique datasource ID")
 	ErrDataSourceIdentifierNotSet        = errors.New("unique identifier and org id are needed to be able to get or delete a datasource")
 	ErrDatasourceIsReadOnly              = errors.New("data source is readonly, can only be updated from configuration")
 	ErrDataSourceNameInvalid             = errutil.ValidationFailed("datasource.nameInvalid", errutil.WithPublicMessage("Invalid datasource name."))
 	ErrDataSourceURLInvalid              = errutil.ValidationFailed("datasource.urlInvalid", errutil.WithPublicMessage("Invalid datasource url."))
+	ErrDataSourceTypeInvalid             = errutil.ValidationFailed("datasource.typeInvalid", errutil.WithPublicMessage("Unsupported datasource type."))
+	ErrDataSourceDefaultExists           = errutil.ValidationFailed("datasource.defaultExists", errutil.WithPublicMessage("Another datasource of this type is already the default."))
+	ErrDataSourceUIDInvalid              = errutil.ValidationFailed("datasource.uidInvalid", errutil.WithPublicMessage("Invalid datasource uid."))
+	ErrDataSourcePermissionsInvalid      = errutil.ValidationFailed("datasource.permissionsInvalid", errutil.WithPublicMessage("Invalid datasource permissions."))
+	ErrDataSourceAccessDenied            = errutil.Forbidden("datasource.accessDenied").MustTemplate("{{ .Private.Identity }} has no {{ .Public.Permission }} permission on datasource {{ .Public.DatasourceUID }}", errutil.WithPublic("The {{ .Public.Permission }} permission on datasource {{ .Public.DatasourceUID }} is required."))
 )
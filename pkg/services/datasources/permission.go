@@ -0,0 +1,103 @@
+package datasources
+
+import (
+	"slices"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+// Permission is what an identity may do with a datasource. Every permission
+// includes the ones before it: query runs queries, explore also browses the
+// resources of the datasource, like its labels, and tails its logs, admin
+// also updates and deletes it.
+type Permission string
+
+const (
+	PermissionQuery   Permission = "query"
+	PermissionExplore Permission = "explore"
+	PermissionAdmin   Permission = "admin"
+)
+
+var permissionRanks = map[Permission]int{
+	PermissionQuery:   1,
+	PermissionExplore: 2,
+	PermissionAdmin:   3,
+}
+
+func (p Permission) IsValid() bool {
+	_, ok := permissionRanks[p]
+	return ok
+}
+
+// Includes returns whether p allows what other allows.
+func (p Permission) Includes(other Permission) bool {
+	return p.IsValid() && permissionRanks[p] >= permissionRanks[other]
+}
+
+// DataSourcePermission grants a permission on a datasource to the identities
+// with a role, or to the members of a team.
+type DataSourcePermission struct {
+	// Role grants Permission to the identities with the role or a higher one.
+	Role authn.Role `json:"role,omitempty"`
+	// Team grants Permission to the users in the group of the identity
+	// provider with this name.
+	Team       string     `json:"team,omitempty"`
+	Permission Permission `json:"permission"`
+}
+
+// PermissionOf returns the highest permission identity has on ds, empty when
+// it has none. Datasources without permissions are not restricted, every
+// identity may query and explore them. Admins have the admin permission on
+// every datasource.
+func (ds *DataSource) PermissionOf(identity *authn.Identity) Permission {
+	if identity == nil {
+		return ""
+	}
+	if identity.HasRole(authn.RoleAdmin) {
+		return PermissionAdmin
+	}
+	if len(ds.Permissions) == 0 {
+		return PermissionExplore
+	}
+
+	var result Permission
+	for _, p := range ds.Permissions {
+		granted := p.Role != "" && identity.HasRole(p.Role) ||
+			p.Team != "" && slices.Contains(identity.Groups, p.Team)
+		if granted && !result.Includes(p.Permission) {
+			result = p.Permission
+		}
+	}
+	return result
+}
+
+// CheckPermission returns ErrDataSourceAccessDenied when identity does not
+// have permission on ds.
+func CheckPermission(identity *authn.Identity, ds *DataSource, permission Permission) error {
+	if ds.PermissionOf(identity).Includes(permission) {
+		return nil
+	}
+	return ErrDataSourceAccessDenied.Build(errutil.TemplateData{
+		Private: map[string]any{"Identity": identity},
+		Public:  map[string]any{"Permission": permission, "DatasourceUID": ds.UID},
+	})
+}
+
+// ValidatePermissions returns ErrDataSourcePermissionsInvalid when a
+// permission is unknown or does not grant it to exactly one role or team.
+func ValidatePermissions(permissions []*DataSourcePermission) error {
+	for _, p := range permissions {
+		switch {
+		case p == nil:
+			return ErrDataSourcePermissionsInvalid.Errorf("empty permission")
+		case !p.Permission.IsValid():
+			return ErrDataSourcePermissionsInvalid.Errorf("unknown permission %q", p.Permission)
+		case (p.Role == "") == (p.Team == ""):
+			return ErrDataSourcePermissionsInvalid.Errorf("permission %q needs either a role or a team", p.Permission)
+		case p.Role != "" && !p.Role.IsValid():
+			return ErrDataSourcePermissionsInvalid.Errorf("unknown role %q", p.Role)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package datasources
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+func TestDataSource_PermissionOf(t *testing.T) {
+	viewer := &authn.Identity{Kind: authn.KindUser, ID: "1", Role: authn.RoleViewer}
+	editor := &authn.Identity{Kind: authn.KindUser, ID: "2", Role: authn.RoleEditor}
+	sre := &authn.Identity{Kind: authn.KindUser, ID: "3", Role: authn.RoleViewer, Groups: []string{"dev", "sre"}}
+	admin := &authn.Identity{Kind: authn.KindUser, ID: "4", Role: authn.RoleAdmin}
+
+	unrestricted := &DataSource{UID: "loki-dev"}
+	require.Equal(t, PermissionExplore, unrestricted.PermissionOf(viewer))
+	require.Equal(t, PermissionAdmin, unrestricted.PermissionOf(admin))
+	require.Equal(t, Permission(""), unrestricted.PermissionOf(nil))
+
+	restricted := &DataSource{UID: "loki", Permissions: []*DataSourcePermission{
+		{Role: authn.RoleEditor, Permission: PermissionQuery},
+		{Team: "sre", Permission: PermissionQuery},
+		{Team: "sre", Permission: PermissionAdmin},
+	}}
+	require.Equal(t, Permission(""), restricted.PermissionOf(viewer))
+	require.Equal(t, PermissionQuery, restricted.PermissionOf(editor))
+	require.Equal(t, PermissionAdmin, restricted.PermissionOf(sre))
+	require.Equal(t, PermissionAdmin, restricted.PermissionOf(admin))
+}
+
+func TestCheckPermission(t *testing.T) {
+	ds := &DataSource{UID: "loki", Permissions: []*DataSourcePermission{
+		{Team: "sre", Permission: PermissionQuery},
+	}}
+	sre := &authn.Identity{Kind: authn.KindUser, ID: "1", Role: authn.RoleViewer, Groups: []string{"sre"}}
+	require.NoError(t, CheckPermission(sre, ds, PermissionQuery))
+
+	err := CheckPermission(sre, ds, PermissionExplore)
+	require.ErrorIs(t, err, ErrDataSourceAccessDenied)
+	var errutilErr errutil.Error
+	require.True(t, errors.As(err, &errutilErr))
+	require.Equal(t, http.StatusForbidden, errutilErr.Public().StatusCode)
+	require.Equal(t, "The explore permission on datasource loki is required.", errutilErr.Public().Message)
+
+	err = CheckPermission(&authn.Identity{Kind: authn.KindUser, ID: "2", Role: authn.RoleEditor}, ds, PermissionQuery)
+	require.ErrorIs(t, err, ErrDataSourceAccessDenied)
+}
@@ -0,0 +1,434 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"maps"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util"
+)
+
+// Service is a datasource registry keyed by UID. It holds the provisioned
+// datasources, which are read-only, and the datasources managed through the API,
+// which are persisted in the data directory.
+//
+// Stored datasources are never mutated in place, a change always replaces
+// the stored value. Callers may therefore hold on to returned datasources
+// but must treat them as read-only.
+type Service struct {
+	log   log.Logger
+	store *fileStore
+
+	mu     sync.RWMutex
+	byUID  map[string]*datasources.DataSource
+	ids    map[string]int64
+	nextID int64
+}
+
+var _ datasources.DataSourceService = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg) (*Service, error) {
+	s := &Service{
+		log:   log.New("datasources"),
+		store: &fileStore{path: filepath.Join(cfg.DataPath, "datasources.json")},
+		byUID: make(map[string]*datasources.DataSource),
+		ids:   make(map[string]int64),
+	}
+
+	stored, err := s.store.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, ds := range stored {
+		ds.ID = s.idFor(ds.UID)
+		ds.ReadOnly = false
+		s.byUID[ds.UID] = ds
+	}
+	return s, nil
+}
+
+func (s *Service) GetDataSource(_ context.Context, query *datasources.GetDataSourceQuery) (*datasources.DataSource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if query.UID != "" {
+		if ds, ok := s.byUID[query.UID]; ok {
+			return ds, nil
+		}
+		return nil, datasources.ErrDataSourceNotFound
+	}
+
+	if query.Name == "" && query.ID == 0 {
+		return nil, datasources.ErrDataSourceIdentifierNotSet
+	}
+
+	for _, ds := range s.byUID {
+		if query.Name != "" && ds.Name == query.Name {
+			return ds, nil
+		}
+		if query.Name == "" && ds.ID == query.ID {
+			return ds, nil
+		}
+	}
+	return nil, datasources.ErrDataSourceNotFound
+}
+
+func (s *Service) GetDataSources(_ context.Context, query *datasources.GetDataSourcesQuery) ([]*datasources.DataSource, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*datasources.DataSource, 0, len(s.byUID))
+	for _, ds := range s.byUID {
+		if query.Type != "" && ds.Type != query.Type {
+			continue
+		}
+		result = append(result, ds)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+func (s *Service) GetDefaultDataSource(ctx context.Context, dsType datasources.DataSourceType) (*datasources.DataSource, error) {
+	dss, err := s.GetDataSources(ctx, &datasources.GetDataSourcesQuery{Type: dsType})
+	if err != nil {
+		return nil, err
+	}
+	if len(dss) == 0 {
+		return nil, datasources.ErrDataSourceNotFound
+	}
+	for _, ds := range dss {
+		if ds.IsDefault {
+			return ds, nil
+		}
+	}
+	// Without an explicit default, a type with a single datasource is unambiguous.
+	if len(dss) == 1 {
+		return dss[0], nil
+	}
+	return nil, datasources.ErrDataSourceNotFound
+}
+
+func (s *Service) AddDataSource(_ context.Context, cmd *datasources.AddDataSourceCommand) (*datasources.DataSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uid := cmd.UID
+	if uid == "" {
+		var err error
+		if uid, err = s.generateNewUID(); err != nil {
+			return nil, err
+		}
+	}
+	if !util.IsValidShortUID(uid) || util.IsShortUIDTooLong(uid) {
+		return nil, datasources.ErrDataSourceUIDInvalid.Errorf("invalid uid %q", uid)
+	}
+	if _, ok := s.byUID[uid]; ok {
+		return nil, datasources.ErrDataSourceUidExists
+	}
+
+	now := time.Now()
+	ds := &datasources.DataSource{
+		ID:             s.idFor(uid),
+		UID:            uid,
+		Name:           strings.TrimSpace(cmd.Name),
+		Type:           cmd.Type,
+		URL:            cmd.URL,
+		Version:        1,
+		BasicAuth:      cmd.BasicAuth,
+		BasicAuthUser:  cmd.BasicAuthUser,
+		IsDefault:      cmd.IsDefault,
+		JsonData:       jsonDataOrEmpty(cmd.JsonData),
+		SecureJsonData: maps.Clone(cmd.SecureJsonData),
+		Permissions:    cmd.Permissions,
+		Created:        now,
+		Updated:        now,
+	}
+	if err := s.validate(ds); err != nil {
+		return nil, err
+	}
+
+	next := maps.Clone(s.byUID)
+	next[uid] = ds
+	if err := s.commit(next); err != nil {
+		return nil, err
+	}
+	s.log.Info("Added datasource", "uid", ds.UID, "name", ds.Name, "type", ds.Type)
+	return ds, nil
+}
+
+func (s *Service) UpdateDataSource(_ context.Context, cmd *datasources.UpdateDataSourceCommand) (*datasources.DataSource, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.byUID[cmd.UID]
+	if !ok {
+		return nil, datasources.ErrDataSourceNotFound
+	}
+	if existing.ReadOnly {
+		return nil, datasources.ErrDatasourceIsReadOnly
+	}
+	if cmd.Version != 0 && cmd.Version != existing.Version {
+		return nil, datasources.ErrDataSourceUpdatingOldVersion
+	}
+
+	secure := maps.Clone(existing.SecureJsonData)
+	if secure == nil {
+		secure = make(map[string]string, len(cmd.SecureJsonData))
+	}
+	maps.Copy(secure, cmd.SecureJsonData)
+	permissions := existing.Permissions
+	if cmd.Permissions != nil {
+		permissions = cmd.Permissions
+	}
+
+	now := time.Now()
+	ds := &datasources.DataSource{
+		ID:             existing.ID,
+		UID:            existing.UID,
+		Name:           strings.TrimSpace(cmd.Name),
+		Type:           cmd.Type,
+		URL:            cmd.URL,
+		Version:        existing.Version + 1,
+		BasicAuth:      cmd.BasicAuth,
+		BasicAuthUser:  cmd.BasicAuthUser,
+		IsDefault:      cmd.IsDefault,
+		JsonData:       jsonDataOrEmpty(cmd.JsonData),
+		SecureJsonData: secure,
+		Permissions:    permissions,
+		Created:        existing.Created,
+		Updated:        now,
+	}
+	if err := s.validate(ds); err != nil {
+		return nil, err
+	}
+
+	next := maps.Clone(s.byUID)
+	next[ds.UID] = ds
+	if err := s.commit(next); err != nil {
+		return nil, err
+	}
+	s.log.Info("Updated datasource", "uid", ds.UID, "name", ds.Name, "version", ds.Version)
+	return ds, nil
+}
+
+func (s *Service) DeleteDataSource(_ context.Context, cmd *datasources.DeleteDataSourceCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.byUID[cmd.UID]
+	if !ok {
+		return datasources.ErrDataSourceNotFound
+	}
+	if existing.ReadOnly {
+		return datasources.ErrDatasourceIsReadOnly
+	}
+
+	next := maps.Clone(s.byUID)
+	delete(next, cmd.UID)
+	if err := s.commit(next); err != nil {
+		return err
+	}
+	s.log.Info("Deleted datasource", "uid", existing.UID, "name", existing.Name)
+	return nil
+}
+
+// ProvisionDataSources replaces all provisioned datasources with the given set. Datasources
+// whose settings did not change keep their version and updated timestamp, so plugin instances
+// created for them stay valid. Datasources created through the API are never replaced: a
+// provisioned datasource with the UID or the name of one of them is skipped with an error log.
+func (s *Service) ProvisionDataSources(_ context.Context, dataSources []*datasources.DataSource) error {
+	names := make(map[string]bool, len(dataSources))
+	uids := make(map[string]bool, len(dataSources))
+	for _, ds := range dataSources {
+		if uids[ds.UID] {
+			return datasources.ErrDataSourceUidExists
+		}
+		if names[ds.Name] {
+			return datasources.ErrDataSourceNameExists
+		}
+		uids[ds.UID] = true
+		names[ds.Name] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	next := make(map[string]*datasources.DataSource, len(dataSources)+len(s.byUID))
+	apiNames := make(map[string]string)
+	for uid, ds := range s.byUID {
+		if !ds.ReadOnly {
+			next[uid] = ds
+			apiNames[ds.Name] = uid
+		}
+	}
+
+	for _, ds := range dataSources {
+		if existing, ok := next[ds.UID]; ok {
+			s.log.Error("Skipped provisioned datasource, a datasource created through the API has its uid",
+				"uid", ds.UID, "name", ds.Name, "existingName", existing.Name, "error", datasources.ErrDataSourceUidExists)
+			continue
+		}
+		if uid, ok := apiNames[ds.Name]; ok {
+			s.log.Error("Skipped provisioned datasource, a datasource created through the API has its name",
+				"uid", ds.UID, "name", ds.Name, "existingUid", uid, "error", datasources.ErrDataSourceNameExists)
+			continue
+		}
+		stored := *ds
+		stored.ID = s.idFor(ds.UID)
+		stored.ReadOnly = true
+		if existing, ok := s.byUID[ds.UID]; ok {
+			stored.Created = existing.Created
+			stored.Version = existing.Version
+			stored.Updated = existing.Updated
+			if !sameSettings(existing, &stored) {
+				stored.Version++
+				stored.Updated = now
+				s.log.Info("Updated datasource", "uid", ds.UID, "name", ds.Name, "version", stored.Version)
+			}
+		} else {
+			stored.Created = now
+			stored.Updated = now
+			stored.Version = 1
+			s.log.Info("Added datasource", "uid", ds.UID, "name", ds.Name, "type", ds.Type)
+		}
+		next[ds.UID] = &stored
+	}
+
+	for uid := range s.byUID {
+		if _, ok := next[uid]; !ok {
+			s.log.Info("Removed datasource", "uid", uid)
+		}
+	}
+	return s.commit(next)
+}
+
+// commit swaps in next, persisting the datasources managed through the API
+// first if they changed. The caller must hold the write lock.
+func (s *Service) commit(next map[string]*datasources.DataSource) error {
+	var writable []*datasources.DataSource
+	changed := false
+	for uid, ds := range next {
+		if ds.ReadOnly {
+			continue
+		}
+		writable = append(writable, ds)
+		if s.byUID[uid] != ds {
+			changed = true
+		}
+	}
+	for uid, ds := range s.byUID {
+		if _, ok := next[uid]; !ok && !ds.ReadOnly {
+			changed = true
+		}
+	}
+
+	if changed {
+		sort.Slice(writable, func(i, j int) bool {
+			return writable[i].UID < writable[j].UID
+		})
+		if err := s.store.save(writable); err != nil {
+			return err
+		}
+	}
+	s.byUID = next
+	return nil
+}
+
+// validate checks ds against the other datasources. The caller must hold the lock.
+func (s *Service) validate(ds *datasources.DataSource) error {
+	if ds.Name == "" {
+		return datasources.ErrDataSourceNameInvalid.Errorf("datasource name is required")
+	}
+	if !ds.Type.IsValid() {
+		return datasources.ErrDataSourceTypeInvalid.Errorf("unsupported type %q", ds.Type)
+	}
+	if u, err := url.Parse(ds.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return datasources.ErrDataSourceURLInvalid.Errorf("invalid url %q", ds.URL)
+	}
+	if err := datasources.ValidatePermissions(ds.Permissions); err != nil {
+		return err
+	}
+
+	for _, other := range s.byUID {
+		if other.UID == ds.UID {
+			continue
+		}
+		if other.Name == ds.Name {
+			return datasources.ErrDataSourceNameExists
+		}
+		if ds.IsDefault && other.IsDefault && other.Type == ds.Type {
+			return datasources.ErrDataSourceDefaultExists.Errorf("datasource %q is already the default for %s", other.Name, ds.Type)
+		}
+	}
+	return nil
+}
+
+func (s *Service) generateNewUID() (string, error) {
+	for i := 0; i < 3; i++ {
+		uid := util.GenerateShortUID()
+		if _, ok := s.byUID[uid]; !ok {
+			return uid, nil
+		}
+	}
+	return "", datasources.ErrDataSourceFailedGenerateUniqueUid
+}
+
+// idFor returns a numeric ID for the UID. IDs are stable for the lifetime of the process,
+// plugin instance managers use them as cache key.
+func (s *Service) idFor(uid string) int64 {
+	if id, ok := s.ids[uid]; ok {
+		return id
+	}
+	s.nextID++
+	s.ids[uid] = s.nextID
+	return s.nextID
+}
+
+func jsonDataOrEmpty(jsonData *simplejson.Json) *simplejson.Json {
+	if jsonData == nil {
+		return simplejson.New()
+	}
+	return jsonData
+}
+
+func sameSettings(a, b *datasources.DataSource) bool {
+	if a.Name != b.Name || a.Type != b.Type || a.URL != b.URL ||
+		a.BasicAuth != b.BasicAuth || a.BasicAuthUser != b.BasicAuthUser ||
+		a.IsDefault != b.IsDefault || a.ReadOnly != b.ReadOnly {
+		return false
+	}
+	if !maps.Equal(a.SecureJsonData, b.SecureJsonData) {
+		return false
+	}
+	if !slices.EqualFunc(a.Permissions, b.Permissions, func(p, q *datasources.DataSourcePermission) bool {
+		return *p == *q
+	}) {
+		return false
+	}
+	return bytes.Equal(encodeJSONData(a), encodeJSONData(b))
+}
+
+func encodeJSONData(ds *datasources.DataSource) []byte {
+	if ds.JsonData == nil {
+		return nil
+	}
+	b, err := ds.JsonData.Encode()
+	if err != nil {
+		return nil
+	}
+	return b
+}
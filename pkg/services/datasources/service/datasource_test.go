@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func TestService_GetDataSource(t *testing.T) {
+	s := newTestService(t)
+	err := s.ProvisionDataSources(context.Background(), []*datasources.DataSource{
+		{UID: "loki-a", Name: "Loki A", Type: datasources.LokiType, URL: "http://a"},
+		{UID: "loki-b", Name: "Loki B", Type: datasources.LokiType, URL: "http://b", IsDefault: true},
+		{UID: "prom", Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://prom"},
+	})
+	require.NoError(t, err)
+
+	t.Run("by uid", func(t *testing.T) {
+		ds, err := s.GetDataSource(context.Background(), &datasources.GetDataSourceQuery{UID: "loki-b"})
+		require.NoError(t, err)
+		require.Equal(t, "http://b", ds.URL)
+		require.NotZero(t, ds.ID)
+	})
+
+	t.Run("by name", func(t *testing.T) {
+		ds, err := s.GetDataSource(context.Background(), &datasources.GetDataSourceQuery{Name: "Prometheus"})
+		require.NoError(t, err)
+		require.Equal(t, "prom", ds.UID)
+	})
+
+	t.Run("unknown uid", func(t *testing.T) {
+		_, err := s.GetDataSource(context.Background(), &datasources.GetDataSourceQuery{UID: "nope"})
+		require.ErrorIs(t, err, datasources.ErrDataSourceNotFound)
+	})
+
+	t.Run("every datasource has its own id", func(t *testing.T) {
+		dss, err := s.GetDataSources(context.Background(), &datasources.GetDataSourcesQuery{})
+		require.NoError(t, err)
+		ids := map[int64]bool{}
+		for _, ds := range dss {
+			ids[ds.ID] = true
+		}
+		require.Len(t, ids, 3)
+	})
+
+	t.Run("default by type", func(t *testing.T) {
+		ds, err := s.GetDefaultDataSource(context.Background(), datasources.LokiType)
+		require.NoError(t, err)
+		require.Equal(t, "loki-b", ds.UID)
+
+		ds, err = s.GetDefaultDataSource(context.Background(), datasources.PrometheusType)
+		require.NoError(t, err)
+		require.Equal(t, "prom", ds.UID)
+	})
+}
+
+func TestService_ProvisionDataSources(t *testing.T) {
+	s := newTestService(t)
+	ctx := context.Background()
+
+	loki := func(url string) *datasources.DataSource {
+		return &datasources.DataSource{UID: "loki", Name: "Loki", Type: datasources.LokiType, URL: url, JsonData: simplejson.NewFromAny(map[string]any{"maxLines": 100})}
+	}
+
+	require.NoError(t, s.ProvisionDataSources(ctx, []*datasources.DataSource{loki("http://a")}))
+	first, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: "loki"})
+	require.NoError(t, err)
+	require.Equal(t, 1, first.Version)
+
+	t.Run("unchanged settings keep version and id", func(t *testing.T) {
+		require.NoError(t, s.ProvisionDataSources(ctx, []*datasources.DataSource{loki("http://a")}))
+		ds, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: "loki"})
+		require.NoError(t, err)
+		require.Equal(t, first.Version, ds.Version)
+		require.Equal(t, first.Updated, ds.Updated)
+		require.Equal(t, first.ID, ds.ID)
+	})
+
+	t.Run("changed settings bump version", func(t *testing.T) {
+		require.NoError(t, s.ProvisionDataSources(ctx, []*datasources.DataSource{loki("http://b")}))
+		ds, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: "loki"})
+		require.NoError(t, err)
+		require.Equal(t, first.Version+1, ds.Version)
+		require.Equal(t, first.ID, ds.ID)
+		require.Equal(t, "http://a", first.URL, "previously returned datasources must not change")
+	})
+
+	t.Run("removes datasources missing from the new set", func(t *testing.T) {
+		require.NoError(t, s.ProvisionDataSources(ctx, nil))
+		_, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: "loki"})
+		require.ErrorIs(t, err, datasources.ErrDataSourceNotFound)
+	})
+
+	t.Run("rejects duplicate names", func(t *testing.T) {
+		a := loki("http://a")
+		b := loki("http://b")
+		b.UID = "other"
+		require.ErrorIs(t, s.ProvisionDataSources(ctx, []*datasources.DataSource{a, b}), datasources.ErrDataSourceNameExists)
+	})
+}
+
+func TestService_CRUD(t *testing.T) {
+	ctx := context.Background()
+	cfg := &setting.Cfg{DataPath: t.TempDir()}
+	s, err := ProvideService(cfg)
+	require.NoError(t, err)
+	require.NoError(t, s.ProvisionDataSources(ctx, []*datasources.DataSource{
+		{UID: "prom", Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://prom", IsDefault: true},
+	}))
+
+	added, err := s.AddDataSource(ctx, &datasources.AddDataSourceCommand{
+		Name:           "Loki EU",
+		Type:           datasources.LokiType,
+		URL:            "http://loki-eu:3100",
+		SecureJsonData: map[string]string{"basicAuthPassword": "secret"},
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, added.UID)
+	require.Equal(t, 1, added.Version)
+	require.False(t, added.ReadOnly)
+
+	t.Run("add validates the datasource", func(t *testing.T) {
+		_, err := s.AddDataSource(ctx, &datasources.AddDataSourceCommand{Name: "Loki EU", Type: datasources.LokiType, URL: "http://other"})
+		require.ErrorIs(t, err, datasources.ErrDataSourceNameExists)
+
+		_, err = s.AddDataSource(ctx, &datasources.AddDataSourceCommand{UID: added.UID, Name: "Other", Type: datasources.LokiType, URL: "http://other"})
+		require.ErrorIs(t, err, datasources.ErrDataSourceUidExists)
+
+		_, err = s.AddDataSource(ctx, &datasources.AddDataSourceCommand{Name: "Other", Type: datasources.LokiType, URL: "loki:3100"})
+		require.ErrorIs(t, err, datasources.ErrDataSourceURLInvalid)
+
+		_, err = s.AddDataSource(ctx, &datasources.AddDataSourceCommand{Name: "Other", Type: "graphite", URL: "http://other"})
+		require.ErrorIs(t, err, datasources.ErrDataSourceTypeInvalid)
+
+		_, err = s.AddDataSource(ctx, &datasources.AddDataSourceCommand{Name: "Other", Type: datasources.PrometheusType, URL: "http://other", IsDefault: true})
+		require.ErrorIs(t, err, datasources.ErrDataSourceDefaultExists)
+	})
+
+	t.Run("update checks the version", func(t *testing.T) {
+		updated, err := s.UpdateDataSource(ctx, &datasources.UpdateDataSourceCommand{
+			UID: added.UID, Name: "Loki EU", Type: datasources.LokiType, URL: "http://loki-eu-2:3100", Version: added.Version,
+		})
+		require.NoError(t, err)
+		require.Equal(t, added.Version+1, updated.Version)
+		require.Equal(t, added.ID, updated.ID)
+		require.Equal(t, "secret", updated.SecureJsonData["basicAuthPassword"], "secure fields not in the update are kept")
+		require.True(t, updated.Updated.After(added.Updated))
+
+		_, err = s.UpdateDataSource(ctx, &datasources.UpdateDataSourceCommand{
+			UID: added.UID, Name: "Loki EU", Type: datasources.LokiType, URL: "http://loki-eu-3:3100", Version: added.Version,
+		})
+		require.ErrorIs(t, err, datasources.ErrDataSourceUpdatingOldVersion)
+	})
+
+	t.Run("provisioned datasources are read-only", func(t *testing.T) {
+		_, err := s.UpdateDataSource(ctx, &datasources.UpdateDataSourceCommand{UID: "prom", Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://x"})
+		require.ErrorIs(t, err, datasources.ErrDatasourceIsReadOnly)
+		require.ErrorIs(t, s.DeleteDataSource(ctx, &datasources.DeleteDataSourceCommand{UID: "prom"}), datasources.ErrDatasourceIsReadOnly)
+	})
+
+	t.Run("provisioning keeps datasources created through the api", func(t *testing.T) {
+		require.NoError(t, s.ProvisionDataSources(ctx, nil))
+		_, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: added.UID})
+		require.NoError(t, err)
+	})
+
+	t.Run("provisioned datasources with the uid of one created through the api are skipped", func(t *testing.T) {
+		require.NoError(t, s.ProvisionDataSources(ctx, []*datasources.DataSource{
+			{UID: added.UID, Name: "Loki Provisioned", Type: datasources.LokiType, URL: "http://provisioned"},
+			{UID: "prom", Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://prom", IsDefault: true},
+		}))
+		ds, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: added.UID})
+		require.NoError(t, err)
+		require.False(t, ds.ReadOnly)
+		require.Equal(t, "Loki EU", ds.Name)
+		require.Equal(t, "secret", ds.SecureJsonData["basicAuthPassword"])
+		_, err = s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: "prom"})
+		require.NoError(t, err, "the other provisioned datasources are provisioned")
+	})
+
+	t.Run("provisioned datasources with the name of one created through the api are skipped", func(t *testing.T) {
+		require.NoError(t, s.ProvisionDataSources(ctx, []*datasources.DataSource{
+			{UID: "loki-eu", Name: "Loki EU", Type: datasources.LokiType, URL: "http://provisioned"},
+			{UID: "prom", Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://prom", IsDefault: true},
+		}))
+		_, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: "loki-eu"})
+		require.ErrorIs(t, err, datasources.ErrDataSourceNotFound)
+		ds, err := s.GetDataSource(ctx, &datasources.GetDataSourceQuery{Name: "Loki EU"})
+		require.NoError(t, err)
+		require.Equal(t, added.UID, ds.UID)
+		_, err = s.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: "prom"})
+		require.NoError(t, err)
+	})
+
+	t.Run("datasources created through the api are persisted", func(t *testing.T) {
+		reloaded, err := ProvideService(cfg)
+		require.NoError(t, err)
+		ds, err := reloaded.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: added.UID})
+		require.NoError(t, err)
+		require.Equal(t, "http://loki-eu-2:3100", ds.URL)
+		require.Equal(t, added.Version+1, ds.Version)
+		require.Equal(t, "secret", ds.SecureJsonData["basicAuthPassword"])
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, s.DeleteDataSource(ctx, &datasources.DeleteDataSourceCommand{UID: added.UID}))
+		require.ErrorIs(t, s.DeleteDataSource(ctx, &datasources.DeleteDataSourceCommand{UID: added.UID}), datasources.ErrDataSourceNotFound)
+
+		reloaded, err := ProvideService(cfg)
+		require.NoError(t, err)
+		_, err = reloaded.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: added.UID})
+		require.ErrorIs(t, err, datasources.ErrDataSourceNotFound)
+	})
+}
+
+func TestService_Permissions(t *testing.T) {
+	ctx := context.Background()
+	s := newTestService(t)
+	permissions := []*datasources.DataSourcePermission{
+		{Team: "sre", Permission: datasources.PermissionExplore},
+	}
+	added, err := s.AddDataSource(ctx, &datasources.AddDataSourceCommand{
+		Name: "Loki", Type: datasources.LokiType, URL: "http://loki:3100", Permissions: permissions,
+	})
+	require.NoError(t, err)
+	require.Equal(t, permissions, added.Permissions)
+
+	t.Run("updates without permissions keep them", func(t *testing.T) {
+		updated, err := s.UpdateDataSource(ctx, &datasources.UpdateDataSourceCommand{
+			UID: added.UID, Name: "Loki", Type: datasources.LokiType, URL: "http://loki-2:3100",
+		})
+		require.NoError(t, err)
+		require.Equal(t, permissions, updated.Permissions)
+
+		updated, err = s.UpdateDataSource(ctx, &datasources.UpdateDataSourceCommand{
+			UID: added.UID, Name: "Loki", Type: datasources.LokiType, URL: "http://loki-2:3100",
+			Permissions: []*datasources.DataSourcePermission{},
+		})
+		require.NoError(t, err)
+		require.Empty(t, updated.Permissions)
+	})
+
+	t.Run("invalid permissions", func(t *testing.T) {
+		for _, p := range []*datasources.DataSourcePermission{
+			{Team: "sre", Permission: "write"},
+			{Permission: datasources.PermissionQuery},
+			{Role: authn.RoleViewer, Team: "sre", Permission: datasources.PermissionQuery},
+			{Role: "Guest", Permission: datasources.PermissionQuery},
+		} {
+			_, err := s.UpdateDataSource(ctx, &datasources.UpdateDataSourceCommand{
+				UID: added.UID, Name: "Loki", Type: datasources.LokiType, URL: "http://loki:3100",
+				Permissions: []*datasources.DataSourcePermission{p},
+			})
+			require.ErrorIs(t, err, datasources.ErrDataSourcePermissionsInvalid)
+		}
+	})
+}
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	s, err := ProvideService(&setting.Cfg{DataPath: t.TempDir()})
+	require.NoError(t, err)
+	return s
+}
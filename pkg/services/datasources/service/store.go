@@ -0,0 +1,31 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/xquare-dashboard/pkg/infra/fs"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+// fileStore persists the datasources managed through the API as a JSON file.
+// Provisioned datasources are never written, they are read from provisioning
+// on every start. The file contains secure settings and is only readable by
+// the server user.
+type fileStore struct {
+	path string
+}
+
+func (f *fileStore) load() ([]*datasources.DataSource, error) {
+	var dss []*datasources.DataSource
+	if err := fs.ReadJSON(f.path, &dss); err != nil {
+		return nil, fmt.Errorf("failed to read datasources from %q: %w", f.path, err)
+	}
+	return dss, nil
+}
+
+func (f *fileStore) save(dss []*datasources.DataSource) error {
+	if err := fs.WriteJSONAtomic(f.path, dss, 0o600); err != nil {
+		return fmt.Errorf("failed to save datasources to %q: %w", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+// Package live shares plugin streams between subscribers.
+//
+// A plugin stream is started with RunStream on the first subscription to a
+// channel and every message it sends is fanned out to all subscribers of that
+// channel. The stream is canceled when the last subscriber leaves.
+package live
+
+import (
+	"context"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/plugins"
+)
+
+// subscriberBuffer is the number of messages buffered per subscriber. A subscriber
+// that falls further behind is disconnected rather than slowing down the others.
+const subscriberBuffer = 256
+
+// StreamRunner runs plugin streams, plugins.Client implements it.
+type StreamRunner interface {
+	RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error
+}
+
+type Service struct {
+	log    log.Logger
+	runner StreamRunner
+
+	mu      sync.Mutex
+	streams map[string]*stream
+}
+
+func ProvideService(pluginClient plugins.Client) *Service {
+	return newService(pluginClient)
+}
+
+func newService(runner StreamRunner) *Service {
+	return &Service{
+		log:     log.New("live"),
+		runner:  runner,
+		streams: make(map[string]*stream),
+	}
+}
+
+type stream struct {
+	channel     string
+	cancel      context.CancelFunc
+	subscribers map[*Subscription]struct{}
+}
+
+// Subscription receives the messages of a channel on C. C is closed when the
+// stream ends or the subscriber could not keep up.
+type Subscription struct {
+	C <-chan []byte
+
+	c       chan []byte
+	service *Service
+	stream  *stream
+}
+
+// Unsubscribe stops receiving messages. The stream is canceled when this was
+// its last subscriber. Unsubscribe can be called more than once.
+func (sub *Subscription) Unsubscribe() {
+	sub.service.mu.Lock()
+	defer sub.service.mu.Unlock()
+	sub.service.removeSubscriber(sub)
+}
+
+// Subscribe subscribes to channel, starting the plugin stream described by req
+// unless it is already running. Channels identify streams, so requests for the
+// same channel must describe the same stream.
+func (s *Service) Subscribe(channel string, req *backend.RunStreamRequest) *Subscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, ok := s.streams[channel]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		st = &stream{
+			channel:     channel,
+			cancel:      cancel,
+			subscribers: make(map[*Subscription]struct{}),
+		}
+		s.streams[channel] = st
+		go s.run(ctx, st, req)
+	}
+
+	c := make(chan []byte, subscriberBuffer)
+	sub := &Subscription{C: c, c: c, service: s, stream: st}
+	st.subscribers[sub] = struct{}{}
+	s.log.Debug("Subscribed to channel", "channel", channel, "subscribers", len(st.subscribers))
+	return sub
+}
+
+// NumSubscribers returns the number of subscribers of channel.
+func (s *Service) NumSubscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.streams[channel]; ok {
+		return len(st.subscribers)
+	}
+	return 0
+}
+
+func (s *Service) run(ctx context.Context, st *stream, req *backend.RunStreamRequest) {
+	s.log.Info("Starting stream", "channel", st.channel)
+	err := s.runner.RunStream(ctx, req, backend.NewStreamSender(&broadcaster{service: s, stream: st}))
+	if err != nil && ctx.Err() == nil {
+		s.log.Error("Stream stopped", "channel", st.channel, "error", err)
+	} else {
+		s.log.Info("Stream stopped", "channel", st.channel)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.cancel()
+	if s.streams[st.channel] == st {
+		delete(s.streams, st.channel)
+	}
+	for sub := range st.subscribers {
+		delete(st.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// removeSubscriber must be called with the lock held.
+func (s *Service) removeSubscriber(sub *Subscription) {
+	st := sub.stream
+	if _, ok := st.subscribers[sub]; !ok {
+		return
+	}
+	delete(st.subscribers, sub)
+	close(sub.c)
+
+	if len(st.subscribers) == 0 {
+		s.log.Debug("Last subscriber left, stopping stream", "channel", st.channel)
+		st.cancel()
+		if s.streams[st.channel] == st {
+			delete(s.streams, st.channel)
+		}
+	}
+}
+
+// broadcaster sends the packets of a plugin stream to its subscribers.
+type broadcaster struct {
+	service *Service
+	stream  *stream
+}
+
+func (b *broadcaster) Send(packet *backend.StreamPacket) error {
+	b.service.mu.Lock()
+	defer b.service.mu.Unlock()
+
+	for sub := range b.stream.subscribers {
+		select {
+		case sub.c <- packet.Data:
+		default:
+			b.service.log.Warn("Subscriber too slow, disconnecting", "channel", b.stream.channel)
+			b.service.removeSubscriber(sub)
+		}
+	}
+	return nil
+}
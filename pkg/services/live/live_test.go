@@ -0,0 +1,99 @@
+package live
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_Subscribe(t *testing.T) {
+	t.Run("shares one stream between subscribers", func(t *testing.T) {
+		runner := newFakeRunner()
+		s := newService(runner)
+
+		a := s.Subscribe("ds/tail/1", &backend.RunStreamRequest{Path: "tail/1"})
+		b := s.Subscribe("ds/tail/1", &backend.RunStreamRequest{Path: "tail/1"})
+		sender := <-runner.started
+		require.Equal(t, 2, s.NumSubscribers("ds/tail/1"))
+
+		require.NoError(t, sender.SendJSON([]byte(`{"line":1}`)))
+		require.JSONEq(t, `{"line":1}`, string(receive(t, a.C)))
+		require.JSONEq(t, `{"line":1}`, string(receive(t, b.C)))
+		require.EqualValues(t, 1, runner.runs.Load())
+
+		a.Unsubscribe()
+		a.Unsubscribe()
+		require.Equal(t, 1, s.NumSubscribers("ds/tail/1"))
+		select {
+		case <-runner.stopped:
+			t.Fatal("stream stopped while it still has subscribers")
+		default:
+		}
+
+		b.Unsubscribe()
+		select {
+		case <-runner.stopped:
+		case <-time.After(time.Second):
+			t.Fatal("stream not stopped after the last subscriber left")
+		}
+		require.Equal(t, 0, s.NumSubscribers("ds/tail/1"))
+	})
+
+	t.Run("closes subscriptions when the stream ends", func(t *testing.T) {
+		runner := newFakeRunner()
+		s := newService(runner)
+
+		sub := s.Subscribe("ds/tail/2", &backend.RunStreamRequest{Path: "tail/2"})
+		<-runner.started
+		runner.end <- struct{}{}
+
+		select {
+		case _, ok := <-sub.C:
+			require.False(t, ok)
+		case <-time.After(time.Second):
+			t.Fatal("subscription not closed")
+		}
+		sub.Unsubscribe()
+	})
+}
+
+func receive(t *testing.T, c <-chan []byte) []byte {
+	t.Helper()
+	select {
+	case msg := <-c:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+type fakeRunner struct {
+	runs    atomic.Int32
+	started chan *backend.StreamSender
+	stopped chan struct{}
+	end     chan struct{}
+}
+
+func newFakeRunner() *fakeRunner {
+	return &fakeRunner{
+		started: make(chan *backend.StreamSender, 1),
+		stopped: make(chan struct{}, 1),
+		end:     make(chan struct{}),
+	}
+}
+
+func (r *fakeRunner) RunStream(ctx context.Context, _ *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	r.runs.Add(1)
+	r.started <- sender
+	defer func() { r.stopped <- struct{}{} }()
+	select {
+	case <-ctx.Done():
+	case <-r.end:
+	}
+	return nil
+}
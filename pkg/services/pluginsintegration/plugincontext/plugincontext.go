@@ -3,28 +3,32 @@ package plugincontext
 import (
 	"context"
 	"encoding/json"
+	"errors"
+
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/xquare-dashboard/pkg/plugins"
 	"github.com/xquare-dashboard/pkg/plugins/manager/store"
 	"github.com/xquare-dashboard/pkg/services/datasources"
 )
 
-func ProvideService(pluginStore store.Service) *Provider {
+func ProvideService(pluginStore store.Service, dataSourceService datasources.DataSourceService) *Provider {
 	return &Provider{
-		pluginStore: pluginStore,
+		pluginStore:       pluginStore,
+		dataSourceService: dataSourceService,
 	}
 }
 
 type Provider struct {
-	pluginStore store.Service
+	pluginStore       store.Service
+	dataSourceService datasources.DataSourceService
 }
 
 // Get allows getting plugin context by its ID. If datasourceUID is not empty string
 // then PluginContext.DataSourceInstanceSettings will be resolved and appended to
 // returned context.
 // Note: identity.Requester can be nil.
-func (p *Provider) Get(ctx context.Context, pluginType datasources.DataSourceType, orgID int64) (backend.PluginContext, error) {
-	plugin, exists := p.pluginStore.Plugin(ctx, string(pluginType))
+func (p *Provider) Get(ctx context.Context, pluginID string, orgID int64) (backend.PluginContext, error) {
+	plugin, exists := p.pluginStore.Plugin(ctx, pluginID)
 	if !exists {
 		return backend.PluginContext{}, plugins.ErrPluginNotRegistered
 	}
@@ -37,21 +41,62 @@ func (p *Provider) Get(ctx context.Context, pluginType datasources.DataSourceTyp
 // GetWithDataSource allows getting plugin context by its ID and PluginContext.DataSourceInstanceSettings will be
 // resolved and appended to the returned context.
 // Note: *user.SignedInUser can be nil.
-func (p *Provider) GetWithDataSource(ctx context.Context, pluginType datasources.DataSourceType, ds *datasources.DataSource) (backend.PluginContext, error) {
-	plugin, exists := p.pluginStore.Plugin(ctx, string(pluginType))
+func (p *Provider) GetWithDataSource(ctx context.Context, pluginID string, ds *datasources.DataSource) (backend.PluginContext, error) {
+	plugin, exists := p.pluginStore.Plugin(ctx, pluginID)
 	if !exists {
 		return backend.PluginContext{}, plugins.ErrPluginNotRegistered
 	}
 	pCtx := backend.PluginContext{
 		PluginID: plugin.ID,
 	}
-	name := string(ds.Type)
-	pCtx.DataSourceInstanceSettings = &backend.DataSourceInstanceSettings{
-		Type:     name,
-		Name:     name,
-		URL:      ds.URL,
-		UID:      name,
-		JSONData: json.RawMessage("{}"),
+	datasourceSettings, err := ToDataSourceInstanceSettings(ds)
+	if err != nil {
+		return pCtx, err
 	}
+	pCtx.DataSourceInstanceSettings = datasourceSettings
 	return pCtx, nil
 }
+
+// GetWithDataSourceUID resolves the datasource by UID in the datasource registry
+// and returns the plugin context for it.
+func (p *Provider) GetWithDataSourceUID(ctx context.Context, uid string) (backend.PluginContext, *datasources.DataSource, error) {
+	ds, err := p.dataSourceService.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: uid})
+	if err != nil {
+		if errors.Is(err, datasources.ErrDataSourceNotFound) {
+			return backend.PluginContext{}, nil, datasources.ErrInvalidDatasourceID
+		}
+		return backend.PluginContext{}, nil, err
+	}
+	pCtx, err := p.GetWithDataSource(ctx, string(ds.Type), ds)
+	return pCtx, ds, err
+}
+
+// ToDataSourceInstanceSettings converts a datasource into the settings passed to the plugin.
+func ToDataSourceInstanceSettings(ds *datasources.DataSource) (*backend.DataSourceInstanceSettings, error) {
+	jsonDataBytes := json.RawMessage("{}")
+	if ds.JsonData != nil {
+		b, err := ds.JsonData.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		jsonDataBytes = b
+	}
+
+	decrypted := make(map[string]string, len(ds.SecureJsonData))
+	for k, v := range ds.SecureJsonData {
+		decrypted[k] = v
+	}
+
+	return &backend.DataSourceInstanceSettings{
+		Type:                    string(ds.Type),
+		ID:                      ds.ID,
+		Name:                    ds.Name,
+		URL:                     ds.URL,
+		UID:                     ds.UID,
+		BasicAuthEnabled:        ds.BasicAuth,
+		BasicAuthUser:           ds.BasicAuthUser,
+		JSONData:                jsonDataBytes,
+		DecryptedSecureJSONData: decrypted,
+		Updated:                 ds.Updated,
+	}, nil
+}
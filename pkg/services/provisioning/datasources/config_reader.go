@@ -0,0 +1,247 @@
+package datasources
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/util"
+)
+
+type configs struct {
+	APIVersion  int64                   `yaml:"apiVersion"`
+	Datasources []*dataSourceFromConfig `yaml:"datasources"`
+}
+
+type dataSourceFromConfig struct {
+	Name          string `yaml:"name"`
+	Type          string `yaml:"type"`
+	UID           string `yaml:"uid"`
+	URL           string `yaml:"url"`
+	BasicAuth     bool   `yaml:"basicAuth"`
+	BasicAuthUser string `yaml:"basicAuthUser"`
+	IsDefault     bool   `yaml:"isDefault"`
+
+	JSONData       map[string]any    `yaml:"jsonData"`
+	SecureJSONData map[string]string `yaml:"secureJsonData"`
+
+	Permissions []*permissionFromConfig `yaml:"permissions"`
+}
+
+type permissionFromConfig struct {
+	Role       string `yaml:"role"`
+	Team       string `yaml:"team"`
+	Permission string `yaml:"permission"`
+}
+
+type configReader struct {
+	log log.Logger
+}
+
+var invalidUIDChars = regexp.MustCompile(`[^a-zA-Z0-9\-_]+`)
+
+// readConfig reads the provisioning config at path, which is either a single
+// yaml file or a directory of yaml files.
+func (cr *configReader) readConfig(path string) ([]*datasources.DataSource, error) {
+	files, err := configFiles(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*datasources.DataSource
+	for _, file := range files {
+		dss, err := cr.parseDatasourceConfig(file)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, dss...)
+	}
+
+	if err := validateDataSources(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func configFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if ext == ".yaml" || ext == ".yml" {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+func (cr *configReader) parseDatasourceConfig(path string) ([]*datasources.DataSource, error) {
+	// nolint:gosec
+	// We can ignore the gosec G304 warning on this one because `path` comes from the provisioning configuration.
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg configs
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %q: %w", path, err)
+	}
+	if cfg.APIVersion > 1 {
+		return nil, fmt.Errorf("%q: unsupported apiVersion %d", path, cfg.APIVersion)
+	}
+
+	result := make([]*datasources.DataSource, 0, len(cfg.Datasources))
+	for _, dsCfg := range cfg.Datasources {
+		if dsCfg == nil {
+			continue
+		}
+		ds, err := dsCfg.toDataSource()
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", path, err)
+		}
+		result = append(result, ds)
+	}
+
+	cr.log.Debug("Read datasource provisioning file", "path", path, "datasources", len(result))
+	return result, nil
+}
+
+func (cfg *dataSourceFromConfig) toDataSource() (*datasources.DataSource, error) {
+	name := expandEnv(cfg.Name)
+	if name == "" {
+		return nil, datasources.ErrDataSourceNameInvalid.Errorf("datasource name is required")
+	}
+
+	dsType := datasources.DataSourceType(expandEnv(cfg.Type))
+	if !dsType.IsValid() {
+		return nil, fmt.Errorf("datasource %q: unsupported type %q", name, dsType)
+	}
+
+	uid := expandEnv(cfg.UID)
+	if uid == "" {
+		uid = strings.Trim(invalidUIDChars.ReplaceAllString(strings.ToLower(name), "-"), "-")
+	}
+	if !util.IsValidShortUID(uid) || util.IsShortUIDTooLong(uid) {
+		return nil, fmt.Errorf("datasource %q: invalid uid %q", name, uid)
+	}
+
+	secure := make(map[string]string, len(cfg.SecureJSONData))
+	for k, v := range cfg.SecureJSONData {
+		secure[k] = expandEnv(v)
+	}
+
+	jsonData := simplejson.New()
+	if cfg.JSONData != nil {
+		jsonData = simplejson.NewFromAny(expandEnvValue(cfg.JSONData))
+	}
+
+	var permissions []*datasources.DataSourcePermission
+	for _, p := range cfg.Permissions {
+		if p == nil {
+			continue
+		}
+		permissions = append(permissions, &datasources.DataSourcePermission{
+			Role:       authn.Role(expandEnv(p.Role)),
+			Team:       expandEnv(p.Team),
+			Permission: datasources.Permission(expandEnv(p.Permission)),
+		})
+	}
+	if err := datasources.ValidatePermissions(permissions); err != nil {
+		return nil, fmt.Errorf("datasource %q: %w", name, err)
+	}
+
+	return &datasources.DataSource{
+		UID:            uid,
+		Name:           name,
+		Type:           dsType,
+		URL:            expandEnv(cfg.URL),
+		BasicAuth:      cfg.BasicAuth,
+		BasicAuthUser:  expandEnv(cfg.BasicAuthUser),
+		IsDefault:      cfg.IsDefault,
+		JsonData:       jsonData,
+		SecureJsonData: secure,
+		Permissions:    permissions,
+		ReadOnly:       true,
+	}, nil
+}
+
+func validateDataSources(dss []*datasources.DataSource) error {
+	uids := make(map[string]string, len(dss))
+	names := make(map[string]bool, len(dss))
+	defaults := make(map[datasources.DataSourceType]string)
+	for _, ds := range dss {
+		if other, ok := uids[ds.UID]; ok {
+			return fmt.Errorf("datasources %q and %q: %w", other, ds.Name, datasources.ErrDataSourceUidExists)
+		}
+		if names[ds.Name] {
+			return fmt.Errorf("datasource %q: %w", ds.Name, datasources.ErrDataSourceNameExists)
+		}
+		if ds.IsDefault {
+			if other, ok := defaults[ds.Type]; ok {
+				return fmt.Errorf("datasources %q and %q are both marked as default for type %s", other, ds.Name, ds.Type)
+			}
+			defaults[ds.Type] = ds.Name
+		}
+		uids[ds.UID] = ds.Name
+		names[ds.Name] = true
+	}
+	return nil
+}
+
+// expandEnv replaces $VAR and ${VAR} with the value of the environment variable.
+// A literal $ is written as $$.
+func expandEnv(s string) string {
+	return os.Expand(s, func(key string) string {
+		if key == "$" {
+			return "$"
+		}
+		return os.Getenv(key)
+	})
+}
+
+func expandEnvValue(v any) any {
+	switch t := v.(type) {
+	case string:
+		return expandEnv(t)
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			out[k] = expandEnvValue(val)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, val := range t {
+			out[i] = expandEnvValue(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
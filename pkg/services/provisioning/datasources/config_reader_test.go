@@ -0,0 +1,116 @@
+package datasources
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+const (
+	allProperties = "testdata/all-properties"
+	multiple      = "testdata/multiple"
+	invalidType   = "testdata/invalid-type"
+	duplicateUID  = "testdata/duplicate-uid"
+	missing       = "testdata/does-not-exist"
+)
+
+func TestDatasourceAsConfig(t *testing.T) {
+	t.Run("can read all properties and expand env variables", func(t *testing.T) {
+		t.Setenv("TEST_LOKI_USER", "admin")
+		t.Setenv("TEST_LOKI_PASSWORD", "secret")
+
+		cr := &configReader{log: log.NewNopLogger()}
+		dss, err := cr.readConfig(allProperties)
+		require.NoError(t, err)
+		require.Len(t, dss, 1)
+
+		ds := dss[0]
+		require.Equal(t, "loki-prod", ds.UID)
+		require.Equal(t, "Loki Production", ds.Name)
+		require.Equal(t, datasources.LokiType, ds.Type)
+		require.Equal(t, "http://loki-prod:3100", ds.URL)
+		require.True(t, ds.BasicAuth)
+		require.Equal(t, "admin", ds.BasicAuthUser)
+		require.True(t, ds.IsDefault)
+		require.True(t, ds.ReadOnly)
+		require.Equal(t, 60, ds.JsonData.Get("timeout").MustInt())
+		require.Equal(t, "secret", ds.SecureJsonData["basicAuthPassword"])
+		require.Equal(t, []*datasources.DataSourcePermission{
+			{Team: "sre", Permission: datasources.PermissionExplore},
+			{Role: authn.RoleEditor, Permission: datasources.PermissionQuery},
+		}, ds.Permissions)
+	})
+
+	t.Run("reads every yaml file in a directory", func(t *testing.T) {
+		cr := &configReader{log: log.NewNopLogger()}
+		dss, err := cr.readConfig(multiple)
+		require.NoError(t, err)
+		require.Len(t, dss, 3)
+
+		byName := map[string]*datasources.DataSource{}
+		for _, ds := range dss {
+			byName[ds.Name] = ds
+		}
+		require.Equal(t, "loki-staging", byName["Loki Staging"].UID, "uid should be derived from the name")
+		require.Equal(t, "GET", byName["Prometheus"].JsonData.Get("httpMethod").MustString())
+	})
+
+	t.Run("reads a single file", func(t *testing.T) {
+		cr := &configReader{log: log.NewNopLogger()}
+		dss, err := cr.readConfig(multiple + "/prometheus.yml")
+		require.NoError(t, err)
+		require.Len(t, dss, 1)
+		require.Equal(t, "prom", dss[0].UID)
+	})
+
+	t.Run("rejects unsupported types", func(t *testing.T) {
+		cr := &configReader{log: log.NewNopLogger()}
+		_, err := cr.readConfig(invalidType)
+		require.ErrorContains(t, err, `unsupported type "graphite"`)
+	})
+
+	t.Run("rejects duplicate uids", func(t *testing.T) {
+		cr := &configReader{log: log.NewNopLogger()}
+		_, err := cr.readConfig(duplicateUID)
+		require.ErrorIs(t, err, datasources.ErrDataSourceUidExists)
+	})
+
+	t.Run("missing directory is not an error", func(t *testing.T) {
+		cr := &configReader{log: log.NewNopLogger()}
+		dss, err := cr.readConfig(missing)
+		require.NoError(t, err)
+		require.Empty(t, dss)
+	})
+}
+
+func TestProvision(t *testing.T) {
+	t.Run("falls back to legacy urls when nothing is provisioned", func(t *testing.T) {
+		store := &fakeStore{}
+		err := Provision(context.Background(), missing, LegacyURLs{Loki: "http://loki:3100", Prometheus: "http://prometheus:9090"}, store)
+		require.NoError(t, err)
+		require.Len(t, store.provisioned, 2)
+		require.Equal(t, "loki", store.provisioned[0].UID)
+		require.Equal(t, "prometheus", store.provisioned[1].UID)
+	})
+
+	t.Run("ignores legacy urls when datasources are provisioned", func(t *testing.T) {
+		store := &fakeStore{}
+		err := Provision(context.Background(), multiple, LegacyURLs{Loki: "http://loki:3100"}, store)
+		require.NoError(t, err)
+		require.Len(t, store.provisioned, 3)
+	})
+}
+
+type fakeStore struct {
+	provisioned []*datasources.DataSource
+}
+
+func (s *fakeStore) ProvisionDataSources(_ context.Context, dss []*datasources.DataSource) error {
+	s.provisioned = dss
+	return nil
+}
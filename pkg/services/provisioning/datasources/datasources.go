@@ -0,0 +1,90 @@
+package datasources
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+// Store is the subset of the datasource service the provisioner writes to.
+type Store interface {
+	ProvisionDataSources(ctx context.Context, dataSources []*datasources.DataSource) error
+}
+
+// LegacyURLs are the datasource URLs from the single-instance environment
+// variables. They are used when nothing has been provisioned.
+type LegacyURLs struct {
+	Loki       string
+	Prometheus string
+}
+
+// Provision scans a directory for provisioning config files
+// and replaces the provisioned datasources in the store with its content.
+func Provision(ctx context.Context, configDirectory string, legacy LegacyURLs, store Store) error {
+	dc := newDatasourceProvisioner(log.New("provisioning.datasources"), store)
+	return dc.applyChanges(ctx, configDirectory, legacy)
+}
+
+// DatasourceProvisioner is responsible for provisioning datasources based on
+// configuration read by the `configReader`
+type DatasourceProvisioner struct {
+	log         log.Logger
+	cfgProvider *configReader
+	store       Store
+}
+
+func newDatasourceProvisioner(log log.Logger, store Store) DatasourceProvisioner {
+	return DatasourceProvisioner{
+		log:         log,
+		cfgProvider: &configReader{log: log},
+		store:       store,
+	}
+}
+
+func (dc *DatasourceProvisioner) applyChanges(ctx context.Context, configPath string, legacy LegacyURLs) error {
+	dss, err := dc.cfgProvider.readConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("%v: %w", "failed to read datasource provisioning config", err)
+	}
+
+	if len(dss) == 0 {
+		dss = legacyDataSources(legacy)
+		if len(dss) > 0 {
+			dc.log.Info("No datasources provisioned, using LOKI_URL and PROMETHEUS_URL", "path", configPath)
+		}
+	}
+
+	return dc.store.ProvisionDataSources(ctx, dss)
+}
+
+// legacyDataSources keeps the uids "loki" and "prometheus" working for deployments
+// that still configure a single datasource per type through the environment.
+func legacyDataSources(legacy LegacyURLs) []*datasources.DataSource {
+	var dss []*datasources.DataSource
+	if legacy.Loki != "" {
+		dss = append(dss, &datasources.DataSource{
+			UID:       string(datasources.LokiType),
+			Name:      "Loki",
+			Type:      datasources.LokiType,
+			URL:       legacy.Loki,
+			IsDefault: true,
+			JsonData:  simplejson.New(),
+			ReadOnly:  true,
+		})
+	}
+	if legacy.Prometheus != "" {
+		dss = append(dss, &datasources.DataSource{
+			UID:       string(datasources.PrometheusType),
+			Name:      "Prometheus",
+			Type:      datasources.PrometheusType,
+			URL:       legacy.Prometheus,
+			IsDefault: true,
+			JsonData:  simplejson.New(),
+			ReadOnly:  true,
+		})
+	}
+	return dss
+}
@@ -0,0 +1,90 @@
+package provisioning
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	dsProvisioning "github.com/xquare-dashboard/pkg/services/provisioning/datasources"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+// ProvideService provisions datasources on creation, so they are available
+// before any other service starts handling requests.
+func ProvideService(cfg *setting.Cfg, dataSourceService datasources.DataSourceService) (*ProvisioningServiceImpl, error) {
+	s := &ProvisioningServiceImpl{
+		Cfg:                  cfg,
+		log:                  log.New("provisioning"),
+		dataSourceService:    dataSourceService,
+		provisionDatasources: dsProvisioning.Provision,
+		reloadDebounce:       time.Second,
+	}
+	if err := s.ProvisionDatasources(context.Background()); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+type ProvisioningService interface {
+	ProvisionDatasources(ctx context.Context) error
+}
+
+type ProvisioningServiceImpl struct {
+	Cfg                  *setting.Cfg
+	log                  log.Logger
+	dataSourceService    datasources.DataSourceService
+	provisionDatasources func(context.Context, string, dsProvisioning.LegacyURLs, dsProvisioning.Store) error
+	reloadDebounce       time.Duration
+}
+
+var _ ProvisioningService = (*ProvisioningServiceImpl)(nil)
+
+// Run re-provisions datasources whenever the provisioning directory changes or
+// the process receives SIGHUP. A config that fails to load leaves the
+// currently provisioned datasources in place.
+func (ps *ProvisioningServiceImpl) Run(ctx context.Context) error {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changes <-chan struct{}
+	watcher, err := newConfigWatcher(ps.datasourcePath(), ps.reloadDebounce, ps.log)
+	if err != nil {
+		ps.log.Warn("Not watching datasource provisioning for changes, send SIGHUP to reload", "path", ps.datasourcePath(), "error", err)
+	} else {
+		changes = watcher.C
+		go watcher.Run(ctx)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-hup:
+			ps.log.Info("Received SIGHUP, reloading datasources")
+			_ = ps.ProvisionDatasources(ctx)
+		case <-changes:
+			ps.log.Info("Datasource provisioning changed, reloading datasources")
+			_ = ps.ProvisionDatasources(ctx)
+		}
+	}
+}
+
+func (ps *ProvisioningServiceImpl) datasourcePath() string {
+	return filepath.Join(ps.Cfg.ProvisioningPath, "datasources")
+}
+
+func (ps *ProvisioningServiceImpl) ProvisionDatasources(ctx context.Context) error {
+	datasourcePath := ps.datasourcePath()
+	legacy := dsProvisioning.LegacyURLs{Loki: ps.Cfg.LokiURL, Prometheus: ps.Cfg.PrometheusURL}
+	if err := ps.provisionDatasources(ctx, datasourcePath, legacy, ps.dataSourceService); err != nil {
+		ps.log.Error("Failed to provision data sources", "error", err)
+		return err
+	}
+	return nil
+}
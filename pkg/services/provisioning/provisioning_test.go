@@ -0,0 +1,70 @@
+package provisioning
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	datasourceservice "github.com/xquare-dashboard/pkg/services/datasources/service"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+const lokiConfig = `apiVersion: 1
+datasources:
+  - name: Loki
+    uid: loki
+    type: loki
+    url: %s
+`
+
+func TestProvisioningServiceImpl_Run(t *testing.T) {
+	dir := t.TempDir()
+	dsDir := filepath.Join(dir, "datasources")
+	require.NoError(t, os.Mkdir(dsDir, 0o750))
+	writeConfig := func(content string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dsDir, "loki.yaml"), []byte(content), 0o600))
+	}
+	writeConfig(fmt.Sprintf(lokiConfig, "http://loki-a:3100"))
+
+	cfg := &setting.Cfg{ProvisioningPath: dir, DataPath: t.TempDir()}
+	dsService, err := datasourceservice.ProvideService(cfg)
+	require.NoError(t, err)
+	ps, err := ProvideService(cfg, dsService)
+	require.NoError(t, err)
+	ps.reloadDebounce = 10 * time.Millisecond
+
+	lokiURL := func() string {
+		ds, err := dsService.GetDataSource(context.Background(), &datasources.GetDataSourceQuery{UID: "loki"})
+		require.NoError(t, err)
+		return ds.URL
+	}
+	require.Equal(t, "http://loki-a:3100", lokiURL())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- ps.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	// Give the watcher a moment to start before changing files.
+	time.Sleep(50 * time.Millisecond)
+
+	t.Run("reloads when the config changes", func(t *testing.T) {
+		writeConfig(fmt.Sprintf(lokiConfig, "http://loki-b:3100"))
+		require.Eventually(t, func() bool { return lokiURL() == "http://loki-b:3100" }, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("keeps the previous datasources when the config is invalid", func(t *testing.T) {
+		writeConfig("datasources:\n  - name: Loki\n    type: graphite\n")
+		time.Sleep(200 * time.Millisecond)
+		require.Equal(t, "http://loki-b:3100", lokiURL())
+	})
+}
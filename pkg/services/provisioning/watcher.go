@@ -0,0 +1,93 @@
+package provisioning
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/fsnotify/fsnotify.v1"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+)
+
+// configWatcher notifies on C whenever the provisioning config at path changes.
+// Bursts of file events, like an editor saving a file or Kubernetes swapping a
+// ConfigMap symlink, are collapsed into a single notification.
+type configWatcher struct {
+	C <-chan struct{}
+
+	c        chan struct{}
+	path     string
+	debounce time.Duration
+	log      log.Logger
+	watcher  *fsnotify.Watcher
+}
+
+func newConfigWatcher(path string, debounce time.Duration, logger log.Logger) (*configWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	// Watch the directory rather than the file itself: files replaced by
+	// rename are otherwise lost after the first change.
+	dir := path
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+	if err := watcher.Add(dir); err != nil {
+		_ = watcher.Close()
+		return nil, err
+	}
+
+	c := make(chan struct{}, 1)
+	return &configWatcher{
+		C:        c,
+		c:        c,
+		path:     path,
+		debounce: debounce,
+		log:      logger,
+		watcher:  watcher,
+	}, nil
+}
+
+func (w *configWatcher) Run(ctx context.Context) {
+	defer func() {
+		if err := w.watcher.Close(); err != nil {
+			w.log.Warn("Failed to close provisioning watcher", "error", err)
+		}
+	}()
+
+	timer := time.NewTimer(w.debounce)
+	if !timer.Stop() {
+		<-timer.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			w.log.Debug("Provisioning config changed", "path", event.Name, "op", event.Op.String())
+			timer.Reset(w.debounce)
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.log.Warn("Provisioning watcher error", "path", w.path, "error", err)
+		case <-timer.C:
+			select {
+			case w.c <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+
+	"github.com/xquare-dashboard/pkg/services/contexthandler"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+// queryTimeoutKey is the jsonData key of the per datasource query timeout, it
+// replaces the server default. 0 disables the timeout for the datasource.
+const queryTimeoutKey = "queryTimeout"
+
+// queryGroups tracks the running requests by query group id, so that all
+// queries of a group can be cancelled at once.
+type queryGroups struct {
+	mu     sync.Mutex
+	groups map[queryGroup]map[*context.CancelCauseFunc]struct{}
+}
+
+// queryGroup identifies a query group, the same group id of different
+// requesters are different groups.
+type queryGroup struct {
+	owner string
+	id    string
+}
+
+type queryGroupKey struct{}
+
+// queryGroupID returns the query group id of the request of ctx.
+func queryGroupID(ctx context.Context) string {
+	if reqCtx := contexthandler.FromContext(ctx); reqCtx != nil && reqCtx.Req != nil {
+		return reqCtx.Req.Header.Get(HeaderQueryGroupID)
+	}
+	return ""
+}
+
+// track makes ctx cancellable by the query group id of its request and its
+// requester. The returned func must be called once the request is done.
+func (g *queryGroups) track(ctx context.Context, requester string) (context.Context, func()) {
+	group := queryGroup{owner: requester, id: queryGroupID(ctx)}
+	if group.id == "" || ctx.Value(queryGroupKey{}) != nil {
+		return ctx, func() {}
+	}
+
+	ctx, cancel := context.WithCancelCause(context.WithValue(ctx, queryGroupKey{}, group.id))
+	g.mu.Lock()
+	if g.groups[group] == nil {
+		g.groups[group] = map[*context.CancelCauseFunc]struct{}{}
+	}
+	g.groups[group][&cancel] = struct{}{}
+	g.mu.Unlock()
+
+	return ctx, func() {
+		g.mu.Lock()
+		delete(g.groups[group], &cancel)
+		if len(g.groups[group]) == 0 {
+			delete(g.groups, group)
+		}
+		g.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// cancel cancels the running requests of a query group and returns how many
+// there were.
+func (g *queryGroups) cancel(group queryGroup) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cancels := g.groups[group]
+	for cancel := range cancels {
+		(*cancel)(ErrQueryCancelled.Errorf("query group %s was cancelled", group.id))
+	}
+	delete(g.groups, group)
+	return len(cancels)
+}
+
+// CancelQueryGroup aborts the running queries of the requests with the given
+// X-Query-Group-Id made by the requester of ctx, the groups of others are not
+// found. The requests respond with the results of the queries that completed
+// and a cancellation error for the others.
+func (s *ServiceImpl) CancelQueryGroup(ctx context.Context, groupID string) error {
+	group := queryGroup{owner: s.requester(ctx), id: groupID}
+	if groupID == "" || s.queryGroups.cancel(group) == 0 {
+		return ErrQueryGroupNotFound.Errorf("no running queries in group %q", groupID)
+	}
+	s.log.Info("Cancelled query group", "queryGroupId", groupID, "requester", group.owner)
+	return nil
+}
+
+// parseTimeout parses the timeout of a request or a query, which is a
+// duration like 30s.
+func parseTimeout(timeout string) (time.Duration, error) {
+	if timeout == "" {
+		return 0, nil
+	}
+	d, err := gtime.ParseDuration(timeout)
+	if err != nil || d < 0 {
+		return 0, ErrInvalidTimeout.Errorf("invalid timeout %q", timeout)
+	}
+	return d, nil
+}
+
+// timeoutFor returns the timeout of the queries of a datasource: the shortest
+// of the timeouts of the request, of the queries and of the datasource, or the
+// server default when the datasource doesn't set one. 0 means no timeout.
+func (s *ServiceImpl) timeoutFor(ds *datasources.DataSource, requestTimeout time.Duration, queries []parsedQuery) time.Duration {
+	timeout := s.queryTimeout
+	if ds.JsonData != nil {
+		if raw, ok := ds.JsonData.CheckGet(queryTimeoutKey); ok {
+			if d, err := gtime.ParseDuration(raw.MustString()); err == nil {
+				timeout = d
+			} else {
+				s.log.Warn("Invalid datasource query timeout, using the default", "uid", ds.UID, "queryTimeout", raw.Interface(), "error", err)
+			}
+		}
+	}
+	shorten := func(d time.Duration) {
+		if d > 0 && (timeout <= 0 || d < timeout) {
+			timeout = d
+		}
+	}
+	shorten(requestTimeout)
+	for _, q := range queries {
+		shorten(q.timeout)
+	}
+	return timeout
+}
+
+// withContextErrors replaces the response of every query that failed or did
+// not complete because ctx timed out or was cancelled with the cause. The
+// responses of queries that completed are kept.
+func withContextErrors(ctx context.Context, resp *backend.QueryDataResponse, queries []parsedQuery) *backend.QueryDataResponse {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, context.DeadlineExceeded) {
+		cause = ErrQueryTimeout.Errorf("query timed out: %w", cause)
+	}
+	status := backend.Status(0)
+	if errors.Is(cause, ErrQueryTimeout) {
+		status = backend.StatusTimeout
+	}
+
+	if resp == nil {
+		resp = backend.NewQueryDataResponse()
+	}
+	for _, q := range queries {
+		if r, ok := resp.Responses[q.query.RefID]; ok && r.Error == nil {
+			continue
+		}
+		resp.Responses[q.query.RefID] = backend.DataResponse{Error: cause, Status: status}
+	}
+	return resp
+}
@@ -0,0 +1,241 @@
+package query
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/plugins"
+	"github.com/xquare-dashboard/pkg/services/admission"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/caching"
+	"github.com/xquare-dashboard/pkg/services/contexthandler/ctxkey"
+	contextmodel "github.com/xquare-dashboard/pkg/services/contexthandler/model"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	datasourcesservice "github.com/xquare-dashboard/pkg/services/datasources/service"
+	"github.com/xquare-dashboard/pkg/services/pluginsintegration/plugincontext"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/web"
+)
+
+// withRequest returns ctx with a request of identity, with the given query
+// group id when it isn't empty.
+func withRequest(ctx context.Context, identity *authn.Identity, groupID string) context.Context {
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/api/ds/query", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if groupID != "" {
+		req.Header.Set(HeaderQueryGroupID, groupID)
+	}
+	return ctxkey.Set(ctx, &contextmodel.ReqContext{
+		Context:  &web.Context{Req: req, Resp: web.NewResponseWriter(req.Method, httptest.NewRecorder())},
+		Identity: identity,
+		Logger:   log.New("test"),
+	})
+}
+
+var (
+	alice = &authn.Identity{Kind: authn.KindUser, ID: "alice", Role: authn.RoleViewer}
+	bob   = &authn.Identity{Kind: authn.KindUser, ID: "bob", Role: authn.RoleViewer}
+)
+
+func TestQueryGroups(t *testing.T) {
+	g := &queryGroups{groups: map[queryGroup]map[*context.CancelCauseFunc]struct{}{}}
+
+	t.Run("cancels the requests of the group", func(t *testing.T) {
+		ctx1, done1 := g.track(withRequest(context.Background(), alice, "group"), alice.String())
+		defer done1()
+		ctx2, done2 := g.track(withRequest(context.Background(), alice, "group"), alice.String())
+		defer done2()
+		other, doneOther := g.track(withRequest(context.Background(), alice, "other"), alice.String())
+		defer doneOther()
+
+		require.Equal(t, 2, g.cancel(queryGroup{owner: alice.String(), id: "group"}))
+		require.ErrorIs(t, context.Cause(ctx1), ErrQueryCancelled)
+		require.ErrorIs(t, context.Cause(ctx2), ErrQueryCancelled)
+		require.NoError(t, other.Err())
+		require.Zero(t, g.cancel(queryGroup{owner: alice.String(), id: "group"}))
+	})
+
+	t.Run("does not cancel the groups of other requesters", func(t *testing.T) {
+		ctx, done := g.track(withRequest(context.Background(), alice, "shared"), alice.String())
+		defer done()
+
+		require.Zero(t, g.cancel(queryGroup{owner: bob.String(), id: "shared"}))
+		require.NoError(t, ctx.Err())
+	})
+
+	t.Run("forgets finished requests", func(t *testing.T) {
+		ctx, done := g.track(withRequest(context.Background(), alice, "finished"), alice.String())
+		done()
+
+		require.Zero(t, g.cancel(queryGroup{owner: alice.String(), id: "finished"}))
+		require.NotErrorIs(t, context.Cause(ctx), ErrQueryCancelled)
+	})
+
+	t.Run("does not track requests without group", func(t *testing.T) {
+		ctx := withRequest(context.Background(), alice, "")
+		tracked, done := g.track(ctx, alice.String())
+		defer done()
+		require.Equal(t, ctx, tracked)
+	})
+
+	t.Run("tracks nested queries once", func(t *testing.T) {
+		ctx, done := g.track(withRequest(context.Background(), alice, "nested"), alice.String())
+		defer done()
+		nested, doneNested := g.track(ctx, alice.String())
+		defer doneNested()
+
+		require.Equal(t, ctx, nested)
+		require.Equal(t, 1, g.cancel(queryGroup{owner: alice.String(), id: "nested"}))
+	})
+}
+
+func TestTimeoutFor(t *testing.T) {
+	s := &ServiceImpl{log: log.New("test"), queryTimeout: time.Minute}
+	withTimeout := func(timeout string) *datasources.DataSource {
+		return &datasources.DataSource{UID: "ds", JsonData: simplejson.NewFromAny(map[string]any{queryTimeoutKey: timeout})}
+	}
+
+	tests := []struct {
+		name           string
+		ds             *datasources.DataSource
+		requestTimeout time.Duration
+		queries        []parsedQuery
+		want           time.Duration
+	}{
+		{name: "server default", ds: &datasources.DataSource{}, want: time.Minute},
+		{name: "datasource timeout", ds: withTimeout("10s"), want: 10 * time.Second},
+		{name: "datasource without timeout", ds: withTimeout("0s"), want: 0},
+		{name: "invalid datasource timeout", ds: withTimeout("soon"), want: time.Minute},
+		{name: "shorter request timeout", ds: withTimeout("10s"), requestTimeout: 5 * time.Second, want: 5 * time.Second},
+		{name: "longer request timeout", ds: withTimeout("10s"), requestTimeout: time.Hour, want: 10 * time.Second},
+		{name: "request timeout without datasource timeout", ds: withTimeout("0s"), requestTimeout: time.Hour, want: time.Hour},
+		{name: "shortest query timeout", ds: withTimeout("10s"), requestTimeout: 8 * time.Second, queries: []parsedQuery{{}, {timeout: 3 * time.Second}, {timeout: 5 * time.Second}}, want: 3 * time.Second},
+		{name: "longer query timeout", ds: withTimeout("10s"), queries: []parsedQuery{{timeout: time.Hour}}, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, s.timeoutFor(tt.ds, tt.requestTimeout, tt.queries))
+		})
+	}
+}
+
+func TestWithContextErrors(t *testing.T) {
+	queries := []parsedQuery{{query: backend.DataQuery{RefID: "A"}}, {query: backend.DataQuery{RefID: "B"}}, {query: backend.DataQuery{RefID: "C"}}}
+	partial := func() *backend.QueryDataResponse {
+		return &backend.QueryDataResponse{Responses: backend.Responses{
+			"A": {Frames: data.Frames{data.NewFrame("A")}},
+			"B": {Error: errors.New("context canceled")},
+		}}
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 0)
+		defer cancel()
+		<-ctx.Done()
+
+		resp := withContextErrors(ctx, partial(), queries)
+		require.NoError(t, resp.Responses["A"].Error)
+		require.Len(t, resp.Responses["A"].Frames, 1)
+		for _, refID := range []string{"B", "C"} {
+			require.ErrorIs(t, resp.Responses[refID].Error, ErrQueryTimeout)
+			require.Equal(t, backend.StatusTimeout, resp.Responses[refID].Status)
+		}
+	})
+
+	t.Run("cancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(ErrQueryCancelled.Errorf("cancelled"))
+
+		resp := withContextErrors(ctx, partial(), queries)
+		require.NoError(t, resp.Responses["A"].Error)
+		for _, refID := range []string{"B", "C"} {
+			require.ErrorIs(t, resp.Responses[refID].Error, ErrQueryCancelled)
+			require.Zero(t, resp.Responses[refID].Status)
+		}
+	})
+
+	t.Run("no response", func(t *testing.T) {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		cancel(ErrQueryCancelled.Errorf("cancelled"))
+
+		resp := withContextErrors(ctx, nil, queries)
+		require.Len(t, resp.Responses, 3)
+	})
+}
+
+func TestCancelQueryGroup(t *testing.T) {
+	cfg := &setting.Cfg{DataPath: t.TempDir(), QueryMaxConcurrent: 10, QueryMaxQueue: 10, QueryMaxQueuePerTenant: 10}
+	dataSources, err := datasourcesservice.ProvideService(cfg)
+	require.NoError(t, err)
+	_, err = dataSources.AddDataSource(context.Background(), &datasources.AddDataSourceCommand{UID: "loki", Name: "loki", Type: datasources.LokiType, URL: "http://loki:3100"})
+	require.NoError(t, err)
+	cachingService, err := caching.ProvideService(cfg, prometheus.NewRegistry())
+	require.NoError(t, err)
+
+	// A answers at once, B until the request is done.
+	started := make(chan struct{})
+	client := &blockingClient{started: started}
+	s := ProvideService(cfg, plugincontext.ProvideService(fakePluginStore{}, dataSources), client, dataSources, cachingService, admission.NewService(cfg))
+
+	reqDTO := dtos.MetricRequest{From: "now-1h", To: "now", Queries: []*simplejson.Json{
+		simplejson.NewFromAny(map[string]any{"refId": "A", "datasource": map[string]any{"uid": "loki"}}),
+		simplejson.NewFromAny(map[string]any{"refId": "B", "datasource": map[string]any{"uid": "loki"}}),
+	}}
+
+	type result struct {
+		resp *backend.QueryDataResponse
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		resp, err := s.QueryData(withRequest(context.Background(), alice, "dashboard"), reqDTO)
+		results <- result{resp, err}
+	}()
+	<-started
+
+	require.ErrorIs(t, s.CancelQueryGroup(withRequest(context.Background(), bob, ""), "dashboard"), ErrQueryGroupNotFound)
+	require.ErrorIs(t, s.CancelQueryGroup(withRequest(context.Background(), alice, ""), ""), ErrQueryGroupNotFound)
+	require.NoError(t, s.CancelQueryGroup(withRequest(context.Background(), alice, ""), "dashboard"))
+
+	res := <-results
+	require.NoError(t, res.err)
+	require.NoError(t, res.resp.Responses["A"].Error)
+	require.Len(t, res.resp.Responses["A"].Frames, 1)
+	require.ErrorIs(t, res.resp.Responses["B"].Error, ErrQueryCancelled)
+
+	require.ErrorIs(t, s.CancelQueryGroup(withRequest(context.Background(), alice, ""), "dashboard"), ErrQueryGroupNotFound)
+}
+
+type fakePluginStore struct{}
+
+func (fakePluginStore) Plugin(_ context.Context, id string) (*plugins.Plugin, bool) {
+	return &plugins.Plugin{ID: id}, true
+}
+
+// blockingClient answers the query A and waits for the request to be done
+// before returning, as plugins do when their upstream requests are aborted.
+type blockingClient struct {
+	plugins.Client
+	started chan struct{}
+}
+
+func (c *blockingClient) QueryData(ctx context.Context, _ *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+	close(c.started)
+	<-ctx.Done()
+	return &backend.QueryDataResponse{Responses: backend.Responses{
+		"A": {Frames: data.Frames{data.NewFrame("A")}},
+		"B": {Error: ctx.Err()},
+	}}, nil
+}
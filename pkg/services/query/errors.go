@@ -5,8 +5,14 @@ import (
 )
 
 var (
-	ErrNoQueriesFound        = errutil.BadRequest("query.noQueries", errutil.WithPublicMessage("No queries found")).Errorf("no queries found")
-	ErrMissingDataSourceInfo = errutil.BadRequest("query.missingDataSourceInfo").MustTemplate("query missing datasources info: {{ .Public.RefId }}", errutil.WithPublic("Query {{ .Public.RefId }} is missing datasources information"))
-	ErrQueryParamMismatch    = errutil.BadRequest("query.headerMismatch", errutil.WithPublicMessage("The request headers point to a different plugin than is defined in the request body")).Errorf("plugin header/body mismatch")
-	ErrDuplicateRefId        = errutil.BadRequest("query.duplicateRefId", errutil.WithPublicMessage("Multiple queries using the same RefId is not allowed ")).Errorf("multiple queries using the same RefId is not allowed")
+	ErrNoQueriesFound         = errutil.BadRequest("query.noQueries", errutil.WithPublicMessage("No queries found")).Errorf("no queries found")
+	ErrMissingDataSourceInfo  = errutil.BadRequest("query.missingDataSourceInfo").MustTemplate("query missing datasources info: {{ .Public.RefId }}", errutil.WithPublic("Query {{ .Public.RefId }} is missing datasources information"))
+	ErrQueryParamMismatch     = errutil.BadRequest("query.headerMismatch", errutil.WithPublicMessage("The request headers point to a different plugin than is defined in the request body")).Errorf("plugin header/body mismatch")
+	ErrDuplicateRefId         = errutil.BadRequest("query.duplicateRefId", errutil.WithPublicMessage("Multiple queries using the same RefId is not allowed ")).Errorf("multiple queries using the same RefId is not allowed")
+	ErrInvalidTimeout         = errutil.BadRequest("query.invalidTimeout", errutil.WithPublicMessage("Invalid query timeout."))
+	ErrInvalidQueryTimeOption = errutil.ValidationFailed("query.invalidTimeOption").MustTemplate("query {{ .Public.RefId }} has an invalid {{ .Public.Option }} {{ .Public.Value }}", errutil.WithPublic("Query {{ .Public.RefId }} has an invalid {{ .Public.Option }} \"{{ .Public.Value }}\""))
+	ErrInvalidTimeRange       = errutil.ValidationFailed("query.invalidTimeRange").MustTemplate("invalid time range: {{ .Public.Reason }}", errutil.WithPublic("Invalid time range: {{ .Public.Reason }}"))
+	ErrQueryTimeout           = errutil.Timeout("query.timeout", errutil.WithPublicMessage("The query timed out."))
+	ErrQueryCancelled         = errutil.ClientClosedRequest("query.cancelled", errutil.WithPublicMessage("The query was cancelled."))
+	ErrQueryGroupNotFound     = errutil.NotFound("query.groupNotFound", errutil.WithPublicMessage("No running queries with this query group id."))
 )
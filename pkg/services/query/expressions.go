@@ -0,0 +1,60 @@
+package query
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/expr"
+)
+
+type fromExpressionKey struct{}
+
+// isFromExpression reports whether the queries of ctx are inputs of expressions.
+func isFromExpression(ctx context.Context) bool {
+	v, _ := ctx.Value(fromExpressionKey{}).(bool)
+	return v
+}
+
+// handleExpressions runs the datasource queries of the request, then the
+// expressions on their results. Hidden queries are only inputs of the
+// expressions and are left out of the response.
+func (s *ServiceImpl) handleExpressions(ctx context.Context, reqDTO dtos.MetricRequest, parsedReq *parsedRequest) (*backend.QueryDataResponse, error) {
+	var dsQueries []*simplejson.Json
+	var dsRefIDs, hidden []string
+	var exprQueries []expr.Query
+	for uid, queries := range parsedReq.parsedQueries {
+		for _, pq := range queries {
+			if pq.rawQuery.Get("hide").MustBool() {
+				hidden = append(hidden, pq.query.RefID)
+			}
+			if expr.IsDataSource(uid) {
+				exprQueries = append(exprQueries, expr.Query{RefID: pq.query.RefID, JSON: pq.query.JSON, TimeRange: pq.query.TimeRange})
+				continue
+			}
+			dsQueries = append(dsQueries, pq.rawQuery)
+			dsRefIDs = append(dsRefIDs, pq.query.RefID)
+		}
+	}
+
+	pipeline, err := expr.BuildPipeline(exprQueries, dsRefIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := backend.NewQueryDataResponse()
+	if len(dsQueries) > 0 {
+		ctx = context.WithValue(ctx, fromExpressionKey{}, true)
+		if resp, err = s.QueryData(ctx, reqDTO.CloneWithQueries(dsQueries)); err != nil {
+			return nil, err
+		}
+	}
+
+	pipeline.Execute(resp.Responses)
+	for _, refID := range hidden {
+		delete(resp.Responses, refID)
+	}
+	return resp, nil
+}
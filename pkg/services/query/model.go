@@ -7,18 +7,38 @@ import (
 	"github.com/xquare-dashboard/pkg/services/contexthandler"
 	"github.com/xquare-dashboard/pkg/services/datasources"
 	"strings"
+	"time"
 )
 
 type parsedQuery struct {
 	query      backend.DataQuery
 	datasource *datasources.DataSource
 	rawQuery   *simplejson.Json
+	// timeShift moves the time range of the query back, its results are
+	// moved forward by as much.
+	timeShift time.Duration
+	// timeout is the timeout of the query, 0 if it doesn't set one. The
+	// queries of a datasource run with the shortest timeout among them.
+	timeout time.Duration
+}
+
+// timeShiftName is the timeShift of the query as it was given, e.g. 1w.
+func (pq parsedQuery) timeShiftName() string {
+	query, err := simplejson.NewJson(pq.query.JSON)
+	if err != nil {
+		return pq.timeShift.String()
+	}
+	return query.Get("timeShift").MustString(pq.timeShift.String())
 }
 
 type parsedRequest struct {
 	hasExpression bool
-	parsedQueries map[datasources.DataSourceType][]parsedQuery
-	dsTypes       map[datasources.DataSourceType]bool
+	parsedQueries map[string][]parsedQuery
+	dsTypes       map[string]bool
+	// timeout is the timeout of the request, 0 if it doesn't set one.
+	timeout time.Duration
+	// debug makes the datasources add a debug block to the query responses.
+	debug bool
 }
 
 func (pr parsedRequest) getFlattenedQueries() []parsedQuery {
@@ -68,7 +88,7 @@ func (pr parsedRequest) validateRequest(ctx context.Context) error {
 			return ErrQueryParamMismatch
 		}
 		for _, t := range vals {
-			if pr.parsedQueries[datasources.DataSourceType(t)] == nil {
+			if pr.parsedQueries[t] == nil {
 				return ErrQueryParamMismatch
 			}
 		}
@@ -81,7 +101,7 @@ func (pr parsedRequest) validateRequest(ctx context.Context) error {
 			return ErrQueryParamMismatch
 		}
 		for _, t := range vals {
-			if !pr.dsTypes[datasources.DataSourceType(t)] {
+			if !pr.dsTypes[t] {
 				return ErrQueryParamMismatch
 			}
 		}
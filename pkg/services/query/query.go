@@ -2,17 +2,27 @@ package query
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/xquare-dashboard/pkg/api/dtos"
 	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/expr"
 	"github.com/xquare-dashboard/pkg/infra/log"
 	"github.com/xquare-dashboard/pkg/plugins"
+	"github.com/xquare-dashboard/pkg/services/admission"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/caching"
 	"github.com/xquare-dashboard/pkg/services/contexthandler"
 	"github.com/xquare-dashboard/pkg/services/datasources"
 	"github.com/xquare-dashboard/pkg/services/pluginsintegration/plugincontext"
+	"github.com/xquare-dashboard/pkg/services/templating"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util/errutil"
 	"golang.org/x/sync/errgroup"
+	"net"
 	"net/http"
+	"net/netip"
 	"runtime"
 	"slices"
 	"time"
@@ -25,14 +35,23 @@ const (
 	HeaderPanelID        = "X-Panel-Id"           // mainly useful for debugging slow queries
 	HeaderQueryGroupID   = "X-Query-Group-Id"     // mainly useful for finding related queries with query chunking
 	HeaderFromExpression = "X-Grafana-From-Expr"  // used by datasources to identify expression queries
+	HeaderTenantID       = "X-Scope-OrgID"        // used for fair queuing, defaults to the identity or the client address
+	HeaderQueryDebug     = "X-Query-Debug"        // used by datasources to add debug information to query responses
 )
 
-func ProvideService(pCtxProvider *plugincontext.Provider, pluginClient plugins.Client) *ServiceImpl {
+func ProvideService(cfg *setting.Cfg, pCtxProvider *plugincontext.Provider, pluginClient plugins.Client, dataSourceService datasources.DataSourceService,
+	cachingService *caching.Service, admissionService *admission.Service) *ServiceImpl {
 	g := &ServiceImpl{
 		log:                  log.New("query_data"),
 		concurrentQueryLimit: runtime.NumCPU(),
 		pCtxProvider:         pCtxProvider,
 		pluginsClient:        pluginClient,
+		dataSourceService:    dataSourceService,
+		cachingService:       cachingService,
+		admissionService:     admissionService,
+		queryTimeout:         cfg.QueryTimeout,
+		trustedProxies:       cfg.QueryTrustedProxies,
+		queryGroups:          &queryGroups{groups: map[queryGroup]map[*context.CancelCauseFunc]struct{}{}},
 	}
 	g.log.Info("Query Service initialization")
 	return g
@@ -41,6 +60,8 @@ func ProvideService(pCtxProvider *plugincontext.Provider, pluginClient plugins.C
 type Service interface {
 	Run(ctx context.Context) error
 	QueryData(ctx context.Context, reqDTO dtos.MetricRequest) (*backend.QueryDataResponse, error)
+	CancelQueryGroup(ctx context.Context, groupID string) error
+	CheckQueryPermission(ctx context.Context, identity *authn.Identity, reqDTO dtos.MetricRequest) error
 }
 
 // Gives us compile time error if the service does not adhere to the contract of the interface
@@ -51,6 +72,12 @@ type ServiceImpl struct {
 	concurrentQueryLimit int
 	pCtxProvider         *plugincontext.Provider
 	pluginsClient        plugins.Client
+	dataSourceService    datasources.DataSourceService
+	cachingService       *caching.Service
+	admissionService     *admission.Service
+	queryTimeout         time.Duration
+	trustedProxies       []netip.Prefix
+	queryGroups          *queryGroups
 }
 
 // Run ServiceImpl.
@@ -61,12 +88,19 @@ func (s *ServiceImpl) Run(ctx context.Context) error {
 
 // QueryData processes queries and returns query responses. It handles queries to single or mixed datasources, as well as expressions.
 func (s *ServiceImpl) QueryData(ctx context.Context, reqDTO dtos.MetricRequest) (*backend.QueryDataResponse, error) {
+	ctx, done := s.queryGroups.track(ctx, s.requester(ctx))
+	defer done()
+
 	// Parse the request into parsed queries grouped by datasource uid
 	parsedReq, err := s.parseMetricRequest(ctx, reqDTO)
 	if err != nil {
 		return nil, err
 	}
 
+	if parsedReq.hasExpression {
+		return s.handleExpressions(ctx, reqDTO, parsedReq)
+	}
+
 	if len(parsedReq.parsedQueries) == 1 {
 		return s.handleQuerySingleDatasource(ctx, parsedReq)
 	}
@@ -83,12 +117,12 @@ func (s *ServiceImpl) handleQuerySingleDatasource(ctx context.Context, parsedReq
 
 	// ensure that each query passed to this function has the same datasource
 	for _, pq := range queries {
-		if ds.Type != pq.datasource.Type {
-			return nil, fmt.Errorf("all queries must have the same datasource - found %s and %s", ds.Type, pq.datasource.Type)
+		if ds.UID != pq.datasource.UID {
+			return nil, fmt.Errorf("all queries must have the same datasource - found %s and %s", ds.UID, pq.datasource.UID)
 		}
 	}
 
-	pCtx, err := s.pCtxProvider.GetWithDataSource(ctx, ds.Type, ds)
+	pCtx, err := s.pCtxProvider.GetWithDataSource(ctx, string(ds.Type), ds)
 	if err != nil {
 		return nil, err
 	}
@@ -97,12 +131,120 @@ func (s *ServiceImpl) handleQuerySingleDatasource(ctx context.Context, parsedReq
 		Headers:       map[string]string{},
 		Queries:       []backend.DataQuery{},
 	}
+	if isFromExpression(ctx) {
+		req.Headers[HeaderFromExpression] = "true"
+	}
+	if groupID := queryGroupID(ctx); groupID != "" {
+		req.SetHTTPHeader(HeaderQueryGroupID, groupID)
+	}
+	if parsedReq.debug {
+		req.SetHTTPHeader(HeaderQueryDebug, "true")
+	}
 
 	for _, q := range queries {
 		req.Queries = append(req.Queries, q.query)
 	}
 
-	return s.pluginsClient.QueryData(ctx, req)
+	if timeout := s.timeoutFor(ds, parsedReq.timeout, queries); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrQueryTimeout.Errorf("queries of datasource %s timed out after %s", ds.UID, timeout))
+		defer cancel()
+	}
+
+	reqCtx := contexthandler.FromContext(ctx)
+	// Debug responses describe the upstream requests, they are never cached.
+	skipCache := parsedReq.debug || skipsCache(ctx) || reqCtx != nil && reqCtx.Req.Header.Get(caching.HeaderCacheSkip) == "true"
+	resp, status, err := s.cachingService.QueryData(ctx, ds, req, skipCache, s.admittedQueryData(ds))
+	if reqCtx != nil {
+		mergeCacheStatus(reqCtx.Resp.Header(), status)
+	}
+	alignTimeShifted(resp, queries)
+	if ctx.Err() != nil {
+		// Keep the results of the queries that completed before the timeout or
+		// the cancellation.
+		return withContextErrors(ctx, resp, queries), nil
+	}
+	return resp, err
+}
+
+// admittedQueryData returns a caching.QueryFunc that waits for the admission
+// of the queries to ds before running them, cached queries are not queued.
+func (s *ServiceImpl) admittedQueryData(ds *datasources.DataSource) caching.QueryFunc {
+	return func(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
+		release, err := s.admissionService.Acquire(ctx, ds, s.tenantID(ctx))
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return s.pluginsClient.QueryData(ctx, req)
+	}
+}
+
+// tenantID returns the tenant of the request of ctx, queries of different
+// tenants are queued fairly. Authenticated requests are keyed on their
+// identity. Anonymous requests are keyed on the X-Scope-OrgID header when a
+// trusted proxy sets it, otherwise on their client address.
+func (s *ServiceImpl) tenantID(ctx context.Context) string {
+	reqCtx := contexthandler.FromContext(ctx)
+	if reqCtx != nil && reqCtx.Req != nil && !isAuthenticated(reqCtx.Identity) && s.fromTrustedProxy(reqCtx.Req) {
+		if tenant := reqCtx.Req.Header.Get(HeaderTenantID); tenant != "" {
+			return tenant
+		}
+	}
+	return s.requester(ctx)
+}
+
+// requester returns who made the request of ctx: the identity, or the client
+// address for anonymous requests.
+func (s *ServiceImpl) requester(ctx context.Context) string {
+	reqCtx := contexthandler.FromContext(ctx)
+	if reqCtx == nil || reqCtx.Req == nil {
+		return ""
+	}
+	if isAuthenticated(reqCtx.Identity) {
+		return reqCtx.Identity.String()
+	}
+	if s.fromTrustedProxy(reqCtx.Req) {
+		return reqCtx.RemoteAddr()
+	}
+	addr, _, err := net.SplitHostPort(reqCtx.Req.RemoteAddr)
+	if err != nil {
+		return reqCtx.Req.RemoteAddr
+	}
+	return addr
+}
+
+func isAuthenticated(identity *authn.Identity) bool {
+	return identity != nil && identity.Kind != authn.KindAnonymous
+}
+
+// fromTrustedProxy returns whether req was sent by one of the trusted proxies,
+// whose headers name the client.
+func (s *ServiceImpl) fromTrustedProxy(req *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+	for _, proxy := range s.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+type skipCacheKey struct{}
+
+// WithoutCache returns a context whose queries always run against the
+// datasources, for callers without request such as alert rule evaluation.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCacheKey{}, true)
+}
+
+func skipsCache(ctx context.Context) bool {
+	v, _ := ctx.Value(skipCacheKey{}).(bool)
+	return v
 }
 
 // splitResponse contains the results of a concurrent data source query - the response and any headers
@@ -113,7 +255,7 @@ type splitResponse struct {
 
 // executeConcurrentQueries executes queries to multiple datasources concurrently and returns the aggregate result.
 func (s *ServiceImpl) executeConcurrentQueries(
-	ctx context.Context, reqDTO dtos.MetricRequest, queriesbyDs map[datasources.DataSourceType][]parsedQuery,
+	ctx context.Context, reqDTO dtos.MetricRequest, queriesbyDs map[string][]parsedQuery,
 ) (*backend.QueryDataResponse, error) {
 	g, ctx := errgroup.WithContext(ctx)
 	g.SetLimit(s.concurrentQueryLimit) // prevent too many concurrent requests
@@ -175,6 +317,10 @@ func (s *ServiceImpl) executeConcurrentQueries(
 		}
 		if reqCtx != nil {
 			for k, v := range result.header {
+				if k == caching.HeaderCache {
+					mergeCacheStatus(reqCtx.Resp.Header(), caching.CacheStatus(result.header.Get(k)))
+					continue
+				}
 				for _, val := range v {
 					if !slices.Contains(reqCtx.Resp.Header().Values(k), val) {
 						reqCtx.Resp.Header().Add(k, val)
@@ -189,6 +335,12 @@ func (s *ServiceImpl) executeConcurrentQueries(
 	return resp, nil
 }
 
+// mergeCacheStatus merges status into the cache status of header, so a
+// response of several datasources carries a single cache status.
+func mergeCacheStatus(header http.Header, status caching.CacheStatus) {
+	header.Set(caching.HeaderCache, string(caching.CacheStatus(header.Get(caching.HeaderCache)).Merge(status)))
+}
+
 // buildErrorResponses applies the provided error to each query response in the list. These queries should all belong to the same datasource.
 func buildErrorResponses(err error, queries []*simplejson.Json) splitResponse {
 	er := backend.Responses{}
@@ -206,27 +358,50 @@ func (s *ServiceImpl) parseMetricRequest(ctx context.Context, reqDTO dtos.Metric
 		return nil, ErrNoQueriesFound
 	}
 
-	timeRange := newDataTimeRange(reqDTO.From, reqDTO.To)
+	timeout, err := parseTimeout(reqDTO.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	timeRange, err := NewDataTimeRange(reqDTO.From, reqDTO.To, reqDTO.Timezone, reqDTO.WeekStart, reqDTO.FiscalYearStartMonth)
+	if err != nil {
+		return nil, err
+	}
+	from, to, err := timeRange.Parse()
+	if err != nil {
+		return nil, err
+	}
+
 	req := &parsedRequest{
+		timeout:       timeout,
+		debug:         reqDTO.Debug,
 		hasExpression: false,
-		parsedQueries: make(map[datasources.DataSourceType][]parsedQuery),
-		dsTypes:       make(map[datasources.DataSourceType]bool),
+		parsedQueries: make(map[string][]parsedQuery),
+		dsTypes:       make(map[string]bool),
 	}
 
 	// Parse the queries and store them by datasource
-	datasources := map[datasources.DataSourceType]*datasources.DataSource{}
-	for _, query := range reqDTO.Queries {
-		ds, err := s.getDataSourceFromQuery(query)
+	for _, rawQuery := range reqDTO.Queries {
+		query, err := interpolateQuery(rawQuery, reqDTO.Variables)
 		if err != nil {
 			return nil, err
 		}
 
-		datasources[ds.Type] = ds
-		req.hasExpression = true
-		req.dsTypes[ds.Type] = true
+		ds, err := s.getDataSourceFromQuery(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkQueryPermission(ctx, ds); err != nil {
+			return nil, err
+		}
+
+		req.dsTypes[string(ds.Type)] = true
+		if expr.IsDataSource(ds.UID) {
+			req.hasExpression = true
+		}
 
-		if _, ok := req.parsedQueries[ds.Type]; !ok {
-			req.parsedQueries[ds.Type] = []parsedQuery{}
+		if _, ok := req.parsedQueries[ds.UID]; !ok {
+			req.parsedQueries[ds.UID] = []parsedQuery{}
 		}
 
 		s.log.Debug("Processing metrics query", "query", query)
@@ -236,12 +411,26 @@ func (s *ServiceImpl) parseMetricRequest(ctx context.Context, reqDTO dtos.Metric
 			return nil, err
 		}
 
-		req.parsedQueries[ds.Type] = append(req.parsedQueries[ds.Type], parsedQuery{
+		queryTimeout, err := parseTimeout(query.Get("timeout").MustString())
+		if err != nil {
+			return nil, err
+		}
+
+		// Expressions run on the range of the request, which the results of
+		// time shifted queries are aligned to.
+		queryFrom, queryTo, timeShift := from, to, time.Duration(0)
+		if !expr.IsDataSource(ds.UID) {
+			if queryFrom, queryTo, timeShift, err = queryTimeRange(query, timeRange, from, to); err != nil {
+				return nil, err
+			}
+		}
+
+		req.parsedQueries[ds.UID] = append(req.parsedQueries[ds.UID], parsedQuery{
 			datasource: ds,
 			query: backend.DataQuery{
 				TimeRange: backend.TimeRange{
-					From: timeRange.GetFromAsTimeUTC(),
-					To:   timeRange.GetToAsTimeUTC(),
+					From: queryFrom,
+					To:   queryTo,
 				},
 				RefID:         query.Get("refId").MustString("A"),
 				MaxDataPoints: query.Get("maxDataPoints").MustInt64(100),
@@ -249,22 +438,114 @@ func (s *ServiceImpl) parseMetricRequest(ctx context.Context, reqDTO dtos.Metric
 				QueryType:     query.Get("queryType").MustString(""),
 				JSON:          modelJSON,
 			},
-			rawQuery: query,
+			rawQuery:  rawQuery,
+			timeShift: timeShift,
+			timeout:   queryTimeout,
 		})
 	}
 
 	return req, req.validateRequest(ctx)
 }
 
-func newDataTimeRange(from, to string) DataTimeRange {
-	return DataTimeRange{
-		From: from,
-		To:   to,
-		Now:  time.Now(),
+// CheckQueryPermission returns datasources.ErrDataSourceAccessDenied when
+// identity may not query one of the datasources of reqDTO, for callers that
+// store queries to run them later without request, like alert and recording
+// rules. Otherwise it returns the first error resolving a datasource.
+func (s *ServiceImpl) CheckQueryPermission(ctx context.Context, identity *authn.Identity, reqDTO dtos.MetricRequest) error {
+	var resolveErr error
+	for _, rawQuery := range reqDTO.Queries {
+		query, err := interpolateQuery(rawQuery, reqDTO.Variables)
+		if err != nil {
+			return err
+		}
+		ds, err := s.getDataSourceFromQuery(ctx, query)
+		if err != nil {
+			if resolveErr == nil {
+				resolveErr = err
+			}
+			continue
+		}
+		if err := checkPermission(identity, ds); err != nil {
+			return err
+		}
+	}
+	return resolveErr
+}
+
+// checkQueryPermission returns datasources.ErrDataSourceAccessDenied when
+// the identity of the request of ctx may not query ds. Queries without
+// request, like the ones of alert and recording rules, are not checked here:
+// the identity saving a rule must be allowed to run its queries.
+func checkQueryPermission(ctx context.Context, ds *datasources.DataSource) error {
+	reqCtx := contexthandler.FromContext(ctx)
+	if reqCtx == nil {
+		return nil
+	}
+	return checkPermission(reqCtx.Identity, ds)
+}
+
+func checkPermission(identity *authn.Identity, ds *datasources.DataSource) error {
+	if expr.IsDataSource(ds.UID) {
+		return nil
+	}
+	return datasources.CheckPermission(identity, ds, datasources.PermissionQuery)
+}
+
+// interpolateQuery returns a copy of the query with the template variables
+// replaced, the raw query is kept as is so that it can be parsed again.
+func interpolateQuery(query *simplejson.Json, vars templating.Variables) (*simplejson.Json, error) {
+	if len(vars) == 0 {
+		return query, nil
+	}
+	interpolated := query.DeepCopy()
+	if err := vars.InterpolateJSON(interpolated, "refId"); err != nil {
+		return nil, err
+	}
+	return interpolated, nil
+}
+
+// getDataSourceFromQuery resolves the datasource of a query. The datasource is either
+// referenced as {"uid": "..."} or, for older clients, as a plain string holding the
+// uid, the name or the type of the datasource.
+func (s *ServiceImpl) getDataSourceFromQuery(ctx context.Context, query *simplejson.Json) (*datasources.DataSource, error) {
+	dsRef := query.Get("datasource")
+	if expr.IsDataSource(dsRef.Get("uid").MustString()) || expr.IsDataSource(dsRef.MustString()) {
+		return expr.DataSourceModel(), nil
 	}
+	if uid := dsRef.Get("uid").MustString(); uid != "" {
+		ds, err := s.dataSourceService.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: uid})
+		if err != nil {
+			return nil, toDataSourceError(err)
+		}
+		return ds, nil
+	}
+
+	ref := dsRef.MustString()
+	if ref == "" {
+		return nil, ErrMissingDataSourceInfo.Build(errutil.TemplateData{
+			Public: map[string]any{"RefId": query.Get("refId").MustString("A")},
+		})
+	}
+
+	if ds, err := s.dataSourceService.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: ref}); err == nil {
+		return ds, nil
+	}
+	if ds, err := s.dataSourceService.GetDataSource(ctx, &datasources.GetDataSourceQuery{Name: ref}); err == nil {
+		return ds, nil
+	}
+	if dsType := datasources.DataSourceType(ref); dsType.IsValid() {
+		ds, err := s.dataSourceService.GetDefaultDataSource(ctx, dsType)
+		if err != nil {
+			return nil, toDataSourceError(err)
+		}
+		return ds, nil
+	}
+	return nil, datasources.ErrInvalidDatasourceID
 }
 
-func (s *ServiceImpl) getDataSourceFromQuery(query *simplejson.Json) (*datasources.DataSource, error) {
-	ds := datasources.DataSourceType(query.Get("datasource").MustString())
-	return datasources.GetDataSource(ds)
+func toDataSourceError(err error) error {
+	if errors.Is(err, datasources.ErrDataSourceNotFound) {
+		return datasources.ErrInvalidDatasourceID
+	}
+	return err
 }
@@ -0,0 +1,105 @@
+package query
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/contexthandler"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	datasourcesservice "github.com/xquare-dashboard/pkg/services/datasources/service"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func TestTenantID(t *testing.T) {
+	s := &ServiceImpl{trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	anonymous := &authn.Identity{Kind: authn.KindAnonymous, ID: "anonymous", Role: authn.RoleViewer}
+	withTenant := func(identity *authn.Identity, remoteAddr, tenant string) context.Context {
+		ctx := withRequest(context.Background(), identity, "")
+		req := contexthandler.FromContext(ctx).Req
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", "192.168.0.1")
+		if tenant != "" {
+			req.Header.Set(HeaderTenantID, tenant)
+		}
+		return ctx
+	}
+
+	require.Equal(t, "", s.tenantID(context.Background()))
+	require.Equal(t, alice.String(), s.tenantID(withTenant(alice, "10.0.0.1:1234", "")))
+	require.Equal(t, alice.String(), s.tenantID(withTenant(alice, "10.0.0.1:1234", "team-a")))
+
+	t.Run("anonymous request from a trusted proxy", func(t *testing.T) {
+		require.Equal(t, "team-a", s.tenantID(withTenant(anonymous, "10.0.0.1:1234", "team-a")))
+		require.Equal(t, "192.168.0.1", s.tenantID(withTenant(anonymous, "10.0.0.1:1234", "")))
+	})
+
+	t.Run("anonymous request from another client", func(t *testing.T) {
+		require.Equal(t, "172.16.0.1", s.tenantID(withTenant(anonymous, "172.16.0.1:1234", "team-a")))
+		require.Equal(t, "172.16.0.1", s.tenantID(withTenant(anonymous, "172.16.0.1:1234", "")))
+	})
+}
+
+func TestCheckQueryPermission(t *testing.T) {
+	ctx := context.Background()
+	dataSources, err := datasourcesservice.ProvideService(&setting.Cfg{DataPath: t.TempDir()})
+	require.NoError(t, err)
+	_, err = dataSources.AddDataSource(ctx, &datasources.AddDataSourceCommand{Name: "Loki", Type: datasources.LokiType, URL: "http://loki:3100", UID: "loki"})
+	require.NoError(t, err)
+	_, err = dataSources.AddDataSource(ctx, &datasources.AddDataSourceCommand{
+		Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://prometheus:9090", UID: "prom",
+		Permissions: []*datasources.DataSourcePermission{{Team: "sre", Permission: datasources.PermissionQuery}},
+	})
+	require.NoError(t, err)
+	s := &ServiceImpl{dataSourceService: dataSources}
+	sre := &authn.Identity{Kind: authn.KindUser, ID: "sre", Role: authn.RoleViewer, Groups: []string{"sre"}}
+	request := func(uids ...string) dtos.MetricRequest {
+		req := dtos.MetricRequest{}
+		for _, uid := range uids {
+			req.Queries = append(req.Queries, simplejson.NewFromAny(map[string]any{"refId": uid, "datasource": map[string]any{"uid": uid}}))
+		}
+		return req
+	}
+
+	require.NoError(t, s.CheckQueryPermission(ctx, alice, request("loki", "__expr__")))
+	require.NoError(t, s.CheckQueryPermission(ctx, sre, request("loki", "prom")))
+	require.ErrorIs(t, s.CheckQueryPermission(ctx, alice, request("loki", "prom")), datasources.ErrDataSourceAccessDenied)
+	require.ErrorIs(t, s.CheckQueryPermission(ctx, nil, request("loki")), datasources.ErrDataSourceAccessDenied)
+
+	// Every datasource is checked, even after one that can't be resolved.
+	require.ErrorIs(t, s.CheckQueryPermission(ctx, alice, request("missing", "prom")), datasources.ErrDataSourceAccessDenied)
+	require.ErrorIs(t, s.CheckQueryPermission(ctx, alice, request("missing", "loki")), datasources.ErrInvalidDatasourceID)
+}
+
+func TestParseMetricRequest_QueryTimeout(t *testing.T) {
+	ctx := context.Background()
+	dataSources, err := datasourcesservice.ProvideService(&setting.Cfg{DataPath: t.TempDir()})
+	require.NoError(t, err)
+	_, err = dataSources.AddDataSource(ctx, &datasources.AddDataSourceCommand{Name: "Loki", Type: datasources.LokiType, URL: "http://loki:3100", UID: "loki"})
+	require.NoError(t, err)
+	s := &ServiceImpl{log: log.New("test"), dataSourceService: dataSources, queryTimeout: time.Minute}
+	request := func(timeout string) dtos.MetricRequest {
+		return dtos.MetricRequest{From: "now-1h", To: "now", Queries: []*simplejson.Json{
+			simplejson.NewFromAny(map[string]any{"refId": "A", "datasource": map[string]any{"uid": "loki"}}),
+			simplejson.NewFromAny(map[string]any{"refId": "B", "datasource": map[string]any{"uid": "loki"}, "timeout": timeout}),
+		}}
+	}
+
+	parsed, err := s.parseMetricRequest(ctx, request("2s"))
+	require.NoError(t, err)
+	queries := parsed.parsedQueries["loki"]
+	require.Len(t, queries, 2)
+	require.Zero(t, queries[0].timeout)
+	require.Equal(t, 2*time.Second, queries[1].timeout)
+	require.Equal(t, 2*time.Second, s.timeoutFor(queries[0].datasource, parsed.timeout, queries))
+
+	_, err = s.parseMetricRequest(ctx, request("soon"))
+	require.ErrorIs(t, err, ErrInvalidTimeout)
+}
@@ -0,0 +1,44 @@
+// Package querytest provides a fake query service for the tests of the
+// services that run queries.
+package querytest
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/query"
+)
+
+// FakeService records the requests of QueryData and answers them with Resp
+// and Err. Its other methods, except CheckQueryPermission, panic.
+type FakeService struct {
+	query.Service
+	Reqs []dtos.MetricRequest
+	Resp *backend.QueryDataResponse
+	Err  error
+	// DataSources are the datasources whose permissions are checked, by uid.
+	// Queries to other datasources are allowed.
+	DataSources map[string]*datasources.DataSource
+}
+
+var _ query.Service = (*FakeService)(nil)
+
+func (f *FakeService) QueryData(_ context.Context, req dtos.MetricRequest) (*backend.QueryDataResponse, error) {
+	f.Reqs = append(f.Reqs, req)
+	return f.Resp, f.Err
+}
+
+func (f *FakeService) CheckQueryPermission(_ context.Context, identity *authn.Identity, req dtos.MetricRequest) error {
+	for _, q := range req.Queries {
+		if ds, ok := f.DataSources[q.GetPath("datasource", "uid").MustString()]; ok {
+			if err := datasources.CheckPermission(identity, ds, datasources.PermissionQuery); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
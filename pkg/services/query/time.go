@@ -1,52 +1,138 @@
 package query
 
 import (
-	"github.com/timberio/go-datemath"
+	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/timberio/go-datemath"
+
+	"github.com/xquare-dashboard/pkg/util/errutil"
 )
 
 type DataTimeRange struct {
 	From string
 	To   string
 	Now  time.Time
+	// Location is the timezone in which now/d and the other roundings are
+	// applied, UTC when nil.
+	Location *time.Location
+	// WeekStart is the first day of the week for now/w, Monday when nil.
+	WeekStart *time.Weekday
+	// FiscalYearStartMonth is the first month of the fiscal year for now/fy
+	// and now/fQ, January when 0.
+	FiscalYearStartMonth time.Month
 }
 
-func (tr DataTimeRange) GetFromAsTimeUTC() time.Time {
-	return tr.MustGetFrom().UTC()
+// NewDataTimeRange returns the time range of a request. timezone is an IANA
+// name, "utc" or "browser", weekStart the name of a weekday and
+// fiscalYearStartMonth the first month of the fiscal year counted from 0
+// like the frontend does.
+func NewDataTimeRange(from, to, timezone, weekStart string, fiscalYearStartMonth int) (DataTimeRange, error) {
+	location, err := ParseTimezone(timezone)
+	if err != nil {
+		return DataTimeRange{}, err
+	}
+	weekday, err := ParseWeekStart(weekStart)
+	if err != nil {
+		return DataTimeRange{}, err
+	}
+	if fiscalYearStartMonth < 0 || fiscalYearStartMonth > 11 {
+		return DataTimeRange{}, invalidTimeRange(nil, "fiscal year start month %d is not between 0 and 11", fiscalYearStartMonth)
+	}
+	return DataTimeRange{
+		From:                 from,
+		To:                   to,
+		Now:                  time.Now(),
+		Location:             location,
+		WeekStart:            weekday,
+		FiscalYearStartMonth: time.Month(fiscalYearStartMonth + 1),
+	}, nil
 }
 
-func (tr DataTimeRange) GetToAsTimeUTC() time.Time {
-	return tr.MustGetTo().UTC()
+// ParseTimezone returns the location of an IANA timezone name. "", "utc" and
+// "browser" are UTC, the timezone of a browser is not known on the server.
+func ParseTimezone(timezone string) (*time.Location, error) {
+	switch strings.ToLower(timezone) {
+	case "", "utc", "browser":
+		return time.UTC, nil
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, invalidTimeRange(err, "unknown timezone %q", timezone)
+	}
+	return location, nil
 }
 
-func (tr DataTimeRange) MustGetFrom() time.Time {
-	res, err := tr.ParseFrom()
-	if err != nil {
-		return time.Unix(0, 0)
+// ParseWeekStart returns the weekday of its name, nil for "".
+func ParseWeekStart(weekStart string) (*time.Weekday, error) {
+	if weekStart == "" {
+		return nil, nil
+	}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(weekStart, d.String()) {
+			weekday := d
+			return &weekday, nil
+		}
 	}
-	return res
+	return nil, invalidTimeRange(nil, "unknown week start %q", weekStart)
 }
 
-func (tr DataTimeRange) MustGetTo() time.Time {
-	res, err := tr.ParseTo()
+// Parse returns the start and the end of the time range in UTC. It fails with
+// ErrInvalidTimeRange when either can't be parsed or the range is empty.
+func (tr DataTimeRange) Parse() (time.Time, time.Time, error) {
+	from, err := tr.ParseFrom()
+	if err != nil {
+		return time.Time{}, time.Time{}, invalidTimeRange(err, "invalid from %q", tr.From)
+	}
+	to, err := tr.ParseTo()
 	if err != nil {
-		return time.Unix(0, 0)
+		return time.Time{}, time.Time{}, invalidTimeRange(err, "invalid to %q", tr.To)
+	}
+	if !from.Before(to) {
+		return time.Time{}, time.Time{}, invalidTimeRange(nil, "from %q must be before to %q", tr.From, tr.To)
 	}
-	return res
+	return from.UTC(), to.UTC(), nil
 }
 
 func (tr DataTimeRange) ParseFrom() (time.Time, error) {
-	pt := newParsableTime(tr.From)
-	return pt.Parse()
+	return tr.parsableTime(tr.From, false).Parse()
 }
 
+// ParseTo parses the end of the range, roundings are up to the end of the
+// unit: to=now/d is the end of today.
 func (tr DataTimeRange) ParseTo() (time.Time, error) {
-	pt := newParsableTime(tr.To)
-	return pt.Parse()
+	return tr.parsableTime(tr.To, true).Parse()
+}
+
+func (tr DataTimeRange) parsableTime(t string, roundUp bool) parsableTime {
+	pt := newParsableTime(t)
+	if !tr.Now.IsZero() {
+		pt.now = tr.Now
+	}
+	pt.location = tr.Location
+	pt.weekstart = tr.WeekStart
+	if tr.FiscalYearStartMonth != 0 {
+		pt.fiscalStartMonth = &tr.FiscalYearStartMonth
+	}
+	pt.roundUp = roundUp
+	return pt
+}
+
+func invalidTimeRange(err error, format string, args ...any) error {
+	return ErrInvalidTimeRange.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+		Error:  err,
+	})
 }
 
 func (t parsableTime) Parse() (time.Time, error) {
+	if t.time == "" {
+		return time.Time{}, fmt.Errorf("empty time")
+	}
+
 	// Milliseconds since Unix epoch.
 	if val, err := strconv.ParseInt(t.time, 10, 64); err == nil {
 		return time.UnixMilli(val), nil
@@ -57,7 +143,12 @@ func (t parsableTime) Parse() (time.Time, error) {
 		return t.now.Add(diff), nil
 	}
 
-	// Advanced time string, mimics the frontend's datemath library.
+	// Relative to now, mimics the frontend's datemath library.
+	if strings.HasPrefix(t.time, "now") {
+		return t.parseNow()
+	}
+
+	// Absolute time string, optionally followed by ||math.
 	return datemath.ParseAndEvaluate(t.time, t.datemathOptions()...)
 }
 
@@ -74,6 +165,115 @@ func (t parsableTime) datemathOptions() []func(*datemath.Options) {
 	return options
 }
 
+// nowMathRegexp matches the operations after now: /d, -1h, +2fQ.
+var nowMathRegexp = regexp.MustCompile(`^(?:/(fy|fQ|[yQMwdhms])|([+-])(\d*)(fy|fQ|[yQMwdhms]))`)
+
+// parseNow evaluates now followed by additions and roundings in the location
+// of t. The datemath library rounds hours in UTC and has no fiscal units.
+func (t parsableTime) parseNow() (time.Time, error) {
+	location := time.UTC
+	if t.location != nil {
+		location = t.location
+	}
+	res := t.now.In(location)
+
+	rest := strings.TrimPrefix(t.time, "now")
+	for rest != "" {
+		m := nowMathRegexp.FindStringSubmatch(rest)
+		if m == nil {
+			return time.Time{}, fmt.Errorf("unexpected %q in %q", rest, t.time)
+		}
+		rest = rest[len(m[0]):]
+
+		if m[1] != "" {
+			res = t.startOf(res, m[1])
+			if t.roundUp {
+				res = t.add(res, m[1], 1).Add(-time.Millisecond)
+			}
+			continue
+		}
+
+		n := 1
+		if m[3] != "" {
+			var err error
+			if n, err = strconv.Atoi(m[3]); err != nil {
+				return time.Time{}, fmt.Errorf("invalid number %q in %q", m[3], t.time)
+			}
+		}
+		if m[2] == "-" {
+			n = -n
+		}
+		res = t.add(res, m[4], n)
+	}
+	return res, nil
+}
+
+func (t parsableTime) add(ts time.Time, unit string, n int) time.Time {
+	switch unit {
+	case "y", "fy":
+		return ts.AddDate(n, 0, 0)
+	case "Q", "fQ":
+		return ts.AddDate(0, 3*n, 0)
+	case "M":
+		return ts.AddDate(0, n, 0)
+	case "w":
+		return ts.AddDate(0, 0, 7*n)
+	case "d":
+		return ts.AddDate(0, 0, n)
+	case "h":
+		return ts.Add(time.Duration(n) * time.Hour)
+	case "m":
+		return ts.Add(time.Duration(n) * time.Minute)
+	default:
+		return ts.Add(time.Duration(n) * time.Second)
+	}
+}
+
+// startOf rounds ts down to the start of unit in its location.
+func (t parsableTime) startOf(ts time.Time, unit string) time.Time {
+	y, mo, d := ts.Date()
+	loc := ts.Location()
+	switch unit {
+	case "y":
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, loc)
+	case "Q":
+		return time.Date(y, mo-(mo-1)%3, 1, 0, 0, 0, 0, loc)
+	case "fy":
+		start := t.fiscalYearStartMonth()
+		if mo < start {
+			y--
+		}
+		return time.Date(y, start, 1, 0, 0, 0, 0, loc)
+	case "fQ":
+		offset := (int(mo) - int(t.fiscalYearStartMonth()) + 12) % 12
+		return time.Date(y, mo-time.Month(offset%3), 1, 0, 0, 0, 0, loc)
+	case "M":
+		return time.Date(y, mo, 1, 0, 0, 0, 0, loc)
+	case "w":
+		weekStart := time.Monday
+		if t.weekstart != nil {
+			weekStart = *t.weekstart
+		}
+		diff := (int(ts.Weekday()) - int(weekStart) + 7) % 7
+		return time.Date(y, mo, d-diff, 0, 0, 0, 0, loc)
+	case "d":
+		return time.Date(y, mo, d, 0, 0, 0, 0, loc)
+	case "h":
+		return time.Date(y, mo, d, ts.Hour(), 0, 0, 0, loc)
+	case "m":
+		return time.Date(y, mo, d, ts.Hour(), ts.Minute(), 0, 0, loc)
+	default:
+		return time.Date(y, mo, d, ts.Hour(), ts.Minute(), ts.Second(), 0, loc)
+	}
+}
+
+func (t parsableTime) fiscalYearStartMonth() time.Month {
+	if t.fiscalStartMonth != nil {
+		return *t.fiscalStartMonth
+	}
+	return time.January
+}
+
 type parsableTime struct {
 	time             string
 	now              time.Time
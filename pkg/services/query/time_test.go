@@ -0,0 +1,129 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+func TestDataTimeRangeParse(t *testing.T) {
+	// Wednesday 2024-05-15 02:30 in Seoul.
+	now := time.Date(2024, 5, 14, 17, 30, 0, 0, time.UTC)
+	seoul, err := time.LoadLocation("Asia/Seoul")
+	require.NoError(t, err)
+
+	cases := []struct {
+		name      string
+		from, to  string
+		timezone  string
+		weekStart string
+		fiscal    int
+		wantFrom  time.Time
+		wantTo    time.Time
+	}{
+		{
+			name: "relative",
+			from: "now-6h", to: "now",
+			wantFrom: now.Add(-6 * time.Hour), wantTo: now,
+		},
+		{
+			name: "duration and epoch",
+			from: "1715650200000", to: "1h",
+			wantFrom: time.UnixMilli(1715650200000).UTC(), wantTo: now.Add(-time.Hour),
+		},
+		{
+			name: "today in utc",
+			from: "now/d", to: "now/d",
+			wantFrom: time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2024, 5, 14, 23, 59, 59, int(999*time.Millisecond), time.UTC),
+		},
+		{
+			name: "today in seoul",
+			from: "now/d", to: "now/d", timezone: "Asia/Seoul",
+			wantFrom: time.Date(2024, 5, 15, 0, 0, 0, 0, seoul).UTC(),
+			wantTo:   time.Date(2024, 5, 15, 23, 59, 59, int(999*time.Millisecond), seoul).UTC(),
+		},
+		{
+			name: "yesterday in seoul",
+			from: "now-1d/d", to: "now-1d/d", timezone: "Asia/Seoul",
+			wantFrom: time.Date(2024, 5, 14, 0, 0, 0, 0, seoul).UTC(),
+			wantTo:   time.Date(2024, 5, 14, 23, 59, 59, int(999*time.Millisecond), seoul).UTC(),
+		},
+		{
+			name: "week starting on monday",
+			from: "now/w", to: "now",
+			wantFrom: time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC), wantTo: now,
+		},
+		{
+			name: "week starting on sunday",
+			from: "now/w", to: "now", weekStart: "sunday",
+			wantFrom: time.Date(2024, 5, 12, 0, 0, 0, 0, time.UTC), wantTo: now,
+		},
+		{
+			name: "fiscal year starting in april",
+			from: "now/fy", to: "now/fy", fiscal: 3,
+			wantFrom: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2025, 3, 31, 23, 59, 59, int(999*time.Millisecond), time.UTC),
+		},
+		{
+			name: "previous fiscal quarter",
+			from: "now-1fQ/fQ", to: "now-1fQ/fQ", fiscal: 1,
+			wantFrom: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+			wantTo:   time.Date(2024, 4, 30, 23, 59, 59, int(999*time.Millisecond), time.UTC),
+		},
+		{
+			name: "absolute",
+			from: "2024-05-01", to: "2024-05-02", timezone: "Asia/Seoul",
+			wantFrom: time.Date(2024, 5, 1, 0, 0, 0, 0, seoul).UTC(),
+			wantTo:   time.Date(2024, 5, 2, 0, 0, 0, 0, seoul).UTC(),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := NewDataTimeRange(tc.from, tc.to, tc.timezone, tc.weekStart, tc.fiscal)
+			require.NoError(t, err)
+			tr.Now = now
+
+			from, to, err := tr.Parse()
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantFrom, from)
+			assert.Equal(t, tc.wantTo, to)
+		})
+	}
+}
+
+func TestDataTimeRangeParseInvalid(t *testing.T) {
+	cases := []struct {
+		name               string
+		from, to, timezone string
+		weekStart          string
+		fiscal             int
+		wantPublicMessage  string
+	}{
+		{name: "invalid from", from: "yesterday", to: "now", wantPublicMessage: `Invalid time range: invalid from "yesterday"`},
+		{name: "invalid math", from: "now-1x", to: "now", wantPublicMessage: `Invalid time range: invalid from "now-1x"`},
+		{name: "missing to", from: "now-1h", to: "", wantPublicMessage: `Invalid time range: invalid to ""`},
+		{name: "from after to", from: "now", to: "now-1h", wantPublicMessage: `Invalid time range: from "now" must be before to "now-1h"`},
+		{name: "unknown timezone", from: "now-1h", to: "now", timezone: "Mars/Olympus", wantPublicMessage: `Invalid time range: unknown timezone "Mars/Olympus"`},
+		{name: "unknown week start", from: "now-1h", to: "now", weekStart: "someday", wantPublicMessage: `Invalid time range: unknown week start "someday"`},
+		{name: "fiscal month out of range", from: "now-1h", to: "now", fiscal: 12, wantPublicMessage: "Invalid time range: fiscal year start month 12 is not between 0 and 11"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr, err := NewDataTimeRange(tc.from, tc.to, tc.timezone, tc.weekStart, tc.fiscal)
+			if err == nil {
+				_, _, err = tr.Parse()
+			}
+			require.Error(t, err)
+
+			var gfErr errutil.Error
+			require.ErrorAs(t, err, &gfErr)
+			assert.Equal(t, errutil.StatusValidationFailed, gfErr.Reason.Status())
+			assert.Equal(t, tc.wantPublicMessage, gfErr.PublicMessage)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package query
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+// queryTimeRange returns the time range of a query: the range of the request,
+// replaced by the relativeTime of the query and moved back by its timeShift.
+// The shift is returned to align the results back onto the request range.
+func queryTimeRange(query *simplejson.Json, tr DataTimeRange, from, to time.Time) (time.Time, time.Time, time.Duration, error) {
+	refID := query.Get("refId").MustString("A")
+
+	if relativeTime := query.Get("relativeTime").MustString(); relativeTime != "" {
+		tr.From, tr.To = relativeTimeRange(relativeTime)
+		var err error
+		if from, to, err = tr.Parse(); err != nil {
+			return time.Time{}, time.Time{}, 0, invalidQueryTimeOption(err, refID, "relativeTime", relativeTime)
+		}
+	}
+
+	var shift time.Duration
+	if timeShift := query.Get("timeShift").MustString(); timeShift != "" {
+		var err error
+		if shift, err = gtime.ParseDuration(timeShift); err != nil || shift < 0 {
+			return time.Time{}, time.Time{}, 0, invalidQueryTimeOption(err, refID, "timeShift", timeShift)
+		}
+		from, to = from.Add(-shift), to.Add(-shift)
+	}
+	return from, to, shift, nil
+}
+
+// relativeTimeRange returns the range of a relative time: 1h is the last hour,
+// now/d is today and now-1d/d yesterday.
+func relativeTimeRange(relativeTime string) (string, string) {
+	if !strings.HasPrefix(relativeTime, "now") {
+		return "now-" + relativeTime, "now"
+	}
+	if strings.Contains(relativeTime, "/") {
+		return relativeTime, relativeTime
+	}
+	return relativeTime, "now"
+}
+
+func invalidQueryTimeOption(err error, refID, option, value string) error {
+	return ErrInvalidQueryTimeOption.Build(errutil.TemplateData{
+		Public: map[string]any{"RefId": refID, "Option": option, "Value": value},
+		Error:  err,
+	})
+}
+
+// alignTimeShifted moves the time series of the time shifted queries forward
+// onto the time range of the request, so that a panel can overlay them with
+// the queries that aren't shifted. Their series are named after the shift,
+// their labels are kept for expressions to pair them.
+func alignTimeShifted(resp *backend.QueryDataResponse, queries []parsedQuery) {
+	if resp == nil {
+		return
+	}
+	for _, pq := range queries {
+		if pq.timeShift == 0 {
+			continue
+		}
+		dr, ok := resp.Responses[pq.query.RefID]
+		if !ok {
+			continue
+		}
+		suffix := " (" + pq.timeShiftName() + " ago)"
+		for _, frame := range dr.Frames {
+			if frame.TimeSeriesSchema().Type == data.TimeSeriesTypeNot {
+				continue
+			}
+			shiftFrame(frame, pq.timeShift, suffix)
+		}
+	}
+}
+
+func shiftFrame(frame *data.Frame, shift time.Duration, suffix string) {
+	for _, field := range frame.Fields {
+		switch field.Type() {
+		case data.FieldTypeTime:
+			for i := 0; i < field.Len(); i++ {
+				field.Set(i, field.At(i).(time.Time).Add(shift))
+			}
+		case data.FieldTypeNullableTime:
+			for i := 0; i < field.Len(); i++ {
+				if t := field.At(i).(*time.Time); t != nil {
+					shifted := t.Add(shift)
+					field.Set(i, &shifted)
+				}
+			}
+		default:
+			if !field.Type().Numeric() {
+				continue
+			}
+			// The config may be shared with cached frames.
+			config := data.FieldConfig{}
+			if field.Config != nil {
+				config = *field.Config
+			}
+			config.DisplayNameFromDS = seriesName(frame, field) + suffix
+			field.Config = &config
+		}
+	}
+}
+
+// seriesName returns the name the frontend shows for a series: the name set
+// by the datasource, or the metric name followed by the labels.
+func seriesName(frame *data.Frame, field *data.Field) string {
+	if field.Config != nil && field.Config.DisplayNameFromDS != "" {
+		return field.Config.DisplayNameFromDS
+	}
+	if frame.Name != "" {
+		return frame.Name
+	}
+
+	name := field.Name
+	if n, ok := field.Labels["__name__"]; ok {
+		name = n
+	}
+	keys := make([]string, 0, len(field.Labels))
+	for k := range field.Labels {
+		if k != "__name__" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		return name
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + strconv.Quote(field.Labels[k])
+	}
+	return name + "{" + strings.Join(pairs, ", ") + "}"
+}
@@ -0,0 +1,134 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+func TestQueryTimeRange(t *testing.T) {
+	now := time.Date(2024, 5, 14, 17, 30, 0, 0, time.UTC)
+	tr, err := NewDataTimeRange("now-6h", "now", "", "", 0)
+	require.NoError(t, err)
+	tr.Now = now
+	from, to, err := tr.Parse()
+	require.NoError(t, err)
+
+	cases := []struct {
+		name      string
+		query     string
+		wantFrom  time.Time
+		wantTo    time.Time
+		wantShift time.Duration
+		wantErr   string
+	}{
+		{
+			name:     "request range",
+			query:    `{"refId": "A"}`,
+			wantFrom: from, wantTo: to,
+		},
+		{
+			name:     "time shift",
+			query:    `{"refId": "A", "timeShift": "1w"}`,
+			wantFrom: from.AddDate(0, 0, -7), wantTo: to.AddDate(0, 0, -7), wantShift: 7 * 24 * time.Hour,
+		},
+		{
+			name:     "relative time",
+			query:    `{"refId": "A", "relativeTime": "1h"}`,
+			wantFrom: now.Add(-time.Hour), wantTo: now,
+		},
+		{
+			name:     "relative time rounded to today",
+			query:    `{"refId": "A", "relativeTime": "now/d"}`,
+			wantFrom: time.Date(2024, 5, 14, 0, 0, 0, 0, time.UTC), wantTo: time.Date(2024, 5, 15, 0, 0, 0, 0, time.UTC).Add(-time.Millisecond),
+		},
+		{
+			name:     "relative time and time shift",
+			query:    `{"refId": "A", "relativeTime": "1h", "timeShift": "1d"}`,
+			wantFrom: now.Add(-25 * time.Hour), wantTo: now.Add(-24 * time.Hour), wantShift: 24 * time.Hour,
+		},
+		{
+			name:    "invalid time shift",
+			query:   `{"refId": "B", "timeShift": "last week"}`,
+			wantErr: `Query B has an invalid timeShift "last week"`,
+		},
+		{
+			name:    "invalid relative time",
+			query:   `{"refId": "B", "relativeTime": "now-"}`,
+			wantErr: `Query B has an invalid relativeTime "now-"`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, err := simplejson.NewJson([]byte(tc.query))
+			require.NoError(t, err)
+
+			gotFrom, gotTo, shift, err := queryTimeRange(query, tr, from, to)
+			if tc.wantErr != "" {
+				var gfErr errutil.Error
+				require.ErrorAs(t, err, &gfErr)
+				assert.Equal(t, "query.invalidTimeOption", gfErr.MessageID)
+				assert.Equal(t, tc.wantErr, gfErr.PublicMessage)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantFrom, gotFrom)
+			assert.Equal(t, tc.wantTo, gotTo)
+			assert.Equal(t, tc.wantShift, shift)
+		})
+	}
+}
+
+func TestAlignTimeShifted(t *testing.T) {
+	start := time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC)
+	week := 7 * 24 * time.Hour
+
+	// Prometheus range result without legend.
+	prom := data.NewFrame("",
+		data.NewField("Time", nil, []time.Time{start, start.Add(time.Minute)}),
+		data.NewField("Value", data.Labels{"__name__": "up", "job": "api"}, []float64{1, 0}),
+	)
+	// Loki metric result, named by the datasource.
+	loki := data.NewFrame("",
+		data.NewField("Time", nil, []time.Time{start}),
+		data.NewField("Value", data.Labels{"app": "api"}, []float64{3}).SetConfig(&data.FieldConfig{DisplayNameFromDS: `{app="api"}`}),
+	)
+	// Logs are not time series, they stay where they are.
+	logs := data.NewFrame("",
+		data.NewField("timestamp", nil, []time.Time{start}),
+		data.NewField("body", nil, []string{"line"}),
+	)
+	resp := backend.NewQueryDataResponse()
+	resp.Responses["A"] = backend.DataResponse{Frames: data.Frames{prom}}
+	resp.Responses["B"] = backend.DataResponse{Frames: data.Frames{loki, logs}}
+	resp.Responses["C"] = backend.DataResponse{Frames: data.Frames{data.NewFrame("",
+		data.NewField("Time", nil, []time.Time{start}),
+		data.NewField("Value", nil, []float64{1}),
+	)}}
+
+	queries := []parsedQuery{
+		{query: backend.DataQuery{RefID: "A", JSON: []byte(`{"timeShift": "1w"}`)}, timeShift: week},
+		{query: backend.DataQuery{RefID: "B", JSON: []byte(`{"timeShift": "7d"}`)}, timeShift: week},
+		{query: backend.DataQuery{RefID: "C", JSON: []byte(`{}`)}},
+	}
+	alignTimeShifted(resp, queries)
+
+	assert.Equal(t, start.Add(week), prom.Fields[0].At(0))
+	assert.Equal(t, start.Add(week+time.Minute), prom.Fields[0].At(1))
+	assert.Equal(t, `up{job="api"} (1w ago)`, prom.Fields[1].Config.DisplayNameFromDS)
+	assert.Equal(t, data.Labels{"__name__": "up", "job": "api"}, prom.Fields[1].Labels)
+
+	assert.Equal(t, start.Add(week), loki.Fields[0].At(0))
+	assert.Equal(t, `{app="api"} (7d ago)`, loki.Fields[1].Config.DisplayNameFromDS)
+
+	assert.Equal(t, start, logs.Fields[0].At(0))
+	assert.Equal(t, start, resp.Responses["C"].Frames[0].Fields[0].At(0))
+	assert.Nil(t, resp.Responses["C"].Frames[0].Fields[1].Config)
+}
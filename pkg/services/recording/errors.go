@@ -0,0 +1,16 @@
+package recording
+
+import (
+	"errors"
+
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+var (
+	ErrRuleNotFound                = errors.New("recording rule not found")
+	ErrRuleUidExists               = errors.New("recording rule with the same uid already exists")
+	ErrRuleVersionMismatch         = errors.New("the recording rule has been changed by someone else")
+	ErrRuleFailedGenerateUniqueUid = errors.New("failed to generate unique recording rule ID")
+	ErrRuleUIDInvalid              = errutil.ValidationFailed("recording.ruleUidInvalid", errutil.WithPublicMessage("Invalid recording rule uid."))
+	ErrRuleInvalid                 = errutil.ValidationFailed("recording.ruleInvalid").MustTemplate("invalid recording rule: {{ .Public.Reason }}", errutil.WithPublic("Invalid recording rule: {{ .Public.Reason }}"))
+)
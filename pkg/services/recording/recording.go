@@ -0,0 +1,28 @@
+package recording
+
+import (
+	"context"
+)
+
+// RecordingService interface for interacting with recording rules and their
+// status.
+type RecordingService interface {
+	// GetRule gets a recording rule.
+	GetRule(ctx context.Context, query *GetRuleQuery) (*Rule, error)
+
+	// GetRules gets recording rules sorted by metric.
+	GetRules(ctx context.Context, query *GetRulesQuery) ([]*Rule, error)
+
+	// AddRule adds a new recording rule.
+	AddRule(ctx context.Context, cmd *AddRuleCommand) (*Rule, error)
+
+	// UpdateRule updates an existing recording rule.
+	UpdateRule(ctx context.Context, cmd *UpdateRuleCommand) (*Rule, error)
+
+	// DeleteRule deletes a recording rule and its status.
+	DeleteRule(ctx context.Context, cmd *DeleteRuleCommand) error
+
+	// GetStatuses gets the last evaluation of the recording rules sorted by
+	// metric.
+	GetStatuses(ctx context.Context, query *GetStatusesQuery) ([]*RuleStatus, error)
+}
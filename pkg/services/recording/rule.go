@@ -0,0 +1,115 @@
+package recording
+
+import (
+	"time"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+// Rule is a recording rule. Its query is evaluated at every interval as an
+// instant query, every series of the result is written to the remote write
+// endpoint as a sample of Metric.
+type Rule struct {
+	UID string `json:"uid"`
+	// Metric is the name of the written series, e.g. app:errors:rate5m. It
+	// replaces the name of the series of the result.
+	Metric string `json:"metric"`
+	// DatasourceUID is the Prometheus or Loki datasource Expr is run against.
+	DatasourceUID string `json:"datasourceUid"`
+	// Expr is a PromQL or LogQL metric query.
+	Expr string `json:"expr"`
+	// Interval is how often the rule is evaluated, e.g. 1m.
+	Interval string `json:"interval"`
+	// Labels are added to the labels of every written series, replacing the
+	// labels of the result with the same name.
+	Labels map[string]string `json:"labels"`
+	// IsPaused stops the evaluation of the rule.
+	IsPaused bool `json:"isPaused"`
+	Version  int  `json:"version"`
+
+	Created time.Time `json:"created,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+func (r *Rule) GetUID() string      { return r.UID }
+func (r *Rule) GetInterval() string { return r.Interval }
+func (r *Rule) GetIsPaused() bool   { return r.IsPaused }
+
+// Health of the last evaluation of a rule.
+const (
+	HealthUnknown = "unknown"
+	HealthOk      = "ok"
+	HealthError   = "error"
+)
+
+// RuleStatus is the result of the last evaluation of a rule.
+type RuleStatus struct {
+	UID       string `json:"uid"`
+	Metric    string `json:"metric"`
+	Health    string `json:"health"`
+	LastError string `json:"lastError,omitempty"`
+	// Samples is how many series the last evaluation wrote.
+	Samples int `json:"samples"`
+	// EvaluationTime is how long the last evaluation took, in seconds.
+	EvaluationTime float64   `json:"evaluationTime"`
+	LastEvaluation time.Time `json:"lastEvaluation,omitempty"`
+}
+
+type GetRuleQuery struct {
+	UID string
+	// Identity must be allowed to query the datasource of the rule.
+	Identity *authn.Identity
+}
+
+type GetRulesQuery struct {
+	// DatasourceUID limits the result to the rules of a datasource, when set.
+	DatasourceUID string
+	// Identity limits the result to the rules whose datasource it may query.
+	Identity *authn.Identity
+}
+
+type GetStatusesQuery struct {
+	// RuleUID limits the result to a rule, when set.
+	RuleUID string
+	// Identity limits the result to the rules whose datasource it may query.
+	Identity *authn.Identity
+}
+
+// AddRuleCommand creates a rule. A UID is generated when none is given.
+type AddRuleCommand struct {
+	UID           string            `json:"uid"`
+	Metric        string            `json:"metric"`
+	DatasourceUID string            `json:"datasourceUid"`
+	Expr          string            `json:"expr"`
+	Interval      string            `json:"interval"`
+	Labels        map[string]string `json:"labels"`
+	IsPaused      bool              `json:"isPaused"`
+
+	// Identity must be allowed to query the datasource of the rule.
+	Identity *authn.Identity `json:"-"`
+}
+
+// UpdateRuleCommand replaces the rule with the given UID.
+type UpdateRuleCommand struct {
+	Metric        string            `json:"metric"`
+	DatasourceUID string            `json:"datasourceUid"`
+	Expr          string            `json:"expr"`
+	Interval      string            `json:"interval"`
+	Labels        map[string]string `json:"labels"`
+	IsPaused      bool              `json:"isPaused"`
+
+	// Version is the version the update is based on. When set, the update is
+	// rejected with ErrRuleVersionMismatch if the rule changed since.
+	Version int `json:"version"`
+
+	UID string `json:"-"`
+	// Identity must be allowed to query the datasource of the rule, before
+	// and after the update.
+	Identity *authn.Identity `json:"-"`
+}
+
+type DeleteRuleCommand struct {
+	UID string
+	// Identity must be allowed to query the datasource of the rule.
+	Identity *authn.Identity
+}
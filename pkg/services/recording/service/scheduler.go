@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/xquare-dashboard/pkg/api/dtos"
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/expr"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/recording"
+)
+
+// refID is the refId of the query of a rule.
+const refID = "A"
+
+// Run evaluates the recording rules until ctx is done.
+func (s *Service) Run(ctx context.Context) error {
+	if s.cfg.RecordingRemoteWriteURL == "" {
+		s.log.Info("No remote write url configured, recording rules are not evaluated")
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.scheduler.Run(ctx, s.listRules, s.evaluate)
+}
+
+func (s *Service) listRules() []*recording.Rule {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.store.List()
+}
+
+// evaluate runs the query of rule at now and writes its series.
+func (s *Service) evaluate(ctx context.Context, rule *recording.Rule, now time.Time) {
+	start := time.Now()
+	if s.cfg.RecordingEvaluationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.RecordingEvaluationTimeout)
+		defer cancel()
+	}
+
+	samples, err := s.querySeries(ctx, rule, now)
+	if err == nil && len(samples) > 0 {
+		err = s.write(ctx, samples, now)
+	}
+	if err != nil {
+		s.log.Warn("Failed to evaluate recording rule", "uid", rule.UID, "metric", rule.Metric, "error", err)
+	}
+
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	st, ok := s.statuses[rule.UID]
+	if !ok {
+		// The rule was deleted during the evaluation.
+		return
+	}
+	st.health, st.lastError, st.samples = recording.HealthOk, "", len(samples)
+	if err != nil {
+		st.health, st.lastError, st.samples = recording.HealthError, err.Error(), 0
+	}
+	st.lastEvaluation = now
+	st.evaluationTime = time.Since(start)
+}
+
+// series is a series of a rule with its value at an evaluation.
+type series struct {
+	labels data.Labels
+	value  float64
+}
+
+// querySeries runs the query of rule as an instant query at now and returns
+// its series renamed to the metric of the rule. Series without value are
+// left out.
+func (s *Service) querySeries(ctx context.Context, rule *recording.Rule, now time.Time) ([]series, error) {
+	interval, err := gtime.ParseDuration(rule.Interval)
+	if err != nil {
+		return nil, err
+	}
+	q := simplejson.New()
+	q.Set("refId", refID)
+	q.Set("datasource", map[string]any{"uid": rule.DatasourceUID})
+	q.Set("expr", rule.Expr)
+	// Prometheus reads instant and range, Loki queryType.
+	q.Set("instant", true)
+	q.Set("range", false)
+	q.Set("queryType", "instant")
+	req := dtos.MetricRequest{
+		From:    strconv.FormatInt(now.Add(-interval).UnixMilli(), 10),
+		To:      strconv.FormatInt(now.UnixMilli(), 10),
+		Queries: []*simplejson.Json{q},
+	}
+
+	resp, err := s.queryService.QueryData(query.WithoutCache(ctx), req)
+	if err != nil {
+		return nil, err
+	}
+	dr, ok := resp.Responses[refID]
+	if !ok {
+		return nil, fmt.Errorf("query returned no result")
+	}
+	if dr.Error != nil {
+		return nil, dr.Error
+	}
+
+	var result []series
+	seen := map[string]bool{}
+	for _, v := range expr.FromFrames(dr.Frames).Values {
+		value, ok := expr.LastValue(v)
+		if !ok {
+			continue
+		}
+		labels := make(data.Labels, len(v.GetLabels())+len(rule.Labels)+1)
+		for k, v := range v.GetLabels() {
+			labels[k] = v
+		}
+		for k, v := range rule.Labels {
+			labels[k] = v
+		}
+		labels[metricNameLabel] = rule.Metric
+		key := labels.String()
+		if seen[key] {
+			return nil, fmt.Errorf("the result has several series with the labels %s after applying the rule labels", key)
+		}
+		seen[key] = true
+		result = append(result, series{labels: labels, value: value})
+	}
+	return result, nil
+}
@@ -0,0 +1,287 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/recording"
+	"github.com/xquare-dashboard/pkg/services/rules"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+const defaultInterval = "1m"
+
+var (
+	metricNameRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Service stores recording rules in the data directory, evaluates them in the
+// background with the query service and writes their results to a Prometheus
+// remote write endpoint.
+type Service struct {
+	cfg               *setting.Cfg
+	log               log.Logger
+	scheduler         *rules.Scheduler[*recording.Rule]
+	client            *http.Client
+	queryService      query.Service
+	dataSourceService datasources.DataSourceService
+
+	mu    sync.RWMutex
+	store *rules.Store[*recording.Rule]
+
+	// statuses has the status of every stored rule.
+	statusMu sync.Mutex
+	statuses map[string]*ruleStatus
+}
+
+var _ recording.RecordingService = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg, queryService query.Service, dataSourceService datasources.DataSourceService,
+	httpClientProvider httpclient.Provider) (*Service, error) {
+	client, err := httpClientProvider.New()
+	if err != nil {
+		return nil, err
+	}
+	store, err := rules.NewStore(filepath.Join(cfg.DataPath, "recording", "rules.json"), "recording rules", lessRule)
+	if err != nil {
+		return nil, err
+	}
+	logger := log.New("recording")
+	s := &Service{
+		cfg:               cfg,
+		log:               logger,
+		scheduler:         rules.NewScheduler[*recording.Rule](logger, cfg.RecordingMinInterval),
+		client:            client,
+		queryService:      queryService,
+		dataSourceService: dataSourceService,
+		store:             store,
+		statuses:          map[string]*ruleStatus{},
+	}
+	for _, rule := range store.List() {
+		s.statuses[rule.UID] = &ruleStatus{}
+	}
+	return s, nil
+}
+
+func (s *Service) GetRule(ctx context.Context, query *recording.GetRuleQuery) (*recording.Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rule, ok := s.store.Get(query.UID)
+	if !ok {
+		return nil, recording.ErrRuleNotFound
+	}
+	if err := s.checkPermission(ctx, query.Identity, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *Service) GetRules(ctx context.Context, query *recording.GetRulesQuery) ([]*recording.Rule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	all := s.store.List()
+	result := make([]*recording.Rule, 0, len(all))
+	for _, rule := range all {
+		if query.DatasourceUID != "" && rule.DatasourceUID != query.DatasourceUID {
+			continue
+		}
+		if err := s.checkPermission(ctx, query.Identity, rule); err != nil {
+			if errors.Is(err, datasources.ErrDataSourceAccessDenied) {
+				continue
+			}
+			return nil, err
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+func (s *Service) AddRule(ctx context.Context, cmd *recording.AddRuleCommand) (*recording.Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uid := cmd.UID
+	if uid == "" {
+		var ok bool
+		if uid, ok = s.store.GenerateUID(); !ok {
+			return nil, recording.ErrRuleFailedGenerateUniqueUid
+		}
+	}
+	if !util.IsValidShortUID(uid) || util.IsShortUIDTooLong(uid) {
+		return nil, recording.ErrRuleUIDInvalid.Errorf("invalid uid %q", uid)
+	}
+	if _, ok := s.store.Get(uid); ok {
+		return nil, recording.ErrRuleUidExists
+	}
+
+	now := time.Now()
+	rule := &recording.Rule{
+		UID:           uid,
+		Metric:        strings.TrimSpace(cmd.Metric),
+		DatasourceUID: cmd.DatasourceUID,
+		Expr:          cmd.Expr,
+		Interval:      cmd.Interval,
+		Labels:        cmd.Labels,
+		IsPaused:      cmd.IsPaused,
+		Version:       1,
+		Created:       now,
+		Updated:       now,
+	}
+	if err := s.prepare(ctx, cmd.Identity, rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Save(rule); err != nil {
+		return nil, err
+	}
+	s.statusMu.Lock()
+	s.statuses[rule.UID] = &ruleStatus{}
+	s.statusMu.Unlock()
+	s.log.Info("Added recording rule", "uid", rule.UID, "metric", rule.Metric)
+	return rule, nil
+}
+
+func (s *Service) UpdateRule(ctx context.Context, cmd *recording.UpdateRuleCommand) (*recording.Rule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.store.Get(cmd.UID)
+	if !ok {
+		return nil, recording.ErrRuleNotFound
+	}
+	if err := s.checkPermission(ctx, cmd.Identity, existing); err != nil {
+		return nil, err
+	}
+	if cmd.Version != 0 && cmd.Version != existing.Version {
+		return nil, recording.ErrRuleVersionMismatch
+	}
+
+	rule := &recording.Rule{
+		UID:           existing.UID,
+		Metric:        strings.TrimSpace(cmd.Metric),
+		DatasourceUID: cmd.DatasourceUID,
+		Expr:          cmd.Expr,
+		Interval:      cmd.Interval,
+		Labels:        cmd.Labels,
+		IsPaused:      cmd.IsPaused,
+		Version:       existing.Version + 1,
+		Created:       existing.Created,
+		Updated:       time.Now(),
+	}
+	if err := s.prepare(ctx, cmd.Identity, rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.store.Save(rule); err != nil {
+		return nil, err
+	}
+	s.log.Info("Updated recording rule", "uid", rule.UID, "metric", rule.Metric, "version", rule.Version)
+	return rule, nil
+}
+
+func (s *Service) DeleteRule(ctx context.Context, cmd *recording.DeleteRuleCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rule, ok := s.store.Get(cmd.UID)
+	if !ok {
+		return recording.ErrRuleNotFound
+	}
+	if err := s.checkPermission(ctx, cmd.Identity, rule); err != nil {
+		return err
+	}
+	if err := s.store.Delete(cmd.UID); err != nil {
+		return err
+	}
+
+	s.statusMu.Lock()
+	delete(s.statuses, cmd.UID)
+	s.statusMu.Unlock()
+
+	s.log.Info("Deleted recording rule", "uid", cmd.UID, "metric", rule.Metric)
+	return nil
+}
+
+// prepare validates rule and fills in defaults. Rules are evaluated without
+// request, so identity must be allowed to query their datasource.
+func (s *Service) prepare(ctx context.Context, identity *authn.Identity, rule *recording.Rule) error {
+	if !metricNameRegexp.MatchString(rule.Metric) {
+		return invalidRule(nil, "invalid metric name %q", rule.Metric)
+	}
+	if rule.Labels == nil {
+		rule.Labels = map[string]string{}
+	}
+	for name := range rule.Labels {
+		if !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") {
+			return invalidRule(nil, "invalid label name %q", name)
+		}
+	}
+
+	if rule.Interval == "" {
+		rule.Interval = defaultInterval
+	}
+	if _, err := rules.ParseInterval(rule.Interval, s.cfg.RecordingMinInterval); err != nil {
+		return invalidRule(err, "%s", err)
+	}
+
+	if strings.TrimSpace(rule.Expr) == "" {
+		return invalidRule(nil, "expr is required")
+	}
+	if rule.DatasourceUID == "" {
+		return invalidRule(nil, "datasourceUid is required")
+	}
+	ds, err := s.dataSourceService.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: rule.DatasourceUID})
+	if err != nil {
+		if errors.Is(err, datasources.ErrDataSourceNotFound) {
+			return invalidRule(err, "datasource %q not found", rule.DatasourceUID)
+		}
+		return err
+	}
+	return datasources.CheckPermission(identity, ds, datasources.PermissionQuery)
+}
+
+// checkPermission returns datasources.ErrDataSourceAccessDenied when
+// identity may not query the datasource of a saved rule. Rules whose
+// datasource was deleted aren't checked, they can't run either.
+func (s *Service) checkPermission(ctx context.Context, identity *authn.Identity, rule *recording.Rule) error {
+	ds, err := s.dataSourceService.GetDataSource(ctx, &datasources.GetDataSourceQuery{UID: rule.DatasourceUID})
+	if err != nil {
+		if errors.Is(err, datasources.ErrDataSourceNotFound) {
+			return nil
+		}
+		return err
+	}
+	return datasources.CheckPermission(identity, ds, datasources.PermissionQuery)
+}
+
+func invalidRule(err error, format string, args ...any) error {
+	return recording.ErrRuleInvalid.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+		Error:  err,
+	})
+}
+
+// lessRule sorts rules by metric.
+func lessRule(a, b *recording.Rule) bool {
+	if a.Metric != b.Metric {
+		return a.Metric < b.Metric
+	}
+	return a.UID < b.UID
+}
@@ -0,0 +1,290 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	datasourceservice "github.com/xquare-dashboard/pkg/services/datasources/service"
+	"github.com/xquare-dashboard/pkg/services/query/querytest"
+	"github.com/xquare-dashboard/pkg/services/recording"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+var editor = &authn.Identity{Kind: authn.KindUser, ID: "editor", Role: authn.RoleEditor}
+
+func setupService(t *testing.T) (*Service, *querytest.FakeService) {
+	t.Helper()
+	cfg := &setting.Cfg{DataPath: t.TempDir(), RecordingMinInterval: 10 * time.Second, RecordingEvaluationTimeout: 30 * time.Second}
+	dsService, err := datasourceservice.ProvideService(cfg)
+	require.NoError(t, err)
+	_, err = dsService.AddDataSource(context.Background(), &datasources.AddDataSourceCommand{
+		Name: "Loki", Type: datasources.LokiType, URL: "http://loki:3100", UID: "loki",
+	})
+	require.NoError(t, err)
+	queryService := &querytest.FakeService{}
+	s, err := ProvideService(cfg, queryService, dsService, httpclient.NewProvider())
+	require.NoError(t, err)
+	return s, queryService
+}
+
+func TestService_CRUD(t *testing.T) {
+	s, _ := setupService(t)
+	ctx := context.Background()
+
+	invalid := []*recording.AddRuleCommand{
+		{Metric: "app errors", DatasourceUID: "loki", Expr: "1", Identity: editor},
+		{Metric: "app:errors", DatasourceUID: "loki", Identity: editor},
+		{Metric: "app:errors", Expr: "1", Identity: editor},
+		{Metric: "app:errors", DatasourceUID: "missing", Expr: "1", Identity: editor},
+		{Metric: "app:errors", DatasourceUID: "loki", Expr: "1", Interval: "15s", Identity: editor},
+		{Metric: "app:errors", DatasourceUID: "loki", Expr: "1", Labels: map[string]string{"__name__": "x"}, Identity: editor},
+	}
+	for _, cmd := range invalid {
+		_, err := s.AddRule(ctx, cmd)
+		require.ErrorIs(t, err, recording.ErrRuleInvalid, cmd)
+	}
+
+	rule, err := s.AddRule(ctx, &recording.AddRuleCommand{
+		UID:           "errors",
+		Metric:        "app:errors:rate5m",
+		DatasourceUID: "loki",
+		Expr:          `sum by (app) (rate({app="x"} |= "error" [5m]))`,
+		Identity:      editor,
+	})
+	require.NoError(t, err)
+	require.Equal(t, "1m", rule.Interval)
+	require.Equal(t, 1, rule.Version)
+
+	_, err = s.AddRule(ctx, &recording.AddRuleCommand{UID: "errors", Metric: "x", DatasourceUID: "loki", Expr: "1", Identity: editor})
+	require.ErrorIs(t, err, recording.ErrRuleUidExists)
+
+	_, err = s.UpdateRule(ctx, &recording.UpdateRuleCommand{UID: "errors", Metric: "x", DatasourceUID: "loki", Expr: "1", Version: 5, Identity: editor})
+	require.ErrorIs(t, err, recording.ErrRuleVersionMismatch)
+	updated, err := s.UpdateRule(ctx, &recording.UpdateRuleCommand{
+		UID: "errors", Metric: "app:errors:rate1m", DatasourceUID: "loki", Expr: "1", Interval: "30s", Version: 1, Identity: editor,
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, updated.Version)
+
+	reloaded, err := ProvideService(s.cfg, s.queryService, s.dataSourceService, httpclient.NewProvider())
+	require.NoError(t, err)
+	got, err := reloaded.GetRule(ctx, &recording.GetRuleQuery{UID: "errors", Identity: editor})
+	require.NoError(t, err)
+	require.Equal(t, "app:errors:rate1m", got.Metric)
+
+	statuses, err := s.GetStatuses(ctx, &recording.GetStatusesQuery{Identity: editor})
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, recording.HealthUnknown, statuses[0].Health)
+
+	require.NoError(t, s.DeleteRule(ctx, &recording.DeleteRuleCommand{UID: "errors", Identity: editor}))
+	_, err = s.GetRule(ctx, &recording.GetRuleQuery{UID: "errors", Identity: editor})
+	require.ErrorIs(t, err, recording.ErrRuleNotFound)
+}
+
+func TestService_Permissions(t *testing.T) {
+	s, _ := setupService(t)
+	ctx := context.Background()
+	_, err := s.dataSourceService.AddDataSource(ctx, &datasources.AddDataSourceCommand{
+		Name: "Prometheus", Type: datasources.PrometheusType, URL: "http://prometheus:9090", UID: "prom",
+		Permissions: []*datasources.DataSourcePermission{{Team: "sre", Permission: datasources.PermissionQuery}},
+	})
+	require.NoError(t, err)
+	sre := &authn.Identity{Kind: authn.KindUser, ID: "sre", Role: authn.RoleEditor, Groups: []string{"sre"}}
+
+	// Rules run without user, the user saving them must be allowed to query
+	// their datasource.
+	_, err = s.AddRule(ctx, &recording.AddRuleCommand{UID: "up", Metric: "job:up", DatasourceUID: "prom", Expr: "up", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	_, err = s.AddRule(ctx, &recording.AddRuleCommand{UID: "up", Metric: "job:up", DatasourceUID: "prom", Expr: "up", Identity: sre})
+	require.NoError(t, err)
+	_, err = s.AddRule(ctx, &recording.AddRuleCommand{UID: "errors", Metric: "app:errors", DatasourceUID: "loki", Expr: "1", Identity: editor})
+	require.NoError(t, err)
+
+	_, err = s.UpdateRule(ctx, &recording.UpdateRuleCommand{UID: "errors", Metric: "app:errors", DatasourceUID: "prom", Expr: "1", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	_, err = s.UpdateRule(ctx, &recording.UpdateRuleCommand{UID: "up", Metric: "job:up", DatasourceUID: "loki", Expr: "1", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	require.ErrorIs(t, s.DeleteRule(ctx, &recording.DeleteRuleCommand{UID: "up", Identity: editor}), datasources.ErrDataSourceAccessDenied)
+
+	// Reads leave out the rules of datasources the user may not query.
+	_, err = s.GetRule(ctx, &recording.GetRuleQuery{UID: "up", Identity: editor})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	rules, err := s.GetRules(ctx, &recording.GetRulesQuery{Identity: editor})
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	require.Equal(t, "errors", rules[0].UID)
+	rules, err = s.GetRules(ctx, &recording.GetRulesQuery{Identity: sre})
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	statuses, err := s.GetStatuses(ctx, &recording.GetStatusesQuery{Identity: editor})
+	require.NoError(t, err)
+	require.Len(t, statuses, 1)
+	require.Equal(t, "errors", statuses[0].UID)
+}
+
+// writtenSeries decodes a remote write request into the labels and the
+// sample of every series.
+func writtenSeries(t *testing.T, body []byte) []series {
+	t.Helper()
+	b, err := snappy.Decode(nil, body)
+	require.NoError(t, err)
+
+	var result []series
+	fields := func(b []byte, fn func(num protowire.Number, typ protowire.Type, b []byte) int) {
+		for len(b) > 0 {
+			num, typ, n := protowire.ConsumeTag(b)
+			require.GreaterOrEqual(t, n, 0)
+			b = b[n:]
+			n = fn(num, typ, b)
+			require.GreaterOrEqual(t, n, 0)
+			b = b[n:]
+		}
+	}
+	fields(b, func(_ protowire.Number, _ protowire.Type, b []byte) int {
+		ts, n := protowire.ConsumeBytes(b)
+		s := series{labels: data.Labels{}}
+		fields(ts, func(num protowire.Number, _ protowire.Type, b []byte) int {
+			msg, n := protowire.ConsumeBytes(b)
+			var name, value string
+			fields(msg, func(field protowire.Number, typ protowire.Type, b []byte) int {
+				switch {
+				case num == 1 && field == 1:
+					var n int
+					name, n = protowire.ConsumeString(b)
+					return n
+				case num == 1 && field == 2:
+					var n int
+					value, n = protowire.ConsumeString(b)
+					return n
+				case num == 2 && field == 1:
+					v, n := protowire.ConsumeFixed64(b)
+					s.value = math.Float64frombits(v)
+					return n
+				}
+				return protowire.ConsumeFieldValue(field, typ, b)
+			})
+			if num == 1 {
+				s.labels[name] = value
+			}
+			return n
+		})
+		result = append(result, s)
+		return n
+	})
+	return result
+}
+
+func TestService_Evaluate(t *testing.T) {
+	s, queryService := setupService(t)
+	ctx := context.Background()
+
+	var bodies [][]byte
+	var header http.Header
+	status := http.StatusNoContent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies, header = append(bodies, b), r.Header
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	s.cfg.RecordingRemoteWriteURL = srv.URL
+	s.cfg.RecordingRemoteWriteUsername, s.cfg.RecordingRemoteWritePassword = "user", "secret"
+
+	rule, err := s.AddRule(ctx, &recording.AddRuleCommand{
+		Metric:        "app:errors:rate5m",
+		DatasourceUID: "loki",
+		Expr:          `sum by (app) (rate({app="x"} |= "error" [5m]))`,
+		Labels:        map[string]string{"source": "loki"},
+		Identity:      editor,
+	})
+	require.NoError(t, err)
+
+	respond := func(values map[string]*float64) {
+		frames := data.Frames{}
+		for app, v := range values {
+			frames = append(frames, data.NewFrame("",
+				data.NewField("Time", nil, []time.Time{time.Unix(60, 0)}),
+				data.NewField("Value", data.Labels{"app": app, "source": "x"}, []*float64{v}),
+			))
+		}
+		queryService.Resp = backend.NewQueryDataResponse()
+		queryService.Resp.Responses[refID] = backend.DataResponse{Frames: frames}
+		queryService.Err = nil
+	}
+	evaluate := func(now time.Time) *recording.RuleStatus {
+		s.evaluate(ctx, rule, now)
+		statuses, err := s.GetStatuses(ctx, &recording.GetStatusesQuery{RuleUID: rule.UID, Identity: editor})
+		require.NoError(t, err)
+		require.Len(t, statuses, 1)
+		return statuses[0]
+	}
+	one, two := 1.0, 2.0
+	now := time.Date(2024, 5, 14, 12, 0, 0, 0, time.UTC)
+
+	respond(map[string]*float64{"api": &one, "web": &two, "db": nil})
+	st := evaluate(now)
+	require.Equal(t, recording.HealthOk, st.Health)
+	require.Equal(t, 2, st.Samples)
+	require.Equal(t, now, st.LastEvaluation)
+
+	req := queryService.Reqs[0]
+	require.Equal(t, "1715687940000", req.From)
+	require.Equal(t, "1715688000000", req.To)
+	require.Equal(t, "instant", req.Queries[0].Get("queryType").MustString())
+	require.True(t, req.Queries[0].Get("instant").MustBool())
+
+	require.Len(t, bodies, 1)
+	require.Equal(t, "snappy", header.Get("Content-Encoding"))
+	require.Equal(t, "application/x-protobuf", header.Get("Content-Type"))
+	require.Equal(t, "0.1.0", header.Get("X-Prometheus-Remote-Write-Version"))
+	user, password, ok := (&http.Request{Header: header}).BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "user", user)
+	require.Equal(t, "secret", password)
+
+	written := writtenSeries(t, bodies[0])
+	require.ElementsMatch(t, []series{
+		{labels: data.Labels{"__name__": "app:errors:rate5m", "app": "api", "source": "loki"}, value: 1},
+		{labels: data.Labels{"__name__": "app:errors:rate5m", "app": "web", "source": "loki"}, value: 2},
+	}, written)
+
+	status = http.StatusBadRequest
+	st = evaluate(now.Add(time.Minute))
+	require.Equal(t, recording.HealthError, st.Health)
+	require.Contains(t, st.LastError, "400")
+	require.Equal(t, 0, st.Samples)
+
+	status = http.StatusNoContent
+	queryService.Err = errors.New("loki is down")
+	st = evaluate(now.Add(2 * time.Minute))
+	require.Equal(t, recording.HealthError, st.Health)
+	require.Equal(t, "loki is down", st.LastError)
+	require.Len(t, bodies, 2)
+
+	// Series that only differ by the labels the rule replaces collide.
+	queryService.Resp = backend.NewQueryDataResponse()
+	queryService.Resp.Responses[refID] = backend.DataResponse{Frames: data.Frames{
+		data.NewFrame("", data.NewField("Time", nil, []time.Time{time.Unix(60, 0)}), data.NewField("Value", data.Labels{"source": "a"}, []*float64{&one})),
+		data.NewFrame("", data.NewField("Time", nil, []time.Time{time.Unix(60, 0)}), data.NewField("Value", data.Labels{"source": "b"}, []*float64{&two})),
+	}}
+	queryService.Err = nil
+	st = evaluate(now.Add(3 * time.Minute))
+	require.Equal(t, recording.HealthError, st.Health)
+	require.Contains(t, st.LastError, "several series")
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/services/recording"
+)
+
+// ruleStatus holds the result of the last evaluation of a rule.
+type ruleStatus struct {
+	health         string
+	lastError      string
+	samples        int
+	lastEvaluation time.Time
+	evaluationTime time.Duration
+}
+
+func (st *ruleStatus) snapshot(rule *recording.Rule) *recording.RuleStatus {
+	rs := &recording.RuleStatus{
+		UID:    rule.UID,
+		Metric: rule.Metric,
+		Health: recording.HealthUnknown,
+	}
+	if st == nil {
+		return rs
+	}
+	if st.health != "" {
+		rs.Health = st.health
+	}
+	rs.LastError = st.lastError
+	rs.Samples = st.samples
+	rs.LastEvaluation = st.lastEvaluation
+	rs.EvaluationTime = st.evaluationTime.Seconds()
+	return rs
+}
+
+func (s *Service) GetStatuses(ctx context.Context, query *recording.GetStatusesQuery) ([]*recording.RuleStatus, error) {
+	rules, err := s.GetRules(ctx, &recording.GetRulesQuery{Identity: query.Identity})
+	if err != nil {
+		return nil, err
+	}
+
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+
+	result := make([]*recording.RuleStatus, 0, len(rules))
+	for _, rule := range rules {
+		if query.RuleUID != "" && rule.UID != query.RuleUID {
+			continue
+		}
+		result = append(result, s.statuses[rule.UID].snapshot(rule))
+	}
+	return result, nil
+}
@@ -0,0 +1,32 @@
+// Package rules stores and schedules the rules of the alerting and recording
+// services.
+package rules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+)
+
+// Rule is a rule evaluated at an interval.
+type Rule interface {
+	GetUID() string
+	// GetInterval returns how often the rule is evaluated, e.g. 1m.
+	GetInterval() string
+	// GetIsPaused returns whether the evaluation of the rule is stopped.
+	GetIsPaused() bool
+}
+
+// ParseInterval parses the interval of a rule, which must be a positive
+// multiple of minInterval.
+func ParseInterval(interval string, minInterval time.Duration) (time.Duration, error) {
+	d, err := gtime.ParseDuration(interval)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid interval %q", interval)
+	}
+	if d%minInterval != 0 {
+		return 0, fmt.Errorf("interval %q must be a multiple of %s", interval, minInterval)
+	}
+	return d, nil
+}
@@ -0,0 +1,111 @@
+package rules
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+)
+
+type testRule struct {
+	UID      string `json:"uid"`
+	Interval string `json:"interval"`
+	IsPaused bool   `json:"isPaused"`
+}
+
+func (r *testRule) GetUID() string      { return r.UID }
+func (r *testRule) GetInterval() string { return r.Interval }
+func (r *testRule) GetIsPaused() bool   { return r.IsPaused }
+
+func lessTestRule(a, b *testRule) bool { return a.UID < b.UID }
+
+func uids(rules []*testRule) []string {
+	result := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		result = append(result, rule.UID)
+	}
+	return result
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules", "rules.json")
+	s, err := NewStore(path, "test rules", lessTestRule)
+	require.NoError(t, err)
+	require.Empty(t, s.List())
+
+	require.NoError(t, s.Save(&testRule{UID: "b", Interval: "1m"}))
+	require.NoError(t, s.Save(&testRule{UID: "a", Interval: "1m"}))
+	require.NoError(t, s.Save(&testRule{UID: "b", Interval: "5m"}))
+	require.Equal(t, []string{"a", "b"}, uids(s.List()))
+	rule, ok := s.Get("b")
+	require.True(t, ok)
+	require.Equal(t, "5m", rule.Interval)
+
+	uid, ok := s.GenerateUID()
+	require.True(t, ok)
+	_, exists := s.Get(uid)
+	require.False(t, exists)
+
+	require.NoError(t, s.Delete("a"))
+	_, ok = s.Get("a")
+	require.False(t, ok)
+
+	// Rules are read back from the file.
+	reloaded, err := NewStore(path, "test rules", lessTestRule)
+	require.NoError(t, err)
+	require.Equal(t, s.List(), reloaded.List())
+}
+
+func TestScheduler_Due(t *testing.T) {
+	s := NewScheduler[*testRule](log.New("test"), 10*time.Second)
+	rules := []*testRule{
+		{UID: "every-tick", Interval: "10s"},
+		{UID: "every-3rd-tick", Interval: "30s"},
+		{UID: "paused", Interval: "10s", IsPaused: true},
+		{UID: "invalid", Interval: "soon"},
+	}
+
+	require.Equal(t, []string{"every-tick", "every-3rd-tick"}, uids(s.due(rules, 0)))
+	// Running rules are skipped until their evaluation is done.
+	require.Empty(t, uids(s.due(rules, 3)))
+	s.done("every-tick")
+	require.Equal(t, []string{"every-tick"}, uids(s.due(rules, 1)))
+	s.done("every-tick")
+	s.done("every-3rd-tick")
+	require.Equal(t, []string{"every-tick", "every-3rd-tick"}, uids(s.due(rules, 3)))
+}
+
+func TestScheduler_Run(t *testing.T) {
+	s := NewScheduler[*testRule](log.New("test"), 10*time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	evaluated := make(chan string)
+	go func() {
+		<-evaluated
+		cancel()
+	}()
+
+	list := func() []*testRule { return []*testRule{{UID: "a", Interval: "10ms"}} }
+	err := s.Run(ctx, list, func(_ context.Context, rule *testRule, _ time.Time) {
+		select {
+		case evaluated <- rule.UID:
+		default:
+		}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, s.running)
+}
+
+func TestParseInterval(t *testing.T) {
+	d, err := ParseInterval("1m", 10*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, time.Minute, d)
+
+	for _, interval := range []string{"", "soon", "0s", "-1m", "15s"} {
+		_, err := ParseInterval(interval, 10*time.Second)
+		require.Error(t, err, interval)
+	}
+}
@@ -0,0 +1,85 @@
+package rules
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+)
+
+// Scheduler evaluates rules at the ticks of the minimum interval that are
+// multiples of their interval. At most one evaluation of a rule runs at a
+// time, an evaluation that takes longer than the interval delays the next
+// one.
+type Scheduler[R Rule] struct {
+	log         log.Logger
+	minInterval time.Duration
+
+	mu      sync.Mutex
+	running map[string]bool
+}
+
+func NewScheduler[R Rule](logger log.Logger, minInterval time.Duration) *Scheduler[R] {
+	return &Scheduler[R]{log: logger, minInterval: minInterval, running: map[string]bool{}}
+}
+
+// Run evaluates the due ones of the rules returned by list at every tick,
+// until ctx is done.
+func (s *Scheduler[R]) Run(ctx context.Context, list func() []R, evaluate func(ctx context.Context, rule R, now time.Time)) error {
+	ticker := time.NewTicker(s.minInterval)
+	defer ticker.Stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for tick := int64(0); ; tick++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-ticker.C:
+			for _, rule := range s.due(list(), tick) {
+				wg.Add(1)
+				go func(rule R) {
+					defer wg.Done()
+					defer s.done(rule.GetUID())
+					evaluate(ctx, rule, now)
+				}(rule)
+			}
+		}
+	}
+}
+
+// due returns the rules to evaluate at tick and marks them as running.
+func (s *Scheduler[R]) due(rules []R, tick int64) []R {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []R
+	for _, rule := range rules {
+		if rule.GetIsPaused() {
+			continue
+		}
+		interval, err := gtime.ParseDuration(rule.GetInterval())
+		if err != nil {
+			continue
+		}
+		if every := max(int64(interval/s.minInterval), 1); tick%every != 0 {
+			continue
+		}
+		if s.running[rule.GetUID()] {
+			s.log.Warn("Skipping rule evaluation, the previous one is still running", "uid", rule.GetUID())
+			continue
+		}
+		s.running[rule.GetUID()] = true
+		due = append(due, rule)
+	}
+	return due
+}
+
+func (s *Scheduler[R]) done(uid string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, uid)
+}
@@ -0,0 +1,115 @@
+package rules
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/xquare-dashboard/pkg/infra/fs"
+	"github.com/xquare-dashboard/pkg/util"
+)
+
+// Store holds rules by UID and persists them as a JSON file. Stored rules are
+// never mutated in place, saving a rule always replaces the stored value, so
+// the rules it returns must be treated as read-only.
+//
+// Store is not safe for concurrent use, callers guard it with the lock they
+// hold while validating a rule before saving it.
+type Store[R Rule] struct {
+	path string
+	// name names the rules in errors, e.g. alert rules.
+	name  string
+	less  func(a, b R) bool
+	byUID map[string]R
+}
+
+// NewStore returns a store of the rules in the file at path, sorted by less.
+// name names the rules in errors, e.g. alert rules.
+func NewStore[R Rule](path, name string, less func(a, b R) bool) (*Store[R], error) {
+	s := &Store[R]{path: path, name: name, less: less, byUID: map[string]R{}}
+	rules, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		s.byUID[rule.GetUID()] = rule
+	}
+	return s, nil
+}
+
+// Get returns the rule with the given UID.
+func (s *Store[R]) Get(uid string) (R, bool) {
+	rule, ok := s.byUID[uid]
+	return rule, ok
+}
+
+// List returns the rules sorted.
+func (s *Store[R]) List() []R {
+	rules := s.others("")
+	s.sort(rules)
+	return rules
+}
+
+// Save persists the rules with rule swapped in, then swaps it in.
+func (s *Store[R]) Save(rule R) error {
+	rules := append(s.others(rule.GetUID()), rule)
+	s.sort(rules)
+	if err := s.save(rules); err != nil {
+		return err
+	}
+	s.byUID[rule.GetUID()] = rule
+	return nil
+}
+
+// Delete persists the rules without the rule with the given UID, then
+// removes it.
+func (s *Store[R]) Delete(uid string) error {
+	rules := s.others(uid)
+	s.sort(rules)
+	if err := s.save(rules); err != nil {
+		return err
+	}
+	delete(s.byUID, uid)
+	return nil
+}
+
+// GenerateUID returns a UID no rule has, false when it failed to generate
+// one.
+func (s *Store[R]) GenerateUID() (string, bool) {
+	for i := 0; i < 3; i++ {
+		uid := util.GenerateShortUID()
+		if _, ok := s.byUID[uid]; !ok {
+			return uid, true
+		}
+	}
+	return "", false
+}
+
+// others returns the rules except the one with the given UID, unsorted.
+func (s *Store[R]) others(uid string) []R {
+	rules := make([]R, 0, len(s.byUID)+1)
+	for u, rule := range s.byUID {
+		if u != uid {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+func (s *Store[R]) sort(rules []R) {
+	sort.Slice(rules, func(i, j int) bool { return s.less(rules[i], rules[j]) })
+}
+
+func (s *Store[R]) load() ([]R, error) {
+	var rules []R
+	if err := fs.ReadJSON(s.path, &rules); err != nil {
+		return nil, fmt.Errorf("failed to read %s from %q: %w", s.name, s.path, err)
+	}
+	return rules, nil
+}
+
+func (s *Store[R]) save(rules []R) error {
+	if err := fs.WriteJSONAtomic(s.path, rules, 0o640); err != nil {
+		return fmt.Errorf("failed to save %s to %q: %w", s.name, s.path, err)
+	}
+	return nil
+}
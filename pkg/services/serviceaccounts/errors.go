@@ -0,0 +1,23 @@
+package serviceaccounts
+
+import (
+	"errors"
+
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+var (
+	ErrServiceAccountNotFound                = errors.New("service account not found")
+	ErrServiceAccountUidExists               = errors.New("service account with the same uid already exists")
+	ErrServiceAccountNameExists              = errors.New("service account with the same name already exists")
+	ErrServiceAccountFailedGenerateUniqueUid = errors.New("failed to generate unique service account ID")
+	ErrServiceAccountUIDInvalid              = errutil.ValidationFailed("serviceaccounts.uidInvalid", errutil.WithPublicMessage("Invalid service account uid."))
+	ErrServiceAccountInvalid                 = errutil.ValidationFailed("serviceaccounts.invalid").MustTemplate("invalid service account: {{ .Public.Reason }}", errutil.WithPublic("Invalid service account: {{ .Public.Reason }}"))
+	ErrTokenNotFound                         = errors.New("token not found")
+	ErrTokenNameExists                       = errors.New("token with the same name already exists")
+	ErrTokenInvalid                          = errutil.ValidationFailed("serviceaccounts.tokenInvalid").MustTemplate("invalid token: {{ .Public.Reason }}", errutil.WithPublic("Invalid token: {{ .Public.Reason }}"))
+
+	ErrTokenUnknown           = errutil.Unauthorized("serviceaccounts.tokenUnknown", errutil.WithPublicMessage("Invalid API token."))
+	ErrTokenExpired           = errutil.Unauthorized("serviceaccounts.tokenExpired", errutil.WithPublicMessage("Expired API token."))
+	ErrServiceAccountDisabled = errutil.Unauthorized("serviceaccounts.disabled", errutil.WithPublicMessage("The service account is disabled."))
+)
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+	"github.com/xquare-dashboard/pkg/setting"
+	"github.com/xquare-dashboard/pkg/util"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+// Service stores service accounts and the hashes of their API tokens in the
+// data directory.
+//
+// Stored values are never mutated in place, saving always replaces the
+// stored maps. Callers must treat returned values as read-only.
+type Service struct {
+	log   log.Logger
+	store *fileStore
+
+	mu          sync.RWMutex
+	byUID       map[string]*serviceaccounts.ServiceAccount
+	tokens      map[int64]*storedToken
+	byHash      map[string]*storedToken
+	nextTokenID int64
+}
+
+var _ serviceaccounts.ServiceAccountService = (*Service)(nil)
+
+func ProvideService(cfg *setting.Cfg) (*Service, error) {
+	s := &Service{
+		log:         log.New("serviceaccounts"),
+		store:       &fileStore{path: filepath.Join(cfg.DataPath, "serviceaccounts", "serviceaccounts.json")},
+		byUID:       map[string]*serviceaccounts.ServiceAccount{},
+		tokens:      map[int64]*storedToken{},
+		byHash:      map[string]*storedToken{},
+		nextTokenID: 1,
+	}
+
+	st, err := s.store.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, sa := range st.ServiceAccounts {
+		s.byUID[sa.UID] = sa
+	}
+	for _, t := range st.Tokens {
+		s.tokens[t.ID] = t
+		s.byHash[t.Hash] = t
+		s.nextTokenID = max(s.nextTokenID, t.ID+1)
+	}
+	return s, nil
+}
+
+func (s *Service) GetServiceAccount(_ context.Context, query *serviceaccounts.GetServiceAccountQuery) (*serviceaccounts.ServiceAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sa, ok := s.byUID[query.UID]
+	if !ok {
+		return nil, serviceaccounts.ErrServiceAccountNotFound
+	}
+	return sa, nil
+}
+
+func (s *Service) GetServiceAccounts(_ context.Context, _ *serviceaccounts.GetServiceAccountsQuery) ([]*serviceaccounts.ServiceAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := make([]*serviceaccounts.ServiceAccount, 0, len(s.byUID))
+	for _, sa := range s.byUID {
+		result = append(result, sa)
+	}
+	sortServiceAccounts(result)
+	return result, nil
+}
+
+func (s *Service) AddServiceAccount(_ context.Context, cmd *serviceaccounts.AddServiceAccountCommand) (*serviceaccounts.ServiceAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uid := cmd.UID
+	if uid == "" {
+		var err error
+		if uid, err = s.generateNewUID(); err != nil {
+			return nil, err
+		}
+	}
+	if !util.IsValidShortUID(uid) || util.IsShortUIDTooLong(uid) {
+		return nil, serviceaccounts.ErrServiceAccountUIDInvalid.Errorf("invalid uid %q", uid)
+	}
+	if _, ok := s.byUID[uid]; ok {
+		return nil, serviceaccounts.ErrServiceAccountUidExists
+	}
+
+	now := time.Now()
+	sa := &serviceaccounts.ServiceAccount{
+		UID:        uid,
+		Name:       strings.TrimSpace(cmd.Name),
+		Role:       cmd.Role,
+		IsDisabled: cmd.IsDisabled,
+		Created:    now,
+		Updated:    now,
+	}
+	if err := s.prepare(sa); err != nil {
+		return nil, err
+	}
+
+	byUID := maps.Clone(s.byUID)
+	byUID[sa.UID] = sa
+	if err := s.commit(byUID, s.tokens); err != nil {
+		return nil, err
+	}
+	s.log.Info("Added service account", "uid", sa.UID, "name", sa.Name, "role", sa.Role)
+	return sa, nil
+}
+
+func (s *Service) UpdateServiceAccount(_ context.Context, cmd *serviceaccounts.UpdateServiceAccountCommand) (*serviceaccounts.ServiceAccount, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.byUID[cmd.UID]
+	if !ok {
+		return nil, serviceaccounts.ErrServiceAccountNotFound
+	}
+
+	sa := &serviceaccounts.ServiceAccount{
+		UID:        existing.UID,
+		Name:       strings.TrimSpace(cmd.Name),
+		Role:       cmd.Role,
+		IsDisabled: cmd.IsDisabled,
+		Created:    existing.Created,
+		Updated:    time.Now(),
+	}
+	if err := s.prepare(sa); err != nil {
+		return nil, err
+	}
+
+	byUID := maps.Clone(s.byUID)
+	byUID[sa.UID] = sa
+	if err := s.commit(byUID, s.tokens); err != nil {
+		return nil, err
+	}
+	s.log.Info("Updated service account", "uid", sa.UID, "name", sa.Name, "role", sa.Role, "disabled", sa.IsDisabled)
+	return sa, nil
+}
+
+func (s *Service) DeleteServiceAccount(_ context.Context, cmd *serviceaccounts.DeleteServiceAccountCommand) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sa, ok := s.byUID[cmd.UID]
+	if !ok {
+		return serviceaccounts.ErrServiceAccountNotFound
+	}
+
+	byUID := maps.Clone(s.byUID)
+	delete(byUID, cmd.UID)
+	tokens := maps.Clone(s.tokens)
+	maps.DeleteFunc(tokens, func(_ int64, t *storedToken) bool {
+		return t.ServiceAccountUID == cmd.UID
+	})
+	if err := s.commit(byUID, tokens); err != nil {
+		return err
+	}
+	s.log.Info("Deleted service account", "uid", cmd.UID, "name", sa.Name)
+	return nil
+}
+
+// commit persists byUID and tokens, then swaps them in. The caller must hold
+// the write lock.
+func (s *Service) commit(byUID map[string]*serviceaccounts.ServiceAccount, tokens map[int64]*storedToken) error {
+	st := &state{
+		ServiceAccounts: make([]*serviceaccounts.ServiceAccount, 0, len(byUID)),
+		Tokens:          make([]*storedToken, 0, len(tokens)),
+	}
+	for _, sa := range byUID {
+		st.ServiceAccounts = append(st.ServiceAccounts, sa)
+	}
+	sortServiceAccounts(st.ServiceAccounts)
+	byHash := make(map[string]*storedToken, len(tokens))
+	for _, t := range tokens {
+		st.Tokens = append(st.Tokens, t)
+		byHash[t.Hash] = t
+	}
+	sort.Slice(st.Tokens, func(i, j int) bool {
+		return st.Tokens[i].ID < st.Tokens[j].ID
+	})
+	if err := s.store.save(st); err != nil {
+		return err
+	}
+
+	s.byUID, s.tokens, s.byHash = byUID, tokens, byHash
+	return nil
+}
+
+// prepare validates sa and fills in defaults.
+func (s *Service) prepare(sa *serviceaccounts.ServiceAccount) error {
+	if sa.Name == "" {
+		return invalidServiceAccount("name is required")
+	}
+	for _, other := range s.byUID {
+		if other.UID != sa.UID && strings.EqualFold(other.Name, sa.Name) {
+			return serviceaccounts.ErrServiceAccountNameExists
+		}
+	}
+	if sa.Role == "" {
+		sa.Role = authn.RoleViewer
+	}
+	if !sa.Role.IsValid() {
+		return invalidServiceAccount("invalid role %q", sa.Role)
+	}
+	return nil
+}
+
+func (s *Service) generateNewUID() (string, error) {
+	for i := 0; i < 3; i++ {
+		uid := util.GenerateShortUID()
+		if _, ok := s.byUID[uid]; !ok {
+			return uid, nil
+		}
+	}
+	return "", serviceaccounts.ErrServiceAccountFailedGenerateUniqueUid
+}
+
+func invalidServiceAccount(format string, args ...any) error {
+	return serviceaccounts.ErrServiceAccountInvalid.Build(errutil.TemplateData{
+		Public: map[string]any{"Reason": fmt.Sprintf(format, args...)},
+	})
+}
+
+func sortServiceAccounts(items []*serviceaccounts.ServiceAccount) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Name != items[j].Name {
+			return items[i].Name < items[j].Name
+		}
+		return items[i].UID < items[j].UID
+	})
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+	"github.com/xquare-dashboard/pkg/setting"
+)
+
+func TestService_CRUD(t *testing.T) {
+	cfg := &setting.Cfg{DataPath: t.TempDir()}
+	s, err := ProvideService(cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = s.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{Name: " "})
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountInvalid)
+	_, err = s.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{Name: "ci", Role: "Owner"})
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountInvalid)
+	_, err = s.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{UID: "c i", Name: "ci"})
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountUIDInvalid)
+
+	sa, err := s.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{UID: "ci", Name: "CI"})
+	require.NoError(t, err)
+	require.Equal(t, authn.RoleViewer, sa.Role)
+	_, err = s.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{UID: "ci", Name: "other"})
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountUidExists)
+	_, err = s.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{Name: "ci"})
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountNameExists)
+
+	updated, err := s.UpdateServiceAccount(ctx, &serviceaccounts.UpdateServiceAccountCommand{UID: "ci", Name: "CI", Role: authn.RoleEditor})
+	require.NoError(t, err)
+	require.Equal(t, authn.RoleEditor, updated.Role)
+	require.Equal(t, sa.Created, updated.Created)
+	_, err = s.UpdateServiceAccount(ctx, &serviceaccounts.UpdateServiceAccountCommand{UID: "missing", Name: "x"})
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountNotFound)
+
+	reloaded, err := ProvideService(cfg)
+	require.NoError(t, err)
+	got, err := reloaded.GetServiceAccount(ctx, &serviceaccounts.GetServiceAccountQuery{UID: "ci"})
+	require.NoError(t, err)
+	require.Equal(t, authn.RoleEditor, got.Role)
+
+	require.NoError(t, s.DeleteServiceAccount(ctx, &serviceaccounts.DeleteServiceAccountCommand{UID: "ci"}))
+	items, err := s.GetServiceAccounts(ctx, &serviceaccounts.GetServiceAccountsQuery{})
+	require.NoError(t, err)
+	require.Empty(t, items)
+}
+
+func TestService_Tokens(t *testing.T) {
+	cfg := &setting.Cfg{DataPath: t.TempDir()}
+	s, err := ProvideService(cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = s.AddToken(ctx, &serviceaccounts.AddTokenCommand{ServiceAccountUID: "ci", Name: "deploy"})
+	require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountNotFound)
+	_, err = s.AddServiceAccount(ctx, &serviceaccounts.AddServiceAccountCommand{UID: "ci", Name: "CI", Role: authn.RoleEditor})
+	require.NoError(t, err)
+	_, err = s.AddToken(ctx, &serviceaccounts.AddTokenCommand{ServiceAccountUID: "ci"})
+	require.ErrorIs(t, err, serviceaccounts.ErrTokenInvalid)
+	_, err = s.AddToken(ctx, &serviceaccounts.AddTokenCommand{ServiceAccountUID: "ci", Name: "deploy", SecondsToLive: -1})
+	require.ErrorIs(t, err, serviceaccounts.ErrTokenInvalid)
+
+	token, err := s.AddToken(ctx, &serviceaccounts.AddTokenCommand{ServiceAccountUID: "ci", Name: "deploy"})
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(token.Key, serviceaccounts.KeyPrefix))
+	require.Nil(t, token.Expires)
+	_, err = s.AddToken(ctx, &serviceaccounts.AddTokenCommand{ServiceAccountUID: "ci", Name: "Deploy"})
+	require.ErrorIs(t, err, serviceaccounts.ErrTokenNameExists)
+	expiring, err := s.AddToken(ctx, &serviceaccounts.AddTokenCommand{ServiceAccountUID: "ci", Name: "nightly", SecondsToLive: 3600})
+	require.NoError(t, err)
+	require.WithinDuration(t, time.Now().Add(time.Hour), *expiring.Expires, time.Minute)
+
+	t.Run("only the hashes of the keys are stored", func(t *testing.T) {
+		b, err := os.ReadFile(s.store.path)
+		require.NoError(t, err)
+		require.NotContains(t, string(b), token.Key)
+		require.Contains(t, string(b), hashKey(token.Key))
+	})
+
+	t.Run("tokens authenticate their service account", func(t *testing.T) {
+		reloaded, err := ProvideService(cfg)
+		require.NoError(t, err)
+		sa, err := reloaded.AuthenticateToken(ctx, token.Key)
+		require.NoError(t, err)
+		require.Equal(t, "ci", sa.UID)
+
+		_, err = reloaded.AuthenticateToken(ctx, serviceaccounts.KeyPrefix+"unknown")
+		require.ErrorIs(t, err, serviceaccounts.ErrTokenUnknown)
+		_, err = reloaded.AuthenticateToken(ctx, "")
+		require.ErrorIs(t, err, serviceaccounts.ErrTokenUnknown)
+	})
+
+	t.Run("expired tokens are rejected", func(t *testing.T) {
+		expired := time.Now().Add(-time.Second)
+		s.tokens[expiring.ID].Expires = &expired
+		_, err := s.AuthenticateToken(ctx, expiring.Key)
+		require.ErrorIs(t, err, serviceaccounts.ErrTokenExpired)
+	})
+
+	t.Run("tokens of disabled service accounts are rejected", func(t *testing.T) {
+		_, err := s.UpdateServiceAccount(ctx, &serviceaccounts.UpdateServiceAccountCommand{UID: "ci", Name: "CI", IsDisabled: true})
+		require.NoError(t, err)
+		_, err = s.AuthenticateToken(ctx, token.Key)
+		require.ErrorIs(t, err, serviceaccounts.ErrServiceAccountDisabled)
+		_, err = s.UpdateServiceAccount(ctx, &serviceaccounts.UpdateServiceAccountCommand{UID: "ci", Name: "CI"})
+		require.NoError(t, err)
+	})
+
+	t.Run("revoked tokens are rejected", func(t *testing.T) {
+		require.ErrorIs(t, s.DeleteToken(ctx, &serviceaccounts.DeleteTokenCommand{ServiceAccountUID: "other", ID: token.ID}), serviceaccounts.ErrTokenNotFound)
+		require.NoError(t, s.DeleteToken(ctx, &serviceaccounts.DeleteTokenCommand{ServiceAccountUID: "ci", ID: token.ID}))
+		_, err := s.AuthenticateToken(ctx, token.Key)
+		require.ErrorIs(t, err, serviceaccounts.ErrTokenUnknown)
+
+		tokens, err := s.GetTokens(ctx, &serviceaccounts.GetTokensQuery{ServiceAccountUID: "ci"})
+		require.NoError(t, err)
+		require.Len(t, tokens, 1)
+		require.Equal(t, "nightly", tokens[0].Name)
+	})
+
+	t.Run("deleting a service account deletes its tokens", func(t *testing.T) {
+		require.NoError(t, s.DeleteServiceAccount(ctx, &serviceaccounts.DeleteServiceAccountCommand{UID: "ci"}))
+		require.Empty(t, s.tokens)
+		_, err := s.AuthenticateToken(ctx, expiring.Key)
+		require.ErrorIs(t, err, serviceaccounts.ErrTokenUnknown)
+	})
+}
@@ -0,0 +1,41 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/xquare-dashboard/pkg/infra/fs"
+	"github.com/xquare-dashboard/pkg/services/serviceaccounts"
+)
+
+// state is the persisted service accounts and tokens.
+type state struct {
+	ServiceAccounts []*serviceaccounts.ServiceAccount `json:"serviceAccounts"`
+	Tokens          []*storedToken                    `json:"tokens"`
+}
+
+// storedToken is a token with the hash of its key.
+type storedToken struct {
+	serviceaccounts.Token
+	// Hash is the hex encoded SHA-256 of the key.
+	Hash string `json:"hash"`
+}
+
+// fileStore persists the service accounts and their tokens as a JSON file.
+type fileStore struct {
+	path string
+}
+
+func (f *fileStore) load() (*state, error) {
+	st := &state{}
+	if err := fs.ReadJSON(f.path, st); err != nil {
+		return nil, fmt.Errorf("failed to read service accounts from %q: %w", f.path, err)
+	}
+	return st, nil
+}
+
+func (f *fileStore) save(st *state) error {
+	if err := fs.WriteJSONAtomic(f.path, st, 0o600); err != nil {
+		return fmt.Errorf("failed to save service accounts to %q: %w", f.path, err)
+	}
+	return nil
+}
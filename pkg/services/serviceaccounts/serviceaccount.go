@@ -0,0 +1,97 @@
+package serviceaccounts
+
+import (
+	"time"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+)
+
+// ServiceAccount is a non-human identity, like a CI job or an internal tool,
+// that authenticates with API tokens.
+type ServiceAccount struct {
+	UID  string     `json:"uid"`
+	Name string     `json:"name"`
+	Role authn.Role `json:"role"`
+	// IsDisabled rejects the tokens of the service account.
+	IsDisabled bool `json:"isDisabled"`
+
+	Created time.Time `json:"created,omitempty"`
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// KeyPrefix starts the keys of tokens, so they can be told apart from other
+// bearer tokens and found by secret scanners.
+const KeyPrefix = "xqd_"
+
+// Token is an API token of a service account. Only the hash of its key is
+// stored, the key is returned once when the token is added.
+type Token struct {
+	ID                int64  `json:"id"`
+	ServiceAccountUID string `json:"serviceAccountUid"`
+	Name              string `json:"name"`
+	// Expires is when the token stops being accepted, nil for never.
+	Expires *time.Time `json:"expires,omitempty"`
+
+	Created time.Time `json:"created,omitempty"`
+}
+
+// HasExpired returns whether the token has expired at now.
+func (t *Token) HasExpired(now time.Time) bool {
+	return t.Expires != nil && !now.Before(*t.Expires)
+}
+
+// NewToken is an added token with its key.
+type NewToken struct {
+	*Token
+	// Key is the secret of the token, sent as Authorization: Bearer <key>.
+	Key string `json:"key"`
+}
+
+type GetServiceAccountQuery struct {
+	UID string
+}
+
+type GetServiceAccountsQuery struct{}
+
+// AddServiceAccountCommand creates a service account. A uid is generated
+// when empty, the role defaults to Viewer.
+type AddServiceAccountCommand struct {
+	UID        string     `json:"uid"`
+	Name       string     `json:"name"`
+	Role       authn.Role `json:"role"`
+	IsDisabled bool       `json:"isDisabled"`
+}
+
+// UpdateServiceAccountCommand replaces the service account with the given
+// UID. Its tokens are kept.
+type UpdateServiceAccountCommand struct {
+	Name       string     `json:"name"`
+	Role       authn.Role `json:"role"`
+	IsDisabled bool       `json:"isDisabled"`
+
+	UID string `json:"-"`
+}
+
+// DeleteServiceAccountCommand deletes a service account and its tokens.
+type DeleteServiceAccountCommand struct {
+	UID string
+}
+
+type GetTokensQuery struct {
+	ServiceAccountUID string
+}
+
+// AddTokenCommand creates a token of a service account. It expires after
+// SecondsToLive, or never when 0.
+type AddTokenCommand struct {
+	Name          string `json:"name"`
+	SecondsToLive int64  `json:"secondsToLive"`
+
+	ServiceAccountUID string `json:"-"`
+}
+
+// DeleteTokenCommand revokes a token by deleting it.
+type DeleteTokenCommand struct {
+	ServiceAccountUID string
+	ID                int64
+}
@@ -0,0 +1,39 @@
+package serviceaccounts
+
+import (
+	"context"
+)
+
+// ServiceAccountService interface for interacting with service accounts and
+// their API tokens.
+type ServiceAccountService interface {
+	// GetServiceAccount gets a service account.
+	GetServiceAccount(ctx context.Context, query *GetServiceAccountQuery) (*ServiceAccount, error)
+
+	// GetServiceAccounts gets service accounts sorted by name.
+	GetServiceAccounts(ctx context.Context, query *GetServiceAccountsQuery) ([]*ServiceAccount, error)
+
+	// AddServiceAccount adds a new service account.
+	AddServiceAccount(ctx context.Context, cmd *AddServiceAccountCommand) (*ServiceAccount, error)
+
+	// UpdateServiceAccount updates an existing service account.
+	UpdateServiceAccount(ctx context.Context, cmd *UpdateServiceAccountCommand) (*ServiceAccount, error)
+
+	// DeleteServiceAccount deletes a service account and its tokens.
+	DeleteServiceAccount(ctx context.Context, cmd *DeleteServiceAccountCommand) error
+
+	// GetTokens gets the tokens of a service account sorted by ID.
+	GetTokens(ctx context.Context, query *GetTokensQuery) ([]*Token, error)
+
+	// AddToken adds a new token to a service account and returns it with
+	// its key.
+	AddToken(ctx context.Context, cmd *AddTokenCommand) (*NewToken, error)
+
+	// DeleteToken revokes a token.
+	DeleteToken(ctx context.Context, cmd *DeleteTokenCommand) error
+
+	// AuthenticateToken returns the service account of the token with the
+	// given key, or ErrTokenUnknown, ErrTokenExpired or
+	// ErrServiceAccountDisabled.
+	AuthenticateToken(ctx context.Context, key string) (*ServiceAccount, error)
+}
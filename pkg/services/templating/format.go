@@ -0,0 +1,106 @@
+package templating
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// formatters are the Grafana variable formats, ${var:format}.
+var formatters = map[string]func(values []string) string{
+	"raw": func(values []string) string {
+		return strings.Join(values, ",")
+	},
+	"regex": func(values []string) string {
+		escaped := make([]string, len(values))
+		for i, v := range values {
+			escaped[i] = regexEscape(v)
+		}
+		if len(escaped) == 1 {
+			return escaped[0]
+		}
+		return "(" + strings.Join(escaped, "|") + ")"
+	},
+	"pipe": func(values []string) string {
+		return strings.Join(values, "|")
+	},
+	"csv": func(values []string) string {
+		return strings.Join(values, ",")
+	},
+	"json": func(values []string) string {
+		var b []byte
+		if len(values) == 1 {
+			b, _ = json.Marshal(values[0])
+		} else {
+			b, _ = json.Marshal(values)
+		}
+		return string(b)
+	},
+	"singlequote": func(values []string) string {
+		return quote(values, "'")
+	},
+	"doublequote": func(values []string) string {
+		return quote(values, `"`)
+	},
+	"glob": func(values []string) string {
+		if len(values) == 1 {
+			return values[0]
+		}
+		return "{" + strings.Join(values, ",") + "}"
+	},
+}
+
+// format formats the value with the named format. Without a format a single
+// value is used as is and multiple values are formatted as a regex, which is
+// what the Prometheus and Loki label matchers expect.
+func (v Value) format(name, format string) (string, error) {
+	values, custom := v.resolve()
+	if values == nil {
+		values = []string{}
+	}
+
+	if format == "" {
+		if custom || len(values) == 1 {
+			return strings.Join(values, ","), nil
+		}
+		format = "regex"
+	}
+	formatter, ok := formatters[format]
+	if !ok {
+		return "", ErrInvalidFormat.Errorf("unknown format %q of variable %q", format, name)
+	}
+	// The custom all value is usually a regex, like .*, and is never escaped.
+	if custom {
+		return values[0], nil
+	}
+	return formatter(values), nil
+}
+
+// regexEscape escapes the regex metacharacters of a value the way Grafana's
+// prometheusSpecialRegexEscape does. The regex ends up in a double-quoted
+// PromQL or LogQL string, where a single backslash would start an unknown
+// escape sequence, so backslashes are doubled and quotes are escaped.
+func regexEscape(v string) string {
+	var b strings.Builder
+	for _, r := range v {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\\\`)
+		case r == '"':
+			b.WriteString(`\"`)
+		case strings.ContainsRune(`.+*?()|[]{}^$`, r):
+			b.WriteString(`\\`)
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func quote(values []string, q string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = q + strings.ReplaceAll(v, q, `\`+q) + q
+	}
+	return strings.Join(quoted, ",")
+}
@@ -0,0 +1,92 @@
+package templating
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+var ErrInvalidFormat = errutil.ValidationFailed("templating.invalidFormat", errutil.WithPublicMessage("Invalid template variable format."))
+
+// variableRegex matches $var, [[var]], [[var:format]], ${var} and
+// ${var:format}, it is the one the frontend uses.
+var variableRegex = regexp.MustCompile(`\$(\w+)|\[\[(\w+?)(?::(\w+))?\]\]|\$\{(\w+)(?:\.([^:^\}]+))?(?::([^\}]+))?\}`)
+
+// Interpolate replaces the variables in s with their values. Unknown variables,
+// like the built-in $__interval, are left as they are.
+func (vars Variables) Interpolate(s string) (string, error) {
+	if len(vars) == 0 || !strings.ContainsAny(s, "$[") {
+		return s, nil
+	}
+
+	var err error
+	result := variableRegex.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+		sub := variableRegex.FindStringSubmatch(match)
+		name, format := sub[1], ""
+		switch {
+		case sub[2] != "":
+			name, format = sub[2], sub[3]
+		case sub[4] != "":
+			name, format = sub[4], sub[6]
+		}
+
+		value, ok := vars[name]
+		if !ok {
+			return match
+		}
+		var formatted string
+		formatted, err = value.format(name, format)
+		return formatted
+	})
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// InterpolateJSON interpolates every string of j in place, except those under
+// the given keys.
+func (vars Variables) InterpolateJSON(j *simplejson.Json, skipKeys ...string) error {
+	if len(vars) == 0 || j == nil {
+		return nil
+	}
+	v, err := vars.interpolateAny(j.Interface(), skipKeys)
+	if err != nil {
+		return err
+	}
+	j.SetPath(nil, v)
+	return nil
+}
+
+func (vars Variables) interpolateAny(v any, skipKeys []string) (any, error) {
+	switch v := v.(type) {
+	case string:
+		return vars.Interpolate(v)
+	case map[string]any:
+		for key, item := range v {
+			if slices.Contains(skipKeys, key) {
+				continue
+			}
+			interpolated, err := vars.interpolateAny(item, skipKeys)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = interpolated
+		}
+	case []any:
+		for i, item := range v {
+			interpolated, err := vars.interpolateAny(item, skipKeys)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = interpolated
+		}
+	}
+	return v, nil
+}
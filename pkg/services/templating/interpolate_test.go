@@ -0,0 +1,114 @@
+package templating
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/components/simplejson"
+)
+
+func TestVariables_Interpolate(t *testing.T) {
+	vars := Variables{
+		"namespace": NewValue("prod"),
+		"pod":       NewValue("api-0", "api.1"),
+		"quote":     NewValue("it's", "b"),
+		"special":   NewValue(`a"b`, `c\d`),
+		"job":       {All: true, Options: []string{"node", "api"}},
+		"custom":    {All: true, AllValue: ".*", Options: []string{"a"}},
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{name: "single value", input: `up{namespace="$namespace"}`, expected: `up{namespace="prod"}`},
+		{name: "braces", input: `${namespace}-x`, expected: `prod-x`},
+		{name: "brackets", input: `[[namespace]]`, expected: `prod`},
+		{name: "multi value defaults to regex", input: `{pod=~"$pod"}`, expected: `{pod=~"(api-0|api\\.1)"}`},
+		{name: "regex", input: `${namespace:regex}`, expected: `prod`},
+		{name: "regex escapes quotes and backslashes", input: `${special:regex}`, expected: `(a\"b|c\\\\d)`},
+		{name: "pipe", input: `${pod:pipe}`, expected: `api-0|api.1`},
+		{name: "csv", input: `[[pod:csv]]`, expected: `api-0,api.1`},
+		{name: "json", input: `${pod:json} ${namespace:json}`, expected: `["api-0","api.1"] "prod"`},
+		{name: "singlequote", input: `${quote:singlequote}`, expected: `'it\'s','b'`},
+		{name: "doublequote", input: `${pod:doublequote}`, expected: `"api-0","api.1"`},
+		{name: "glob", input: `${pod:glob}`, expected: `{api-0,api.1}`},
+		{name: "raw", input: `${pod:raw}`, expected: `api-0,api.1`},
+		{name: "all uses the options", input: `{job=~"$job"}`, expected: `{job=~"(node|api)"}`},
+		{name: "custom all value is not escaped", input: `{a=~"${custom:regex}"}`, expected: `{a=~".*"}`},
+		{name: "built-ins and unknown variables are kept", input: `rate(x[$__rate_interval]) $unknown`, expected: `rate(x[$__rate_interval]) $unknown`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, err := vars.Interpolate(tt.input)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+
+	t.Run("unknown format", func(t *testing.T) {
+		_, err := vars.Interpolate(`${namespace:nope}`)
+		require.ErrorIs(t, err, ErrInvalidFormat)
+	})
+}
+
+func TestVariables_InterpolateRegex(t *testing.T) {
+	vars := Variables{"instance": NewValue("10.0.0.1:9100", "host.example.com", `a"b\c`)}
+	actual, err := vars.Interpolate(`up{instance=~"$instance"}`)
+	require.NoError(t, err)
+
+	// PromQL and LogQL unquote label matcher values like Go string literals.
+	value, err := strconv.Unquote(strings.TrimSuffix(strings.TrimPrefix(actual, `up{instance=~`), "}"))
+	require.NoError(t, err)
+	re, err := regexp.Compile("^(?:" + value + ")$")
+	require.NoError(t, err)
+	for _, v := range vars["instance"].Values {
+		require.True(t, re.MatchString(v), v)
+	}
+	require.False(t, re.MatchString("10x0x0x1:9100"))
+}
+
+func TestVariables_InterpolateJSON(t *testing.T) {
+	vars := Variables{"ns": NewValue("prod"), "ds": NewValue("loki")}
+	query := simplejson.NewFromAny(map[string]any{
+		"refId":      "$ns",
+		"expr":       `{namespace="$ns"}`,
+		"datasource": map[string]any{"uid": "${ds}"},
+		"list":       []any{"$ns", 1},
+	})
+
+	require.NoError(t, vars.InterpolateJSON(query, "refId"))
+	require.Equal(t, "$ns", query.Get("refId").MustString())
+	require.Equal(t, `{namespace="prod"}`, query.Get("expr").MustString())
+	require.Equal(t, "loki", query.GetPath("datasource", "uid").MustString())
+	require.Equal(t, []any{"prod", 1}, query.Get("list").MustArray())
+}
+
+func TestValue_UnmarshalJSON(t *testing.T) {
+	var vars Variables
+	err := json.Unmarshal([]byte(`{
+		"a": "x",
+		"b": ["x", "y"],
+		"c": {"value": "$__all", "allValue": ".*"},
+		"d": ["$__all"]
+	}`), &vars)
+	require.NoError(t, err)
+	require.Equal(t, Variables{
+		"a": {Values: []string{"x"}},
+		"b": {Values: []string{"x", "y"}},
+		"c": {All: true, AllValue: ".*"},
+		"d": {All: true},
+	}, vars)
+
+	b, err := json.Marshal(vars["c"])
+	require.NoError(t, err)
+	require.JSONEq(t, `{"value": "$__all", "allValue": ".*"}`, string(b))
+
+	require.Error(t, json.Unmarshal([]byte(`{"a": 1}`), &vars))
+}
@@ -0,0 +1,114 @@
+// Package templating interpolates template variables in queries.
+package templating
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// AllValue is the value of a variable with "All" selected.
+const AllValue = "$__all"
+
+// Variables are the template variable values by variable name.
+type Variables map[string]Value
+
+// Value is the current value of a template variable. In JSON it is either a
+// string, a list of strings, or an object:
+//
+//	{"value": "$__all", "allValue": ".*", "options": ["a", "b"]}
+type Value struct {
+	// Values are the selected values.
+	Values []string
+	// All is set when "All" is selected. It resolves to AllValue when set,
+	// otherwise to all Options.
+	All      bool
+	AllValue string
+	Options  []string
+}
+
+type valueJSON struct {
+	Value    json.RawMessage `json:"value"`
+	AllValue string          `json:"allValue,omitempty"`
+	Options  []string        `json:"options,omitempty"`
+}
+
+func (v *Value) UnmarshalJSON(b []byte) error {
+	var obj valueJSON
+	if len(b) > 0 && b[0] == '{' {
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return err
+		}
+	} else {
+		obj.Value = b
+	}
+
+	values, err := parseValues(obj.Value)
+	if err != nil {
+		return err
+	}
+	*v = NewValue(values...)
+	v.AllValue = obj.AllValue
+	v.Options = obj.Options
+	return nil
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	obj := valueJSON{AllValue: v.AllValue, Options: v.Options}
+	var err error
+	if v.All {
+		obj.Value, err = json.Marshal(AllValue)
+	} else {
+		obj.Value, err = json.Marshal(v.Values)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(obj)
+}
+
+// NewValue returns the value with the given values selected, AllValue among
+// them selects "All".
+func NewValue(values ...string) Value {
+	v := Value{}
+	for _, value := range values {
+		if value == AllValue {
+			v.All = true
+			continue
+		}
+		v.Values = append(v.Values, value)
+	}
+	if v.All {
+		v.Values = nil
+	}
+	return v
+}
+
+// resolve returns the values the variable stands for. custom is set when they
+// are the custom all value, which is never escaped.
+func (v Value) resolve() (values []string, custom bool) {
+	if !v.All {
+		return v.Values, false
+	}
+	if v.AllValue != "" {
+		return []string{v.AllValue}, true
+	}
+	return v.Options, false
+}
+
+func parseValues(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	if raw[0] == '[' {
+		var values []string
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, fmt.Errorf("variable values must be strings: %w", err)
+		}
+		return values, nil
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, fmt.Errorf("variable value must be a string: %w", err)
+	}
+	return []string{value}, nil
+}
@@ -0,0 +1,202 @@
+package variables
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+
+	"github.com/xquare-dashboard/pkg/services/datasources"
+)
+
+type functionKind int
+
+const (
+	labelNames functionKind = iota
+	labelValues
+	metricNames
+	queryResult
+)
+
+var (
+	labelNamesRegex  = regexp.MustCompile(`^label_names\(\s*(.*?)\s*\)$`)
+	labelValuesRegex = regexp.MustCompile(`^label_values\(\s*(?:(.+)\s*,\s*)?([a-zA-Z_][a-zA-Z0-9_]*)\s*\)$`)
+	metricsRegex     = regexp.MustCompile(`^metrics\(\s*(.*?)\s*\)$`)
+	queryResultRegex = regexp.MustCompile(`^query_result\(\s*(.+?)\s*\)$`)
+)
+
+// function is a parsed variable query.
+type function struct {
+	kind functionKind
+	// selector is the series selector of label_names and label_values, the
+	// regex of metrics, or the expression of query_result.
+	selector string
+	label    string
+}
+
+// permission returns the permission on the datasource the function needs:
+// query_result runs a query, the other functions browse the labels.
+func (f *function) permission() datasources.Permission {
+	if f.kind == queryResult {
+		return datasources.PermissionQuery
+	}
+	return datasources.PermissionExplore
+}
+
+func parseQuery(q string) (*function, error) {
+	if m := labelNamesRegex.FindStringSubmatch(q); m != nil {
+		return &function{kind: labelNames, selector: m[1]}, nil
+	}
+	if m := labelValuesRegex.FindStringSubmatch(q); m != nil {
+		return &function{kind: labelValues, selector: strings.TrimSpace(m[1]), label: m[2]}, nil
+	}
+	if m := metricsRegex.FindStringSubmatch(q); m != nil {
+		return &function{kind: metricNames, selector: m[1]}, nil
+	}
+	if m := queryResultRegex.FindStringSubmatch(q); m != nil {
+		return &function{kind: queryResult, selector: m[1]}, nil
+	}
+	return nil, ErrInvalidQuery.Errorf("unsupported variable query %q, expected label_names(), label_values(), metrics() or query_result()", q)
+}
+
+func (fn *function) prometheusRequest(start, end time.Time) (*backend.CallResourceRequest, error) {
+	params := url.Values{}
+	var path string
+	switch fn.kind {
+	case labelNames:
+		path = "api/v1/labels"
+	case labelValues:
+		path = "api/v1/label/" + fn.label + "/values"
+	case metricNames:
+		path = "api/v1/label/__name__/values"
+	case queryResult:
+		path = "api/v1/query"
+		params.Set("query", fn.selector)
+		params.Set("time", strconv.FormatInt(end.Unix(), 10))
+	}
+	if fn.kind != queryResult {
+		params.Set("start", strconv.FormatInt(start.Unix(), 10))
+		params.Set("end", strconv.FormatInt(end.Unix(), 10))
+		if fn.kind != metricNames && fn.selector != "" {
+			params.Set("match[]", fn.selector)
+		}
+	}
+	return resourceRequest(path, params), nil
+}
+
+func (fn *function) lokiRequest(start, end time.Time) (*backend.CallResourceRequest, error) {
+	params := url.Values{}
+	params.Set("start", strconv.FormatInt(start.UnixNano(), 10))
+	params.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	if fn.selector != "" {
+		params.Set("query", fn.selector)
+	}
+
+	switch fn.kind {
+	case labelNames:
+		return resourceRequest("labels", params), nil
+	case labelValues:
+		return resourceRequest("label/"+fn.label+"/values", params), nil
+	}
+	return nil, ErrUnsupportedDatasource.Errorf("loki supports label_names() and label_values() only")
+}
+
+func resourceRequest(path string, params url.Values) *backend.CallResourceRequest {
+	return &backend.CallResourceRequest{
+		Path:   path,
+		Method: "GET",
+		URL:    path + "?" + params.Encode(),
+	}
+}
+
+// values extracts the values from the response of the request of fn.
+func (fn *function) values(body []byte) ([]string, error) {
+	if fn.kind == queryResult {
+		return queryResultValues(body)
+	}
+
+	var resp struct {
+		Data []string `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, ErrDatasourceRequestFailed.Errorf("failed to parse response: %w", err)
+	}
+	if fn.kind != metricNames || fn.selector == "" {
+		return resp.Data, nil
+	}
+
+	re, err := regexp.Compile(fn.selector)
+	if err != nil {
+		return nil, ErrInvalidQuery.Errorf("invalid metrics regex %q: %w", fn.selector, err)
+	}
+	names := make([]string, 0, len(resp.Data))
+	for _, name := range resp.Data {
+		if re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// queryResultValues formats the samples of an instant query the way Grafana
+// does: name{label="value", ...} value timestamp.
+func queryResultValues(body []byte) ([]string, error) {
+	var resp struct {
+		Data struct {
+			ResultType string          `json:"resultType"`
+			Result     json.RawMessage `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, ErrDatasourceRequestFailed.Errorf("failed to parse response: %w", err)
+	}
+
+	switch resp.Data.ResultType {
+	case "vector":
+		var samples []struct {
+			Metric map[string]string `json:"metric"`
+			Value  [2]any            `json:"value"`
+		}
+		if err := json.Unmarshal(resp.Data.Result, &samples); err != nil {
+			return nil, ErrDatasourceRequestFailed.Errorf("failed to parse vector: %w", err)
+		}
+		values := make([]string, 0, len(samples))
+		for _, s := range samples {
+			values = append(values, fmt.Sprintf("%s %v %s", metricString(s.Metric), s.Value[1], sampleTimestamp(s.Value[0])))
+		}
+		return values, nil
+	case "scalar", "string":
+		var sample [2]any
+		if err := json.Unmarshal(resp.Data.Result, &sample); err != nil {
+			return nil, ErrDatasourceRequestFailed.Errorf("failed to parse %s: %w", resp.Data.ResultType, err)
+		}
+		return []string{fmt.Sprintf("%s %v %s", resp.Data.ResultType, sample[1], sampleTimestamp(sample[0]))}, nil
+	}
+	return nil, ErrInvalidQuery.Errorf("query_result does not support %s results", resp.Data.ResultType)
+}
+
+func metricString(metric map[string]string) string {
+	labels := make([]string, 0, len(metric))
+	for name, value := range metric {
+		if name != "__name__" {
+			labels = append(labels, fmt.Sprintf("%s=%q", name, value))
+		}
+	}
+	sort.Strings(labels)
+	return metric["__name__"] + "{" + strings.Join(labels, ", ") + "}"
+}
+
+// sampleTimestamp formats a Prometheus sample timestamp, in seconds, as milliseconds.
+func sampleTimestamp(ts any) string {
+	seconds, ok := ts.(float64)
+	if !ok {
+		return fmt.Sprint(ts)
+	}
+	return strconv.FormatInt(int64(seconds*1000), 10)
+}
@@ -0,0 +1,270 @@
+// Package variables resolves the values of query template variables.
+//
+// A variable query is one of the functions the Prometheus and Loki variable
+// editors support, like label_values(up, job). It is translated into a request
+// to the label APIs of the datasource, sent through the CallResource handler of
+// its plugin.
+package variables
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/patrickmn/go-cache"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+	"github.com/xquare-dashboard/pkg/plugins"
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/pluginsintegration/plugincontext"
+	"github.com/xquare-dashboard/pkg/services/query"
+	"github.com/xquare-dashboard/pkg/services/templating"
+	"github.com/xquare-dashboard/pkg/util/errutil"
+)
+
+const (
+	// cacheTTL is how long the values of a variable query are reused. Dashboards
+	// opened by many clients resolve their variables once.
+	cacheTTL = 30 * time.Second
+
+	defaultFrom = "now-6h"
+	defaultTo   = "now"
+)
+
+var (
+	ErrInvalidQuery            = errutil.BadRequest("variables.invalidQuery", errutil.WithPublicMessage("Invalid variable query."))
+	ErrInvalidRegex            = errutil.BadRequest("variables.invalidRegex", errutil.WithPublicMessage("Invalid variable regex."))
+	ErrUnsupportedDatasource   = errutil.BadRequest("variables.unsupportedDatasource", errutil.WithPublicMessage("The datasource does not support this variable query."))
+	ErrDatasourceRequestFailed = errutil.BadGateway("variables.requestFailed", errutil.WithPublicMessage("The datasource failed to resolve the variable query."))
+)
+
+// Query is a variable query against a datasource.
+type Query struct {
+	// Datasource is the uid of the datasource.
+	Datasource string `json:"datasource"`
+	// Query is the variable query, e.g. label_values(up{job="api"}, instance).
+	Query string `json:"query"`
+	// Regex filters the values, the first capture group, or the one named
+	// value, extracts the value. It can be written as /pattern/flags.
+	Regex string `json:"regex"`
+	// From and To limit the series the values are taken from, they default to
+	// the last 6 hours.
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Variables are interpolated in the query, for variables depending on
+	// other variables.
+	Variables templating.Variables `json:"variables"`
+}
+
+// ResourceCaller calls plugin resources, plugins.Client implements it.
+type ResourceCaller interface {
+	CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error
+}
+
+// PluginContextProvider resolves datasources, *plugincontext.Provider implements it.
+type PluginContextProvider interface {
+	GetWithDataSourceUID(ctx context.Context, uid string) (backend.PluginContext, *datasources.DataSource, error)
+}
+
+type Service struct {
+	log          log.Logger
+	pCtxProvider PluginContextProvider
+	caller       ResourceCaller
+	cache        *cache.Cache
+}
+
+func ProvideService(pCtxProvider *plugincontext.Provider, pluginClient plugins.Client) *Service {
+	return newService(pCtxProvider, pluginClient)
+}
+
+func newService(pCtxProvider PluginContextProvider, caller ResourceCaller) *Service {
+	return &Service{
+		log:          log.New("variables"),
+		pCtxProvider: pCtxProvider,
+		caller:       caller,
+		cache:        cache.New(cacheTTL, 2*cacheTTL),
+	}
+}
+
+// Query returns the sorted, distinct values of a variable query made by
+// identity. Queries of label names and values need the explore permission on
+// the datasource, query_result the query permission.
+func (s *Service) Query(ctx context.Context, identity *authn.Identity, q *Query) ([]string, error) {
+	expr, err := q.Variables.Interpolate(strings.TrimSpace(q.Query))
+	if err != nil {
+		return nil, err
+	}
+	fn, err := parseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := parseRegex(q.Regex)
+	if err != nil {
+		return nil, err
+	}
+
+	pCtx, ds, err := s.pCtxProvider.GetWithDataSourceUID(ctx, q.Datasource)
+	if err != nil {
+		return nil, err
+	}
+	// Cached values are only returned to identities that may query them.
+	if err := datasources.CheckPermission(identity, ds, fn.permission()); err != nil {
+		return nil, err
+	}
+
+	from, to := q.From, q.To
+	if from == "" {
+		from = defaultFrom
+	}
+	if to == "" {
+		to = defaultTo
+	}
+	key := fmt.Sprintf("%s/%d/%s/%s/%s/%s", ds.UID, ds.Version, from, to, expr, q.Regex)
+	if values, ok := s.cache.Get(key); ok {
+		return values.([]string), nil
+	}
+
+	tr := query.DataTimeRange{From: from, To: to, Now: time.Now()}
+	start, err := tr.ParseFrom()
+	if err != nil {
+		return nil, ErrInvalidQuery.Errorf("invalid from %q: %w", from, err)
+	}
+	end, err := tr.ParseTo()
+	if err != nil {
+		return nil, ErrInvalidQuery.Errorf("invalid to %q: %w", to, err)
+	}
+
+	var req *backend.CallResourceRequest
+	switch ds.Type {
+	case datasources.PrometheusType:
+		req, err = fn.prometheusRequest(start, end)
+	case datasources.LokiType:
+		req, err = fn.lokiRequest(start, end)
+	default:
+		err = ErrUnsupportedDatasource.Errorf("datasource type %q", ds.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.PluginContext = pCtx
+
+	resp, err := s.callResource(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	values, err := fn.values(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	values = distinctValues(filter(values))
+	s.cache.SetDefault(key, values)
+	return values, nil
+}
+
+func (s *Service) callResource(ctx context.Context, req *backend.CallResourceRequest) ([]byte, error) {
+	var resp *backend.CallResourceResponse
+	err := s.caller.CallResource(ctx, req, responseSender(func(r *backend.CallResourceResponse) error {
+		resp = r
+		return nil
+	}))
+	if err != nil {
+		return nil, ErrDatasourceRequestFailed.Errorf("%s: %w", req.Path, err)
+	}
+	if resp == nil {
+		return nil, ErrDatasourceRequestFailed.Errorf("%s: no response", req.Path)
+	}
+	if resp.Status/100 != 2 {
+		return nil, ErrDatasourceRequestFailed.Errorf("%s: status %d: %s", req.Path, resp.Status, responseError(resp.Body))
+	}
+	return resp.Body, nil
+}
+
+type responseSender func(resp *backend.CallResourceResponse) error
+
+func (fn responseSender) Send(resp *backend.CallResourceResponse) error {
+	return fn(resp)
+}
+
+// responseError extracts the error message of a Prometheus or Loki error response.
+func responseError(body []byte) string {
+	var resp struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &resp); err == nil {
+		if resp.Error != "" {
+			return resp.Error
+		}
+		if resp.Message != "" {
+			return resp.Message
+		}
+	}
+	return strings.TrimSpace(string(body))
+}
+
+// parseRegex returns a filter keeping the values matching pattern. When the
+// pattern has capture groups the group named value, or else the first group
+// that matched, replaces the value.
+func parseRegex(pattern string) (func([]string) []string, error) {
+	if pattern == "" {
+		return func(values []string) []string { return values }, nil
+	}
+
+	if len(pattern) > 1 && pattern[0] == '/' {
+		if i := strings.LastIndex(pattern, "/"); i > 0 {
+			flags := ""
+			for _, f := range pattern[i+1:] {
+				switch f {
+				case 'i', 'm', 's':
+					flags += string(f)
+				case 'g':
+				default:
+					return nil, ErrInvalidRegex.Errorf("unsupported flag %q in %q", f, pattern)
+				}
+			}
+			pattern = pattern[1:i]
+			if flags != "" {
+				pattern = "(?" + flags + ")" + pattern
+			}
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, ErrInvalidRegex.Errorf("%q: %w", pattern, err)
+	}
+	valueGroup := re.SubexpIndex("value")
+
+	return func(values []string) []string {
+		filtered := make([]string, 0, len(values))
+		for _, v := range values {
+			match := re.FindStringSubmatch(v)
+			if match == nil {
+				continue
+			}
+			if valueGroup > 0 {
+				v = match[valueGroup]
+			} else {
+				for _, group := range match[1:] {
+					if group != "" {
+						v = group
+						break
+					}
+				}
+			}
+			filtered = append(filtered, v)
+		}
+		return filtered
+	}, nil
+}
+
+func distinctValues(values []string) []string {
+	slices.Sort(values)
+	return slices.Compact(values)
+}
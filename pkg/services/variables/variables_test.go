@@ -0,0 +1,159 @@
+package variables
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/services/authn"
+	"github.com/xquare-dashboard/pkg/services/datasources"
+	"github.com/xquare-dashboard/pkg/services/templating"
+)
+
+var viewer = &authn.Identity{Kind: authn.KindUser, ID: "viewer", Role: authn.RoleViewer}
+
+type fakeProvider struct {
+	ds *datasources.DataSource
+}
+
+func (p *fakeProvider) GetWithDataSourceUID(_ context.Context, uid string) (backend.PluginContext, *datasources.DataSource, error) {
+	if uid != p.ds.UID {
+		return backend.PluginContext{}, nil, datasources.ErrInvalidDatasourceID
+	}
+	return backend.PluginContext{PluginID: string(p.ds.Type)}, p.ds, nil
+}
+
+type fakeCaller struct {
+	requests []*backend.CallResourceRequest
+	status   int
+	body     string
+}
+
+func (c *fakeCaller) CallResource(_ context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	c.requests = append(c.requests, req)
+	return sender.Send(&backend.CallResourceResponse{Status: c.status, Body: []byte(c.body)})
+}
+
+func TestService_QueryPrometheus(t *testing.T) {
+	caller := &fakeCaller{status: 200, body: `{"status":"success","data":["b","a","api-1","api-0","a"]}`}
+	s := newService(&fakeProvider{ds: &datasources.DataSource{UID: "prom", Type: datasources.PrometheusType}}, caller)
+	ctx := context.Background()
+
+	values, err := s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "label_values(up{namespace=\"$ns\", job=~\"a,b\"}, pod)", Variables: templating.Variables{"ns": templating.NewValue("prod")}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a", "api-0", "api-1", "b"}, values)
+
+	req := caller.requests[0]
+	require.Equal(t, "api/v1/label/pod/values", req.Path)
+	require.Equal(t, "GET", req.Method)
+	params := requestParams(t, req)
+	require.Equal(t, `up{namespace="prod", job=~"a,b"}`, params.Get("match[]"))
+	require.NotEmpty(t, params.Get("start"))
+
+	t.Run("results are cached", func(t *testing.T) {
+		_, err := s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "label_values(up{namespace=\"$ns\", job=~\"a,b\"}, pod)", Variables: templating.Variables{"ns": templating.NewValue("prod")}})
+		require.NoError(t, err)
+		require.Len(t, caller.requests, 1)
+	})
+
+	t.Run("regex", func(t *testing.T) {
+		values, err := s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "label_names()", Regex: "/API-(.*)/i"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"0", "1"}, values)
+		require.Equal(t, "api/v1/labels", caller.requests[1].Path)
+		require.Empty(t, requestParams(t, caller.requests[1]).Get("match[]"))
+	})
+
+	t.Run("metrics", func(t *testing.T) {
+		values, err := s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "metrics(^api)"})
+		require.NoError(t, err)
+		require.Equal(t, []string{"api-0", "api-1"}, values)
+		require.Equal(t, "api/v1/label/__name__/values", caller.requests[2].Path)
+	})
+
+	t.Run("multi-value variables are escaped for the label matcher", func(t *testing.T) {
+		_, err := s.Query(ctx, viewer, &Query{Datasource: "prom", Query: `label_values(up{instance=~"$instance"}, job)`, Variables: templating.Variables{"instance": templating.NewValue("10.0.0.1:9100", "host.example.com")}})
+		require.NoError(t, err)
+		require.Equal(t, `up{instance=~"(10\\.0\\.0\\.1:9100|host\\.example\\.com)"}`, requestParams(t, caller.requests[len(caller.requests)-1]).Get("match[]"))
+	})
+
+	t.Run("invalid queries", func(t *testing.T) {
+		_, err := s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "up"})
+		require.ErrorIs(t, err, ErrInvalidQuery)
+		_, err = s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "label_names()", Regex: "("})
+		require.ErrorIs(t, err, ErrInvalidRegex)
+		_, err = s.Query(ctx, viewer, &Query{Datasource: "nope", Query: "label_names()"})
+		require.ErrorIs(t, err, datasources.ErrInvalidDatasourceID)
+	})
+}
+
+func TestService_QueryResult(t *testing.T) {
+	caller := &fakeCaller{status: 200, body: `{"status":"success","data":{"resultType":"vector","result":[
+		{"metric":{"__name__":"up","job":"api","instance":"a:80"},"value":[1700000000.5,"1"]}
+	]}}`}
+	s := newService(&fakeProvider{ds: &datasources.DataSource{UID: "prom", Type: datasources.PrometheusType}}, caller)
+
+	values, err := s.Query(context.Background(), viewer, &Query{Datasource: "prom", Query: "query_result(up == 1)"})
+	require.NoError(t, err)
+	require.Equal(t, []string{`up{instance="a:80", job="api"} 1 1700000000500`}, values)
+	require.Equal(t, "api/v1/query", caller.requests[0].Path)
+	require.Equal(t, "up == 1", requestParams(t, caller.requests[0]).Get("query"))
+}
+
+func TestService_QueryLoki(t *testing.T) {
+	caller := &fakeCaller{status: 200, body: `{"status":"success","data":["prod","dev"]}`}
+	s := newService(&fakeProvider{ds: &datasources.DataSource{UID: "loki", Type: datasources.LokiType}}, caller)
+	ctx := context.Background()
+
+	values, err := s.Query(ctx, viewer, &Query{Datasource: "loki", Query: `label_values({app="api"}, namespace)`})
+	require.NoError(t, err)
+	require.Equal(t, []string{"dev", "prod"}, values)
+	require.Equal(t, "label/namespace/values", caller.requests[0].Path)
+	require.Equal(t, `{app="api"}`, requestParams(t, caller.requests[0]).Get("query"))
+
+	_, err = s.Query(ctx, viewer, &Query{Datasource: "loki", Query: "metrics(.*)"})
+	require.ErrorIs(t, err, ErrUnsupportedDatasource)
+
+	caller.status, caller.body = 400, `{"message":"parse error"}`
+	_, err = s.Query(ctx, viewer, &Query{Datasource: "loki", Query: "label_names()"})
+	require.ErrorIs(t, err, ErrDatasourceRequestFailed)
+	require.ErrorContains(t, err, "parse error")
+}
+
+func requestParams(t *testing.T, req *backend.CallResourceRequest) url.Values {
+	t.Helper()
+	u, err := url.Parse(req.URL)
+	require.NoError(t, err)
+	return u.Query()
+}
+
+func TestService_QueryPermissions(t *testing.T) {
+	caller := &fakeCaller{status: 200, body: `{"status":"success","data":["a"]}`}
+	ds := &datasources.DataSource{UID: "prom", Type: datasources.PrometheusType, Permissions: []*datasources.DataSourcePermission{
+		{Role: authn.RoleViewer, Permission: datasources.PermissionQuery},
+		{Team: "sre", Permission: datasources.PermissionExplore},
+	}}
+	s := newService(&fakeProvider{ds: ds}, caller)
+	ctx := context.Background()
+	sre := &authn.Identity{Kind: authn.KindUser, ID: "sre", Role: authn.RoleViewer, Groups: []string{"sre"}}
+
+	// The values are cached for sre before the viewer asks for them.
+	values, err := s.Query(ctx, sre, &Query{Datasource: "prom", Query: "label_values(job)"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, values)
+
+	_, err = s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "label_values(job)"})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	_, err = s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "label_names()"})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	_, err = s.Query(ctx, nil, &Query{Datasource: "prom", Query: "label_values(job)"})
+	require.ErrorIs(t, err, datasources.ErrDataSourceAccessDenied)
+	require.Len(t, caller.requests, 1)
+
+	caller.body = `{"status":"success","data":{"resultType":"scalar","result":[1700000000,"1"]}}`
+	_, err = s.Query(ctx, viewer, &Query{Datasource: "prom", Query: "query_result(scalar(up))"})
+	require.NoError(t, err)
+}
@@ -0,0 +1,237 @@
+// Package setting contains the server configuration.
+package setting
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cfg holds the configuration the server was started with.
+// Values are read from the environment, see ProvideCfg.
+type Cfg struct {
+	// HomePath is the directory relative paths are resolved against.
+	HomePath string
+	// DataPath is where the server persists its state.
+	DataPath string
+	// ProvisioningPath is the directory containing provisioning config,
+	// datasources are read from the "datasources" sub directory.
+	ProvisioningPath string
+
+	// LokiURL and PrometheusURL are the legacy single datasource settings.
+	// They are only used when no datasource has been provisioned.
+	LokiURL       string
+	PrometheusURL string
+
+	// QueryCacheEnabled enables caching of query results.
+	QueryCacheEnabled bool
+	// QueryCacheTTL is how long query results are cached unless the
+	// datasource sets its own cacheTTL.
+	QueryCacheTTL time.Duration
+	// QueryCacheMaxSize is the maximum size of the in-memory query cache in bytes.
+	QueryCacheMaxSize int64
+	// QueryTimeout is how long the queries of a datasource may run unless the
+	// datasource sets its own queryTimeout. 0 disables the timeout.
+	QueryTimeout time.Duration
+
+	// QueryMaxConcurrent is how many queries run at once per datasource unless
+	// the datasource sets its own maxConcurrentQueries. 0 disables the limit.
+	QueryMaxConcurrent int
+	// QueryMaxQueue is how many queries may wait for a datasource, and
+	// QueryMaxQueuePerTenant how many of them may come from the same tenant.
+	QueryMaxQueue          int
+	QueryMaxQueuePerTenant int
+	// QueryTrustedProxies are the addresses of the proxies whose X-Scope-OrgID,
+	// X-Real-IP and X-Forwarded-For headers name the tenant and the client of
+	// anonymous requests. The headers of other clients are ignored.
+	QueryTrustedProxies []netip.Prefix
+
+	// AlertingEnabled enables the evaluation of alert rules.
+	AlertingEnabled bool
+	// AlertingMinInterval is the shortest evaluation interval of a rule, rules
+	// are scheduled at multiples of it.
+	AlertingMinInterval time.Duration
+	// AlertingEvaluationTimeout is how long the queries of a rule may run.
+	AlertingEvaluationTimeout time.Duration
+
+	// RecordingRemoteWriteURL is the Prometheus remote write endpoint the
+	// recording rules write to. Recording rules are only evaluated when set.
+	RecordingRemoteWriteURL string
+	// RecordingRemoteWriteUsername and RecordingRemoteWritePassword are the
+	// basic auth credentials of the remote write endpoint, when set.
+	RecordingRemoteWriteUsername string
+	RecordingRemoteWritePassword string
+	// RecordingMinInterval is the shortest evaluation interval of a recording
+	// rule, rules are scheduled at multiples of it.
+	RecordingMinInterval time.Duration
+	// RecordingEvaluationTimeout is how long the query and the remote write of
+	// a recording rule may take.
+	RecordingEvaluationTimeout time.Duration
+
+	// AdminUser and AdminPassword are the basic auth credentials of the
+	// built-in admin, who manages the service accounts. Basic auth is disabled
+	// while the password is empty or "admin".
+	AdminUser     string
+	AdminPassword string
+	// AnonymousEnabled lets requests without credentials in with
+	// AnonymousRole. Otherwise the API requires authentication.
+	AnonymousEnabled bool
+	AnonymousRole    string
+
+	// AuthJWTJWKSURL and AuthJWTJWKSFile are where the keys that JWTs are
+	// verified with are read from. Bearer JWTs are accepted when one is set.
+	AuthJWTJWKSURL  string
+	AuthJWTJWKSFile string
+	// AuthJWTIssuer and AuthJWTAudience must match the iss and aud claims of
+	// JWTs, when set.
+	AuthJWTIssuer   string
+	AuthJWTAudience string
+	// AuthJWTLoginClaim, AuthJWTEmailClaim, AuthJWTNameClaim,
+	// AuthJWTGroupsClaim and AuthJWTRoleClaim name the claims of the user.
+	// Users without role claim get AuthJWTDefaultRole.
+	AuthJWTLoginClaim  string
+	AuthJWTEmailClaim  string
+	AuthJWTNameClaim   string
+	AuthJWTGroupsClaim string
+	AuthJWTRoleClaim   string
+	AuthJWTDefaultRole string
+
+	// AuthOAuthClientID enables the OAuth2 authorization code login with the
+	// provider at AuthOAuthAuthURL and AuthOAuthTokenURL. The ID tokens it
+	// returns are verified like JWTs.
+	AuthOAuthClientID     string
+	AuthOAuthClientSecret string
+	AuthOAuthAuthURL      string
+	AuthOAuthTokenURL     string
+	// AuthOAuthRedirectURL is the public URL of /login/oauth/callback.
+	AuthOAuthRedirectURL string
+	AuthOAuthScopes      []string
+	// AuthSessionLifetime is how long the session of an OAuth login lasts.
+	AuthSessionLifetime time.Duration
+}
+
+func ProvideCfg() (*Cfg, error) {
+	homePath := envOrDefault("HOME_PATH", ".")
+	cfg := &Cfg{
+		HomePath:         homePath,
+		DataPath:         makeAbsolute(envOrDefault("DATA_PATH", "data"), homePath),
+		ProvisioningPath: makeAbsolute(envOrDefault("PROVISIONING_PATH", "conf/provisioning"), homePath),
+		LokiURL:          os.Getenv("LOKI_URL"),
+		PrometheusURL:    os.Getenv("PROMETHEUS_URL"),
+
+		RecordingRemoteWriteURL:      os.Getenv("RECORDING_REMOTE_WRITE_URL"),
+		RecordingRemoteWriteUsername: os.Getenv("RECORDING_REMOTE_WRITE_USERNAME"),
+		RecordingRemoteWritePassword: os.Getenv("RECORDING_REMOTE_WRITE_PASSWORD"),
+
+		AdminUser:     envOrDefault("ADMIN_USER", "admin"),
+		AdminPassword: os.Getenv("ADMIN_PASSWORD"),
+		AnonymousRole: envOrDefault("AUTH_ANONYMOUS_ROLE", "Viewer"),
+
+		AuthJWTJWKSURL:     os.Getenv("AUTH_JWT_JWKS_URL"),
+		AuthJWTIssuer:      os.Getenv("AUTH_JWT_ISSUER"),
+		AuthJWTAudience:    os.Getenv("AUTH_JWT_AUDIENCE"),
+		AuthJWTLoginClaim:  envOrDefault("AUTH_JWT_LOGIN_CLAIM", "sub"),
+		AuthJWTEmailClaim:  envOrDefault("AUTH_JWT_EMAIL_CLAIM", "email"),
+		AuthJWTNameClaim:   envOrDefault("AUTH_JWT_NAME_CLAIM", "name"),
+		AuthJWTGroupsClaim: envOrDefault("AUTH_JWT_GROUPS_CLAIM", "groups"),
+		AuthJWTRoleClaim:   envOrDefault("AUTH_JWT_ROLE_CLAIM", "role"),
+		AuthJWTDefaultRole: envOrDefault("AUTH_JWT_DEFAULT_ROLE", "Viewer"),
+
+		AuthOAuthClientID:     os.Getenv("AUTH_OAUTH_CLIENT_ID"),
+		AuthOAuthClientSecret: os.Getenv("AUTH_OAUTH_CLIENT_SECRET"),
+		AuthOAuthAuthURL:      os.Getenv("AUTH_OAUTH_AUTH_URL"),
+		AuthOAuthTokenURL:     os.Getenv("AUTH_OAUTH_TOKEN_URL"),
+		AuthOAuthRedirectURL:  os.Getenv("AUTH_OAUTH_REDIRECT_URL"),
+		AuthOAuthScopes:       strings.Fields(strings.ReplaceAll(envOrDefault("AUTH_OAUTH_SCOPES", "openid profile email"), ",", " ")),
+	}
+	if path := os.Getenv("AUTH_JWT_JWKS_FILE"); path != "" {
+		cfg.AuthJWTJWKSFile = makeAbsolute(path, homePath)
+	}
+
+	var err error
+	if cfg.QueryCacheEnabled, err = strconv.ParseBool(envOrDefault("QUERY_CACHE_ENABLED", "true")); err != nil {
+		return nil, fmt.Errorf("invalid QUERY_CACHE_ENABLED: %w", err)
+	}
+	if cfg.QueryCacheTTL, err = time.ParseDuration(envOrDefault("QUERY_CACHE_TTL", "1m")); err != nil {
+		return nil, fmt.Errorf("invalid QUERY_CACHE_TTL: %w", err)
+	}
+	maxSizeMB, err := strconv.ParseInt(envOrDefault("QUERY_CACHE_MAX_SIZE_MB", "100"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid QUERY_CACHE_MAX_SIZE_MB: %w", err)
+	}
+	cfg.QueryCacheMaxSize = maxSizeMB * 1024 * 1024
+	if cfg.QueryTimeout, err = time.ParseDuration(envOrDefault("QUERY_TIMEOUT", "5m")); err != nil {
+		return nil, fmt.Errorf("invalid QUERY_TIMEOUT: %w", err)
+	}
+	if cfg.QueryMaxConcurrent, err = strconv.Atoi(envOrDefault("QUERY_MAX_CONCURRENT", "10")); err != nil {
+		return nil, fmt.Errorf("invalid QUERY_MAX_CONCURRENT: %w", err)
+	}
+	if cfg.QueryMaxQueue, err = strconv.Atoi(envOrDefault("QUERY_MAX_QUEUE", "100")); err != nil {
+		return nil, fmt.Errorf("invalid QUERY_MAX_QUEUE: %w", err)
+	}
+	if cfg.QueryMaxQueuePerTenant, err = strconv.Atoi(envOrDefault("QUERY_MAX_QUEUE_PER_TENANT", "20")); err != nil {
+		return nil, fmt.Errorf("invalid QUERY_MAX_QUEUE_PER_TENANT: %w", err)
+	}
+	if cfg.QueryTrustedProxies, err = parsePrefixes(os.Getenv("QUERY_TRUSTED_PROXIES")); err != nil {
+		return nil, fmt.Errorf("invalid QUERY_TRUSTED_PROXIES: %w", err)
+	}
+	if cfg.AlertingEnabled, err = strconv.ParseBool(envOrDefault("ALERTING_ENABLED", "true")); err != nil {
+		return nil, fmt.Errorf("invalid ALERTING_ENABLED: %w", err)
+	}
+	if cfg.AlertingMinInterval, err = time.ParseDuration(envOrDefault("ALERTING_MIN_INTERVAL", "10s")); err != nil || cfg.AlertingMinInterval <= 0 {
+		return nil, fmt.Errorf("invalid ALERTING_MIN_INTERVAL: %q", os.Getenv("ALERTING_MIN_INTERVAL"))
+	}
+	if cfg.AlertingEvaluationTimeout, err = time.ParseDuration(envOrDefault("ALERTING_EVALUATION_TIMEOUT", "30s")); err != nil {
+		return nil, fmt.Errorf("invalid ALERTING_EVALUATION_TIMEOUT: %w", err)
+	}
+	if cfg.RecordingMinInterval, err = time.ParseDuration(envOrDefault("RECORDING_MIN_INTERVAL", "10s")); err != nil || cfg.RecordingMinInterval <= 0 {
+		return nil, fmt.Errorf("invalid RECORDING_MIN_INTERVAL: %q", os.Getenv("RECORDING_MIN_INTERVAL"))
+	}
+	if cfg.RecordingEvaluationTimeout, err = time.ParseDuration(envOrDefault("RECORDING_EVALUATION_TIMEOUT", "30s")); err != nil {
+		return nil, fmt.Errorf("invalid RECORDING_EVALUATION_TIMEOUT: %w", err)
+	}
+	if cfg.AnonymousEnabled, err = strconv.ParseBool(envOrDefault("AUTH_ANONYMOUS_ENABLED", "false")); err != nil {
+		return nil, fmt.Errorf("invalid AUTH_ANONYMOUS_ENABLED: %w", err)
+	}
+	if cfg.AuthSessionLifetime, err = time.ParseDuration(envOrDefault("AUTH_SESSION_LIFETIME", "24h")); err != nil || cfg.AuthSessionLifetime <= 0 {
+		return nil, fmt.Errorf("invalid AUTH_SESSION_LIFETIME: %q", os.Getenv("AUTH_SESSION_LIFETIME"))
+	}
+
+	return cfg, nil
+}
+
+func envOrDefault(key, defaultValue string) string {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// parsePrefixes parses a comma or space separated list of addresses and CIDR
+// ranges.
+func parsePrefixes(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Fields(strings.ReplaceAll(list, ",", " ")) {
+		if addr, err := netip.ParseAddr(field); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func makeAbsolute(path string, root string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(root, path)
+}
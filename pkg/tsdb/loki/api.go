@@ -25,6 +25,7 @@ type LokiAPI struct {
 	url                       string
 	log                       log.Logger
 	requestStructuredMetadata bool
+	queryChunkDuration        time.Duration
 }
 
 type RawLokiResponse struct {
@@ -33,8 +34,8 @@ type RawLokiResponse struct {
 	Encoding string
 }
 
-func newLokiAPI(client *http.Client, url string, log log.Logger, requestStructuredMetadata bool) *LokiAPI {
-	return &LokiAPI{client: client, url: url, log: log, requestStructuredMetadata: requestStructuredMetadata}
+func newLokiAPI(client *http.Client, url string, log log.Logger, requestStructuredMetadata bool, queryChunkDuration time.Duration) *LokiAPI {
+	return &LokiAPI{client: client, url: url, log: log, requestStructuredMetadata: requestStructuredMetadata, queryChunkDuration: queryChunkDuration}
 }
 
 func makeDataRequest(ctx context.Context, lokiDsUrl string, query lokiQuery, categorizeLabels bool) (*http.Request, error) {
@@ -100,6 +101,10 @@ func makeDataRequest(ctx context.Context, lokiDsUrl string, query lokiQuery, cat
 		req.Header.Set("X-Loki-Response-Encoding-Flags", "categorize-labels")
 	}
 
+	if query.QueryGroupID != "" {
+		req.Header.Set(headerQueryGroupID, query.QueryGroupID)
+	}
+
 	return req, nil
 }
 
@@ -153,13 +158,22 @@ func readLokiError(body io.ReadCloser) error {
 	return makeLokiError(bytes)
 }
 
+// DataQuery runs the query, range queries longer than the query chunk duration
+// are split and sent to Loki in chunks.
 func (api *LokiAPI) DataQuery(ctx context.Context, query lokiQuery, responseOpts ResponseOpts) (data.Frames, error) {
+	if chunks := splitQuery(query, api.queryChunkDuration); len(chunks) > 1 {
+		return api.splitDataQuery(ctx, query, chunks, responseOpts)
+	}
+	return api.dataQuery(ctx, query, responseOpts)
+}
+
+func (api *LokiAPI) dataQuery(ctx context.Context, query lokiQuery, responseOpts ResponseOpts) (data.Frames, error) {
 	req, err := makeDataRequest(ctx, api.url, query, api.requestStructuredMetadata)
 	if err != nil {
 		return nil, err
 	}
 
-	queryAttrs := []any{"start", query.Start, "end", query.End, "step", query.Step, "query", query.Expr, "queryType", query.QueryType, "direction", query.Direction, "maxLines", query.MaxLines, "supportingQueryType", query.SupportingQueryType, "lokiHost", req.URL.Host, "lokiPath", req.URL.Path}
+	queryAttrs := []any{"start", query.Start, "end", query.End, "step", query.Step, "query", query.Expr, "queryType", query.QueryType, "direction", query.Direction, "maxLines", query.MaxLines, "queryGroupId", query.QueryGroupID, "supportingQueryType", query.SupportingQueryType, "lokiHost", req.URL.Host, "lokiPath", req.URL.Path}
 	api.log.Debug("Sending query to loki", queryAttrs...)
 	start := time.Now()
 	resp, err := api.client.Do(req)
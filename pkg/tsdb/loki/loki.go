@@ -13,12 +13,14 @@ import (
 	"github.com/grafana/dskit/concurrency"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/datasource"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 
 	"github.com/xquare-dashboard/pkg/infra/httpclient"
 	"github.com/xquare-dashboard/pkg/infra/log"
 	"github.com/xquare-dashboard/pkg/tsdb/loki/kinds/dataquery"
+	"github.com/xquare-dashboard/pkg/tsdb/querydebug"
 )
 
 var logger = log.New("tsdb.loki")
@@ -55,12 +57,21 @@ var (
 type datasourceInfo struct {
 	HTTPClient *http.Client
 	URL        string
+	// QueryChunkDuration is the longest time range of a single range query,
+	// longer queries are split. Zero disables splitting.
+	QueryChunkDuration time.Duration
 
 	// open streams
 	streams   map[string]data.FrameJSONCache
 	streamsMu sync.RWMutex
 }
 
+// Dispose is called by the instance manager when the datasource settings
+// changed and this instance is replaced.
+func (dsInfo *datasourceInfo) Dispose() {
+	dsInfo.HTTPClient.CloseIdleConnections()
+}
+
 type QueryJSONModel struct {
 	dataquery.LokiDataQuery
 	Direction           *string `json:"direction,omitempty"`
@@ -90,15 +101,41 @@ func newInstanceSettings(httpClientProvider httpclient.Provider) datasource.Inst
 			return nil, err
 		}
 
+		queryChunkDuration, err := parseQueryChunkDuration(settings.JSONData)
+		if err != nil {
+			return nil, err
+		}
+
 		model := &datasourceInfo{
-			HTTPClient: client,
-			URL:        settings.URL,
-			streams:    make(map[string]data.FrameJSONCache),
+			HTTPClient:         client,
+			URL:                settings.URL,
+			QueryChunkDuration: queryChunkDuration,
+			streams:            make(map[string]data.FrameJSONCache),
 		}
 		return model, nil
 	}
 }
 
+// parseQueryChunkDuration reads the queryChunkDuration jsonData setting.
+func parseQueryChunkDuration(jsonData json.RawMessage) (time.Duration, error) {
+	var opts struct {
+		QueryChunkDuration string `json:"queryChunkDuration"`
+	}
+	if len(jsonData) > 0 {
+		if err := json.Unmarshal(jsonData, &opts); err != nil {
+			return 0, err
+		}
+	}
+	if opts.QueryChunkDuration == "" {
+		return defaultQueryChunkDuration, nil
+	}
+	d, err := gtime.ParseDuration(opts.QueryChunkDuration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid queryChunkDuration: %w", err)
+	}
+	return d, nil
+}
+
 func (s *Service) CallResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	dsInfo, err := s.getDSInfo(ctx, req.PluginContext)
 	logger := s.logger.FromContext(ctx)
@@ -125,7 +162,7 @@ func callResource(ctx context.Context, req *backend.CallResourceRequest, sender
 	}
 	lokiURL := fmt.Sprintf("/loki/api/v1/%s", url)
 
-	api := newLokiAPI(dsInfo.HTTPClient, dsInfo.URL, plog, false)
+	api := newLokiAPI(dsInfo.HTTPClient, dsInfo.URL, plog, false, 0)
 
 	rawLokiResponse, err := api.RawQuery(ctx, lokiURL)
 	if err != nil {
@@ -167,7 +204,7 @@ func queryData(
 ) (*backend.QueryDataResponse, error) {
 	result := backend.NewQueryDataResponse()
 
-	api := newLokiAPI(dsInfo.HTTPClient, dsInfo.URL, plog, requestStructuredMetadata)
+	api := newLokiAPI(dsInfo.HTTPClient, dsInfo.URL, plog, requestStructuredMetadata, dsInfo.QueryChunkDuration)
 
 	start := time.Now()
 	queries, err := parseQuery(req)
@@ -201,6 +238,10 @@ func queryData(
 }
 
 func executeQuery(ctx context.Context, query *lokiQuery, api *LokiAPI, responseOpts ResponseOpts, plog log.Logger) backend.DataResponse {
+	var requests func() []httpclient.RecordedRequest
+	if query.Debug {
+		ctx, requests = querydebug.Record(ctx)
+	}
 
 	frames, err := runQuery(ctx, api, query, responseOpts, plog)
 	queryRes := backend.DataResponse{}
@@ -210,9 +251,33 @@ func executeQuery(ctx context.Context, query *lokiQuery, api *LokiAPI, responseO
 		queryRes.Frames = frames
 	}
 
+	if query.Debug {
+		querydebug.Attach(&queryRes, debugInfo(query, frames, requests()))
+	}
 	return queryRes
 }
 
+// debugInfo returns the debug block of a query, with the query statistics
+// returned by Loki.
+func debugInfo(query *lokiQuery, frames data.Frames, requests []httpclient.RecordedRequest) querydebug.Info {
+	info := querydebug.Info{
+		Expr:      query.Expr,
+		QueryType: string(query.QueryType),
+		Interval:  query.Interval.String(),
+		Requests:  requests,
+	}
+	if query.QueryType == QueryTypeRange {
+		info.Step = query.Step.String()
+	}
+	for _, frame := range frames {
+		if frame.Meta != nil && len(frame.Meta.Stats) > 0 {
+			info.Stats = frame.Meta.Stats
+			break
+		}
+	}
+	return info
+}
+
 // we extracted this part of the functionality to make it easy to unit-test it
 func runQuery(ctx context.Context, api *LokiAPI, query *lokiQuery, responseOpts ResponseOpts, plog log.Logger) (data.Frames, error) {
 	frames, err := api.DataQuery(ctx, *query, responseOpts)
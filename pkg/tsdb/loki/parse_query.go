@@ -11,6 +11,7 @@ import (
 
 	"github.com/xquare-dashboard/pkg/tsdb/intervalv2"
 	"github.com/xquare-dashboard/pkg/tsdb/loki/kinds/dataquery"
+	"github.com/xquare-dashboard/pkg/tsdb/querydebug"
 )
 
 const (
@@ -120,6 +121,8 @@ func parseSupportingQueryType(jsonPointerValue *string) (SupportingQueryType, er
 
 func parseQuery(queryContext *backend.QueryDataRequest) ([]*lokiQuery, error) {
 	qs := []*lokiQuery{}
+	queryGroupID := queryContext.GetHTTPHeader(headerQueryGroupID)
+	debug := querydebug.Enabled(queryContext)
 	for _, query := range queryContext.Queries {
 		model, err := parseQueryModel(query.JSON)
 		if err != nil {
@@ -180,6 +183,9 @@ func parseQuery(queryContext *backend.QueryDataRequest) ([]*lokiQuery, error) {
 			End:                 end,
 			RefID:               query.RefID,
 			SupportingQueryType: supportingQueryType,
+			QueryGroupID:        queryGroupID,
+			Interval:            interval,
+			Debug:               debug,
 		})
 	}
 
@@ -0,0 +1,209 @@
+package loki
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/grafana/dskit/concurrency"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	// defaultQueryChunkDuration is the longest time range sent to Loki in a
+	// single request, longer range queries are split in chunks.
+	defaultQueryChunkDuration = 24 * time.Hour
+	// maxConcurrentChunks is how many chunks of a metric query run at once.
+	maxConcurrentChunks = 4
+	// headerQueryGroupID tags the requests of a split query so they can be
+	// related in Loki, it matches query.HeaderQueryGroupID.
+	headerQueryGroupID = "X-Query-Group-Id"
+)
+
+// splitQuery splits a range query in chunks of at most chunkDuration, rounded
+// up to a multiple of the step so the chunks evaluate the same steps as the whole
+// query. The chunks are ordered in the direction of the query. Queries that fit
+// in one chunk are returned unchanged.
+func splitQuery(query lokiQuery, chunkDuration time.Duration) []lokiQuery {
+	if query.QueryType != QueryTypeRange || chunkDuration <= 0 || query.End.Sub(query.Start) <= chunkDuration {
+		return []lokiQuery{query}
+	}
+	if query.Step > 0 && chunkDuration%query.Step != 0 {
+		chunkDuration = (chunkDuration/query.Step + 1) * query.Step
+	}
+
+	var chunks []lokiQuery
+	for start := query.Start; start.Before(query.End); start = start.Add(chunkDuration) {
+		chunk := query
+		chunk.Start = start
+		chunk.End = start.Add(chunkDuration)
+		if chunk.End.After(query.End) {
+			chunk.End = query.End
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	if query.Direction == DirectionBackward {
+		for i, j := 0, len(chunks)-1; i < j; i, j = i+1, j-1 {
+			chunks[i], chunks[j] = chunks[j], chunks[i]
+		}
+	}
+	return chunks
+}
+
+// splitDataQuery runs the chunks of a split query. The first chunk tells whether
+// it is a logs or a metric query: logs chunks run one after the other until
+// MaxLines lines are read, metric chunks run concurrently and their series are
+// merged.
+func (api *LokiAPI) splitDataQuery(ctx context.Context, query lokiQuery, chunks []lokiQuery, responseOpts ResponseOpts) (data.Frames, error) {
+	groupID := query.QueryGroupID
+	if groupID == "" {
+		groupID = uuid.NewString()
+	}
+	for i := range chunks {
+		chunks[i].QueryGroupID = groupID
+	}
+	api.log.Debug("Splitting query to loki", "query", query.Expr, "chunks", len(chunks), "queryGroupId", groupID)
+
+	first, err := api.dataQuery(ctx, chunks[0], responseOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if isLogsFrames(first) {
+		results := []data.Frames{first}
+		lines := countRows(first)
+		for _, chunk := range chunks[1:] {
+			if query.MaxLines > 0 {
+				if lines >= query.MaxLines {
+					break
+				}
+				chunk.MaxLines = query.MaxLines - lines
+			}
+			frames, err := api.dataQuery(ctx, chunk, responseOpts)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, frames)
+			lines += countRows(frames)
+		}
+		return mergeLogsFrames(results, query.MaxLines)
+	}
+
+	results := make([]data.Frames, len(chunks))
+	results[0] = first
+	err = concurrency.ForEachJob(ctx, len(chunks)-1, maxConcurrentChunks, func(ctx context.Context, idx int) error {
+		frames, err := api.dataQuery(ctx, chunks[idx+1], responseOpts)
+		if err != nil {
+			return err
+		}
+		results[idx+1] = frames
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// merge the series in time order
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return chunks[order[i]].Start.Before(chunks[order[j]].Start)
+	})
+	sorted := make([]data.Frames, len(results))
+	for i, idx := range order {
+		sorted[i] = results[idx]
+	}
+	return mergeMetricFrames(sorted)
+}
+
+// isLogsFrames tells logs frames from metric frames the same way adjustFrame does.
+func isLogsFrames(frames data.Frames) bool {
+	for _, frame := range frames {
+		if len(frame.Fields) >= 2 && frame.Fields[1].Type() != data.FieldTypeFloat64 {
+			return true
+		}
+	}
+	return false
+}
+
+func countRows(frames data.Frames) int {
+	rows := 0
+	for _, frame := range frames {
+		rows += frame.Rows()
+	}
+	return rows
+}
+
+// mergeLogsFrames concatenates the logs frames of the chunks, which are in the
+// direction of the query, into one frame of at most maxLines lines.
+func mergeLogsFrames(results []data.Frames, maxLines int) (data.Frames, error) {
+	var merged *data.Frame
+	for _, frames := range results {
+		for _, frame := range frames {
+			if merged == nil {
+				merged = frame.EmptyCopy()
+				merged.Meta = frame.Meta
+			}
+			if !sameFieldTypes(merged, frame) {
+				return nil, fmt.Errorf("unexpected frame in split logs query")
+			}
+			for i := 0; i < frame.Rows(); i++ {
+				if maxLines > 0 && merged.Rows() >= maxLines {
+					return data.Frames{merged}, nil
+				}
+				merged.AppendRow(frame.RowCopy(i)...)
+			}
+		}
+	}
+	if merged == nil {
+		return data.Frames{}, nil
+	}
+	return data.Frames{merged}, nil
+}
+
+// mergeMetricFrames merges the series of the chunks, which are in time order,
+// by their labels. A sample at the boundary of two chunks is only kept once.
+func mergeMetricFrames(results []data.Frames) (data.Frames, error) {
+	merged := data.Frames{}
+	series := make(map[string]*data.Frame)
+	for _, frames := range results {
+		for _, frame := range frames {
+			if len(frame.Fields) != 2 || frame.Fields[0].Type() != data.FieldTypeTime || frame.Fields[1].Type() != data.FieldTypeFloat64 {
+				return nil, fmt.Errorf("unexpected frame in split metric query")
+			}
+			key := frame.Fields[1].Labels.String()
+			target, ok := series[key]
+			if !ok {
+				target = frame.EmptyCopy()
+				target.Meta = frame.Meta
+				series[key] = target
+				merged = append(merged, target)
+			}
+			for i := 0; i < frame.Rows(); i++ {
+				t := frame.Fields[0].At(i).(time.Time)
+				if n := target.Rows(); n > 0 && !t.After(target.Fields[0].At(n-1).(time.Time)) {
+					continue
+				}
+				target.AppendRow(t, frame.Fields[1].At(i))
+			}
+		}
+	}
+	return merged, nil
+}
+
+func sameFieldTypes(a, b *data.Frame) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range a.Fields {
+		if a.Fields[i].Type() != b.Fields[i].Type() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,120 @@
+package loki
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/infra/log"
+)
+
+func TestSplitQuery(t *testing.T) {
+	start := time.Unix(0, 0)
+	query := lokiQuery{QueryType: QueryTypeRange, Direction: DirectionForward, Step: 7 * time.Minute, Start: start, End: start.Add(50 * time.Hour)}
+
+	t.Run("aligns chunks to the step", func(t *testing.T) {
+		chunks := splitQuery(query, 24*time.Hour)
+		require.Len(t, chunks, 3)
+		// 24h is not a multiple of 7m, chunks are rounded up to 24h02m.
+		require.Equal(t, start, chunks[0].Start)
+		require.Equal(t, start.Add(1442*time.Minute), chunks[0].End)
+		require.Equal(t, chunks[0].End, chunks[1].Start)
+		require.Equal(t, query.End, chunks[2].End)
+	})
+
+	t.Run("orders backward chunks from the end", func(t *testing.T) {
+		backward := query
+		backward.Direction = DirectionBackward
+		chunks := splitQuery(backward, 24*time.Hour)
+		require.Len(t, chunks, 3)
+		require.Equal(t, query.End, chunks[0].End)
+		require.Equal(t, start, chunks[2].Start)
+	})
+
+	t.Run("does not split short or instant queries", func(t *testing.T) {
+		require.Len(t, splitQuery(query, 0), 1)
+		require.Len(t, splitQuery(query, 100*time.Hour), 1)
+		instant := query
+		instant.QueryType = QueryTypeInstant
+		require.Len(t, splitQuery(instant, time.Hour), 1)
+	})
+}
+
+func TestLokiAPI_splitDataQuery(t *testing.T) {
+	type request struct {
+		start, end int64
+		limit      string
+		groupID    string
+	}
+	var mu sync.Mutex
+	var requests []request
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(r.URL.Query().Get("end"), 10, 64)
+		mu.Lock()
+		requests = append(requests, request{start, end, r.URL.Query().Get("limit"), r.Header.Get(headerQueryGroupID)})
+		mu.Unlock()
+
+		startSec, endSec := start/int64(time.Second), end/int64(time.Second)
+		if strings.HasPrefix(r.URL.Query().Get("query"), "rate") {
+			// one sample per hour, including both ends of the chunk
+			var values []string
+			for ts := startSec; ts <= endSec; ts += 3600 {
+				values = append(values, fmt.Sprintf(`[%d,"1"]`, ts))
+			}
+			_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"app":"a"},"values":[%s]}]}}`, strings.Join(values, ","))
+			return
+		}
+		// two lines per chunk, newest first
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"streams","result":[{"stream":{"app":"a"},"values":[["%d","new"],["%d","old"]]}]}}`, end-1, start)
+	}))
+	t.Cleanup(srv.Close)
+
+	api := newLokiAPI(srv.Client(), srv.URL, log.New("test"), false, 24*time.Hour)
+	start := time.Unix(0, 0)
+
+	t.Run("merges metric chunks", func(t *testing.T) {
+		requests = nil
+		query := lokiQuery{Expr: "rate({app=\"a\"}[1m])", QueryType: QueryTypeRange, Direction: DirectionBackward, Step: time.Hour, Start: start, End: start.Add(72 * time.Hour)}
+		frames, err := api.DataQuery(context.Background(), query, ResponseOpts{})
+		require.NoError(t, err)
+		require.Len(t, requests, 3)
+		require.NotEmpty(t, requests[0].groupID)
+		for _, r := range requests {
+			require.Equal(t, requests[0].groupID, r.groupID)
+		}
+
+		require.Len(t, frames, 1)
+		// samples at the chunk boundaries are only kept once
+		require.Equal(t, 73, frames[0].Rows())
+		for i := 1; i < frames[0].Rows(); i++ {
+			prev := frames[0].Fields[0].At(i - 1).(time.Time)
+			require.Equal(t, time.Hour, frames[0].Fields[0].At(i).(time.Time).Sub(prev))
+		}
+	})
+
+	t.Run("reads logs chunks in direction order until max lines", func(t *testing.T) {
+		requests = nil
+		query := lokiQuery{Expr: `{app="a"}`, QueryType: QueryTypeRange, Direction: DirectionBackward, MaxLines: 3, Step: time.Hour, Start: start, End: start.Add(72 * time.Hour), QueryGroupID: "group"}
+		frames, err := api.DataQuery(context.Background(), query, ResponseOpts{})
+		require.NoError(t, err)
+
+		require.Len(t, requests, 2)
+		require.Equal(t, start.Add(72*time.Hour).UnixNano(), requests[0].end)
+		require.Equal(t, "3", requests[0].limit)
+		require.Equal(t, "1", requests[1].limit)
+		require.Equal(t, "group", requests[1].groupID)
+
+		require.Len(t, frames, 1)
+		require.Equal(t, 3, frames[0].Rows())
+	})
+}
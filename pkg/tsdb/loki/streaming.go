@@ -5,8 +5,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
-	"os"
-	"os/signal"
 	"strings"
 	"time"
 
@@ -70,15 +68,12 @@ func (s *Service) RunStream(ctx context.Context, req *backend.RunStreamRequest,
 		return err
 	}
 	if query.Expr == "" {
-		return fmt.Errorf("missing expr in cuannel")
+		return fmt.Errorf("missing expr in channel")
 	}
 
 	logger := logger.FromContext(ctx)
 	count := int64(0)
 
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-
 	params := url.Values{}
 	params.Add("query", query.Expr)
 
@@ -107,8 +102,9 @@ func (s *Service) RunStream(ctx context.Context, req *backend.RunStreamRequest,
 		if r != nil {
 			_ = r.Body.Close()
 		}
-		err = c.Close()
-		logger.Error("Closing loki websocket", "err", err)
+		if err := c.Close(); err != nil {
+			logger.Warn("Failed to close loki websocket", "err", err)
+		}
 	}()
 
 	prev := data.FrameJSONCache{}
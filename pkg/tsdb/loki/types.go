@@ -38,4 +38,8 @@ type lokiQuery struct {
 	End                 time.Time
 	RefID               string
 	SupportingQueryType SupportingQueryType
+	QueryGroupID        string
+	// Interval and Debug are only used for the debug block of debug requests.
+	Interval time.Duration
+	Debug    bool
 }
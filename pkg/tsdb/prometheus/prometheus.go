@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -27,6 +28,7 @@ type Service struct {
 }
 
 type instance struct {
+	httpClient   *http.Client
 	queryData    *querydata.QueryData
 	resource     *resource.Resource
 	versionCache *cache.Cache
@@ -66,6 +68,7 @@ func newInstanceSettings(httpClientProvider *httpclient.Provider, log log.Logger
 		}
 
 		return instance{
+			httpClient:   httpClient,
 			queryData:    qd,
 			resource:     r,
 			versionCache: cache.New(time.Minute*1, time.Minute*5),
@@ -73,6 +76,12 @@ func newInstanceSettings(httpClientProvider *httpclient.Provider, log log.Logger
 	}
 }
 
+// Dispose is called by the instance manager when the datasource settings
+// changed and this instance is replaced.
+func (i instance) Dispose() {
+	i.httpClient.CloseIdleConnections()
+}
+
 func (s *Service) QueryData(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	if len(req.Queries) == 0 {
 		err := fmt.Errorf("query contains no queries")
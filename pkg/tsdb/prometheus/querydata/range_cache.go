@@ -0,0 +1,223 @@
+package querydata
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/xquare-dashboard/pkg/tsdb/prometheus/models"
+)
+
+const (
+	// defaultIncrementalOverlap is how much of the end of a cached range is
+	// queried again, samples close to now may still change as data arrives late.
+	defaultIncrementalOverlap = 10 * time.Minute
+	// rangeCacheMaxEntries bounds the number of range queries cached per datasource.
+	rangeCacheMaxEntries = 256
+)
+
+// rangeCache keeps the series of recent range queries, so a query whose time
+// range slides forward only fetches the samples after the cached range.
+type rangeCache struct {
+	overlap    time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*rangeCacheEntry
+}
+
+type rangeCacheEntry struct {
+	start    time.Time
+	end      time.Time
+	frames   data.Frames
+	lastUsed time.Time
+}
+
+func newRangeCache(overlap time.Duration, maxEntries int) *rangeCache {
+	return &rangeCache{
+		overlap:    overlap,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*rangeCacheEntry),
+	}
+}
+
+// rangeCacheKey identifies the queries whose samples can be shared, everything
+// but the time range.
+func rangeCacheKey(q *models.Query) string {
+	return fmt.Sprintf("%s\x00%d\x00%s\x00%d", q.Expr, q.Step, q.LegendFormat, q.UtcOffsetSec)
+}
+
+// get returns the cached entry the query can be extended from, that is an entry
+// starting before the query and ending inside of it.
+func (c *rangeCache) get(key string, tr models.TimeRange) (*rangeCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || tr.Start.Before(e.start) || tr.Start.After(e.end) || tr.End.Before(e.end) {
+		return nil, false
+	}
+	e.lastUsed = time.Now()
+	return e, true
+}
+
+// set caches frames for the time range. Only plain time series can be merged,
+// responses with other frames are not cached.
+func (c *rangeCache) set(key string, tr models.TimeRange, frames data.Frames) {
+	if !isTimeSeriesFrames(frames) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.evictOldest()
+	}
+	c.entries[key] = &rangeCacheEntry{
+		start:    tr.Start,
+		end:      tr.End,
+		frames:   copyFrames(frames),
+		lastUsed: time.Now(),
+	}
+}
+
+// evictOldest must be called with the lock held.
+func (c *rangeCache) evictOldest() {
+	var oldestKey string
+	var oldest time.Time
+	for k, e := range c.entries {
+		if oldestKey == "" || e.lastUsed.Before(oldest) {
+			oldestKey, oldest = k, e.lastUsed
+		}
+	}
+	delete(c.entries, oldestKey)
+}
+
+// isTimeSeriesFrames reports whether every frame is a [time, value] series, or the
+// empty frame added to carry the metadata of a response without series.
+func isTimeSeriesFrames(frames data.Frames) bool {
+	for _, f := range frames {
+		if len(f.Fields) == 0 {
+			continue
+		}
+		if len(f.Fields) != 2 || f.Fields[0].Type() != data.FieldTypeTime || f.Fields[1].Type() != data.FieldTypeFloat64 {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeRangeFrames combines the cached series from start up to cutoff with the
+// fetched series from cutoff on. Series are matched by their labels, the fetched
+// series provide the metadata. It returns false if the frames can't be merged.
+func mergeRangeFrames(cached, fetched data.Frames, start, cutoff time.Time) (data.Frames, bool) {
+	if !isTimeSeriesFrames(cached) || !isTimeSeriesFrames(fetched) {
+		return nil, false
+	}
+
+	fetchedByLabels := make(map[string]*data.Frame, len(fetched))
+	for _, f := range fetched {
+		if len(f.Fields) == 0 {
+			continue
+		}
+		fetchedByLabels[f.Fields[1].Labels.String()] = f
+	}
+
+	merged := make(data.Frames, 0, len(fetched))
+	for _, f := range cached {
+		if len(f.Fields) == 0 {
+			continue
+		}
+		key := f.Fields[1].Labels.String()
+		if tail, ok := fetchedByLabels[key]; ok {
+			frame := newSeriesFrame(tail)
+			appendRows(frame, f, start, cutoff)
+			appendRows(frame, tail, time.Time{}, time.Time{})
+			merged = append(merged, frame)
+			delete(fetchedByLabels, key)
+			continue
+		}
+		// The series has no samples since the cutoff, keep it while it has
+		// samples in the requested range.
+		frame := newSeriesFrame(f)
+		appendRows(frame, f, start, cutoff)
+		if frame.Rows() > 0 {
+			merged = append(merged, frame)
+		}
+	}
+	for _, f := range fetched {
+		if len(f.Fields) == 0 {
+			continue
+		}
+		if _, ok := fetchedByLabels[f.Fields[1].Labels.String()]; ok {
+			merged = append(merged, copyFrame(f))
+		}
+	}
+
+	if len(merged) == 0 {
+		return copyFrames(fetched), true
+	}
+	return merged, true
+}
+
+// newSeriesFrame returns an empty [time, value] frame with the name, metadata and
+// field settings of f.
+func newSeriesFrame(f *data.Frame) *data.Frame {
+	timeField := data.NewFieldFromFieldType(data.FieldTypeTime, 0)
+	timeField.Name = f.Fields[0].Name
+	timeField.Config = f.Fields[0].Config
+	valueField := data.NewFieldFromFieldType(data.FieldTypeFloat64, 0)
+	valueField.Name = f.Fields[1].Name
+	valueField.Labels = f.Fields[1].Labels.Copy()
+	valueField.Config = f.Fields[1].Config
+
+	frame := data.NewFrame(f.Name, timeField, valueField)
+	frame.RefID = f.RefID
+	if f.Meta != nil {
+		meta := *f.Meta
+		frame.Meta = &meta
+	}
+	return frame
+}
+
+// appendRows appends the rows of src with from <= time < to to dst. Zero bounds
+// are not checked.
+func appendRows(dst, src *data.Frame, from, to time.Time) {
+	for i := 0; i < src.Rows(); i++ {
+		t := src.Fields[0].At(i).(time.Time)
+		if !from.IsZero() && t.Before(from) {
+			continue
+		}
+		if !to.IsZero() && !t.Before(to) {
+			break
+		}
+		dst.Fields[0].Append(t)
+		dst.Fields[1].Append(src.Fields[1].At(i))
+	}
+}
+
+func copyFrame(f *data.Frame) *data.Frame {
+	if len(f.Fields) == 0 {
+		frame := data.NewFrame(f.Name)
+		frame.RefID = f.RefID
+		if f.Meta != nil {
+			meta := *f.Meta
+			frame.Meta = &meta
+		}
+		return frame
+	}
+	frame := newSeriesFrame(f)
+	appendRows(frame, f, time.Time{}, time.Time{})
+	return frame
+}
+
+func copyFrames(frames data.Frames) data.Frames {
+	copied := make(data.Frames, len(frames))
+	for i, f := range frames {
+		copied[i] = copyFrame(f)
+	}
+	return copied
+}
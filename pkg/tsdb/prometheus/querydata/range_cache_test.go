@@ -0,0 +1,113 @@
+package querydata
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/tsdb/prometheus/client"
+	"github.com/xquare-dashboard/pkg/tsdb/prometheus/models"
+	"github.com/xquare-dashboard/pkg/tsdb/prometheus/querydata/exemplar"
+)
+
+func TestQueryData_incrementalRangeQuery(t *testing.T) {
+	var starts []int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		start, _ := strconv.ParseInt(r.Form.Get("start"), 10, 64)
+		end, _ := strconv.ParseInt(r.Form.Get("end"), 10, 64)
+		starts = append(starts, start)
+
+		// The value of every sample is its timestamp, series b stops at 850.
+		var a, b []string
+		for ts := start; ts <= end; ts += 60 {
+			a = append(a, fmt.Sprintf(`[%d,"%d"]`, ts, ts))
+			if ts <= 850 {
+				b = append(b, fmt.Sprintf(`[%d,"%d"]`, ts, ts))
+			}
+		}
+		result := fmt.Sprintf(`{"metric":{"s":"a"},"values":[%s]}`, strings.Join(a, ","))
+		if len(b) > 0 {
+			result += fmt.Sprintf(`,{"metric":{"s":"b"},"values":[%s]}`, strings.Join(b, ","))
+		}
+		_, _ = fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[%s]}}`, result)
+	}))
+	t.Cleanup(srv.Close)
+
+	s := &QueryData{
+		tracer:          tracing.DefaultTracer(),
+		log:             log.New(),
+		exemplarSampler: exemplar.NewStandardDeviationSampler,
+		rangeCache:      newRangeCache(5*time.Minute, rangeCacheMaxEntries),
+	}
+	c := client.NewClient(srv.Client(), http.MethodGet, srv.URL)
+	query := func(from, to int64) *models.Query {
+		return &models.Query{Expr: "up", Step: time.Minute, RangeQuery: true, Start: time.Unix(from, 0), End: time.Unix(to, 0)}
+	}
+
+	res := s.rangeQuery(context.Background(), c, query(0, 1200), nil)
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 2)
+
+	res = s.rangeQuery(context.Background(), c, query(600, 1800), nil)
+	require.NoError(t, res.Error)
+	// Only the samples after the cached range minus the overlap are fetched.
+	require.Equal(t, []int64{0, 900}, starts)
+
+	require.Len(t, res.Frames, 2)
+	a, b := res.Frames[0], res.Frames[1]
+	require.Equal(t, "a", a.Fields[1].Labels["s"])
+	require.Equal(t, 21, a.Rows())
+	for i := 0; i < a.Rows(); i++ {
+		ts := int64(600 + 60*i)
+		require.Equal(t, time.Unix(ts, 0).UTC(), a.Fields[0].At(i).(time.Time).UTC())
+		require.Equal(t, float64(ts), a.Fields[1].At(i))
+	}
+	// b has no samples since the cutoff but still has cached samples in range.
+	require.Equal(t, "b", b.Fields[1].Labels["s"])
+	require.Equal(t, 5, b.Rows())
+	require.Equal(t, executedQueryString(query(600, 1800)), a.Meta.ExecutedQueryString)
+	require.Empty(t, b.Meta.ExecutedQueryString)
+
+	// A time range before the cached one is fetched in full.
+	_ = s.rangeQuery(context.Background(), c, query(0, 600), nil)
+	require.Equal(t, []int64{0, 900, 0}, starts)
+}
+
+func TestMergeRangeFrames(t *testing.T) {
+	series := func(labels data.Labels, from, to int64) *data.Frame {
+		var times []time.Time
+		var values []float64
+		for ts := from; ts <= to; ts += 60 {
+			times = append(times, time.Unix(ts, 0))
+			values = append(values, float64(ts))
+		}
+		return data.NewFrame("", data.NewField(data.TimeSeriesTimeFieldName, nil, times), data.NewField(data.TimeSeriesValueFieldName, labels, values))
+	}
+
+	t.Run("rejects frames other than time series", func(t *testing.T) {
+		table := data.NewFrame("", data.NewField("v", nil, []string{"x"}))
+		_, ok := mergeRangeFrames(data.Frames{table}, data.Frames{series(nil, 0, 60)}, time.Unix(0, 0), time.Unix(60, 0))
+		require.False(t, ok)
+	})
+
+	t.Run("adds new series and drops series out of range", func(t *testing.T) {
+		cached := data.Frames{series(data.Labels{"s": "old"}, 0, 300)}
+		fetched := data.Frames{series(data.Labels{"s": "new"}, 600, 900)}
+		merged, ok := mergeRangeFrames(cached, fetched, time.Unix(600, 0), time.Unix(600, 0))
+		require.True(t, ok)
+		require.Len(t, merged, 1)
+		require.Equal(t, "new", merged[0].Fields[1].Labels["s"])
+		require.Equal(t, 6, merged[0].Rows())
+	})
+}
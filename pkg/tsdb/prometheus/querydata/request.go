@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
@@ -15,11 +17,13 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/tracing"
 
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
 	"github.com/xquare-dashboard/pkg/tsdb/intervalv2"
 	"github.com/xquare-dashboard/pkg/tsdb/prometheus/client"
 	"github.com/xquare-dashboard/pkg/tsdb/prometheus/models"
 	"github.com/xquare-dashboard/pkg/tsdb/prometheus/querydata/exemplar"
 	"github.com/xquare-dashboard/pkg/tsdb/prometheus/utils"
+	"github.com/xquare-dashboard/pkg/tsdb/querydebug"
 	"github.com/xquare-dashboard/pkg/util/maputil"
 )
 
@@ -45,6 +49,7 @@ type QueryData struct {
 	TimeInterval       string
 	enableDataplane    bool
 	exemplarSampler    func() exemplar.Sampler
+	rangeCache         *rangeCache
 }
 
 func New(
@@ -67,11 +72,34 @@ func New(
 		httpMethod = http.MethodPost
 	}
 
+	// Incremental querying is enabled unless the datasource turns it off.
+	incremental := true
+	if _, ok := jsonData["incrementalQuerying"]; ok {
+		if incremental, err = maputil.GetBool(jsonData, "incrementalQuerying"); err != nil {
+			return nil, err
+		}
+	}
+	overlap := defaultIncrementalOverlap
+	overlapWindow, err := maputil.GetStringOptional(jsonData, "incrementalQueryOverlapWindow")
+	if err != nil {
+		return nil, err
+	}
+	if overlapWindow != "" {
+		if overlap, err = gtime.ParseDuration(overlapWindow); err != nil {
+			return nil, fmt.Errorf("invalid incrementalQueryOverlapWindow: %w", err)
+		}
+	}
+
 	promClient := client.NewClient(httpClient, httpMethod, settings.URL)
 
 	// standard deviation sampler is the default for backwards compatibility
 	exemplarSampler := exemplar.NewStandardDeviationSampler
 
+	var rangeCache *rangeCache
+	if incremental {
+		rangeCache = newRangeCache(overlap, rangeCacheMaxEntries)
+	}
+
 	return &QueryData{
 		intervalCalculator: intervalv2.NewCalculator(),
 		tracer:             tracing.DefaultTracer(),
@@ -82,11 +110,13 @@ func New(
 		URL:                settings.URL,
 		enableDataplane:    false,
 		exemplarSampler:    exemplarSampler,
+		rangeCache:         rangeCache,
 	}, nil
 }
 
 func (s *QueryData) Execute(ctx context.Context, req *backend.QueryDataRequest) (*backend.QueryDataResponse, error) {
 	fromAlert := req.Headers["FromAlert"] == "true"
+	debug := querydebug.Enabled(req)
 	result := backend.QueryDataResponse{
 		Responses: backend.Responses{},
 	}
@@ -97,17 +127,47 @@ func (s *QueryData) Execute(ctx context.Context, req *backend.QueryDataRequest)
 			return &result, err
 		}
 
-		r := s.fetch(ctx, s.client, query, req.Headers)
+		queryCtx := ctx
+		var requests func() []httpclient.RecordedRequest
+		if debug {
+			queryCtx, requests = querydebug.Record(ctx)
+		}
+		r := s.fetch(queryCtx, s.client, query, req.Headers)
 		if r == nil {
 			s.log.FromContext(ctx).Debug("Received nil response from runQuery", "query", query.Expr)
 			continue
 		}
+		if debug {
+			querydebug.Attach(r, debugInfo(query, q.Interval, requests()))
+		}
 		result.Responses[q.RefID] = *r
 	}
 
 	return &result, nil
 }
 
+// debugInfo returns the debug block of a query, the warnings returned by
+// Prometheus are added from the frame notices.
+func debugInfo(q *models.Query, interval time.Duration, requests []httpclient.RecordedRequest) querydebug.Info {
+	var queryTypes []string
+	if q.RangeQuery {
+		queryTypes = append(queryTypes, "range")
+	}
+	if q.InstantQuery {
+		queryTypes = append(queryTypes, "instant")
+	}
+	if q.ExemplarQuery {
+		queryTypes = append(queryTypes, "exemplar")
+	}
+	return querydebug.Info{
+		Expr:      q.Expr,
+		QueryType: strings.Join(queryTypes, ","),
+		Step:      q.Step.String(),
+		Interval:  interval.String(),
+		Requests:  requests,
+	}
+}
+
 func (s *QueryData) fetch(ctx context.Context, client *client.Client, q *models.Query, headers map[string]string) *backend.DataResponse {
 	traceCtx, end := s.trace(ctx, q)
 	defer end()
@@ -152,6 +212,59 @@ func (s *QueryData) fetch(ctx context.Context, client *client.Client, q *models.
 }
 
 func (s *QueryData) rangeQuery(ctx context.Context, c *client.Client, q *models.Query, headers map[string]string) backend.DataResponse {
+	if s.rangeCache == nil {
+		return s.queryRange(ctx, c, q)
+	}
+
+	tr := q.TimeRange()
+	key := rangeCacheKey(q)
+	cached, ok := s.rangeCache.get(key, tr)
+	if !ok {
+		res := s.queryRange(ctx, c, q)
+		if res.Error == nil {
+			s.rangeCache.set(key, tr, res.Frames)
+		}
+		return res
+	}
+
+	// Only fetch the samples after the cached range, plus the overlap window
+	// as the most recent samples may have changed.
+	delta := *q
+	delta.Start = cached.end.Add(-s.rangeCache.overlap)
+	if delta.Start.Before(q.Start) {
+		delta.Start = q.Start
+	}
+	cutoff := delta.TimeRange().Start
+
+	res := s.queryRange(ctx, c, &delta)
+	if res.Error != nil {
+		return res
+	}
+	merged, ok := mergeRangeFrames(cached.frames, res.Frames, tr.Start, cutoff)
+	if !ok {
+		s.log.FromContext(ctx).Debug("Unable to merge incremental range query, fetching the full range", "query", q.Expr)
+		res = s.queryRange(ctx, c, q)
+		if res.Error == nil {
+			s.rangeCache.set(key, tr, res.Frames)
+		}
+		return res
+	}
+
+	// Merged series keep their order from the cache, move the executed query to
+	// the first frame again.
+	for i, frame := range merged {
+		addMetadataToMultiFrame(q, frame, s.enableDataplane)
+		frame.Meta.ExecutedQueryString = ""
+		if i == 0 {
+			frame.Meta.ExecutedQueryString = executedQueryString(q)
+		}
+	}
+	s.rangeCache.set(key, tr, merged)
+	res.Frames = merged
+	return res
+}
+
+func (s *QueryData) queryRange(ctx context.Context, c *client.Client, q *models.Query) backend.DataResponse {
 	res, err := c.QueryRange(ctx, q)
 	if err != nil {
 		return backend.DataResponse{
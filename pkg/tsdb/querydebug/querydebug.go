@@ -0,0 +1,88 @@
+// Package querydebug describes how the queries of a debug request were run
+// upstream, to inspect them without capturing the datasource traffic.
+package querydebug
+
+import (
+	"context"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient"
+)
+
+// Header is set to "true" on the query data requests of debug requests, it
+// matches query.HeaderQueryDebug.
+const Header = "X-Query-Debug"
+
+// metaKey is the key of the Info in the custom meta of the first frame.
+const metaKey = "debug"
+
+// Info is the debug block of a query response.
+type Info struct {
+	// Expr is the query sent upstream, after variables were interpolated.
+	Expr      string `json:"expr"`
+	QueryType string `json:"queryType,omitempty"`
+	Step      string `json:"step,omitempty"`
+	Interval  string `json:"interval,omitempty"`
+	// Requests are the upstream requests of the query, split queries have
+	// several.
+	Requests []httpclient.RecordedRequest `json:"requests"`
+	Stats    []data.QueryStat             `json:"stats,omitempty"`
+	Warnings []string                     `json:"warnings,omitempty"`
+}
+
+// Enabled reports whether req is part of a debug request.
+func Enabled(req *backend.QueryDataRequest) bool {
+	return req.GetHTTPHeader(Header) == "true"
+}
+
+// Record returns a context whose upstream requests are recorded, and the func
+// returning them.
+func Record(ctx context.Context) (context.Context, func() []httpclient.RecordedRequest) {
+	ctx, recorder := httpclient.WithRequestRecorder(ctx)
+	return ctx, recorder.Requests
+}
+
+// Attach adds info to the custom meta of the first frame of dr, under the
+// "debug" key. A frame is added when dr has none.
+func Attach(dr *backend.DataResponse, info Info) {
+	for _, frame := range dr.Frames {
+		for _, notice := range frameNotices(frame) {
+			if notice.Severity == data.NoticeSeverityWarning {
+				info.Warnings = append(info.Warnings, notice.Text)
+			}
+		}
+	}
+
+	if len(dr.Frames) == 0 {
+		dr.Frames = append(dr.Frames, data.NewFrame(""))
+	}
+	frame := dr.Frames[0]
+	if frame.Meta == nil {
+		frame.Meta = &data.FrameMeta{}
+	}
+
+	custom := map[string]any{}
+	switch c := frame.Meta.Custom.(type) {
+	case map[string]any:
+		custom = c
+	case map[string]string:
+		// Prometheus frames carry their result type this way.
+		for k, v := range c {
+			custom[k] = v
+		}
+	case nil:
+	default:
+		custom["custom"] = c
+	}
+	custom[metaKey] = info
+	frame.Meta.Custom = custom
+}
+
+func frameNotices(frame *data.Frame) []data.Notice {
+	if frame.Meta == nil {
+		return nil
+	}
+	return frame.Meta.Notices
+}
@@ -0,0 +1,81 @@
+package querydebug
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	sdkhttpclient "github.com/grafana/grafana-plugin-sdk-go/backend/httpclient"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+
+	"github.com/xquare-dashboard/pkg/infra/httpclient/httpclientprovider"
+)
+
+func TestRecord(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"success"}`))
+	}))
+	defer srv.Close()
+
+	client, err := sdkhttpclient.New(sdkhttpclient.Options{
+		Middlewares: []sdkhttpclient.Middleware{httpclientprovider.RequestRecorderMiddleware()},
+	})
+	require.NoError(t, err)
+
+	send := func(ctx context.Context) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/v1/query?query=up", nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		_, _ = io.Copy(io.Discard, resp.Body)
+		require.NoError(t, resp.Body.Close())
+	}
+
+	ctx, requests := Record(context.Background())
+	send(ctx)
+	// Requests without the recording context are not recorded.
+	send(context.Background())
+
+	recorded := requests()
+	require.Len(t, recorded, 1)
+	require.Equal(t, http.MethodGet, recorded[0].Method)
+	require.Equal(t, srv.URL+"/api/v1/query?query=up", recorded[0].URL)
+	require.Equal(t, http.StatusOK, recorded[0].StatusCode)
+	require.Equal(t, int64(len(`{"status":"success"}`)), recorded[0].ResponseBytes)
+}
+
+func TestAttach(t *testing.T) {
+	t.Run("keeps the custom meta of the first frame", func(t *testing.T) {
+		frame := data.NewFrame("")
+		frame.Meta = &data.FrameMeta{
+			Custom:  map[string]string{"resultType": "matrix"},
+			Notices: []data.Notice{{Severity: data.NoticeSeverityWarning, Text: "PromQL info: metric might not be a counter"}},
+		}
+		dr := backend.DataResponse{Frames: data.Frames{frame}}
+
+		Attach(&dr, Info{Expr: "rate(up[5m])"})
+		custom := dr.Frames[0].Meta.Custom.(map[string]any)
+		require.Equal(t, "matrix", custom["resultType"])
+		info := custom["debug"].(Info)
+		require.Equal(t, "rate(up[5m])", info.Expr)
+		require.Equal(t, []string{"PromQL info: metric might not be a counter"}, info.Warnings)
+	})
+
+	t.Run("adds a frame to failed queries", func(t *testing.T) {
+		dr := backend.DataResponse{Error: io.ErrUnexpectedEOF}
+		Attach(&dr, Info{Expr: `{app="api"}`})
+		require.Len(t, dr.Frames, 1)
+		require.Contains(t, dr.Frames[0].Meta.Custom, "debug")
+	})
+}
+
+func TestEnabled(t *testing.T) {
+	req := &backend.QueryDataRequest{Headers: map[string]string{}}
+	require.False(t, Enabled(req))
+	req.SetHTTPHeader(Header, "true")
+	require.True(t, Enabled(req))
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"math/rand"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var uidrand = rand.New(rand.NewSource(time.Now().UnixNano()))
+var mtx sync.Mutex
+var alphaRunes = []rune("abcdefghijklmnopqrstuvwxyz")
+var hexLetters = regexp.MustCompile(`^[a-f]`)
+
+// Legacy UID pattern
+var validUIDPattern = regexp.MustCompile(`^[a-zA-Z0-9\-\_]*$`).MatchString
+
+// MaxUIDLength is the maximum length of a UID.
+const MaxUIDLength = 40
+
+// IsValidShortUID checks if short unique identifier contains valid characters
+// NOTE: future Grafana UIDs will need conform to https://github.com/kubernetes/apimachinery/blob/master/pkg/util/validation/validation.go#L43
+func IsValidShortUID(uid string) bool {
+	return validUIDPattern(uid)
+}
+
+// IsShortUIDTooLong checks if short unique identifier is too long
+func IsShortUIDTooLong(uid string) bool {
+	return len(uid) > MaxUIDLength
+}
+
+// GenerateShortUID will generate a UUID that can also be a k8s name
+// it is guaranteed to have a character as the first letter
+// This UID will be a valid k8s name
+func GenerateShortUID() string {
+	mtx.Lock()
+	defer mtx.Unlock()
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		// This should never happen... but this seems better than a panic
+		for i := range uid {
+			uid[i] = byte(uidrand.Intn(255))
+		}
+	}
+	uuid := uid.String()
+	if hexLetters.MatchString(uuid) {
+		return uuid
+	}
+	return string(alphaRunes[uidrand.Intn(len(alphaRunes))]) + uuid[1:]
+}